@@ -0,0 +1,279 @@
+// Command api-clients manages the clients of the API, registered in the
+// redis of REDIS_URL:
+//
+//	api-clients list
+//	api-clients create -scopes excuses:read,excuses:write -sources guildID NAME
+//	api-clients rotate NAME
+//	api-clients revoke NAME
+//
+// The secrets are generated, and only printed by create and rotate. The
+// changes are recorded in the global audit log.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	redisCtr "github.com/curzolapierre/hook-manager/redis"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const usage = `Usage:
+  api-clients list
+  api-clients create -scopes SCOPES -sources SOURCES [-rate-limits LIMITS] [-cert-subject SUBJECT] NAME
+  api-clients rotate NAME
+  api-clients revoke NAME
+
+The scopes are excuses:read, excuses:write, users:act and admin. The
+sources are the IDs of the sources the client can access, or * for all of
+them. A client with users:act may send the X-Acting-User header. The rate
+limits override the ones of the sources for the client, such as
+read:1000,write:100. A client with a certificate subject, such as
+CN=billing,O=Acme, may authenticate with a client certificate of this
+subject signed by TLS_CLIENT_CA_FILE.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.WarnLevel)
+	ctx := logger.ToCtx(context.Background(), log)
+
+	config, err := config.Lookup()
+	if err != nil {
+		fail(errors.Wrap(err, "fail to load environment"))
+	}
+	redisClient, err := redisCtr.Client(config)
+	if err != nil {
+		fail(errors.Wrap(err, "fail to init redis client"))
+	}
+	store := &models.RedisStoreAPIClients{Client: redisClient}
+	audit := &models.RedisStoreAudit{Client: redisClient}
+
+	switch os.Args[1] {
+	case "list":
+		err = list(ctx, store)
+	case "create":
+		err = create(ctx, store, audit, os.Args[2:])
+	case "rotate":
+		err = rotate(ctx, store, audit, os.Args[2:])
+	case "revoke":
+		err = revoke(ctx, store, audit, os.Args[2:])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func list(ctx context.Context, store *models.RedisStoreAPIClients) error {
+	clients, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSCOPES\tSOURCES\tRATE LIMITS\tCREATED\tROTATED")
+	for _, client := range clients {
+		rotated := "-"
+		if client.RotatedAt != nil {
+			rotated = client.RotatedAt.Format("2006-01-02 15:04")
+		}
+		limits := []string{}
+		for _, class := range models.RateLimitClasses {
+			if limit, ok := client.RateLimits[class]; ok {
+				limits = append(limits, fmt.Sprintf("%s:%d", class, limit))
+			}
+		}
+		if len(limits) == 0 {
+			limits = append(limits, "-")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", client.Name,
+			strings.Join(client.Scopes, ","), strings.Join(client.Sources, ","),
+			strings.Join(limits, ","), client.CreatedAt.Format("2006-01-02 15:04"), rotated)
+	}
+	return w.Flush()
+}
+
+func create(ctx context.Context, store *models.RedisStoreAPIClients, audit *models.RedisStoreAudit, args []string) error {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	scopes := flags.String("scopes", models.ScopeExcusesRead, "comma separated scopes of the client")
+	sources := flags.String("sources", "", "comma separated sources the client can access, * for all")
+	rateLimits := flags.String("rate-limits", "", "comma separated rate limits of the client by route class, such as write:10")
+	certSubject := flags.String("cert-subject", "", "subject of the client certificates of the client, such as CN=billing,O=Acme")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("create takes the name of the client")
+	}
+	if strings.Contains(flags.Arg(0), ":") {
+		return errors.New("the name of a client is a Basic Auth user name, it cannot contain ':'")
+	}
+
+	client := &models.APIClient{
+		Name:        flags.Arg(0),
+		Scopes:      splitList(*scopes),
+		Sources:     splitList(*sources),
+		CertSubject: strings.TrimSpace(*certSubject),
+	}
+	for _, scope := range client.Scopes {
+		if !isScope(scope) {
+			return fmt.Errorf("unknown scope '%s', must be one of %s", scope, strings.Join(models.Scopes, ", "))
+		}
+	}
+	if len(client.Sources) == 0 {
+		return errors.New("the client must be allowed at least one source")
+	}
+	limits, err := parseRateLimits(*rateLimits)
+	if err != nil {
+		return err
+	}
+	if len(limits) > 0 {
+		client.RateLimits = limits
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return err
+	}
+	err = store.Create(ctx, client, secret)
+	if err != nil {
+		return err
+	}
+	recordAudit(ctx, audit, models.AuditClientCreated, client.Name)
+	fmt.Printf("Created %s, its secret is only shown once:\n%s\n", client.Name, secret)
+	return nil
+}
+
+func rotate(ctx context.Context, store *models.RedisStoreAPIClients, audit *models.RedisStoreAudit, args []string) error {
+	if len(args) != 1 {
+		return errors.New("rotate takes the name of the client")
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return err
+	}
+	found, err := store.Rotate(ctx, args[0], secret)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no client named %s", args[0])
+	}
+	recordAudit(ctx, audit, models.AuditClientRotated, args[0])
+	fmt.Printf("Rotated the secret of %s, the new one is only shown once:\n%s\n", args[0], secret)
+	return nil
+}
+
+func revoke(ctx context.Context, store *models.RedisStoreAPIClients, audit *models.RedisStoreAudit, args []string) error {
+	if len(args) != 1 {
+		return errors.New("revoke takes the name of the client")
+	}
+
+	found, err := store.Revoke(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no client named %s", args[0])
+	}
+	recordAudit(ctx, audit, models.AuditClientRevoked, args[0])
+	fmt.Printf("Revoked %s\n", args[0])
+	return nil
+}
+
+// recordAudit records a change of client in the global audit log, on behalf
+// of the user running the command. A failure does not undo the change.
+func recordAudit(ctx context.Context, audit *models.RedisStoreAudit, action, name string) {
+	actingUser := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		actingUser = u.Username
+	}
+	err := audit.Record(ctx, models.AuditRecord{
+		Action:     action,
+		Client:     "api-clients",
+		ActingUser: actingUser,
+		APIClient:  name,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning: fail to record the change in the audit log:", err)
+	}
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseRateLimits(list string) (models.RateLimits, error) {
+	limits := models.RateLimits{}
+	for _, item := range splitList(list) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || !isRateLimitClass(parts[0]) {
+			return nil, fmt.Errorf("invalid rate limit '%s', must be CLASS:LIMIT with a class among %s",
+				item, strings.Join(models.RateLimitClasses, ", "))
+		}
+		limit, err := strconv.Atoi(parts[1])
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid rate limit '%s', the limit must be a positive integer", item)
+		}
+		limits[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+func isRateLimitClass(class string) bool {
+	for _, c := range models.RateLimitClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+func isScope(scope string) bool {
+	for _, s := range models.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to generate secret")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
@@ -23,9 +23,108 @@ type Config struct {
 	RedisScanSize    int64  `envconfig:"REDIS_SCAN_SIZE" default:"10"`
 	ContextTimeout   int    `envconfig:"CONTEXT_TIMEOUT" default:"20"`
 
+	// Timeouts of the web server, in seconds. On SIGTERM, the requests in
+	// progress and the background workers are given the grace period to end.
+	HttpReadTimeout     int `envconfig:"HTTP_READ_TIMEOUT" default:"30"`
+	HttpWriteTimeout    int `envconfig:"HTTP_WRITE_TIMEOUT" default:"60"`
+	HttpIdleTimeout     int `envconfig:"HTTP_IDLE_TIMEOUT" default:"120"`
+	ShutdownGracePeriod int `envconfig:"SHUTDOWN_GRACE_PERIOD" default:"30"`
+
+	// Time, in seconds, each check of /health/ready has to complete
+	HealthCheckTimeout int `envconfig:"HEALTH_CHECK_TIMEOUT" default:"2"`
+
 	// Worker concurrency
 	RedisEntriesPublishConcurrency int `envconfig:"REDIS_ENTRIES_PUBLISH_CONCURRENCY" default:"10"`
 	RedisEntriesCacheConcurrency   int `envconfig:"REDIS_ENTRIES_CACHE_CONCURRENCY" default:"10"`
+
+	// Websocket sessions, the ping period is in seconds
+	WebsocketPingPeriod          int   `envconfig:"WEBSOCKET_PING_PERIOD" default:"30"`
+	WebsocketMaxMessageSize      int64 `envconfig:"WEBSOCKET_MAX_MESSAGE_SIZE" default:"65536"`
+	WebsocketSendBufferSize      int   `envconfig:"WEBSOCKET_SEND_BUFFER_SIZE" default:"64"`
+	WebsocketMaxSubscriptions    int   `envconfig:"WEBSOCKET_MAX_SUBSCRIPTIONS" default:"20"`
+	WebsocketMaxInflightCommands int   `envconfig:"WEBSOCKET_MAX_INFLIGHT_COMMANDS" default:"8"`
+
+	// Outgoing webhooks, timeout and backoff are in seconds, poll interval in
+	// milliseconds
+	WebhookConcurrency  int `envconfig:"WEBHOOK_CONCURRENCY" default:"4"`
+	WebhookMaxAttempts  int `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	WebhookTimeout      int `envconfig:"WEBHOOK_TIMEOUT" default:"10"`
+	WebhookBackoffBase  int `envconfig:"WEBHOOK_BACKOFF_BASE" default:"5"`
+	WebhookPollInterval int `envconfig:"WEBHOOK_POLL_INTERVAL" default:"1000"`
+
+	// Hosts Docker Hub hook callbacks may target
+	DockerHubCallbackHosts []string `envconfig:"DOCKERHUB_CALLBACK_HOSTS" default:"registry.hub.docker.com"`
+
+	// Time, in hours, the responses of the requests made with an
+	// Idempotency-Key are replayed
+	IdempotencyTTL int `envconfig:"IDEMPOTENCY_TTL" default:"24"`
+
+	// Hex encoded public key of the Discord application, the interactions
+	// endpoint rejects every request when it is not set
+	DiscordPublicKey string `envconfig:"DISCORD_PUBLIC_KEY"`
+
+	// Signing secret of the Slack application, and the sources of the Slack
+	// teams or channels such as "T0123:guildID,C0456:other". A channel takes
+	// precedence over its team, the team ID is the source of unmapped teams.
+	SlackSigningSecret string            `envconfig:"SLACK_SIGNING_SECRET"`
+	SlackSources       map[string]string `envconfig:"SLACK_SOURCES"`
+
+	// Bearer JWTs are accepted alongside Basic Auth when the issuer and a JWKS
+	// URL or file are set. The claim listing the sources of the caller is
+	// configurable, the leeway and the JWKS refresh interval are in seconds.
+	JWTIssuer       string `envconfig:"JWT_ISSUER"`
+	JWTAudience     string `envconfig:"JWT_AUDIENCE" default:"hook-manager"`
+	JWTSourcesClaim string `envconfig:"JWT_SOURCES_CLAIM" default:"sources"`
+	JWTLeeway       int    `envconfig:"JWT_LEEWAY" default:"30"`
+	JWKSURL         string `envconfig:"JWKS_URL"`
+	JWKSFile        string `envconfig:"JWKS_FILE"`
+	JWKSRefresh     int    `envconfig:"JWKS_REFRESH" default:"3600"`
+
+	// Requests allowed per window, in seconds, to a client on a source by
+	// route class, and to an IP address before its authentication. The limits
+	// of the classes can be overridden per source and per client, 0 disables
+	// a limit.
+	RateLimitWindow int            `envconfig:"RATE_LIMIT_WINDOW" default:"60"`
+	RateLimits      map[string]int `envconfig:"RATE_LIMITS" default:"read:600,write:60,random:120"`
+	RateLimitIP     int            `envconfig:"RATE_LIMIT_IP" default:"1200"`
+
+	// The IP address of the callers, for the rate limits and the audit log, is
+	// read from X-Real-IP or X-Forwarded-For when the proxy headers are trusted
+	TrustProxyHeaders bool `envconfig:"TRUST_PROXY_HEADERS" default:"false"`
+
+	// The server listens with TLS when the certificate and its key are set,
+	// they are reloaded on SIGHUP. The client certificates signed by the CA
+	// bundle, when it is set, authenticate the API clients registered with
+	// their subject.
+	TLSCertFile     string `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile      string `envconfig:"TLS_KEY_FILE"`
+	TLSMinVersion   string `envconfig:"TLS_MIN_VERSION" default:"1.2"`
+	TLSClientCAFile string `envconfig:"TLS_CLIENT_CA_FILE"`
+
+	// Bearer token required to read /metrics, the metrics are not served when
+	// it is not set
+	MetricsToken string `envconfig:"METRICS_TOKEN"`
+
+	// Exporter of the traces: otlp sends them to the endpoint of the
+	// OTEL_EXPORTER_OTLP_* variables, stdout prints them. They are not
+	// recorded when it is not set. The ratio applies to the traces started
+	// here, the ones of the callers follow their sampling decision.
+	TracingExporter    string  `envconfig:"TRACING_EXPORTER"`
+	TracingSampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+
+	// Records kept, approximately, in the audit log of each source, 0 keeps
+	// all of them
+	AuditLogMaxLength int64 `envconfig:"AUDIT_LOG_MAX_LENGTH" default:"100000"`
+
+	// Time, in days, a deleted excuse stays in the trash
+	TrashRetention int `envconfig:"TRASH_RETENTION" default:"30"`
+
+	// Number of excuses in the Atom and RSS feeds
+	FeedSize int `envconfig:"FEED_SIZE" default:"50"`
+
+	// Key signing the CSRF tokens of the admin UI, a random one is generated
+	// at startup when it is not set
+	AdminCSRFKey string `envconfig:"ADMIN_CSRF_KEY"`
 }
 
 func Lookup() (Config, error) {
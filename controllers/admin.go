@@ -0,0 +1,584 @@
+package controllers
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxAdminFormSize is the maximal size of a form of the admin UI
+	maxAdminFormSize = 1024 * 1024
+
+	// adminListLimit is the number of excuses listed in the moderation queue
+	// and in the trash, adminSearchLimit the number of search results
+	adminListLimit   = 100
+	adminSearchLimit = 50
+
+	// adminCSP only allows the stylesheet of the admin UI, the pages work
+	// without any script
+	adminCSP = "default-src 'none'; style-src 'self'; img-src 'self'; " +
+		"form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+)
+
+//go:embed admin/templates admin/static
+var adminFS embed.FS
+
+// adminPages are the templates of admin/templates rendered in the layout
+var adminPages = []string{"sources", "excuses", "excuse", "moderation", "trash", "stats"}
+
+// adminNotices are the messages shown after a change, the redirection
+// following the change names them with the done parameter
+var adminNotices = map[string]string{
+	"created":   "The excuse has been added.",
+	"updated":   "The excuse has been updated.",
+	"deleted":   "The excuse has been moved to the trash.",
+	"dismissed": "The reports of the excuse have been dismissed.",
+	"restored":  "The excuse has been restored.",
+	"purged":    "The excuse has been deleted for good.",
+}
+
+var adminFuncs = template.FuncMap{
+	"pathEscape": url.PathEscape,
+	"date": func(t time.Time) string {
+		return t.UTC().Format("2006-01-02 15:04 UTC")
+	},
+}
+
+// AdminController serves the admin UI. The pages are rendered on the server
+// and the changes are made with forms, protected against CSRF by Protect.
+type AdminController struct {
+	RedisStore *models.RedisStoreCodexcuses
+	// BasePath is the path the admin UI is mounted on
+	BasePath string
+	ScanSize int64
+	Static   http.Handler
+
+	csrf      csrfSigner
+	templates map[string]*template.Template
+}
+
+// adminPage is the data shared by the pages of the admin UI
+type adminPage struct {
+	Base      string
+	Title     string
+	Source    string
+	Section   string
+	User      string
+	CSRFToken string
+	Notice    string
+}
+
+type sourceSummary struct {
+	Name    string
+	Excuses int64
+}
+
+type sourcesPage struct {
+	adminPage
+	Sources []sourceSummary
+}
+
+type excusesPage struct {
+	adminPage
+	Query   string
+	Excuses []models.Codexcuse
+	Meta    *models.Meta
+}
+
+type excusePage struct {
+	adminPage
+	Action string
+	Form   excuseForm
+	Errors []string
+}
+
+// excuseForm holds the fields of the form of an excuse
+type excuseForm struct {
+	ID           string
+	Title        string
+	Content      string
+	AuthorID     string
+	AuthorName   string
+	ReporterID   string
+	ReporterName string
+}
+
+type moderationPage struct {
+	adminPage
+	Excuses []models.ReportedExcuse
+}
+
+type trashPage struct {
+	adminPage
+	Excuses       []models.TrashedExcuse
+	RetentionDays int
+}
+
+type statsPage struct {
+	adminPage
+	Stats models.CodexcuseStats
+}
+
+func NewAdminController(redisClient *redis.Client, config config.Config, basePath string) (AdminController, error) {
+	ctrl := AdminController{
+		RedisStore: &models.RedisStoreCodexcuses{Client: redisClient},
+		BasePath:   basePath,
+		ScanSize:   config.RedisScanSize,
+		templates:  map[string]*template.Template{},
+	}
+
+	csrf, err := newCSRFSigner(config.AdminCSRFKey)
+	if err != nil {
+		return ctrl, errors.Wrap(err, "fail to generate CSRF key")
+	}
+	ctrl.csrf = csrf
+
+	for _, page := range adminPages {
+		tmpl, err := template.New(page).Funcs(adminFuncs).ParseFS(adminFS,
+			"admin/templates/layout.html", "admin/templates/"+page+".html")
+		if err != nil {
+			return ctrl, errors.Wrap(err, "fail to parse admin template "+page)
+		}
+		ctrl.templates[page] = tmpl
+	}
+
+	static, err := fs.Sub(adminFS, "admin/static")
+	if err != nil {
+		return ctrl, errors.Wrap(err, "fail to get admin static assets")
+	}
+	ctrl.Static = http.StripPrefix(basePath+"/static/", http.FileServer(http.FS(static)))
+	return ctrl, nil
+}
+
+// Protect sets the security headers of the admin UI and rejects the forms
+// posted without a valid CSRF token or from another origin
+func (c AdminController) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log := logger.Get(r.Context())
+
+		w.Header().Set("Content-Security-Policy", adminCSP)
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "same-origin")
+
+		if r.Method == http.MethodPost {
+			r.Body = http.MaxBytesReader(w, r.Body, maxAdminFormSize)
+			user := models.APIClientFromContext(r.Context()).Name
+			if !sameOrigin(r) || !c.csrf.Valid(user, r.PostFormValue(csrfField), time.Now()) {
+				log.WithField("function", "Protect").Infoln("invalid CSRF token on", r.URL.Path)
+				http.Error(w, "The form has expired or comes from another site, reload the page and try again.", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Sources lists the sources having excuses which the client can access
+func (c AdminController) Sources(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminSources").WithField("path", r.URL.Path).Debug("received")
+
+	// The form opening a source, which may not have any excuse yet
+	if source := strings.TrimSpace(r.URL.Query().Get("source")); source != "" {
+		http.Redirect(w, r, c.sourcePath(source), http.StatusSeeOther)
+		return
+	}
+
+	sources, err := c.RedisStore.Sources(ctx, c.ScanSize)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to list sources"))
+		return
+	}
+	page := sourcesPage{
+		adminPage: c.page(r, "Sources", "", ""),
+		Sources:   make([]sourceSummary, 0, len(sources)),
+	}
+	client := models.APIClientFromContext(ctx)
+	for _, source := range sources {
+		if !client.CanAccess(source) {
+			continue
+		}
+		count, err := c.RedisStore.Count(ctx, source)
+		if err != nil {
+			c.internalError(w, r, errors.Wrap(err, "fail to count excuses"))
+			return
+		}
+		page.Sources = append(page.Sources, sourceSummary{Name: source, Excuses: count})
+	}
+	c.render(w, r, http.StatusOK, "sources", page)
+}
+
+// Excuses pages through the excuses of a source, or searches them with the q
+// parameter
+func (c AdminController) Excuses(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminExcuses").WithField("path", r.URL.Path).Debug("received")
+	source := mux.Vars(r)["source"]
+
+	page := excusesPage{
+		adminPage: c.page(r, "Excuses", source, "excuses"),
+		Query:     strings.TrimSpace(r.URL.Query().Get("q")),
+	}
+	if page.Query != "" {
+		excuses, err := c.RedisStore.Search(ctx, source, page.Query, adminSearchLimit)
+		if err != nil {
+			c.internalError(w, r, errors.Wrap(err, "fail to search excuses"))
+			return
+		}
+		page.Excuses = excuses
+		c.render(w, r, http.StatusOK, "excuses", page)
+		return
+	}
+
+	pageNumber, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || pageNumber < 1 {
+		pageNumber = 1
+	}
+	meta, err := c.RedisStore.GetAll(ctx, source, pageNumber, &page.Excuses)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to get excuses"))
+		return
+	}
+	page.Meta = &meta
+	c.render(w, r, http.StatusOK, "excuses", page)
+}
+
+// NewExcuse shows the form adding an excuse
+func (c AdminController) NewExcuse(w http.ResponseWriter, r *http.Request) {
+	log := logger.Get(r.Context())
+
+	log.WithField("function", "AdminNewExcuse").WithField("path", r.URL.Path).Debug("received")
+	source := mux.Vars(r)["source"]
+
+	c.render(w, r, http.StatusOK, "excuse", excusePage{
+		adminPage: c.page(r, "New excuse", source, "excuses"),
+		Action:    c.sourcePath(source) + "/excuses",
+	})
+}
+
+// CreateExcuse adds the excuse of the form
+func (c AdminController) CreateExcuse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminCreateExcuse").WithField("path", r.URL.Path).Debug("received")
+	source := mux.Vars(r)["source"]
+
+	form := parseExcuseForm(r)
+	excuse := form.excuse()
+	retErrors := validateExcuse(excuse)
+	if retErrors != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, "excuse", excusePage{
+			adminPage: c.page(r, "New excuse", source, "excuses"),
+			Action:    c.sourcePath(source) + "/excuses",
+			Form:      form,
+			Errors:    retErrors,
+		})
+		return
+	}
+
+	err := c.RedisStore.Add(ctx, source, &excuse)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to add excuse"))
+		return
+	}
+	c.redirect(w, r, c.sourcePath(source), "created")
+}
+
+// EditExcuse shows the form editing an excuse
+func (c AdminController) EditExcuse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminEditExcuse").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+
+	excuse, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to get excuse"))
+		return
+	}
+	if excuse == nil {
+		http.Error(w, "Excuse not found", http.StatusNotFound)
+		return
+	}
+
+	c.render(w, r, http.StatusOK, "excuse", excusePage{
+		adminPage: c.page(r, "Edit excuse", vars["source"], "excuses"),
+		Action:    c.excusePath(vars["source"], vars["id"]),
+		Form:      newExcuseForm(*excuse),
+	})
+}
+
+// UpdateExcuse saves the changes of the form of an excuse
+func (c AdminController) UpdateExcuse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminUpdateExcuse").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+
+	form := parseExcuseForm(r)
+	form.ID = vars["id"]
+	excuse := form.excuse()
+	retErrors := validateExcuse(excuse)
+	if retErrors != nil {
+		c.render(w, r, http.StatusUnprocessableEntity, "excuse", excusePage{
+			adminPage: c.page(r, "Edit excuse", vars["source"], "excuses"),
+			Action:    c.excusePath(vars["source"], vars["id"]),
+			Form:      form,
+			Errors:    retErrors,
+		})
+		return
+	}
+
+	found, err := c.RedisStore.Update(ctx, vars["source"], excuse)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to update excuse"))
+		return
+	}
+	if !found {
+		http.Error(w, "Excuse not found", http.StatusNotFound)
+		return
+	}
+	c.redirect(w, r, c.sourcePath(vars["source"]), "updated")
+}
+
+// DeleteExcuse moves an excuse to the trash. The form of the moderation queue
+// sets from to get back to it.
+func (c AdminController) DeleteExcuse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminDeleteExcuse").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+
+	err := c.RedisStore.Delete(ctx, vars["source"], vars["id"])
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to delete excuse"))
+		return
+	}
+
+	back := c.sourcePath(vars["source"])
+	if r.PostFormValue("from") == "moderation" {
+		back += "/moderation"
+	}
+	c.redirect(w, r, back, "deleted")
+}
+
+// Moderation lists the reported excuses of a source
+func (c AdminController) Moderation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminModeration").WithField("path", r.URL.Path).Debug("received")
+	source := mux.Vars(r)["source"]
+
+	excuses, err := c.RedisStore.Reported(ctx, source, adminListLimit)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to get reported excuses"))
+		return
+	}
+	c.render(w, r, http.StatusOK, "moderation", moderationPage{
+		adminPage: c.page(r, "Moderation queue", source, "moderation"),
+		Excuses:   excuses,
+	})
+}
+
+// DismissReports keeps a reported excuse and empties its reports
+func (c AdminController) DismissReports(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminDismissReports").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+
+	_, err := c.RedisStore.DismissReports(ctx, vars["source"], vars["id"])
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to dismiss reports"))
+		return
+	}
+	c.redirect(w, r, c.sourcePath(vars["source"])+"/moderation", "dismissed")
+}
+
+// Trash lists the deleted excuses of a source
+func (c AdminController) Trash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminTrash").WithField("path", r.URL.Path).Debug("received")
+	source := mux.Vars(r)["source"]
+
+	excuses, err := c.RedisStore.Trash(ctx, source, adminListLimit)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to get trash"))
+		return
+	}
+	c.render(w, r, http.StatusOK, "trash", trashPage{
+		adminPage:     c.page(r, "Trash", source, "trash"),
+		Excuses:       excuses,
+		RetentionDays: int(models.TrashRetention / (24 * time.Hour)),
+	})
+}
+
+// RestoreExcuse moves an excuse back from the trash
+func (c AdminController) RestoreExcuse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminRestoreExcuse").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.Restore(ctx, vars["source"], vars["id"])
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to restore excuse"))
+		return
+	}
+	if !found {
+		http.Error(w, "Excuse not found in the trash", http.StatusNotFound)
+		return
+	}
+	c.redirect(w, r, c.sourcePath(vars["source"])+"/trash", "restored")
+}
+
+// PurgeExcuse deletes an excuse of the trash for good
+func (c AdminController) PurgeExcuse(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminPurgeExcuse").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+
+	_, err := c.RedisStore.Purge(ctx, vars["source"], vars["id"])
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to purge excuse"))
+		return
+	}
+	c.redirect(w, r, c.sourcePath(vars["source"])+"/trash", "purged")
+}
+
+// Stats shows the figures of a source
+func (c AdminController) Stats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AdminStats").WithField("path", r.URL.Path).Debug("received")
+	source := mux.Vars(r)["source"]
+
+	stats, err := c.RedisStore.Stats(ctx, source)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to get stats"))
+		return
+	}
+	c.render(w, r, http.StatusOK, "stats", statsPage{
+		adminPage: c.page(r, "Stats", source, "stats"),
+		Stats:     stats,
+	})
+}
+
+func (c AdminController) page(r *http.Request, title, source, section string) adminPage {
+	user := models.APIClientFromContext(r.Context()).Name
+	return adminPage{
+		Base:      c.BasePath,
+		Title:     title,
+		Source:    source,
+		Section:   section,
+		User:      user,
+		CSRFToken: c.csrf.Token(user, time.Now()),
+		Notice:    adminNotices[r.URL.Query().Get("done")],
+	}
+}
+
+// render executes the template of a page in a buffer, so that a failing
+// template does not send half of a page
+func (c AdminController) render(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	var buf bytes.Buffer
+	err := c.templates[name].ExecuteTemplate(&buf, "layout", data)
+	if err != nil {
+		c.internalError(w, r, errors.Wrap(err, "fail to render "+name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// redirect answers a form with a redirection to path, which shows the notice
+// named done
+func (c AdminController) redirect(w http.ResponseWriter, r *http.Request, path, done string) {
+	http.Redirect(w, r, path+"?done="+done, http.StatusSeeOther)
+}
+
+func (c AdminController) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	log := logger.Get(r.Context())
+
+	log.Error(err)
+	http.Error(w, "Internal error", http.StatusInternalServerError)
+}
+
+func (c AdminController) sourcePath(source string) string {
+	return c.BasePath + "/sources/" + url.PathEscape(source)
+}
+
+func (c AdminController) excusePath(source, id string) string {
+	return c.sourcePath(source) + "/excuses/" + url.PathEscape(id)
+}
+
+func parseExcuseForm(r *http.Request) excuseForm {
+	return excuseForm{
+		Title:        strings.TrimSpace(r.PostFormValue("title")),
+		Content:      strings.TrimSpace(r.PostFormValue("content")),
+		AuthorID:     strings.TrimSpace(r.PostFormValue("author_id")),
+		AuthorName:   strings.TrimSpace(r.PostFormValue("author_name")),
+		ReporterID:   strings.TrimSpace(r.PostFormValue("reporter_id")),
+		ReporterName: strings.TrimSpace(r.PostFormValue("reporter_name")),
+	}
+}
+
+func newExcuseForm(excuse models.Codexcuse) excuseForm {
+	form := excuseForm{
+		ID:      excuse.ID,
+		Title:   excuse.Title,
+		Content: excuse.Content,
+	}
+	if excuse.Author != nil {
+		form.AuthorID = excuse.Author.ID
+		form.AuthorName = excuse.Author.UserName
+	}
+	if excuse.Reporter != nil {
+		form.ReporterID = excuse.Reporter.ID
+		form.ReporterName = excuse.Reporter.UserName
+	}
+	return form
+}
+
+func (f excuseForm) excuse() models.Codexcuse {
+	return models.Codexcuse{
+		ID:       f.ID,
+		Title:    f.Title,
+		Content:  f.Content,
+		Author:   &models.User{ID: f.AuthorID, UserName: f.AuthorName},
+		Reporter: &models.User{ID: f.ReporterID, UserName: f.ReporterName},
+	}
+}
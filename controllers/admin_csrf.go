@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// csrfField is the form field holding the CSRF token
+	csrfField = "csrf_token"
+	// csrfTokenTTL is the time a page of the admin UI can be submitted
+	csrfTokenTTL = 12 * time.Hour
+)
+
+// csrfSigner signs the CSRF tokens of the admin UI. A token is the time it was
+// made and the HMAC of this time and of the user, so that no state is kept.
+type csrfSigner struct {
+	key []byte
+}
+
+func newCSRFSigner(key string) (csrfSigner, error) {
+	if key != "" {
+		return csrfSigner{key: []byte(key)}, nil
+	}
+	random := make([]byte, 32)
+	_, err := rand.Read(random)
+	if err != nil {
+		return csrfSigner{}, err
+	}
+	return csrfSigner{key: random}, nil
+}
+
+// Token returns a token for user, valid for csrfTokenTTL
+func (s csrfSigner) Token(user string, now time.Time) string {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return ts + "." + s.sign(user, ts)
+}
+
+// Valid checks that token was made for user and has not expired
+func (s csrfSigner) Valid(user, token string, now time.Time) bool {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(ts, 0))
+	if age < 0 || age > csrfTokenTTL {
+		return false
+	}
+	return hmac.Equal([]byte(parts[1]), []byte(s.sign(user, parts[0])))
+}
+
+func (s csrfSigner) sign(user, ts string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(user + "\x00" + ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sameOrigin checks that a form was submitted from a page of this host, with
+// the Origin header or else the Referer. Browsers send at least one of them
+// on a POST of a form.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "null" {
+		return false
+	}
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == r.Host
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestCSRFSigner(t *testing.T) {
+	now := time.Now()
+	signer, _ := newCSRFSigner("key")
+	other, _ := newCSRFSigner("other")
+	token := signer.Token("alice", now)
+
+	tests := []struct {
+		name   string
+		signer csrfSigner
+		user   string
+		token  string
+		at     time.Time
+		valid  bool
+	}{
+		{"valid", signer, "alice", token, now, true},
+		{"before expiry", signer, "alice", token, now.Add(csrfTokenTTL - time.Minute), true},
+		{"expired", signer, "alice", token, now.Add(csrfTokenTTL + time.Minute), false},
+		{"made in the future", signer, "alice", token, now.Add(-time.Minute), false},
+		{"other user", signer, "bob", token, now, false},
+		{"other key", other, "alice", token, now, false},
+		{"tampered time", signer, "alice", "1" + token, now, false},
+		{"no signature", signer, "alice", strings.SplitN(token, ".", 2)[0], now, false},
+		{"empty", signer, "alice", "", now, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.signer.Valid(test.user, test.token, test.at); got != test.valid {
+				t.Errorf("got %v, want %v", got, test.valid)
+			}
+		})
+	}
+
+	random, err := newCSRFSigner("")
+	if err != nil || random.Valid("alice", token, now) {
+		t.Errorf("got %v, the token is valid with a random key", err)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		referer string
+		same    bool
+	}{
+		{"origin", "https://example.com", "", true},
+		{"referer", "", "https://example.com/admin/sources/guild", true},
+		{"origin over referer", "https://evil.com", "https://example.com/admin", false},
+		{"other origin", "https://evil.com", "", false},
+		{"other port", "https://example.com:8443", "", false},
+		{"null origin", "null", "https://example.com/admin", false},
+		{"neither", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "https://example.com/admin", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.referer != "" {
+				r.Header.Set("Referer", test.referer)
+			}
+			if got := sameOrigin(r); got != test.same {
+				t.Errorf("got %v, want %v", got, test.same)
+			}
+		})
+	}
+}
+
+// newAdminRouter serves the admin UI to the admin named by the X-Client header
+func newAdminRouter(t *testing.T) (http.Handler, AdminController) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	ctrl, err := NewAdminController(redisClient, config.Config{AdminCSRFKey: "key", RedisScanSize: 10}, "/admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter().PathPrefix("/admin").Subrouter()
+	router.Use(ctrl.Protect)
+	router.HandleFunc("/sources/{source}/excuses", ctrl.CreateExcuse).Methods("POST")
+	router.HandleFunc("/sources/{source}/excuses/new", ctrl.NewExcuse).Methods("GET")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := &models.APIClient{Name: r.Header.Get("X-Client"), Scopes: []string{models.ScopeAdmin}}
+		router.ServeHTTP(w, r.WithContext(models.WithAPIClient(r.Context(), client)))
+	}), ctrl
+}
+
+func postAdminForm(handler http.Handler, user, origin string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "http://example.com/admin/sources/guild/excuses", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Client", user)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestAdminProtect(t *testing.T) {
+	handler, ctrl := newAdminRouter(t)
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/admin/sources/guild/excuses/new", nil)
+	r.Header.Set("X-Client", "alice")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got %d for the form, want 200", recorder.Code)
+	}
+	for _, header := range []string{"Content-Security-Policy", "X-Frame-Options", "X-Content-Type-Options", "Referrer-Policy"} {
+		if recorder.Header().Get(header) == "" {
+			t.Errorf("missing the %s header", header)
+		}
+	}
+	match := csrfInput.FindStringSubmatch(recorder.Body.String())
+	if match == nil {
+		t.Fatal("no CSRF token in the form")
+	}
+
+	form := url.Values{
+		"title": {"t"}, "content": {"c"}, "author_name": {"a"}, "reporter_id": {"1"}, "reporter_name": {"r"},
+	}
+	tests := []struct {
+		name   string
+		user   string
+		origin string
+		token  string
+		status int
+	}{
+		{"no token", "alice", "http://example.com", "", http.StatusForbidden},
+		{"invalid token", "alice", "http://example.com", "123.abc", http.StatusForbidden},
+		{"token of another admin", "bob", "http://example.com", match[1], http.StatusForbidden},
+		{"other origin", "alice", "https://evil.com", match[1], http.StatusForbidden},
+		{"no origin", "alice", "", match[1], http.StatusForbidden},
+		{"valid", "alice", "http://example.com", match[1], http.StatusSeeOther},
+	}
+	for _, test := range tests {
+		form.Set(csrfField, test.token)
+		if got := postAdminForm(handler, test.user, test.origin, form).Code; got != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.status)
+		}
+	}
+
+	count, err := ctrl.RedisStore.Count(context.Background(), "guild")
+	if err != nil || count != 1 {
+		t.Fatalf("got %d excuses, %v, want only the one of the valid form", count, err)
+	}
+}
@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+type AuditController struct {
+	RedisStore *models.RedisStoreAudit
+}
+
+func NewAuditController(redisClient *redis.Client) AuditController {
+	return AuditController{
+		RedisStore: &models.RedisStoreAudit{Client: redisClient},
+	}
+}
+
+type auditResp struct {
+	Records []models.AuditRecord `json:"records"`
+	// Next is the cursor of the next page, to give in the before parameter
+	Next *string `json:"next"`
+}
+
+// GetAuditLog lists the audit log of a source, newest first. It is filtered
+// by the action, client, acting_user, excuse_id, since and until query
+// parameters and paginated with limit and before. With format=ndjson or an
+// Accept header of application/x-ndjson, every matching record is exported
+// instead, oldest first, one JSON object per line.
+func (c AuditController) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	c.serveAuditLog(w, r, mux.Vars(r)["source"])
+}
+
+// GetGlobalAuditLog lists the audit log of the changes of the API clients,
+// like GetAuditLog. It is only readable by the clients of every source.
+func (c AuditController) GetGlobalAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !models.APIClientFromContext(r.Context()).CanAccess(models.AllSources) {
+		w.Header().Set("Content-Type", "application/json")
+		forbidden(w, "all_sources_required", "the client must have access to every source")
+		return
+	}
+	c.serveAuditLog(w, r, "")
+}
+
+func (c AuditController) serveAuditLog(w http.ResponseWriter, r *http.Request, source string) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetAuditLog").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	query := r.URL.Query()
+
+	filter := models.AuditFilter{
+		Action:     query.Get("action"),
+		Client:     query.Get("client"),
+		ActingUser: query.Get("acting_user"),
+		ExcuseID:   query.Get("excuse_id"),
+		Before:     query.Get("before"),
+		Limit:      defaultAuditLimit,
+	}
+
+	var retErrors []string
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			retErrors = append(retErrors, "limit must be an integer between 1 and "+strconv.Itoa(maxAuditLimit))
+		}
+		filter.Limit = limit
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			retErrors = append(retErrors, "since must be an RFC 3339 date")
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			retErrors = append(retErrors, "until must be an RFC 3339 date")
+		}
+		filter.Until = t
+	}
+	if retErrors != nil {
+		invalidArguments(w, retErrors)
+		return
+	}
+
+	if query.Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		c.exportAuditLog(w, r, source, filter)
+		return
+	}
+
+	records, err := c.RedisStore.List(ctx, source, filter)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get audit log"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp := auditResp{
+		Records: records,
+	}
+	if len(records) == filter.Limit {
+		resp.Next = &records[len(records)-1].ID
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// exportAuditLog streams the records as NDJSON. Once the first record is
+// written, an error can only cut the export short.
+func (c AuditController) exportAuditLog(w http.ResponseWriter, r *http.Request, source string, filter models.AuditFilter) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	w.Header().Set("Content-Type", ndjsonContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	written := 0
+	err := c.RedisStore.Export(ctx, source, filter, func(record models.AuditRecord) error {
+		err := encoder.Encode(record)
+		if err != nil {
+			return err
+		}
+		written++
+		if flusher != nil && written%100 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to export audit log"))
+		if written == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Del("Content-Disposition")
+			resp := response{
+				Message: "Internal error",
+			}
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(resp)
+		}
+	}
+}
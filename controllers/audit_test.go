@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func newAuditRouter(t *testing.T, client *models.APIClient) (http.Handler, AuditController) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	ctrl := NewAuditController(redisClient)
+	router := mux.NewRouter()
+	router.HandleFunc("/sources/{source}/audit", ctrl.GetAuditLog).Methods("GET")
+	router.HandleFunc("/audit", ctrl.GetGlobalAuditLog).Methods("GET")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r.WithContext(models.WithAPIClient(r.Context(), client)))
+	}), ctrl
+}
+
+func TestGetAuditLog(t *testing.T) {
+	router, ctrl := newAuditRouter(t, &models.APIClient{Name: "bot", Sources: []string{"guild"}})
+	ctx := context.Background()
+	for _, action := range []string{models.AuditExcuseCreated, models.AuditExcuseDeleted, models.AuditExcuseCreated} {
+		err := ctrl.RedisStore.Record(ctx, models.AuditRecord{Action: action, Client: "bot", Source: "guild"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var page auditResp
+	recorder := serve(router, "GET", "/sources/guild/audit?action=excuse.created&limit=1", "")
+	json.NewDecoder(recorder.Body).Decode(&page)
+	if recorder.Code != http.StatusOK || len(page.Records) != 1 || page.Next == nil {
+		t.Fatalf("got %d %+v, want a page of 1 record with a cursor", recorder.Code, page)
+	}
+	next := *page.Next
+	page = auditResp{}
+	json.NewDecoder(serve(router, "GET", "/sources/guild/audit?action=excuse.created&limit=1&before="+next, "").Body).Decode(&page)
+	if len(page.Records) != 1 || page.Records[0].ID == next || page.Next == nil {
+		t.Fatalf("got %+v, want the second record", page)
+	}
+	next = *page.Next
+	page = auditResp{}
+	json.NewDecoder(serve(router, "GET", "/sources/guild/audit?action=excuse.created&limit=1&before="+next, "").Body).Decode(&page)
+	if len(page.Records) != 0 || page.Next != nil {
+		t.Errorf("got %+v past the last record, want an empty page", page)
+	}
+
+	recorder = serve(router, "GET", "/sources/guild/audit?format=ndjson", "")
+	if recorder.Header().Get("Content-Type") != ndjsonContentType {
+		t.Fatalf("got the Content-Type %s, want NDJSON", recorder.Header().Get("Content-Type"))
+	}
+	var actions []string
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		var record models.AuditRecord
+		json.Unmarshal(scanner.Bytes(), &record)
+		actions = append(actions, record.Action)
+	}
+	if len(actions) != 3 || actions[1] != models.AuditExcuseDeleted {
+		t.Errorf("got the export %v, want the 3 records oldest first", actions)
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "since=yesterday", "until=2024-01-01"} {
+		if got := serve(router, "GET", "/sources/guild/audit?"+query, "").Code; got != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got %d, want 422", query, got)
+		}
+	}
+	if got := serve(router, "GET", "/audit", "").Code; got != http.StatusForbidden {
+		t.Errorf("got %d for the global log, want 403 without access to every source", got)
+	}
+}
+
+func TestGetGlobalAuditLog(t *testing.T) {
+	router, ctrl := newAuditRouter(t, &models.APIClient{Name: "root", Sources: []string{models.AllSources}})
+	err := ctrl.RedisStore.Record(context.Background(), models.AuditRecord{Action: models.AuditClientCreated, Client: "root", APIClient: "bot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var page auditResp
+	recorder := serve(router, "GET", "/audit", "")
+	json.NewDecoder(recorder.Body).Decode(&page)
+	if recorder.Code != http.StatusOK || len(page.Records) != 1 || page.Records[0].APIClient != "bot" || page.Next != nil {
+		t.Errorf("got %d %+v, want the creation of the client", recorder.Code, page)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		trust    bool
+		remoteIP string
+		wantIP   string
+	}{
+		{"peer", nil, false, "192.0.2.1:1234", "192.0.2.1"},
+		{"untrusted proxy headers", map[string]string{"X-Real-IP": "203.0.113.7"}, false, "192.0.2.1:1234", "192.0.2.1"},
+		{"real IP", map[string]string{"X-Real-IP": "203.0.113.7", "X-Forwarded-For": "198.51.100.1"}, true, "192.0.2.1:1234", "203.0.113.7"},
+		{"last forwarded IP", map[string]string{"X-Forwarded-For": "10.0.0.1, 198.51.100.1"}, true, "192.0.2.1:1234", "198.51.100.1"},
+		{"no port", nil, false, "192.0.2.1", "192.0.2.1"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteIP
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		if got := ClientIP(r, test.trust); got != test.wantIP {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.wantIP)
+		}
+	}
+}
@@ -2,13 +2,12 @@ package controllers
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/Scalingo/go-utils/logger"
 	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 )
@@ -16,11 +15,13 @@ import (
 type ExcuseController struct {
 	Codexcuse  models.Codexcuse
 	RedisStore *models.RedisStoreCodexcuses
+	Moderators *models.RedisStoreModerators
 }
 
-func NewExcuseController() ExcuseController {
+func NewExcuseController(redisClient *redis.Client) ExcuseController {
 	return ExcuseController{
-		RedisStore: &models.RedisStoreCodexcuses{},
+		RedisStore: &models.RedisStoreCodexcuses{Client: redisClient},
+		Moderators: &models.RedisStoreModerators{Client: redisClient},
 	}
 }
 
@@ -33,6 +34,8 @@ var codexcuses []models.Codexcuse
 // response used to answer
 type response struct {
 	Message string `json:"message"`
+	// Code identifies the error for the clients, on some errors
+	Code string `json:"code,omitempty"`
 }
 
 type excuseResp struct {
@@ -45,7 +48,7 @@ func (c ExcuseController) GetExcuses(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := logger.Get(ctx)
 
-	log.WithField("function", "GetExcuses").Infoln("received on", r.URL.Path)
+	log.WithField("function", "GetExcuses").WithField("path", r.URL.Path).Debug("received")
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 
@@ -97,7 +100,7 @@ func (c ExcuseController) GetExcuse(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := logger.Get(ctx)
 
-	log.WithField("function", "GetExcuse").Infoln("received on", r.URL.Path)
+	log.WithField("function", "GetExcuse").WithField("path", r.URL.Path).Debug("received")
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 
@@ -128,7 +131,7 @@ func (c ExcuseController) getUserExcuses(w http.ResponseWriter, r *http.Request)
 	ctx := r.Context()
 	log := logger.Get(ctx)
 
-	log.WithField("function", "getUserExcuses").Infoln("received on", r.URL.Path)
+	log.WithField("function", "getUserExcuses").WithField("path", r.URL.Path).Debug("received")
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 
@@ -153,7 +156,7 @@ func (c ExcuseController) getRandomExcuse(w http.ResponseWriter, r *http.Request
 	ctx := r.Context()
 	log := logger.Get(ctx)
 
-	log.WithField("function", "getRandomExcuse").Infoln("received on", r.URL.Path)
+	log.WithField("function", "getRandomExcuse").WithField("path", r.URL.Path).Debug("received")
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 
@@ -176,48 +179,21 @@ func (c ExcuseController) AddExcuse(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := logger.Get(ctx)
 
-	log.WithField("function", "AddExcuse").Infoln("received on", r.URL.Path)
+	log.WithField("function", "AddExcuse").WithField("path", r.URL.Path).Debug("received")
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 
 	var excuse models.Codexcuse
 	_ = json.NewDecoder(r.Body).Decode(&excuse)
 
-	var retErrors []string
-	if excuse.Author == nil || excuse.Author.UserName == "" {
-		errorStr := "missing Author field"
-		log.Debugln("fail to save excuse", errorStr)
-		retErrors = append(retErrors, errorStr)
-	}
-	if excuse.Reporter == nil || excuse.Reporter.UserName == "" || excuse.Reporter.ID == "" {
-		errorStr := "missing reporter field"
-		log.Debugln("fail to save excuse", errorStr)
-		retErrors = append(retErrors, errorStr)
-	}
-	if excuse.Content == "" {
-		errorStr := "missing content field"
-		log.Debugln("fail to save excuse", errorStr)
-		retErrors = append(retErrors, errorStr)
-	}
-	if excuse.Title == "" {
-		errorStr := "missing title field"
-		log.Debugln("fail to save excuse", errorStr)
-		retErrors = append(retErrors, errorStr)
-	}
+	retErrors := validateExcuse(excuse)
 	if retErrors != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		errArray := make([]string, 0, len(retErrors))
-		for _, attrErrs := range retErrors {
-			errArray = append(errArray, fmt.Sprintf("\t→ %s", attrErrs))
-		}
-		resp := response{
-			Message: fmt.Sprintf("invalid arguments:\n%s", strings.Join(errArray, "\n")),
-		}
-		json.NewEncoder(w).Encode(resp)
+		log.Debugln("fail to save excuse", retErrors)
+		invalidArguments(w, retErrors)
 		return
 	}
 
-	err := c.RedisStore.Add(ctx, vars["source"], excuse)
+	err := c.RedisStore.Add(ctx, vars["source"], &excuse)
 	if err != nil {
 		log.Error(errors.Wrap(err, "fail to save excuse"))
 		resp := response{
@@ -239,11 +215,32 @@ func (c ExcuseController) DeleteExcuse(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	log := logger.Get(ctx)
 
-	log.WithField("function", "DeleteExcuse").Infoln("received on", r.URL.Path)
+	log.WithField("function", "DeleteExcuse").WithField("path", r.URL.Path).Debug("received")
 	w.Header().Set("Content-Type", "application/json")
 	vars := mux.Vars(r)
 
-	err := c.RedisStore.Delete(ctx, vars["source"], vars["id"])
+	actor, ok := c.actor(w, r)
+	if !ok {
+		return
+	}
+	excuse, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get excuse"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if excuse != nil {
+		if code := actor.Denied(*excuse, nil); code != "" {
+			forbidden(w, code, "the acting user may not delete this excuse")
+			return
+		}
+	}
+
+	err = c.RedisStore.Delete(ctx, vars["source"], vars["id"])
 	if err != nil {
 		log.Error(errors.Wrap(err, "fail to delete excuse: "+vars["id"]))
 		resp := response{
@@ -259,3 +256,57 @@ func (c ExcuseController) DeleteExcuse(w http.ResponseWriter, r *http.Request) {
 	}
 	json.NewEncoder(w).Encode(resp)
 }
+
+// actor returns the user on behalf of whom the excuses are changed. A client
+// without acting user needs the admin scope and moderates every source. It
+// answers 403 and returns false when the request may not change any excuse.
+func (c ExcuseController) actor(w http.ResponseWriter, r *http.Request) (models.Actor, bool) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	userID := models.ActingUserFromContext(ctx)
+	if userID == "" {
+		if !models.APIClientFromContext(ctx).HasScope(models.ScopeAdmin) {
+			forbidden(w, "acting_user_required", "the X-Acting-User header is required to change excuses")
+			return models.Actor{}, false
+		}
+		return models.Actor{Moderator: true}, true
+	}
+
+	moderator, err := c.Moderators.IsModerator(ctx, mux.Vars(r)["source"], userID)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get moderator"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return models.Actor{}, false
+	}
+	return models.Actor{UserID: userID, Moderator: moderator}, true
+}
+
+func forbidden(w http.ResponseWriter, code, message string) {
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(response{
+		Message: message,
+		Code:    code,
+	})
+}
+
+func validateExcuse(excuse models.Codexcuse) []string {
+	var retErrors []string
+	if excuse.Author == nil || excuse.Author.UserName == "" {
+		retErrors = append(retErrors, "missing Author field")
+	}
+	if excuse.Reporter == nil || excuse.Reporter.UserName == "" || excuse.Reporter.ID == "" {
+		retErrors = append(retErrors, "missing reporter field")
+	}
+	if excuse.Content == "" {
+		retErrors = append(retErrors, "missing content field")
+	}
+	if excuse.Title == "" {
+		retErrors = append(retErrors, "missing title field")
+	}
+	return retErrors
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	batchTransactional = "transactional"
+	batchBestEffort    = "best_effort"
+
+	maxBatchOperations = 100
+)
+
+type batchReq struct {
+	// Mode is transactional, the default, or best_effort
+	Mode       string                  `json:"mode"`
+	Operations []models.BatchOperation `json:"operations"`
+}
+
+type batchResp struct {
+	Committed bool                 `json:"committed"`
+	Results   []models.BatchResult `json:"results"`
+}
+
+// BatchExcuses runs a list of add, delete and patch operations on the excuses
+// of a source. A transactional batch is applied entirely or not at all, a
+// best-effort one skips the failing operations. The result of each operation
+// is given in the order of the request. The deletions and patches are limited
+// to the excuses of the acting user, unless it moderates the source.
+func (c ExcuseController) BatchExcuses(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "BatchExcuses").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	var req batchReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+	if req.Mode == "" {
+		req.Mode = batchTransactional
+	}
+
+	var retErrors []string
+	if req.Mode != batchTransactional && req.Mode != batchBestEffort {
+		retErrors = append(retErrors, fmt.Sprintf("mode must be %s or %s", batchTransactional, batchBestEffort))
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > maxBatchOperations {
+		retErrors = append(retErrors, fmt.Sprintf("operations must hold between 1 and %d operations", maxBatchOperations))
+	}
+	if retErrors != nil {
+		invalidArguments(w, retErrors)
+		return
+	}
+	transactional := req.Mode == batchTransactional
+
+	// Adding excuses does not need an acting user
+	var actor models.Actor
+	for _, op := range req.Operations {
+		if op.Op == models.BatchDelete || op.Op == models.BatchPatch {
+			var ok bool
+			actor, ok = c.actor(w, r)
+			if !ok {
+				return
+			}
+			break
+		}
+	}
+
+	// The operations are checked before running any of them, the invalid ones
+	// fail a transactional batch
+	results := make([]models.BatchResult, len(req.Operations))
+	valid := make([]models.BatchOperation, 0, len(req.Operations))
+	validIndexes := make([]int, 0, len(req.Operations))
+	refs := map[string]bool{}
+	for i, op := range req.Operations {
+		results[i] = models.BatchResult{Ref: op.Ref, Op: op.Op}
+		opErrors := validateBatchOperation(op, refs)
+		refs[op.Ref] = true
+		if opErrors != nil {
+			results[i].StatusCode = http.StatusUnprocessableEntity
+			results[i].Error = strings.Join(opErrors, ", ")
+			continue
+		}
+		valid = append(valid, op)
+		validIndexes = append(validIndexes, i)
+	}
+
+	if transactional && len(valid) != len(req.Operations) {
+		for _, i := range validIndexes {
+			results[i].StatusCode = http.StatusFailedDependency
+			results[i].Error = "not run as another operation failed"
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(batchResp{
+			Results: results,
+		})
+		return
+	}
+
+	committed := true
+	if len(valid) > 0 {
+		var validResults []models.BatchResult
+		var err error
+		validResults, committed, err = c.RedisStore.Batch(ctx, vars["source"], valid, transactional, actor)
+		if err == models.ErrConcurrentChange {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(response{
+				Message: "the excuses changed during the batch, it can be retried",
+			})
+			return
+		}
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to run batch"))
+			resp := response{
+				Message: "Internal error",
+			}
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		for j, i := range validIndexes {
+			results[i] = validResults[j]
+		}
+	}
+
+	status := 200
+	if !committed {
+		status = http.StatusUnprocessableEntity
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(batchResp{
+		Committed: committed,
+		Results:   results,
+	})
+}
+
+func validateBatchOperation(op models.BatchOperation, refs map[string]bool) []string {
+	var retErrors []string
+	if op.Ref == "" {
+		retErrors = append(retErrors, "missing ref field")
+	} else if refs[op.Ref] {
+		retErrors = append(retErrors, fmt.Sprintf("ref '%s' is used by another operation", op.Ref))
+	}
+
+	switch op.Op {
+	case models.BatchAdd:
+		if op.ID != "" {
+			retErrors = append(retErrors, "the ID of an added excuse is generated")
+		}
+		if op.Excuse == nil {
+			retErrors = append(retErrors, "missing excuse field")
+		} else {
+			retErrors = append(retErrors, validateExcuse(*op.Excuse)...)
+		}
+	case models.BatchDelete:
+		if op.ID == "" {
+			retErrors = append(retErrors, "missing id field")
+		}
+	case models.BatchPatch:
+		if op.ID == "" {
+			retErrors = append(retErrors, "missing id field")
+		}
+		if op.Patch == nil {
+			retErrors = append(retErrors, "missing patch field")
+			break
+		}
+		p := op.Patch
+		if p.Title == nil && p.Content == nil && p.Author == nil && p.Reporter == nil {
+			retErrors = append(retErrors, "patch must change at least one field")
+		}
+		if p.Title != nil && *p.Title == "" {
+			retErrors = append(retErrors, "title must not be empty")
+		}
+		if p.Content != nil && *p.Content == "" {
+			retErrors = append(retErrors, "content must not be empty")
+		}
+		if p.Author != nil && p.Author.UserName == "" {
+			retErrors = append(retErrors, "missing Author field")
+		}
+		if p.Reporter != nil && (p.Reporter.UserName == "" || p.Reporter.ID == "") {
+			retErrors = append(retErrors, "missing reporter field")
+		}
+	default:
+		retErrors = append(retErrors, fmt.Sprintf("unknown op '%s', must be one of %s, %s, %s",
+			op.Op, models.BatchAdd, models.BatchDelete, models.BatchPatch))
+	}
+	return retErrors
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+// runBatch posts a batch on behalf of the acting user, the client only has
+// the excuses scopes
+func runBatch(t *testing.T, ctrl ExcuseController, actingUser, body string) (int, batchResp) {
+	t.Helper()
+	router := mux.NewRouter()
+	router.HandleFunc("/codexcuses/{source}/batch", ctrl.BatchExcuses).Methods("POST")
+
+	req := httptest.NewRequest(http.MethodPost, "/codexcuses/guild/batch", strings.NewReader(body))
+	ctx := models.WithAPIClient(req.Context(), &models.APIClient{Name: "bot", Scopes: []string{models.ScopeExcusesWrite}})
+	if actingUser != "" {
+		ctx = models.WithActingUser(ctx, actingUser)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req.WithContext(ctx))
+
+	var resp batchResp
+	json.NewDecoder(recorder.Body).Decode(&resp)
+	return recorder.Code, resp
+}
+
+func statuses(results []models.BatchResult) []int {
+	codes := make([]int, 0, len(results))
+	for _, result := range results {
+		codes = append(codes, result.StatusCode)
+	}
+	return codes
+}
+
+const batchExcuse = `{"title": "t", "content": "c", "author": {"username": "a"}, "reporter": {"id": "1", "username": "r"}}`
+
+func newBatchController(t *testing.T) (ExcuseController, models.Codexcuse) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	ctrl := NewExcuseController(redisClient)
+	excuse := models.Codexcuse{Title: "mine", Content: "c", Author: &models.User{UserName: "a"}, Reporter: &models.User{ID: "1", UserName: "r"}}
+	err := ctrl.RedisStore.Add(context.Background(), "guild", &excuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctrl, excuse
+}
+
+func countExcuses(t *testing.T, ctrl ExcuseController) int64 {
+	t.Helper()
+	count, err := ctrl.RedisStore.Count(context.Background(), "guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+// storedTitle returns the title of the stored excuse, empty once deleted
+func storedTitle(t *testing.T, ctrl ExcuseController, id string) string {
+	t.Helper()
+	excuse, err := ctrl.RedisStore.Get(context.Background(), "guild", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if excuse == nil {
+		return ""
+	}
+	return excuse.Title
+}
+
+func TestBatchTransactional(t *testing.T) {
+	ctrl, excuse := newBatchController(t)
+
+	// A failing operation cancels the ones before and after it
+	status, resp := runBatch(t, ctrl, "1", `{"operations": [
+		{"ref": "a", "op": "add", "excuse": `+batchExcuse+`},
+		{"ref": "b", "op": "delete", "id": "unknown"},
+		{"ref": "c", "op": "patch", "id": "`+excuse.ID+`", "patch": {"title": "patched"}}]}`)
+	if status != http.StatusUnprocessableEntity || resp.Committed || fmt.Sprint(statuses(resp.Results)) != "[424 404 424]" {
+		t.Fatalf("got %d %+v, want the batch rolled back", status, resp)
+	}
+	if title, count := storedTitle(t, ctrl, excuse.ID), countExcuses(t, ctrl); title != "mine" || count != 1 {
+		t.Fatalf("got the title %q and %d excuses, want nothing changed", title, count)
+	}
+
+	// So does an invalid one, before anything runs
+	status, resp = runBatch(t, ctrl, "1", `{"operations": [
+		{"ref": "a", "op": "add", "excuse": `+batchExcuse+`},
+		{"ref": "a", "op": "delete", "id": "`+excuse.ID+`"}]}`)
+	if status != http.StatusUnprocessableEntity || fmt.Sprint(statuses(resp.Results)) != "[424 422]" || countExcuses(t, ctrl) != 1 {
+		t.Fatalf("got %d %+v, want the batch refused", status, resp)
+	}
+
+	// And an operation the acting user may not make
+	status, resp = runBatch(t, ctrl, "2", `{"operations": [{"ref": "a", "op": "delete", "id": "`+excuse.ID+`"}]}`)
+	if status != http.StatusUnprocessableEntity || resp.Results[0].StatusCode != http.StatusForbidden || resp.Results[0].Code != models.DeniedNotOwner {
+		t.Fatalf("got %d %+v, want the deletion forbidden", status, resp)
+	}
+
+	status, resp = runBatch(t, ctrl, "1", `{"mode": "transactional", "operations": [
+		{"ref": "a", "op": "add", "excuse": `+batchExcuse+`},
+		{"ref": "b", "op": "patch", "id": "`+excuse.ID+`", "patch": {"title": "patched"}}]}`)
+	if status != http.StatusOK || !resp.Committed || fmt.Sprint(statuses(resp.Results)) != "[201 200]" {
+		t.Fatalf("got %d %+v, want the batch committed", status, resp)
+	}
+	if resp.Results[0].Ref != "a" || resp.Results[0].Excuse == nil || resp.Results[0].Excuse.ID == "" {
+		t.Errorf("got the result %+v, want the added excuse", resp.Results[0])
+	}
+	if title, count := storedTitle(t, ctrl, excuse.ID), countExcuses(t, ctrl); title != "patched" || count != 2 {
+		t.Errorf("got the title %q and %d excuses, want the batch applied", title, count)
+	}
+}
+
+func TestBatchBestEffort(t *testing.T) {
+	ctrl, excuse := newBatchController(t)
+
+	status, resp := runBatch(t, ctrl, "1", `{"mode": "best_effort", "operations": [
+		{"ref": "a", "op": "add", "excuse": `+batchExcuse+`},
+		{"ref": "b", "op": "delete", "id": "unknown"},
+		{"ref": "c", "op": "add", "excuse": {"title": "t"}},
+		{"ref": "d", "op": "delete", "id": "`+excuse.ID+`"}]}`)
+	if status != http.StatusOK || !resp.Committed || fmt.Sprint(statuses(resp.Results)) != "[201 404 422 200]" {
+		t.Fatalf("got %d %+v, want the valid operations applied", status, resp)
+	}
+	if title, count := storedTitle(t, ctrl, excuse.ID), countExcuses(t, ctrl); title != "" || count != 1 {
+		t.Errorf("got the title %q and %d excuses, want it deleted and one added", title, count)
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	ctrl, excuse := newBatchController(t)
+	tooMany := strings.Repeat(`{"ref": "x", "op": "delete", "id": "x"},`, maxBatchOperations+1)
+
+	tests := []struct {
+		name       string
+		actingUser string
+		body       string
+		status     int
+	}{
+		{"unknown mode", "1", `{"mode": "eventual", "operations": [{"ref": "a", "op": "add", "excuse": ` + batchExcuse + `}]}`, http.StatusUnprocessableEntity},
+		{"no operations", "1", `{"operations": []}`, http.StatusUnprocessableEntity},
+		{"too many operations", "1", `{"operations": [` + strings.TrimSuffix(tooMany, ",") + `]}`, http.StatusUnprocessableEntity},
+		{"deletion without acting user", "", `{"operations": [{"ref": "a", "op": "delete", "id": "` + excuse.ID + `"}]}`, http.StatusForbidden},
+		{"addition without acting user", "", `{"operations": [{"ref": "a", "op": "add", "excuse": ` + batchExcuse + `}]}`, http.StatusOK},
+	}
+	for _, test := range tests {
+		if status, resp := runBatch(t, ctrl, test.actingUser, test.body); status != test.status {
+			t.Errorf("%s: got %d %+v, want %d", test.name, status, resp, test.status)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func TestDeleteExcuseOwnership(t *testing.T) {
+	tests := []struct {
+		name       string
+		actingUser string
+		scopes     []string
+		moderator  bool
+		status     int
+		code       string
+	}{
+		{"author", "1", []string{models.ScopeExcusesWrite}, false, http.StatusOK, ""},
+		{"reporter", "2", []string{models.ScopeExcusesWrite}, false, http.StatusOK, ""},
+		{"other user", "3", []string{models.ScopeExcusesWrite}, false, http.StatusForbidden, models.DeniedNotOwner},
+		{"moderator", "3", []string{models.ScopeExcusesWrite}, true, http.StatusOK, ""},
+		{"client without acting user", "", []string{models.ScopeExcusesWrite}, false, http.StatusForbidden, "acting_user_required"},
+		{"admin without acting user", "", []string{models.ScopeAdmin}, false, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, redisClient := newTestRedis(t)
+			ctrl := NewExcuseController(redisClient)
+			ctx := context.Background()
+			excuse := models.Codexcuse{Title: "t", Content: "c", Author: &models.User{ID: "1", UserName: "a"}, Reporter: &models.User{ID: "2", UserName: "r"}}
+			err := ctrl.RedisStore.Add(ctx, "guild", &excuse)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.moderator {
+				_, err = ctrl.Moderators.Add(ctx, "guild", test.actingUser)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			router := mux.NewRouter()
+			router.HandleFunc("/codexcuses/{source}/{id}", ctrl.DeleteExcuse).Methods("DELETE")
+			r := httptest.NewRequest(http.MethodDelete, "/codexcuses/guild/"+excuse.ID, nil)
+			reqCtx := models.WithAPIClient(r.Context(), &models.APIClient{Name: "bot", Scopes: test.scopes})
+			if test.actingUser != "" {
+				reqCtx = models.WithActingUser(reqCtx, test.actingUser)
+			}
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, r.WithContext(reqCtx))
+
+			var resp response
+			json.NewDecoder(recorder.Body).Decode(&resp)
+			if recorder.Code != test.status || resp.Code != test.code {
+				t.Fatalf("got %d %+v, want %d with the code %q", recorder.Code, resp, test.status, test.code)
+			}
+			stored, _ := ctrl.RedisStore.Get(ctx, "guild", excuse.ID)
+			if (stored == nil) != (test.status == http.StatusOK) {
+				t.Errorf("got the stored excuse %+v, want it deleted only on success", stored)
+			}
+		})
+	}
+}
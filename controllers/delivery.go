@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/hooks"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultDeliveriesLimit = 50
+	maxDeliveriesLimit     = 500
+)
+
+type DeliveryController struct {
+	RedisStore *models.RedisStoreDeliveryLog
+	Hooks      *models.RedisStoreHooks
+	Router     *hooks.Router
+}
+
+func NewDeliveryController(redisClient *redis.Client) DeliveryController {
+	return DeliveryController{
+		RedisStore: &models.RedisStoreDeliveryLog{Client: redisClient},
+		Hooks:      &models.RedisStoreHooks{Client: redisClient},
+		Router:     hooks.NewRouter(redisClient),
+	}
+}
+
+type deliveriesResp struct {
+	Deliveries []models.DeliveryLogEntry `json:"deliveries"`
+	// Next is the cursor of the next page, to give in the before parameter
+	Next *string `json:"next"`
+}
+
+type replayResp struct {
+	Message      string `json:"message"`
+	MatchedRules int    `json:"matched_rules"`
+}
+
+// GetDeliveries lists the delivery log of a source, newest first. It is
+// filtered by the direction, provider, event_id, delivery_id, status, since
+// and until query parameters and paginated with limit and before.
+func (c DeliveryController) GetDeliveries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetDeliveries").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	filter := models.DeliveryLogFilter{
+		Direction:  query.Get("direction"),
+		Provider:   query.Get("provider"),
+		EventID:    query.Get("event_id"),
+		DeliveryID: query.Get("delivery_id"),
+		Status:     query.Get("status"),
+		Before:     query.Get("before"),
+		Limit:      defaultDeliveriesLimit,
+	}
+
+	var retErrors []string
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxDeliveriesLimit {
+			retErrors = append(retErrors, "limit must be an integer between 1 and "+strconv.Itoa(maxDeliveriesLimit))
+		}
+		filter.Limit = limit
+	}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			retErrors = append(retErrors, "since must be an RFC 3339 date")
+		}
+		filter.Since = t
+	}
+	if until := query.Get("until"); until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			retErrors = append(retErrors, "until must be an RFC 3339 date")
+		}
+		filter.Until = t
+	}
+	if retErrors != nil {
+		invalidArguments(w, retErrors)
+		return
+	}
+
+	entries, err := c.RedisStore.List(ctx, vars["source"], filter)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get deliveries"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp := deliveriesResp{
+		Deliveries: entries,
+	}
+	if len(entries) == filter.Limit {
+		resp.Next = &entries[len(entries)-1].ID
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetDelivery gives the delivery log entry with some ID
+func (c DeliveryController) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetDelivery").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	entry, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get delivery"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if entry == nil {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// ReplayDelivery runs the routing rules of the source again for the event of
+// an incoming delivery
+func (c DeliveryController) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "ReplayDelivery").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	entry, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get delivery"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if entry == nil {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if entry.Direction != models.DeliveryIncoming || entry.EventID == "" {
+		resp := response{
+			Message: "only the accepted incoming hooks can be replayed",
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	event, err := c.Hooks.Get(ctx, vars["source"], entry.EventID)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get hook event"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if event == nil {
+		resp := response{
+			Message: "event is not stored anymore",
+		}
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	matched, err := c.Router.Route(ctx, vars["source"], *event)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to route hook event"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(202)
+	json.NewEncoder(w).Encode(replayResp{
+		Message:      "ok",
+		MatchedRules: matched,
+	})
+}
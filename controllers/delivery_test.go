@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/hooks"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func newDeliveryRouter(ctrl DeliveryController) *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/sources/{source}/deliveries", ctrl.GetDeliveries).Methods("GET")
+	router.HandleFunc("/sources/{source}/deliveries/{id}", ctrl.GetDelivery).Methods("GET")
+	router.HandleFunc("/sources/{source}/deliveries/{id}/replay", ctrl.ReplayDelivery).Methods("POST")
+	return router
+}
+
+func TestDeliveries(t *testing.T) {
+	hookCtrl, hookRouter := newHookRouter(t, config.Config{})
+	setHookConfig(t, hookCtrl, "guild", models.HookConfig{Provider: models.HookProviderGitLab, Secret: "t0k3n"})
+	ctrl := NewDeliveryController(hookCtrl.RedisStore.Client)
+	router := newDeliveryRouter(ctrl)
+
+	ctx := context.Background()
+	err := ctrl.Router.Rules.Save(ctx, "guild", &models.HookRule{
+		Targets: []models.HookTarget{{Type: models.HookTargetChannel, Channel: "ci"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"object_kind": "push", "ref": "refs/heads/main", "project": {"path_with_namespace": "g/p"}}`
+	postHook(hookRouter, "/hooks/gitlab/guild", `{`, map[string]string{hooks.GitLabTokenHeader: "t0k3n", hooks.GitLabEventUUIDHeader: "u0"})
+	postHook(hookRouter, "/hooks/gitlab/guild", body, map[string]string{hooks.GitLabTokenHeader: "t0k3n", hooks.GitLabEventUUIDHeader: "u1"})
+	err = ctrl.RedisStore.Record(ctx, "guild", models.DeliveryLogEntry{
+		Direction: models.DeliveryOutgoing, DeliveryID: "d1", URL: "https://example.com/hook", StatusCode: 503, At: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(query string) deliveriesResp {
+		t.Helper()
+		recorder := serve(router, "GET", "/sources/guild/deliveries"+query, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s, want 200", query, recorder.Code, recorder.Body)
+		}
+		var resp deliveriesResp
+		json.NewDecoder(recorder.Body).Decode(&resp)
+		return resp
+	}
+
+	all := list("")
+	if len(all.Deliveries) != 3 || all.Next != nil {
+		t.Fatalf("got %d deliveries and the next page %v, want 3 on a single page", len(all.Deliveries), all.Next)
+	}
+	if all.Deliveries[0].Direction != models.DeliveryOutgoing {
+		t.Errorf("got the %s delivery first, want the newest one", all.Deliveries[0].Direction)
+	}
+	if got := list("?direction=incoming&status=2xx").Deliveries; len(got) != 1 || got[0].EventID != "u1" {
+		t.Errorf("got %+v, want the accepted push", got)
+	}
+	if got := list("?status=5xx").Deliveries; len(got) != 1 || got[0].DeliveryID != "d1" {
+		t.Errorf("got %+v, want the failed outgoing delivery", got)
+	}
+	page := list("?limit=2")
+	if len(page.Deliveries) != 2 || page.Next == nil {
+		t.Fatalf("got %d deliveries and the next page %v, want 2 and a next page", len(page.Deliveries), page.Next)
+	}
+	if got := list("?limit=2&before=" + *page.Next).Deliveries; len(got) != 1 || got[0].ID != all.Deliveries[2].ID {
+		t.Errorf("got %+v on the second page, want the oldest delivery", got)
+	}
+	for _, query := range []string{"?limit=0", "?limit=501", "?since=yesterday"} {
+		if recorder := serve(router, "GET", "/sources/guild/deliveries"+query, ""); recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got %d, want 422", query, recorder.Code)
+		}
+	}
+
+	push := list("?event_id=u1").Deliveries[0]
+	recorder := serve(router, "POST", "/sources/guild/deliveries/"+push.ID+"/replay", "")
+	var replay replayResp
+	json.NewDecoder(recorder.Body).Decode(&replay)
+	if recorder.Code != http.StatusAccepted || replay.MatchedRules != 1 {
+		t.Errorf("got %d %+v on the replay of the push, want 202 with the rule matched", recorder.Code, replay)
+	}
+	for id, want := range map[string]int{
+		all.Deliveries[0].ID: http.StatusUnprocessableEntity,
+		all.Deliveries[2].ID: http.StatusUnprocessableEntity,
+		"0-1":                http.StatusNotFound,
+	} {
+		if recorder := serve(router, "POST", "/sources/guild/deliveries/"+id+"/replay", ""); recorder.Code != want {
+			t.Errorf("replay of %s: got %d %s, want %d", id, recorder.Code, recorder.Body, want)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/discord"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxInteractionSize is the maximal size of an interaction body
+	maxInteractionSize = 1024 * 1024
+
+	excuseCommand = "excuse"
+
+	// addExcuseModal prefixes the custom ID of the modal of /excuse add, it is
+	// followed by the ID and the username of the author
+	addExcuseModal = "excuse_add"
+
+	// The custom IDs of the buttons of an excuse, upvote and report are
+	// followed by the ID of the excuse
+	anotherExcuseButton = "excuse_another"
+	upvoteExcuseButton  = "excuse_upvote"
+	reportExcuseButton  = "excuse_report"
+
+	// maxEmbeds is the maximal number of embeds in a Discord message
+	maxEmbeds = 10
+
+	// maxChoices and maxChoiceLength are the limits of Discord on the choices
+	// of an autocomplete
+	maxChoices      = 25
+	maxChoiceLength = 100
+)
+
+type DiscordController struct {
+	RedisStore *models.RedisStoreCodexcuses
+	PublicKey  ed25519.PublicKey
+}
+
+// NewDiscordController returns an error when the configured public key is
+// invalid, the controller then rejects every interaction
+func NewDiscordController(redisClient *redis.Client, config config.Config) (DiscordController, error) {
+	ctrl := DiscordController{
+		RedisStore: &models.RedisStoreCodexcuses{Client: redisClient},
+	}
+	if config.DiscordPublicKey == "" {
+		return ctrl, nil
+	}
+
+	key, err := discord.ParsePublicKey(config.DiscordPublicKey)
+	if err != nil {
+		return ctrl, errors.Wrap(err, "invalid Discord public key")
+	}
+	ctrl.PublicKey = key
+	return ctrl, nil
+}
+
+// Interactions receives the interactions of Discord, authenticated by the
+// Ed25519 signature of the timestamp and the body. The guild of an
+// interaction is the source of its excuses.
+func (c DiscordController) Interactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Interactions").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxInteractionSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(response{
+			Message: "fail to read body",
+		})
+		return
+	}
+
+	signature := r.Header.Get(discord.SignatureHeader)
+	timestamp := r.Header.Get(discord.TimestampHeader)
+	if !discord.Verify(c.PublicKey, signature, timestamp, body) {
+		log.Debugln("invalid interaction signature")
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid request signature",
+		})
+		return
+	}
+
+	var interaction discord.Interaction
+	err = json.Unmarshal(body, &interaction)
+	if err != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid interaction",
+		})
+		return
+	}
+
+	var resp interface{}
+	switch interaction.Type {
+	case discord.InteractionPing:
+		resp = discord.InteractionResponse{Type: discord.ResponsePong}
+	case discord.InteractionApplicationCommand:
+		resp = c.command(r, interaction)
+	case discord.InteractionAutocomplete:
+		resp = c.autocomplete(r, interaction)
+	case discord.InteractionMessageComponent:
+		resp = c.component(r, interaction)
+	case discord.InteractionModalSubmit:
+		resp = c.modalSubmit(r, interaction)
+	default:
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: fmt.Sprintf("unsupported interaction type %d", interaction.Type),
+		})
+		return
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (c DiscordController) command(r *http.Request, interaction discord.Interaction) discord.InteractionResponse {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	if interaction.Data == nil || interaction.Data.Name != excuseCommand {
+		return ephemeral("Unknown command")
+	}
+	source := interaction.GuildID
+	if source == "" {
+		return ephemeral("Excuses can only be used in a server")
+	}
+
+	subcommand, options := interaction.Data.Subcommand()
+	log.Debugln("excuse subcommand:", subcommand)
+	switch subcommand {
+	case "random":
+		excuse, err := c.RedisStore.GetRandom(ctx, source)
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to get random excuse"))
+			return ephemeral("Internal error")
+		}
+		if excuse == nil {
+			return ephemeral("There is no excuse yet, add one with `/excuse add`")
+		}
+		return c.excuseCard(ctx, source, "", *excuse, discord.ResponseChannelMessageWithSource)
+
+	case "get":
+		id := discord.FindOption(options, "id")
+		if id == nil {
+			return ephemeral("Missing id option")
+		}
+		excuse, err := c.RedisStore.Get(ctx, source, id.StringValue())
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to get excuse"))
+			return ephemeral("Internal error")
+		}
+		if excuse == nil {
+			return ephemeral(fmt.Sprintf("No excuse with ID `%s`", id.StringValue()))
+		}
+		return c.excuseCard(ctx, source, "", *excuse, discord.ResponseChannelMessageWithSource)
+
+	case "by":
+		user := discord.FindOption(options, "user")
+		if user == nil {
+			return ephemeral("Missing user option")
+		}
+		userID := user.StringValue()
+		excuses, err := c.RedisStore.GetByUser(ctx, source, userID)
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to get excuses by user"))
+			return ephemeral("Internal error")
+		}
+		if len(*excuses) == 0 {
+			return ephemeral(fmt.Sprintf("<@%s> has no excuse yet", userID))
+		}
+
+		content := fmt.Sprintf("<@%s> has %d excuses", userID, len(*excuses))
+		if len(*excuses) > maxEmbeds {
+			content += fmt.Sprintf(", here are %d of them", maxEmbeds)
+			*excuses = (*excuses)[:maxEmbeds]
+		}
+		return excuseMessage(content, *excuses...)
+
+	case "add":
+		author := discord.FindOption(options, "author")
+		if author == nil {
+			return ephemeral("Missing author option")
+		}
+		authorID := author.StringValue()
+		authorName := authorID
+		if interaction.Data.Resolved != nil {
+			if u, ok := interaction.Data.Resolved.Users[authorID]; ok {
+				authorName = u.Username
+			}
+		}
+		return addExcuseModalResponse(authorID, authorName)
+	}
+	return ephemeral("Unknown subcommand")
+}
+
+func (c DiscordController) modalSubmit(r *http.Request, interaction discord.Interaction) discord.InteractionResponse {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	if interaction.Data == nil {
+		return ephemeral("Unknown modal")
+	}
+	parts := strings.SplitN(interaction.Data.CustomID, ":", 3)
+	if len(parts) != 3 || parts[0] != addExcuseModal {
+		return ephemeral("Unknown modal")
+	}
+	source := interaction.GuildID
+	reporter := interaction.Caller()
+	if source == "" || reporter == nil {
+		return ephemeral("Excuses can only be used in a server")
+	}
+
+	excuse := models.Codexcuse{
+		Title:   strings.TrimSpace(interaction.Data.TextInput("title")),
+		Content: strings.TrimSpace(interaction.Data.TextInput("content")),
+		Author: &models.User{
+			ID:       parts[1],
+			UserName: parts[2],
+		},
+		Reporter: &models.User{
+			ID:       reporter.ID,
+			UserName: reporter.Username,
+		},
+	}
+	if excuse.Title == "" || excuse.Content == "" {
+		return ephemeral("The title and the content of an excuse are required")
+	}
+
+	err := c.RedisStore.Add(ctx, source, &excuse)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save excuse"))
+		return ephemeral("Internal error")
+	}
+	return c.excuseCard(ctx, source, "Excuse added", excuse, discord.ResponseChannelMessageWithSource)
+}
+
+// autocomplete suggests the excuses by title for /excuse get, and their
+// authors by username for /excuse by
+func (c DiscordController) autocomplete(r *http.Request, interaction discord.Interaction) discord.AutocompleteResponse {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	resp := discord.AutocompleteResponse{
+		Type: discord.ResponseAutocompleteResult,
+		Data: discord.AutocompleteData{Choices: []discord.Choice{}},
+	}
+	source := interaction.GuildID
+	if interaction.Data == nil || interaction.Data.Name != excuseCommand || source == "" {
+		return resp
+	}
+
+	subcommand, options := interaction.Data.Subcommand()
+	focused := discord.FocusedOption(options)
+	if focused == nil {
+		return resp
+	}
+
+	var suggestions []models.Suggestion
+	var err error
+	switch {
+	case subcommand == "get" && focused.Name == "id":
+		suggestions, err = c.RedisStore.SuggestTitles(ctx, source, focused.StringValue(), maxChoices)
+	case subcommand == "by" && focused.Name == "user":
+		suggestions, err = c.RedisStore.SuggestAuthors(ctx, source, focused.StringValue(), maxChoices)
+	}
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get suggestions"))
+		return resp
+	}
+
+	for _, suggestion := range suggestions {
+		name := []rune(suggestion.Name)
+		if len(name) > maxChoiceLength {
+			name = name[:maxChoiceLength]
+		}
+		resp.Data.Choices = append(resp.Data.Choices, discord.Choice{
+			Name:  string(name),
+			Value: suggestion.ID,
+		})
+	}
+	return resp
+}
+
+// component handles the buttons of an excuse, routed on their custom ID
+func (c DiscordController) component(r *http.Request, interaction discord.Interaction) discord.InteractionResponse {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	source := interaction.GuildID
+	user := interaction.Caller()
+	if interaction.Data == nil || source == "" || user == nil {
+		return ephemeral("Excuses can only be used in a server")
+	}
+
+	parts := strings.SplitN(interaction.Data.CustomID, ":", 2)
+	action := parts[0]
+	log.Debugln("excuse component:", action)
+
+	if action == anotherExcuseButton {
+		excuse, err := c.RedisStore.GetRandom(ctx, source)
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to get random excuse"))
+			return ephemeral("Internal error")
+		}
+		if excuse == nil {
+			return ephemeral("There is no excuse yet, add one with `/excuse add`")
+		}
+		return c.excuseCard(ctx, source, "", *excuse, discord.ResponseChannelMessageWithSource)
+	}
+
+	if len(parts) != 2 || (action != upvoteExcuseButton && action != reportExcuseButton) {
+		return ephemeral("Unknown component")
+	}
+	excuse, err := c.RedisStore.Get(ctx, source, parts[1])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get excuse"))
+		return ephemeral("Internal error")
+	}
+	if excuse == nil {
+		return ephemeral("This excuse has been deleted")
+	}
+
+	if action == upvoteExcuseButton {
+		_, err = c.RedisStore.Upvote(ctx, source, excuse.ID, user.ID)
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to upvote excuse"))
+			return ephemeral("Internal error")
+		}
+		return c.excuseCard(ctx, source, "", *excuse, discord.ResponseUpdateMessage)
+	}
+
+	reported, err := c.RedisStore.Report(ctx, source, excuse, user.ID)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to report excuse"))
+		return ephemeral("Internal error")
+	}
+	if !reported {
+		return ephemeral("You already reported this excuse")
+	}
+	return ephemeral("Thanks, the excuse has been reported to the moderators")
+}
+
+func addExcuseModalResponse(authorID, authorName string) discord.InteractionResponse {
+	return discord.InteractionResponse{
+		Type: discord.ResponseModal,
+		Data: &discord.ResponseData{
+			CustomID: fmt.Sprintf("%s:%s:%s", addExcuseModal, authorID, authorName),
+			Title:    "New excuse of " + authorName,
+			Components: []discord.Component{{
+				Type: discord.ComponentActionRow,
+				Components: []discord.Component{{
+					Type:      discord.ComponentTextInput,
+					CustomID:  "title",
+					Label:     "Title",
+					Style:     discord.TextInputShort,
+					MaxLength: 100,
+					Required:  true,
+				}},
+			}, {
+				Type: discord.ComponentActionRow,
+				Components: []discord.Component{{
+					Type:      discord.ComponentTextInput,
+					CustomID:  "content",
+					Label:     "Excuse",
+					Style:     discord.TextInputParagraph,
+					MaxLength: 2000,
+					Required:  true,
+				}},
+			}},
+		},
+	}
+}
+
+// excuseCard is the message of a single excuse, with its upvotes and the
+// buttons to act on it
+func (c DiscordController) excuseCard(ctx context.Context, source, content string, excuse models.Codexcuse, responseType int) discord.InteractionResponse {
+	log := logger.Get(ctx)
+
+	resp := excuseMessage(content, excuse)
+	resp.Type = responseType
+
+	upvotes, err := c.RedisStore.Upvotes(ctx, source, excuse.ID)
+	if err != nil {
+		log.WithError(err).Error("fail to count upvotes")
+	} else if upvotes > 0 {
+		embed := &resp.Data.Embeds[0]
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: "Upvotes", Value: strconv.FormatInt(upvotes, 10), Inline: true,
+		})
+	}
+
+	resp.Data.Components = []discord.Component{{
+		Type: discord.ComponentActionRow,
+		Components: []discord.Component{{
+			Type:     discord.ComponentButton,
+			Style:    discord.ButtonPrimary,
+			Label:    "Another one",
+			CustomID: anotherExcuseButton,
+		}, {
+			Type:     discord.ComponentButton,
+			Style:    discord.ButtonSecondary,
+			Label:    "Upvote",
+			CustomID: upvoteExcuseButton + ":" + excuse.ID,
+		}, {
+			Type:     discord.ComponentButton,
+			Style:    discord.ButtonDanger,
+			Label:    "Report",
+			CustomID: reportExcuseButton + ":" + excuse.ID,
+		}},
+	}}
+	return resp
+}
+
+func excuseMessage(content string, excuses ...models.Codexcuse) discord.InteractionResponse {
+	embeds := make([]discord.Embed, 0, len(excuses))
+	for _, excuse := range excuses {
+		embeds = append(embeds, excuseEmbed(excuse))
+	}
+	return discord.InteractionResponse{
+		Type: discord.ResponseChannelMessageWithSource,
+		Data: &discord.ResponseData{
+			Content: content,
+			Embeds:  embeds,
+		},
+	}
+}
+
+func excuseEmbed(excuse models.Codexcuse) discord.Embed {
+	embed := discord.Embed{
+		Title:       excuse.Title,
+		Description: excuse.Content,
+		Footer: &discord.EmbedFooter{
+			Text: "ID: " + excuse.ID,
+		},
+	}
+	if excuse.Author != nil {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: "Author", Value: discordUser(*excuse.Author), Inline: true,
+		})
+	}
+	if excuse.Reporter != nil {
+		embed.Fields = append(embed.Fields, discord.EmbedField{
+			Name: "Reporter", Value: discordUser(*excuse.Reporter), Inline: true,
+		})
+	}
+	return embed
+}
+
+// discordUser mentions the user when its ID is known, the excuses added
+// through the API may only have a username
+func discordUser(user models.User) string {
+	if user.ID != "" {
+		return fmt.Sprintf("<@%s>", user.ID)
+	}
+	return user.UserName
+}
+
+func ephemeral(content string) discord.InteractionResponse {
+	return discord.InteractionResponse{
+		Type: discord.ResponseChannelMessageWithSource,
+		Data: &discord.ResponseData{
+			Content: content,
+			Flags:   discord.FlagEphemeral,
+		},
+	}
+}
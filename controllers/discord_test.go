@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/discord"
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+// newDiscordController returns a controller checking the interactions against
+// a locally generated key, and the key signing them
+func newDiscordController(t *testing.T) (DiscordController, ed25519.PrivateKey) {
+	t.Helper()
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, redisClient := newTestRedis(t)
+	ctrl, err := NewDiscordController(redisClient, config.Config{DiscordPublicKey: hex.EncodeToString(publicKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctrl, privateKey
+}
+
+func postInteraction(ctrl DiscordController, key ed25519.PrivateKey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/discord/interactions", strings.NewReader(body))
+	timestamp := "1625097600"
+	if key != nil {
+		req.Header.Set(discord.SignatureHeader, hex.EncodeToString(ed25519.Sign(key, []byte(timestamp+body))))
+		req.Header.Set(discord.TimestampHeader, timestamp)
+	}
+	recorder := httptest.NewRecorder()
+	ctrl.Interactions(recorder, req)
+	return recorder
+}
+
+// interact posts a signed interaction and decodes the response
+func interact(t *testing.T, ctrl DiscordController, key ed25519.PrivateKey, body string) discord.InteractionResponse {
+	t.Helper()
+	recorder := postInteraction(ctrl, key, body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s: got %d %s, want 200", body, recorder.Code, recorder.Body)
+	}
+	var resp discord.InteractionResponse
+	err := json.NewDecoder(recorder.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func excuseCommandBody(subcommand, options string) string {
+	return `{"type": 2, "guild_id": "guild", "member": {"user": {"id": "10", "username": "alice"}},
+		"data": {"name": "excuse", "options": [{"name": "` + subcommand + `", "type": 1, "options": [` + options + `]}],
+		"resolved": {"users": {"20": {"id": "20", "username": "bob"}}}}}`
+}
+
+func TestDiscordInteractionsSignature(t *testing.T) {
+	ctrl, key := newDiscordController(t)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name   string
+		ctrl   DiscordController
+		key    ed25519.PrivateKey
+		body   string
+		status int
+	}{
+		{"ping", ctrl, key, `{"type": 1}`, http.StatusOK},
+		{"unsigned", ctrl, nil, `{"type": 1}`, http.StatusUnauthorized},
+		{"other key", ctrl, otherKey, `{"type": 1}`, http.StatusUnauthorized},
+		{"no key configured", DiscordController{RedisStore: ctrl.RedisStore}, key, `{"type": 1}`, http.StatusUnauthorized},
+		{"invalid interaction", ctrl, key, `{"type": "ping"}`, http.StatusBadRequest},
+		{"unsupported type", ctrl, key, `{"type": 42}`, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		recorder := postInteraction(test.ctrl, test.key, test.body)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, recorder.Code, recorder.Body, test.status)
+		}
+	}
+
+	resp := interact(t, ctrl, key, `{"type": 1}`)
+	if resp.Type != discord.ResponsePong || resp.Data != nil {
+		t.Errorf("got %+v to a ping, want a pong", resp)
+	}
+
+	_, err := NewDiscordController(nil, config.Config{DiscordPublicKey: "zz"})
+	if err == nil {
+		t.Error("got no error with an invalid public key")
+	}
+}
+
+func TestDiscordExcuseCommands(t *testing.T) {
+	ctrl, key := newDiscordController(t)
+
+	resp := interact(t, ctrl, key, excuseCommandBody("random", ""))
+	if resp.Data.Flags != discord.FlagEphemeral || !strings.Contains(resp.Data.Content, "no excuse yet") {
+		t.Errorf("random without excuses: got %+v, want an ephemeral message", resp.Data)
+	}
+
+	// /excuse add opens a modal, the excuse is added once it is submitted
+	resp = interact(t, ctrl, key, excuseCommandBody("add", `{"name": "author", "type": 6, "value": "20"}`))
+	if resp.Type != discord.ResponseModal || resp.Data.CustomID != addExcuseModal+":20:bob" {
+		t.Fatalf("add: got %+v, want the modal of the excuse of bob", resp)
+	}
+	modal := func(title string) string {
+		return `{"type": 5, "guild_id": "guild", "member": {"user": {"id": "10", "username": "alice"}},
+			"data": {"custom_id": "` + addExcuseModal + `:20:bob", "components": [
+				{"type": 1, "components": [{"type": 4, "custom_id": "title", "value": "` + title + `"}]},
+				{"type": 1, "components": [{"type": 4, "custom_id": "content", "value": "It works on my machine"}]}]}}`
+	}
+	resp = interact(t, ctrl, key, modal(" "))
+	if resp.Data.Flags != discord.FlagEphemeral {
+		t.Errorf("modal without title: got %+v, want an ephemeral error", resp.Data)
+	}
+	resp = interact(t, ctrl, key, modal("Works for me"))
+	if resp.Data.Content != "Excuse added" || len(resp.Data.Embeds) != 1 {
+		t.Fatalf("modal: got %+v, want the excuse added", resp.Data)
+	}
+	embed := resp.Data.Embeds[0]
+	id := strings.TrimPrefix(embed.Footer.Text, "ID: ")
+	if embed.Title != "Works for me" || embed.Fields[0].Value != "<@20>" || embed.Fields[1].Value != "<@10>" {
+		t.Errorf("got the embed %+v, want the excuse of bob reported by alice", embed)
+	}
+
+	tests := []struct {
+		name  string
+		body  string
+		check func(discord.InteractionResponse) bool
+	}{
+		{"random", excuseCommandBody("random", ""), func(resp discord.InteractionResponse) bool {
+			return len(resp.Data.Embeds) == 1 && len(resp.Data.Components) == 1
+		}},
+		{"get", excuseCommandBody("get", `{"name": "id", "type": 3, "value": "`+id+`"}`), func(resp discord.InteractionResponse) bool {
+			return len(resp.Data.Embeds) == 1 && resp.Data.Embeds[0].Title == "Works for me"
+		}},
+		{"get unknown", excuseCommandBody("get", `{"name": "id", "type": 3, "value": "unknown"}`), func(resp discord.InteractionResponse) bool {
+			return resp.Data.Flags == discord.FlagEphemeral && strings.Contains(resp.Data.Content, "No excuse with ID")
+		}},
+		{"by", excuseCommandBody("by", `{"name": "user", "type": 6, "value": "20"}`), func(resp discord.InteractionResponse) bool {
+			return resp.Data.Content == "<@20> has 1 excuses" && len(resp.Data.Embeds) == 1
+		}},
+		{"by without excuses", excuseCommandBody("by", `{"name": "user", "type": 6, "value": "10"}`), func(resp discord.InteractionResponse) bool {
+			return resp.Data.Flags == discord.FlagEphemeral
+		}},
+		{"outside a server", `{"type": 2, "user": {"id": "10"}, "data": {"name": "excuse", "options": [{"name": "random", "type": 1}]}}`,
+			func(resp discord.InteractionResponse) bool {
+				return resp.Data.Flags == discord.FlagEphemeral && strings.Contains(resp.Data.Content, "only be used in a server")
+			}},
+		{"unknown command", `{"type": 2, "guild_id": "guild", "data": {"name": "other"}}`, func(resp discord.InteractionResponse) bool {
+			return resp.Data.Content == "Unknown command"
+		}},
+	}
+	for _, test := range tests {
+		resp := interact(t, ctrl, key, test.body)
+		if resp.Type != discord.ResponseChannelMessageWithSource || !test.check(resp) {
+			t.Errorf("%s: got %+v", test.name, resp.Data)
+		}
+	}
+}
+
+func addExcuses(t *testing.T, ctrl DiscordController, excuses ...models.Codexcuse) []models.Codexcuse {
+	t.Helper()
+	for i := range excuses {
+		err := ctrl.RedisStore.Add(context.Background(), "guild", &excuses[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return excuses
+}
+
+func autocomplete(t *testing.T, ctrl DiscordController, key ed25519.PrivateKey, subcommand, option, value string) []discord.Choice {
+	t.Helper()
+	body := `{"type": 4, "guild_id": "guild", "data": {"name": "excuse", "options": [{"name": "` + subcommand + `", "type": 1,
+		"options": [{"name": "` + option + `", "type": 3, "value": "` + value + `", "focused": true}]}]}}`
+	recorder := postInteraction(ctrl, key, body)
+	var resp discord.AutocompleteResponse
+	json.NewDecoder(recorder.Body).Decode(&resp)
+	if recorder.Code != http.StatusOK || resp.Type != discord.ResponseAutocompleteResult || resp.Data.Choices == nil {
+		t.Fatalf("got %d %+v, want autocomplete choices", recorder.Code, resp)
+	}
+	return resp.Data.Choices
+}
+
+func TestDiscordAutocomplete(t *testing.T) {
+	ctrl, key := newDiscordController(t)
+	long := strings.Repeat("é", maxChoiceLength+10)
+	excuses := addExcuses(t, ctrl,
+		models.Codexcuse{Title: "Works on my machine", Content: "c", Author: &models.User{ID: "1", UserName: "alice"}},
+		models.Codexcuse{Title: "Worked yesterday", Content: "c", Author: &models.User{ID: "2", UserName: "albert"}},
+		models.Codexcuse{Title: "Cache", Content: "c", Author: &models.User{ID: "3", UserName: "bob"}},
+		models.Codexcuse{Title: long, Content: "c", Author: &models.User{ID: "1", UserName: "alice"}},
+	)
+
+	choices := autocomplete(t, ctrl, key, "get", "id", "wor")
+	if len(choices) != 2 {
+		t.Fatalf("got the choices %+v, want the 2 titles starting with wor", choices)
+	}
+	for _, choice := range choices {
+		if choice.Value != excuses[0].ID && choice.Value != excuses[1].ID {
+			t.Errorf("got the choice %+v, want the ID of a matching excuse", choice)
+		}
+	}
+
+	choices = autocomplete(t, ctrl, key, "get", "id", "éé")
+	if len(choices) != 1 || []rune(choices[0].Name)[0] != 'é' || len([]rune(choices[0].Name)) != maxChoiceLength {
+		t.Errorf("got the choices %+v, want the long title cut to %d runes", choices, maxChoiceLength)
+	}
+
+	choices = autocomplete(t, ctrl, key, "by", "user", "al")
+	var names []string
+	for _, choice := range choices {
+		names = append(names, choice.Name)
+	}
+	if strings.Join(names, ",") != "albert,alice" {
+		t.Errorf("got the authors %v, want albert and alice", names)
+	}
+
+	if choices := autocomplete(t, ctrl, key, "get", "id", "nothing"); len(choices) != 0 {
+		t.Errorf("got the choices %+v, want none", choices)
+	}
+	if choices := autocomplete(t, ctrl, key, "random", "id", "wor"); len(choices) != 0 {
+		t.Errorf("got the choices %+v for another subcommand, want none", choices)
+	}
+}
+
+func TestDiscordComponents(t *testing.T) {
+	ctrl, key := newDiscordController(t)
+	excuse := addExcuses(t, ctrl, models.Codexcuse{Title: "t", Content: "c", Author: &models.User{ID: "1", UserName: "alice"}})[0]
+
+	click := func(userID, customID string) discord.InteractionResponse {
+		return interact(t, ctrl, key, `{"type": 3, "guild_id": "guild", "member": {"user": {"id": "`+userID+`"}},
+			"data": {"custom_id": "`+customID+`", "component_type": 2}}`)
+	}
+
+	resp := click("10", anotherExcuseButton)
+	if resp.Type != discord.ResponseChannelMessageWithSource || len(resp.Data.Embeds) != 1 {
+		t.Errorf("another one: got %+v, want an excuse", resp)
+	}
+
+	// An upvote updates the message with the count, once per user
+	for _, userID := range []string{"10", "10", "11"} {
+		resp = click(userID, upvoteExcuseButton+":"+excuse.ID)
+	}
+	if resp.Type != discord.ResponseUpdateMessage {
+		t.Fatalf("upvote: got %+v, want the message updated", resp)
+	}
+	fields := resp.Data.Embeds[0].Fields
+	if last := fields[len(fields)-1]; last.Name != "Upvotes" || last.Value != "2" {
+		t.Errorf("upvote: got the fields %+v, want 2 upvotes", fields)
+	}
+
+	resp = click("10", reportExcuseButton+":"+excuse.ID)
+	if resp.Data.Flags != discord.FlagEphemeral || !strings.Contains(resp.Data.Content, "reported to the moderators") {
+		t.Errorf("report: got %+v, want the report acknowledged", resp.Data)
+	}
+	resp = click("10", reportExcuseButton+":"+excuse.ID)
+	if !strings.Contains(resp.Data.Content, "already reported") {
+		t.Errorf("second report: got %+v, want it refused", resp.Data)
+	}
+
+	for customID, want := range map[string]string{
+		upvoteExcuseButton + ":unknown": "This excuse has been deleted",
+		upvoteExcuseButton:              "Unknown component",
+		"other:" + excuse.ID:            "Unknown component",
+	} {
+		if resp := click("10", customID); resp.Data.Content != want {
+			t.Errorf("%s: got %q, want %q", customID, resp.Data.Content, want)
+		}
+	}
+}
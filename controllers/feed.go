@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/feed"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+const (
+	feedFormatAtom = "atom"
+	feedFormatRSS  = "rss"
+)
+
+type FeedController struct {
+	RedisStore     *models.RedisStoreCodexcuses
+	FeedStore      *models.RedisStoreFeeds
+	PublicHostname string
+	Size           int64
+}
+
+func NewFeedController(redisClient *redis.Client, config config.Config) FeedController {
+	return FeedController{
+		RedisStore:     &models.RedisStoreCodexcuses{Client: redisClient},
+		FeedStore:      &models.RedisStoreFeeds{Client: redisClient},
+		PublicHostname: config.Public_hostname,
+		Size:           int64(config.FeedSize),
+	}
+}
+
+// Feed serves the newest excuses of a source as an Atom or RSS feed. A
+// private feed is read with the token of the source in the token parameter.
+// The ETag and Last-Modified headers let the readers make conditional
+// requests.
+func (c FeedController) Feed(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Feed").WithField("path", r.URL.Path).Debug("received")
+	vars := mux.Vars(r)
+	source := vars["source"]
+
+	config, err := c.FeedStore.GetConfig(ctx, source)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get feed config"))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	token := r.URL.Query().Get("token")
+	if config == nil || (!config.Public && token == "") {
+		http.Error(w, "feed not found", http.StatusNotFound)
+		return
+	}
+	if !config.Public && subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) != 1 {
+		http.Error(w, "invalid feed token", http.StatusForbidden)
+		return
+	}
+
+	excuses, err := c.RedisStore.Latest(ctx, source, c.Size)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get latest excuses"))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	f := feed.Feed{
+		ID:          "urn:codexcuses:source:" + url.PathEscape(source),
+		Title:       "Excuses of " + source,
+		Description: "The newest excuses of " + source,
+		Link:        c.selfLink(r),
+		Updated:     config.UpdatedAt,
+		Entries:     make([]feed.Entry, 0, len(excuses)),
+	}
+	for _, excuse := range excuses {
+		entry := feed.Entry{
+			ID:        "urn:uuid:" + excuse.ID,
+			Title:     excuse.Title,
+			Content:   excuse.Content,
+			Published: excuse.CreatedAt,
+		}
+		if excuse.Author != nil {
+			entry.Author = excuse.Author.UserName
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+	if len(excuses) > 0 && excuses[0].CreatedAt.After(f.Updated) {
+		f.Updated = excuses[0].CreatedAt
+	}
+
+	var body []byte
+	if vars["format"] == feedFormatAtom {
+		body, err = feed.Atom(f)
+		w.Header().Set("Content-Type", feed.AtomContentType)
+	} else {
+		body, err = feed.RSS(f)
+		w.Header().Set("Content-Type", feed.RSSContentType)
+	}
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to render feed"))
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// The ETag alone drives the conditional requests: the edits, deletions
+	// and restores don't move the updated time of the feed forward
+	sum := sha256.Sum256(body)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	if config.Public {
+		w.Header().Set("Cache-Control", "public, no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "private, no-cache")
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+}
+
+// GetFeedConfig returns the feed configuration of a source, without its token
+func (c FeedController) GetFeedConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetFeedConfig").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	config, err := c.FeedStore.GetConfig(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get feed config"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if config == nil {
+		resp := response{
+			Message: "feed not enabled",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	config.Token = ""
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(config)
+}
+
+// SetFeedConfig enables the feeds of a source. The token of the private feed
+// is generated on each call, which is the only one returning it.
+func (c FeedController) SetFeedConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SetFeedConfig").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	var config models.FeedConfig
+	_ = json.NewDecoder(r.Body).Decode(&config)
+
+	// The tokens chosen by the clients could be guessed
+	var err error
+	config.Token, err = generateSecret()
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to generate feed token"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = c.FeedStore.SetConfig(ctx, vars["source"], &config)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save feed config"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(config)
+}
+
+// DeleteFeedConfig disables the feeds of a source
+func (c FeedController) DeleteFeedConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteFeedConfig").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.FeedStore.DeleteConfig(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to delete feed config"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "feed not enabled",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// selfLink is the URL of the requested feed without its token, on the public
+// hostname when it is set
+func (c FeedController) selfLink(r *http.Request) string {
+	host := c.PublicHostname
+	if host == "" {
+		host = r.Host
+	}
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/") + r.URL.Path
+	}
+	scheme := "https"
+	if r.TLS == nil && r.Header.Get("X-Forwarded-Proto") != "https" {
+		scheme = "http"
+	}
+	return scheme + "://" + host + r.URL.Path
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func newFeedRouter(t *testing.T) (http.Handler, FeedController) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	ctrl := NewFeedController(redisClient, config.Config{FeedSize: 10, Public_hostname: "https://example.com"})
+	router := mux.NewRouter()
+	router.HandleFunc("/feeds/{source}.{format:atom|rss}", ctrl.Feed).Methods("GET", "HEAD")
+	return router, ctrl
+}
+
+func getFeed(router http.Handler, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, nil)
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestFeedConditionalGet(t *testing.T) {
+	router, ctrl := newFeedRouter(t)
+	ctx := context.Background()
+	err := ctrl.FeedStore.SetConfig(ctx, "guild", &models.FeedConfig{Public: true, Token: "t0k3n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	excuse := models.Codexcuse{Title: "t", Content: "c", Author: &models.User{UserName: "a"}, Reporter: &models.User{ID: "1", UserName: "r"}}
+	err = ctrl.RedisStore.Add(ctx, "guild", &excuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := getFeed(router, http.MethodGet, "/feeds/guild.atom", nil)
+	etag := recorder.Header().Get("ETag")
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with the ETag %q, want 200 with one", recorder.Code, etag)
+	}
+	if got := recorder.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("got the Last-Modified %q, want only the ETag", got)
+	}
+	if got := recorder.Header().Get("Cache-Control"); got != "public, no-cache" {
+		t.Errorf("got the Cache-Control %q, want public", got)
+	}
+	if rss := getFeed(router, http.MethodGet, "/feeds/guild.rss", nil); rss.Header().Get("ETag") == etag {
+		t.Error("the RSS feed has the ETag of the Atom one")
+	}
+
+	// The edits, deletions and restores keep the updated time of the feed,
+	// the dates are ignored
+	lastModified := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+	}{
+		{"same ETag", http.MethodGet, map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"one of the ETags", http.MethodGet, map[string]string{"If-None-Match": `"other", ` + etag}, http.StatusNotModified},
+		{"other ETag", http.MethodGet, map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{"only a date", http.MethodGet, map[string]string{"If-Modified-Since": lastModified}, http.StatusOK},
+		{"ETag and date", http.MethodGet, map[string]string{"If-None-Match": etag, "If-Modified-Since": lastModified}, http.StatusNotModified},
+		{"HEAD", http.MethodHead, map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+	}
+	for _, test := range tests {
+		if got := getFeed(router, test.method, "/feeds/guild.atom", test.headers).Code; got != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.status)
+		}
+	}
+
+	// An edit, a deletion and a restore keep the creation times but change the
+	// ETag
+	excuse.Title = "edited"
+	_, err = ctrl.RedisStore.Update(ctx, "guild", excuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag = changedETag(t, router, etag, "edit")
+	err = ctrl.RedisStore.Delete(ctx, "guild", excuse.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag = changedETag(t, router, etag, "deletion")
+	_, err = ctrl.RedisStore.Restore(ctx, "guild", excuse.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedETag(t, router, etag, "restore")
+}
+
+// changedETag checks that the Atom feed of guild changed since etag, and
+// returns its new ETag
+func changedETag(t *testing.T, router http.Handler, etag, change string) string {
+	t.Helper()
+	recorder := getFeed(router, http.MethodGet, "/feeds/guild.atom", map[string]string{"If-None-Match": etag})
+	if recorder.Code != http.StatusOK || recorder.Header().Get("ETag") == etag {
+		t.Errorf("got %d with the ETag %s after the %s, want 200 with a new one", recorder.Code, recorder.Header().Get("ETag"), change)
+	}
+	return recorder.Header().Get("ETag")
+}
+
+func TestPrivateFeed(t *testing.T) {
+	router, ctrl := newFeedRouter(t)
+	err := ctrl.FeedStore.SetConfig(context.Background(), "guild", &models.FeedConfig{Token: "t0k3n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/feeds/guild.rss", http.StatusNotFound},
+		{"/feeds/guild.rss?token=wrong", http.StatusForbidden},
+		{"/feeds/guild.rss?token=t0k3n", http.StatusOK},
+		{"/feeds/other.rss?token=t0k3n", http.StatusNotFound},
+	}
+	for _, test := range tests {
+		if got := getFeed(router, http.MethodGet, test.path, nil).Code; got != test.status {
+			t.Errorf("%s: got %d, want %d", test.path, got, test.status)
+		}
+	}
+
+	recorder := getFeed(router, http.MethodGet, "/feeds/guild.rss?token=t0k3n", nil)
+	if got := recorder.Header().Get("Cache-Control"); got != "private, no-cache" {
+		t.Errorf("got the Cache-Control %q, want private", got)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "<link>https://example.com/feeds/guild.rss</link>") || strings.Contains(body, "t0k3n") {
+		t.Errorf("got\n%s\nwant the link of the feed without its token", body)
+	}
+}
+
+func TestSetFeedConfig(t *testing.T) {
+	_, ctrl := newFeedRouter(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/sources/{source}/feed", ctrl.GetFeedConfig).Methods("GET")
+	router.HandleFunc("/sources/{source}/feed", ctrl.SetFeedConfig).Methods("PUT")
+
+	tokens := map[string]bool{}
+	for _, body := range []string{`{"public": false, "token": "t0k3n"}`, `{"public": false}`} {
+		recorder := serve(router, "PUT", "/sources/guild/feed", body)
+		var config models.FeedConfig
+		err := json.Unmarshal(recorder.Body.Bytes(), &config)
+		if err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("got %d %s, %v", recorder.Code, recorder.Body, err)
+		}
+		// The token is generated, even when one is sent
+		if len(config.Token) != 64 || tokens[config.Token] {
+			t.Errorf("got the token %q for %s, want a new generated one", config.Token, body)
+		}
+		tokens[config.Token] = true
+	}
+
+	recorder := serve(router, "GET", "/sources/guild/feed", "")
+	if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "token") {
+		t.Errorf("got %d %s, want the config without its token", recorder.Code, recorder.Body)
+	}
+}
@@ -0,0 +1,537 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/hooks"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// maxHookSize is the maximal size of an incoming hook body
+const maxHookSize = 5 * 1024 * 1024
+
+// callbackTimeout is the time allowed to report a hook state to its provider
+const callbackTimeout = 30 * time.Second
+
+// hookProviders lists the providers a source can receive hooks from
+var hookProviders = []string{
+	models.HookProviderGitHub, models.HookProviderDockerHub,
+	models.HookProviderGitLab, models.HookProviderGitea,
+}
+
+type HookController struct {
+	RedisStore  *models.RedisStoreHooks
+	Webhooks    *models.RedisStoreWebhooks
+	DeliveryLog *models.RedisStoreDeliveryLog
+	Router      *hooks.Router
+	Config      config.Config
+	Client      *http.Client
+}
+
+func NewHookController(redisClient *redis.Client, config config.Config) HookController {
+	return HookController{
+		RedisStore:  &models.RedisStoreHooks{Client: redisClient},
+		Webhooks:    &models.RedisStoreWebhooks{Client: redisClient},
+		DeliveryLog: &models.RedisStoreDeliveryLog{Client: redisClient},
+		Router:      hooks.NewRouter(redisClient),
+		Config:      config,
+		Client: &http.Client{
+			Timeout: callbackTimeout,
+		},
+	}
+}
+
+var rejectedHooks = metrics.Default.NewCounter("hook_manager_hooks_rejected_total",
+	"Incoming hooks rejected before their authentication, by provider and status code. They are not recorded in the delivery log.",
+	"provider", "status")
+
+// deliveryLogKey is the context key of the incoming hook being received
+type deliveryLogKey struct{}
+
+// incomingHook is the delivery log entry of an incoming hook, which the
+// handlers complete with the ID of the parsed event. It is only recorded once
+// the handler has authenticated the hook, so that anyone can't fill the log
+// of a source.
+type incomingHook struct {
+	entry         models.DeliveryLogEntry
+	authenticated bool
+}
+
+func incomingHookFromContext(ctx context.Context) *incomingHook {
+	hook, _ := ctx.Value(deliveryLogKey{}).(*incomingHook)
+	if hook == nil {
+		return &incomingHook{}
+	}
+	return hook
+}
+
+// statusRecorder keeps the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// LogDelivery records the hooks received by next in the delivery log of the
+// source, whatever the outcome once authenticated. The rejected ones are only
+// counted.
+func (c HookController) LogDelivery(provider string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.Get(ctx)
+		vars := mux.Vars(r)
+		start := time.Now()
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHookSize))
+		if err != nil {
+			rejectedHooks.Inc(provider, strconv.Itoa(http.StatusRequestEntityTooLarge))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			json.NewEncoder(w).Encode(response{
+				Message: "fail to read body",
+			})
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		hook := &incomingHook{
+			entry: models.DeliveryLogEntry{
+				Direction: models.DeliveryIncoming,
+				Provider:  provider,
+				Headers:   r.Header,
+				Body:      string(body),
+				At:        start,
+			},
+		}
+		recorder := &statusRecorder{ResponseWriter: w, status: 200}
+		next(recorder, r.WithContext(context.WithValue(ctx, deliveryLogKey{}, hook)))
+
+		if !hook.authenticated {
+			rejectedHooks.Inc(provider, strconv.Itoa(recorder.status))
+			return
+		}
+		hook.entry.StatusCode = recorder.status
+		hook.entry.LatencyMs = time.Since(start).Milliseconds()
+		err = c.DeliveryLog.Record(ctx, vars["source"], hook.entry)
+		if err != nil {
+			log.WithError(err).Error("fail to record incoming hook")
+		}
+	}
+}
+
+// GitHub receives the hooks of GitHub, authenticated by the HMAC signature of
+// the body
+func (c HookController) GitHub(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GitHub").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	config, body, ok := c.readHook(w, r, models.HookProviderGitHub)
+	if !ok {
+		return
+	}
+
+	if !hooks.VerifyGitHubSignature(config.Secret, r.Header.Get(hooks.GitHubSignatureHeader), body) {
+		log.Debugln("invalid GitHub signature for source", vars["source"])
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid signature",
+		})
+		return
+	}
+	incomingHookFromContext(ctx).authenticated = true
+
+	eventName := r.Header.Get(hooks.GitHubEventHeader)
+	deliveryID := r.Header.Get(hooks.GitHubDeliveryHeader)
+	if eventName == "ping" {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(response{
+			Message: "pong",
+		})
+		return
+	}
+	if deliveryID == "" {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: "missing " + hooks.GitHubDeliveryHeader + " header",
+		})
+		return
+	}
+
+	event, err := hooks.ParseGitHub(eventName, deliveryID, body)
+	c.receive(w, r, event, err)
+}
+
+// GitLab receives the hooks of GitLab, authenticated by the secret token
+func (c HookController) GitLab(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GitLab").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	config, body, ok := c.readHook(w, r, models.HookProviderGitLab)
+	if !ok {
+		return
+	}
+
+	if !hooks.VerifyGitLabToken(config.Secret, r.Header.Get(hooks.GitLabTokenHeader)) {
+		log.Debugln("invalid GitLab token for source", vars["source"])
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid token",
+		})
+		return
+	}
+	incomingHookFromContext(ctx).authenticated = true
+
+	event, err := hooks.ParseGitLab(r.Header.Get(hooks.GitLabEventUUIDHeader), body)
+	c.receive(w, r, event, err)
+}
+
+// Gitea receives the hooks of Gitea, authenticated by the HMAC signature of
+// the body
+func (c HookController) Gitea(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Gitea").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	config, body, ok := c.readHook(w, r, models.HookProviderGitea)
+	if !ok {
+		return
+	}
+
+	if !hooks.VerifyGiteaSignature(config.Secret, r.Header.Get(hooks.GiteaSignatureHeader), body) {
+		log.Debugln("invalid Gitea signature for source", vars["source"])
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid signature",
+		})
+		return
+	}
+	incomingHookFromContext(ctx).authenticated = true
+
+	deliveryID := r.Header.Get(hooks.GiteaDeliveryHeader)
+	if deliveryID == "" {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: "missing " + hooks.GiteaDeliveryHeader + " header",
+		})
+		return
+	}
+
+	event, err := hooks.ParseGitea(r.Header.Get(hooks.GiteaEventHeader), deliveryID, body)
+	c.receive(w, r, event, err)
+}
+
+// DockerHub receives the hooks of Docker Hub, authenticated by the token given
+// in the path or in the token query parameter
+func (c HookController) DockerHub(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	// The path may carry the token of the source, it is left out of the logs
+	log.WithField("function", "DockerHub").Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	config, body, ok := c.readHook(w, r, models.HookProviderDockerHub)
+	if !ok {
+		return
+	}
+
+	token := vars["token"]
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if !hooks.VerifyDockerHubToken(config.Secret, token) {
+		log.Debugln("invalid Docker Hub token for source", vars["source"])
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid token",
+		})
+		return
+	}
+	incomingHookFromContext(ctx).authenticated = true
+
+	// The callback URL is read on its own, the failure to parse the rest of
+	// the payload is reported to it
+	callbackURL := hooks.DockerHubCallbackURL(body)
+	event, err := hooks.ParseDockerHub(body)
+	received := c.receive(w, r, event, err)
+
+	if !config.Callback || callbackURL == "" {
+		return
+	}
+	if !hooks.ValidDockerHubCallback(callbackURL, c.Config.DockerHubCallbackHosts) {
+		log.Infoln("ignoring Docker Hub callback to unexpected URL", callbackURL)
+		return
+	}
+
+	state, description := hooks.DockerHubStateSuccess, "hook received"
+	if !received {
+		state, description = hooks.DockerHubStateFailure, "fail to process hook"
+	}
+	// The callback outlives the request
+	callbackCtx := logger.ToCtx(context.Background(), log)
+	go func() {
+		err := hooks.DockerHubCallback(callbackCtx, c.Client, callbackURL, state, description)
+		if err != nil {
+			log.WithError(err).Error("fail to call Docker Hub callback")
+		}
+	}()
+}
+
+// readHook reads the body of an incoming hook and the configuration of its
+// provider for the source. When ok is false, the answer has already been
+// written.
+func (c HookController) readHook(w http.ResponseWriter, r *http.Request, provider string) (config *models.HookConfig, body []byte, ok bool) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+	vars := mux.Vars(r)
+
+	config, err := c.RedisStore.GetConfig(ctx, vars["source"], provider)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get hook config"))
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(response{
+			Message: "Internal error",
+		})
+		return nil, nil, false
+	}
+	if config == nil {
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(response{
+			Message: "source does not accept " + provider + " hooks",
+		})
+		return nil, nil, false
+	}
+
+	body, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHookSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(response{
+			Message: "fail to read body",
+		})
+		return nil, nil, false
+	}
+	return config, body, true
+}
+
+// receive records a parsed hook, forwards it to the webhooks of the source and
+// routes it according to the source rules. It returns false if the hook has not
+// been recorded.
+func (c HookController) receive(w http.ResponseWriter, r *http.Request, event *models.HookEvent, parseErr error) bool {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+	vars := mux.Vars(r)
+
+	if parseErr == hooks.ErrUnsupportedEvent {
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(response{
+			Message: "ignored event",
+		})
+		return true
+	}
+	if parseErr != nil {
+		log.Debugln("fail to parse hook", parseErr)
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid payload",
+		})
+		return false
+	}
+
+	incomingHookFromContext(ctx).entry.EventID = event.ID
+
+	recorded, err := c.RedisStore.Record(ctx, vars["source"], *event)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to record hook"))
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(response{
+			Message: "Internal error",
+		})
+		return false
+	}
+	if !recorded {
+		log.Debugln("duplicate hook delivery", event.ID)
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(response{
+			Message: "duplicate delivery",
+		})
+		return true
+	}
+
+	bytes, err := json.Marshal(event)
+	if err == nil {
+		err = c.Webhooks.Enqueue(ctx, vars["source"], models.EventHookReceived, bytes)
+	}
+	if err != nil {
+		// The hook is recorded, the provider must not retry it
+		log.Error(errors.Wrap(err, "fail to forward hook"))
+	}
+
+	_, err = c.Router.Route(ctx, vars["source"], *event)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to route hook"))
+	}
+
+	w.WriteHeader(202)
+	json.NewEncoder(w).Encode(response{
+		Message: "ok",
+	})
+	return true
+}
+
+// GetHookConfig tells whether the source accepts the hooks of a provider,
+// the secret is not returned
+func (c HookController) GetHookConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetHookConfig").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	config, err := c.RedisStore.GetConfig(ctx, vars["source"], vars["provider"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get hook config"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if config == nil {
+		resp := response{
+			Message: "provider not configured",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	config.Secret = ""
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(config)
+}
+
+// SetHookConfig sets the secret of a provider for a source. The secret is
+// generated when not provided and is only returned by this call.
+func (c HookController) SetHookConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SetHookConfig").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	if !isHookProvider(vars["provider"]) {
+		resp := response{
+			Message: fmt.Sprintf("unknown provider '%s', must be one of %s", vars["provider"], strings.Join(hookProviders, ", ")),
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var config models.HookConfig
+	_ = json.NewDecoder(r.Body).Decode(&config)
+	config.Provider = vars["provider"]
+
+	var err error
+	if config.Secret == "" {
+		config.Secret, err = generateSecret()
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to generate hook secret"))
+			resp := response{
+				Message: "Internal error",
+			}
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	err = c.RedisStore.SetConfig(ctx, vars["source"], &config)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save hook config"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(config)
+}
+
+// DeleteHookConfig stops accepting the hooks of a provider
+func (c HookController) DeleteHookConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteHookConfig").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.DeleteConfig(ctx, vars["source"], vars["provider"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to delete hook config: "+vars["provider"]))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "provider not configured",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func isHookProvider(provider string) bool {
+	for _, p := range hookProviders {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
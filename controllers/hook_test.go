@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/hooks"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+// newHookRouter serves the hook routes with a controller on a fresh Redis
+func newHookRouter(t *testing.T, config config.Config) (HookController, *mux.Router) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	ctrl := NewHookController(redisClient, config)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/hooks/github/{source}", ctrl.LogDelivery(models.HookProviderGitHub, ctrl.GitHub)).Methods("POST")
+	router.HandleFunc("/hooks/gitlab/{source}", ctrl.LogDelivery(models.HookProviderGitLab, ctrl.GitLab)).Methods("POST")
+	router.HandleFunc("/hooks/gitea/{source}", ctrl.LogDelivery(models.HookProviderGitea, ctrl.Gitea)).Methods("POST")
+	router.HandleFunc("/hooks/dockerhub/{source}", ctrl.LogDelivery(models.HookProviderDockerHub, ctrl.DockerHub)).Methods("POST")
+	router.HandleFunc("/hooks/dockerhub/{source}/{token}", ctrl.LogDelivery(models.HookProviderDockerHub, ctrl.DockerHub)).Methods("POST")
+	return ctrl, router
+}
+
+func setHookConfig(t *testing.T, ctrl HookController, source string, config models.HookConfig) {
+	t.Helper()
+	err := ctrl.RedisStore.SetConfig(context.Background(), source, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func postHook(router http.Handler, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func hmacSHA256(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestGitHubHook(t *testing.T) {
+	ctrl, router := newHookRouter(t, config.Config{})
+	setHookConfig(t, ctrl, "guild", models.HookConfig{Provider: models.HookProviderGitHub, Secret: "s3cr3t"})
+
+	body := `{"ref": "refs/heads/main", "repository": {"full_name": "o/r"}, "sender": {"login": "alice"}}`
+	signed := func(event, delivery, signature string) map[string]string {
+		return map[string]string{
+			hooks.GitHubEventHeader:     event,
+			hooks.GitHubDeliveryHeader:  delivery,
+			hooks.GitHubSignatureHeader: signature,
+		}
+	}
+	valid := "sha256=" + hmacSHA256("s3cr3t", body)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"unconfigured source", "/hooks/github/other", body, signed("push", "d1", valid), http.StatusNotFound},
+		{"invalid signature", "/hooks/github/guild", body, signed("push", "d1", "sha256="+hmacSHA256("wrong", body)), http.StatusUnauthorized},
+		{"tampered body", "/hooks/github/guild", body + " ", signed("push", "d1", valid), http.StatusUnauthorized},
+		{"missing delivery", "/hooks/github/guild", body, signed("push", "", valid), http.StatusBadRequest},
+		{"ping", "/hooks/github/guild", body, signed("ping", "d0", valid), http.StatusOK},
+		{"unsupported event", "/hooks/github/guild", body, signed("star", "d1", valid), http.StatusOK},
+		{"push", "/hooks/github/guild", body, signed("push", "d1", valid), http.StatusAccepted},
+		{"redelivery", "/hooks/github/guild", body, signed("push", "d1", valid), http.StatusOK},
+	}
+	for _, test := range tests {
+		recorder := postHook(router, test.path, test.body, test.headers)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, recorder.Code, recorder.Body, test.status)
+		}
+	}
+
+	event, err := ctrl.RedisStore.Get(context.Background(), "guild", "d1")
+	if err != nil || event == nil {
+		t.Fatalf("got %v, %v, want the push recorded", event, err)
+	}
+	if event.Type != models.HookTypePush || event.Branch != "main" || event.Actor != "alice" {
+		t.Errorf("got the event %+v, want the push of alice on main", event)
+	}
+}
+
+// rejectedHooksCount reads the counter of the rejected hooks of a provider
+// with some status
+func rejectedHooksCount(t *testing.T, provider string, status int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	err := metrics.Default.WriteTo(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := fmt.Sprintf(`hook_manager_hooks_rejected_total{provider="%s",status="%d"} `, provider, status)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return strings.TrimPrefix(line, prefix)
+		}
+	}
+	return "0"
+}
+
+func TestLogDeliveryOnlyRecordsAuthenticatedHooks(t *testing.T) {
+	ctrl, router := newHookRouter(t, config.Config{})
+	setHookConfig(t, ctrl, "guild", models.HookConfig{Provider: models.HookProviderGitLab, Secret: "t0k3n"})
+	notFound := rejectedHooksCount(t, models.HookProviderGitLab, http.StatusNotFound)
+	unauthorized := rejectedHooksCount(t, models.HookProviderGitLab, http.StatusUnauthorized)
+
+	body := `{"object_kind": "push", "ref": "refs/heads/main", "project": {"path_with_namespace": "g/p"}}`
+	postHook(router, "/hooks/gitlab/unknown", body, map[string]string{hooks.GitLabTokenHeader: "t0k3n"})
+	postHook(router, "/hooks/gitlab/guild", body, map[string]string{hooks.GitLabTokenHeader: "wrong"})
+	postHook(router, "/hooks/gitlab/guild", `{`, map[string]string{hooks.GitLabTokenHeader: "t0k3n", hooks.GitLabEventUUIDHeader: "u0"})
+	postHook(router, "/hooks/gitlab/guild", body, map[string]string{hooks.GitLabTokenHeader: "t0k3n", hooks.GitLabEventUUIDHeader: "u1"})
+
+	ctx := context.Background()
+	for _, source := range []string{"unknown", "guild"} {
+		entries, err := ctrl.DeliveryLog.List(ctx, source, models.DeliveryLogFilter{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var statuses []int
+		for _, entry := range entries {
+			statuses = append(statuses, entry.StatusCode)
+		}
+		want := map[string]string{"unknown": "[]", "guild": "[202 400]"}[source]
+		if fmt.Sprint(statuses) != want {
+			t.Errorf("%s: got the logged statuses %v, want %s", source, statuses, want)
+		}
+	}
+
+	if got := rejectedHooksCount(t, models.HookProviderGitLab, http.StatusNotFound); got == notFound {
+		t.Errorf("got %s hooks rejected with 404, want one more", got)
+	}
+	if got := rejectedHooksCount(t, models.HookProviderGitLab, http.StatusUnauthorized); got == unauthorized {
+		t.Errorf("got %s hooks rejected with 401, want one more", got)
+	}
+}
+
+func TestGitLabHook(t *testing.T) {
+	ctrl, router := newHookRouter(t, config.Config{})
+	setHookConfig(t, ctrl, "guild", models.HookConfig{Provider: models.HookProviderGitLab, Secret: "t0k3n"})
+
+	body := `{"object_kind": "push", "ref": "refs/heads/main", "user_username": "alice", "project": {"path_with_namespace": "g/p"}}`
+	headers := func(token, uuid string) map[string]string {
+		return map[string]string{hooks.GitLabTokenHeader: token, hooks.GitLabEventUUIDHeader: uuid}
+	}
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"unconfigured source", "/hooks/gitlab/other", body, headers("t0k3n", "u1"), http.StatusNotFound},
+		{"invalid token", "/hooks/gitlab/guild", body, headers("wrong", "u1"), http.StatusUnauthorized},
+		{"invalid body", "/hooks/gitlab/guild", `{`, headers("t0k3n", "u1"), http.StatusBadRequest},
+		{"unsupported event", "/hooks/gitlab/guild", `{"object_kind": "note"}`, headers("t0k3n", "u1"), http.StatusOK},
+		{"push", "/hooks/gitlab/guild", body, headers("t0k3n", "u1"), http.StatusAccepted},
+		{"redelivery", "/hooks/gitlab/guild", body, headers("t0k3n", "u1"), http.StatusOK},
+	}
+	for _, test := range tests {
+		recorder := postHook(router, test.path, test.body, test.headers)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, recorder.Code, recorder.Body, test.status)
+		}
+	}
+
+	event, err := ctrl.RedisStore.Get(context.Background(), "guild", "u1")
+	if err != nil || event == nil {
+		t.Fatalf("got %v, %v, want the push recorded", event, err)
+	}
+	if event.Provider != models.HookProviderGitLab || event.Branch != "main" || event.Actor != "alice" {
+		t.Errorf("got the event %+v, want the push of alice on main", event)
+	}
+}
+
+func TestGiteaHook(t *testing.T) {
+	ctrl, router := newHookRouter(t, config.Config{})
+	setHookConfig(t, ctrl, "guild", models.HookConfig{Provider: models.HookProviderGitea, Secret: "s3cr3t"})
+
+	body := `{"ref": "refs/heads/main", "repository": {"full_name": "o/r"}, "sender": {"login": "alice"}}`
+	signed := func(delivery, signature string) map[string]string {
+		return map[string]string{
+			hooks.GiteaEventHeader:     "push",
+			hooks.GiteaDeliveryHeader:  delivery,
+			hooks.GiteaSignatureHeader: signature,
+		}
+	}
+	valid := hmacSHA256("s3cr3t", body)
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		headers map[string]string
+		status  int
+	}{
+		{"unconfigured source", "/hooks/gitea/other", body, signed("d1", valid), http.StatusNotFound},
+		{"invalid signature", "/hooks/gitea/guild", body, signed("d1", hmacSHA256("wrong", body)), http.StatusUnauthorized},
+		{"GitHub signature", "/hooks/gitea/guild", body, signed("d1", "sha256="+valid), http.StatusUnauthorized},
+		{"missing delivery", "/hooks/gitea/guild", body, signed("", valid), http.StatusBadRequest},
+		{"push", "/hooks/gitea/guild", body, signed("d1", valid), http.StatusAccepted},
+		{"redelivery", "/hooks/gitea/guild", body, signed("d1", valid), http.StatusOK},
+	}
+	for _, test := range tests {
+		recorder := postHook(router, test.path, test.body, test.headers)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, recorder.Code, recorder.Body, test.status)
+		}
+	}
+
+	event, err := ctrl.RedisStore.Get(context.Background(), "guild", "d1")
+	if err != nil || event == nil || event.Provider != models.HookProviderGitea {
+		t.Fatalf("got %v, %v, want the push recorded", event, err)
+	}
+}
+
+func TestDockerHubHookCallbacks(t *testing.T) {
+	states := make(chan string, 4)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			State string `json:"state"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		states <- r.URL.Path + " " + body.State
+	}))
+	defer callback.Close()
+	u, _ := url.Parse(callback.URL)
+
+	ctrl, router := newHookRouter(t, config.Config{DockerHubCallbackHosts: []string{u.Host}})
+	setHookConfig(t, ctrl, "guild", models.HookConfig{Provider: models.HookProviderDockerHub, Secret: "t0k3n", Callback: true})
+
+	tests := []struct {
+		name     string
+		body     string
+		status   int
+		callback string
+	}{
+		{"push", `{"callback_url": "` + callback.URL + `/1", "repository": {"repo_name": "o/r"}}`, http.StatusAccepted, "/1 success"},
+		{"redelivery", `{"callback_url": "` + callback.URL + `/1", "repository": {"repo_name": "o/r"}}`, http.StatusOK, "/1 success"},
+		{"invalid payload", `{"callback_url": "` + callback.URL + `/2", "repository": {"repo_name": 42}}`, http.StatusBadRequest, "/2 failure"},
+		{"missing repository", `{"callback_url": "` + callback.URL + `/3"}`, http.StatusBadRequest, "/3 failure"},
+	}
+	for _, test := range tests {
+		recorder := postHook(router, "/hooks/dockerhub/guild/t0k3n", test.body, nil)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d %s, want %d", test.name, recorder.Code, recorder.Body, test.status)
+		}
+		select {
+		case got := <-states:
+			if got != test.callback {
+				t.Errorf("%s: got the callback %q, want %q", test.name, got, test.callback)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: no callback", test.name)
+		}
+	}
+
+	// Neither the hosts out of the allowlist nor the unauthenticated hooks are
+	// called back
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states <- "other host"
+	}))
+	defer other.Close()
+	recorder := postHook(router, "/hooks/dockerhub/guild/t0k3n", `{"callback_url": "`+other.URL+`/4", "repository": {"repo_name": "o/r"}}`, nil)
+	if recorder.Code != http.StatusAccepted {
+		t.Errorf("other host: got %d %s, want 202", recorder.Code, recorder.Body)
+	}
+	recorder = postHook(router, "/hooks/dockerhub/guild/wrong", `{"callback_url": "`+callback.URL+`/5", "repository": {"repo_name": "o/r"}}`, nil)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token: got %d %s, want 401", recorder.Code, recorder.Body)
+	}
+	select {
+	case got := <-states:
+		t.Errorf("got the callback %q, want none", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
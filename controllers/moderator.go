@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type ModeratorController struct {
+	RedisStore *models.RedisStoreModerators
+}
+
+func NewModeratorController(redisClient *redis.Client) ModeratorController {
+	return ModeratorController{
+		RedisStore: &models.RedisStoreModerators{Client: redisClient},
+	}
+}
+
+type moderatorsResp struct {
+	Moderators []string `json:"moderators"`
+}
+
+// GetModerators returns the IDs of the moderators of a source
+func (c ModeratorController) GetModerators(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetModerators").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	userIDs, err := c.RedisStore.List(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get moderators"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(moderatorsResp{
+		Moderators: userIDs,
+	})
+}
+
+// AddModerator lets a user edit and delete every excuse of a source
+func (c ModeratorController) AddModerator(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AddModerator").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	_, err := c.RedisStore.Add(ctx, vars["source"], vars["user"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to add moderator"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// DeleteModerator takes the moderator role of a source from a user
+func (c ModeratorController) DeleteModerator(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteModerator").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.Remove(ctx, vars["source"], vars["user"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to remove moderator"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "user is not a moderator",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type RateLimitController struct {
+	RedisStore *models.RedisStoreRateLimits
+}
+
+func NewRateLimitController(redisClient *redis.Client) RateLimitController {
+	return RateLimitController{
+		RedisStore: &models.RedisStoreRateLimits{Client: redisClient},
+	}
+}
+
+// GetRateLimits returns the rate limits set for a source
+func (c RateLimitController) GetRateLimits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetRateLimits").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	limits, err := c.RedisStore.GetSourceLimits(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get rate limits"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if limits == nil {
+		resp := response{
+			Message: "the source uses the default rate limits",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(limits)
+}
+
+// SetRateLimits overrides the default rate limits of some route classes for
+// a source, such as {"write": 10}
+func (c RateLimitController) SetRateLimits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SetRateLimits").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	var limits models.RateLimits
+	_ = json.NewDecoder(r.Body).Decode(&limits)
+
+	retErrors := validateRateLimits(limits)
+	if retErrors != nil {
+		invalidArguments(w, retErrors)
+		return
+	}
+
+	err := c.RedisStore.SetSourceLimits(ctx, vars["source"], limits)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save rate limits"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(limits)
+}
+
+// DeleteRateLimits makes a source use the default rate limits
+func (c RateLimitController) DeleteRateLimits(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteRateLimits").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.DeleteSourceLimits(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to delete rate limits"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "the source uses the default rate limits",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func validateRateLimits(limits models.RateLimits) []string {
+	var retErrors []string
+	if len(limits) == 0 {
+		retErrors = append(retErrors, "at least one route class must be limited")
+	}
+	for class, limit := range limits {
+		if !isRateLimitClass(class) {
+			retErrors = append(retErrors, fmt.Sprintf("unknown route class '%s', must be one of %s",
+				class, strings.Join(models.RateLimitClasses, ", ")))
+		}
+		if limit < 0 {
+			retErrors = append(retErrors, fmt.Sprintf("the limit of %s must not be negative", class))
+		}
+	}
+	return retErrors
+}
+
+func isRateLimitClass(class string) bool {
+	for _, c := range models.RateLimitClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
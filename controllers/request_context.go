@@ -1,6 +1,11 @@
 package controllers
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
 	"github.com/curzolapierre/hook-manager/models"
 	"github.com/go-redis/redis"
 	"github.com/sirupsen/logrus"
@@ -14,3 +19,37 @@ type RequestContext struct {
 
 func (r *RequestContext) InitStore(redisClient *redis.Client) {
 }
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext returns the ID of the request of ctx, empty if there is
+// none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// ClientIP is the address of the peer, or the one given by the proxy in
+// front of the server when it is trusted. X-Forwarded-For is appended to by
+// each proxy, its last address is the one the nearest proxy saw.
+func ClientIP(r *http.Request, trustProxy bool) string {
+	if trustProxy {
+		if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+			return ip
+		}
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			ips := strings.Split(forwarded, ",")
+			return strings.TrimSpace(ips[len(ips)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,349 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/hooks"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type RuleController struct {
+	RedisStore *models.RedisStoreHookRules
+}
+
+func NewRuleController(redisClient *redis.Client) RuleController {
+	return RuleController{
+		RedisStore: &models.RedisStoreHookRules{Client: redisClient},
+	}
+}
+
+type rulesResp struct {
+	Rules []models.HookRule `json:"rules"`
+}
+
+type previewReq struct {
+	Template string            `json:"template"`
+	Event    *models.HookEvent `json:"event"`
+}
+
+// GetRules lists the routing rules of a source, target secrets are not
+// returned
+func (c RuleController) GetRules(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetRules").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	rules, err := c.RedisStore.List(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get rules"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	hideRulesSecrets(rules)
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(rulesResp{
+		Rules: rules,
+	})
+}
+
+// GetRule gives the rule with some ID
+func (c RuleController) GetRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetRule").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	rule, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get rule"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if rule == nil {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	hideRuleSecrets(rule)
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// AddRule adds a new routing rule
+func (c RuleController) AddRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AddRule").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	var rule models.HookRule
+	_ = json.NewDecoder(r.Body).Decode(&rule)
+	rule.ID = ""
+
+	retErrors := validateRule(rule)
+	if retErrors != nil {
+		log.Debugln("fail to save rule", retErrors)
+		invalidArguments(w, retErrors)
+		return
+	}
+
+	err := c.RedisStore.Save(ctx, vars["source"], &rule)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save rule"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	hideRuleSecrets(&rule)
+
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// UpdateRule replaces the rule with some ID, the targets given without secret
+// keep the stored one
+func (c RuleController) UpdateRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "UpdateRule").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	existing, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get rule"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if existing == nil {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var rule models.HookRule
+	_ = json.NewDecoder(r.Body).Decode(&rule)
+	rule.ID = existing.ID
+	rule.CreatedAt = existing.CreatedAt
+	keepTargetSecrets(&rule, *existing)
+
+	retErrors := validateRule(rule)
+	if retErrors != nil {
+		log.Debugln("fail to save rule", retErrors)
+		invalidArguments(w, retErrors)
+		return
+	}
+
+	err = c.RedisStore.Save(ctx, vars["source"], &rule)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save rule"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	hideRuleSecrets(&rule)
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteRule deletes the rule with some ID
+func (c RuleController) DeleteRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteRule").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.Delete(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to delete rule: "+vars["id"]))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PreviewRule renders a template with the given event, or with a sample push
+// event when none is given
+func (c RuleController) PreviewRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "PreviewRule").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+
+	var req previewReq
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	event := hooks.SampleEvent
+	if req.Event != nil {
+		event = *req.Event
+	}
+
+	msg, err := hooks.Render(req.Template, event)
+	if err != nil {
+		log.Debugln("fail to render preview", err)
+		invalidArguments(w, []string{err.Error()})
+		return
+	}
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response{
+		Message: msg,
+	})
+}
+
+func validateRule(rule models.HookRule) []string {
+	var retErrors []string
+
+	if rule.Match.Provider != "" && !isHookProvider(rule.Match.Provider) {
+		retErrors = append(retErrors, fmt.Sprintf("unknown provider '%s', must be one of %s", rule.Match.Provider, strings.Join(hookProviders, ", ")))
+	}
+	if _, err := path.Match(rule.Match.Repository, ""); err != nil {
+		retErrors = append(retErrors, fmt.Sprintf("invalid repository glob '%s'", rule.Match.Repository))
+	}
+	if _, err := path.Match(rule.Match.Branch, ""); err != nil {
+		retErrors = append(retErrors, fmt.Sprintf("invalid branch glob '%s'", rule.Match.Branch))
+	}
+
+	if len(rule.Targets) == 0 {
+		retErrors = append(retErrors, "missing targets field")
+	}
+	for i, target := range rule.Targets {
+		switch target.Type {
+		case models.HookTargetDiscord:
+			u, err := url.Parse(target.URL)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				retErrors = append(retErrors, fmt.Sprintf("target %d: url field must be an https URL", i))
+			}
+		case models.HookTargetHTTP:
+			u, err := url.Parse(target.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				retErrors = append(retErrors, fmt.Sprintf("target %d: url field must be an absolute http(s) URL", i))
+			}
+		case models.HookTargetChannel:
+			if target.Channel == "" {
+				retErrors = append(retErrors, fmt.Sprintf("target %d: missing channel field", i))
+			}
+		default:
+			retErrors = append(retErrors, fmt.Sprintf("target %d: unknown type '%s', must be one of %s, %s, %s",
+				i, target.Type, models.HookTargetDiscord, models.HookTargetHTTP, models.HookTargetChannel))
+		}
+	}
+
+	// Catch the templates failing at execution time, not only the unparsable
+	// ones
+	_, err := hooks.Render(rule.Template, hooks.SampleEvent)
+	if err != nil {
+		retErrors = append(retErrors, err.Error())
+	}
+
+	return retErrors
+}
+
+// hideRuleSecrets blanks the secrets of the targets of rule before it is
+// returned
+func hideRuleSecrets(rule *models.HookRule) {
+	for i := range rule.Targets {
+		rule.Targets[i].Secret = ""
+	}
+}
+
+func hideRulesSecrets(rules []models.HookRule) {
+	for i := range rules {
+		hideRuleSecrets(&rules[i])
+	}
+}
+
+// keepTargetSecrets gives the targets updated without secret the one stored
+// for the same target, so that a rule sent back as returned by GetRule keeps
+// its secrets
+func keepTargetSecrets(rule *models.HookRule, existing models.HookRule) {
+	for i, target := range rule.Targets {
+		if target.Secret != "" {
+			continue
+		}
+		for _, stored := range existing.Targets {
+			if stored.Type == target.Type && stored.URL == target.URL {
+				rule.Targets[i].Secret = stored.Secret
+				break
+			}
+		}
+	}
+}
+
+// invalidArguments answers with the list of validation errors
+func invalidArguments(w http.ResponseWriter, retErrors []string) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(invalidArgumentsResp(retErrors))
+}
+
+func invalidArgumentsResp(retErrors []string) response {
+	errArray := make([]string, 0, len(retErrors))
+	for _, attrErrs := range retErrors {
+		errArray = append(errArray, fmt.Sprintf("\t→ %s", attrErrs))
+	}
+	return response{
+		Message: fmt.Sprintf("invalid arguments:\n%s", strings.Join(errArray, "\n")),
+	}
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func newRuleRouter(t *testing.T) (RuleController, *mux.Router) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	ctrl := NewRuleController(redisClient)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/sources/{source}/rules", ctrl.GetRules).Methods("GET")
+	router.HandleFunc("/sources/{source}/rules", ctrl.AddRule).Methods("POST")
+	router.HandleFunc("/sources/{source}/rules/preview", ctrl.PreviewRule).Methods("POST")
+	router.HandleFunc("/sources/{source}/rules/{id}", ctrl.GetRule).Methods("GET")
+	router.HandleFunc("/sources/{source}/rules/{id}", ctrl.UpdateRule).Methods("PUT")
+	return ctrl, router
+}
+
+func serve(router http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestRuleSecrets(t *testing.T) {
+	ctrl, router := newRuleRouter(t)
+
+	body := `{"name": "pushes", "match": {"type": "push"}, "targets": [
+		{"type": "http", "url": "https://example.com/a", "secret": "a-secret"},
+		{"type": "http", "url": "https://example.com/b", "secret": "b-secret"}]}`
+	recorder := serve(router, "POST", "/sources/guild/rules", body)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("got %d %s, want 201", recorder.Code, recorder.Body)
+	}
+	var rule models.HookRule
+	json.NewDecoder(recorder.Body).Decode(&rule)
+
+	recorder = serve(router, "GET", "/sources/guild/rules/"+rule.ID, "")
+	if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "secret") {
+		t.Fatalf("got %d %s, want the rule without secrets", recorder.Code, recorder.Body)
+	}
+	recorder = serve(router, "GET", "/sources/guild/rules", "")
+	if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "secret") {
+		t.Fatalf("got %d %s, want the rules without secrets", recorder.Code, recorder.Body)
+	}
+
+	// The rule sent back as returned keeps its secrets, a new secret replaces
+	// the stored one and a new target has none
+	var returned models.HookRule
+	json.Unmarshal(serve(router, "GET", "/sources/guild/rules/"+rule.ID, "").Body.Bytes(), &returned)
+	returned.Targets[1].Secret = "new-secret"
+	returned.Targets = append(returned.Targets, models.HookTarget{Type: models.HookTargetHTTP, URL: "https://example.com/c"})
+	update, _ := json.Marshal(returned)
+	recorder = serve(router, "PUT", "/sources/guild/rules/"+rule.ID, string(update))
+	if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "secret") {
+		t.Fatalf("got %d %s, want the rule updated without secrets", recorder.Code, recorder.Body)
+	}
+
+	stored, err := ctrl.RedisStore.Get(context.Background(), "guild", rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var secrets []string
+	for _, target := range stored.Targets {
+		secrets = append(secrets, target.Secret)
+	}
+	if strings.Join(secrets, ",") != "a-secret,new-secret," {
+		t.Errorf("got the secrets %q, want the stored, the new and no secret", secrets)
+	}
+}
+
+func TestRuleValidation(t *testing.T) {
+	_, router := newRuleRouter(t)
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"no targets", `{"match": {}}`, "missing targets field"},
+		{"unknown provider", `{"match": {"provider": "svn"}, "targets": [{"type": "channel", "channel": "ci"}]}`, "unknown provider 'svn'"},
+		{"invalid glob", `{"match": {"branch": "["}, "targets": [{"type": "channel", "channel": "ci"}]}`, "invalid branch glob"},
+		{"discord over http", `{"targets": [{"type": "discord", "url": "http://discord.com/api/webhooks/1/x"}]}`, "target 0: url field must be an https URL"},
+		{"relative URL", `{"targets": [{"type": "http", "url": "/hook"}]}`, "target 0: url field must be an absolute http(s) URL"},
+		{"missing channel", `{"targets": [{"type": "channel"}]}`, "target 0: missing channel field"},
+		{"unknown target", `{"targets": [{"type": "smtp"}]}`, "target 0: unknown type 'smtp'"},
+		{"failing template", `{"template": "{{.Unknown}}", "targets": [{"type": "channel", "channel": "ci"}]}`, "fail to render template"},
+	}
+	for _, test := range tests {
+		recorder := serve(router, "POST", "/sources/guild/rules", test.body)
+		if recorder.Code != http.StatusUnprocessableEntity || !strings.Contains(recorder.Body.String(), test.want) {
+			t.Errorf("%s: got %d %s, want 422 with %q", test.name, recorder.Code, recorder.Body, test.want)
+		}
+	}
+}
+
+func TestPreviewRule(t *testing.T) {
+	_, router := newRuleRouter(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		want   string
+	}{
+		{"sample event", `{"template": "{{.Actor}} on {{.Branch}}"}`, http.StatusOK, "octocat on main"},
+		{"given event", `{"template": "{{.Actor}}", "event": {"actor": "alice"}}`, http.StatusOK, "alice"},
+		{"invalid template", `{"template": "{{.Actor"}`, http.StatusUnprocessableEntity, "fail to parse template"},
+	}
+	for _, test := range tests {
+		recorder := serve(router, "POST", "/sources/guild/rules/preview", test.body)
+		var resp response
+		json.NewDecoder(recorder.Body).Decode(&resp)
+		if recorder.Code != test.status || !strings.Contains(resp.Message, test.want) {
+			t.Errorf("%s: got %d %q, want %d with %q", test.name, recorder.Code, resp.Message, test.status, test.want)
+		}
+	}
+}
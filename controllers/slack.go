@@ -0,0 +1,405 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/curzolapierre/hook-manager/slack"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxSlackRequestSize is the maximal size of a command or interaction body
+	maxSlackRequestSize = 1024 * 1024
+
+	// slackResponseTimeout is the time allowed to post the answer of an
+	// interaction to its response URL
+	slackResponseTimeout = 10 * time.Second
+
+	// maxSearchResults is the number of excuses listed by /excuse search
+	maxSearchResults = 10
+
+	// The action IDs of the buttons, the value of show and upvote is the ID of
+	// the excuse
+	slackAnotherAction = "excuse_another"
+	slackShowAction    = "excuse_show"
+	slackUpvoteAction  = "excuse_upvote"
+
+	slackUsage = "Usage:\n" +
+		"• `/excuse` gives a random excuse\n" +
+		"• `/excuse add @author Title | What they said` adds an excuse\n" +
+		"• `/excuse search terms` searches the titles and the contents"
+)
+
+type SlackController struct {
+	RedisStore    *models.RedisStoreCodexcuses
+	SigningSecret string
+	Sources       map[string]string
+	Client        *http.Client
+}
+
+func NewSlackController(redisClient *redis.Client, config config.Config) SlackController {
+	return SlackController{
+		RedisStore:    &models.RedisStoreCodexcuses{Client: redisClient},
+		SigningSecret: config.SlackSigningSecret,
+		Sources:       config.SlackSources,
+		Client: &http.Client{
+			Timeout: slackResponseTimeout,
+		},
+	}
+}
+
+// Commands receives the /excuse slash command, authenticated by the Slack
+// signature of the body
+func (c SlackController) Commands(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Commands").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+
+	form, ok := c.readSigned(w, r)
+	if !ok {
+		return
+	}
+	cmd := slack.ParseCommand(form)
+	source := c.source(cmd.TeamID, cmd.ChannelID)
+
+	subcommand, args := cmd.Text, ""
+	if i := strings.IndexAny(cmd.Text, " \t\n"); i != -1 {
+		subcommand, args = cmd.Text[:i], strings.TrimSpace(cmd.Text[i+1:])
+	}
+	log.Debugln("excuse subcommand:", subcommand)
+
+	var msg slack.Message
+	switch subcommand {
+	case "", "random":
+		msg = c.randomExcuse(ctx, source)
+	case "add":
+		msg = c.addExcuse(ctx, source, cmd, args)
+	case "search":
+		msg = c.searchExcuses(ctx, source, args)
+	default:
+		msg = slackEphemeral(slackUsage)
+	}
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(msg)
+}
+
+// Interactions receives the clicks on the buttons of the excuse messages.
+// Slack only expects an acknowledgement, the answer is posted to the
+// response URL of the interaction.
+func (c SlackController) Interactions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Interactions").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+
+	form, ok := c.readSigned(w, r)
+	if !ok {
+		return
+	}
+	var interaction slack.Interaction
+	err := json.Unmarshal([]byte(form.Get("payload")), &interaction)
+	if err != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid payload",
+		})
+		return
+	}
+
+	w.WriteHeader(200)
+	if interaction.Type != slack.InteractionBlockActions || len(interaction.Actions) == 0 {
+		return
+	}
+	if !slack.ValidResponseURL(interaction.ResponseURL) {
+		log.Infoln("ignoring Slack interaction with unexpected response URL", interaction.ResponseURL)
+		return
+	}
+
+	source := c.source(interaction.Team.ID, interaction.Channel.ID)
+	action := interaction.Actions[0]
+	log.Debugln("excuse action:", action.ActionID)
+
+	var msg slack.Message
+	switch action.ActionID {
+	case slackAnotherAction:
+		msg = c.randomExcuse(ctx, source)
+	case slackShowAction:
+		msg = c.showExcuse(ctx, source, action.Value)
+	case slackUpvoteAction:
+		msg = c.upvoteExcuse(ctx, source, action.Value, interaction.User.ID)
+	default:
+		return
+	}
+
+	// The answer outlives the request
+	responseCtx := logger.ToCtx(context.Background(), log)
+	go func() {
+		err := slack.Respond(responseCtx, c.Client, interaction.ResponseURL, msg)
+		if err != nil {
+			log.WithError(err).Error("fail to answer Slack interaction")
+		}
+	}()
+}
+
+// readSigned reads the form of a request of Slack after checking its
+// signature. When ok is false, the answer has already been written.
+func (c SlackController) readSigned(w http.ResponseWriter, r *http.Request) (form url.Values, ok bool) {
+	log := logger.Get(r.Context())
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSlackRequestSize))
+	if err != nil {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(response{
+			Message: "fail to read body",
+		})
+		return nil, false
+	}
+
+	signature := r.Header.Get(slack.SignatureHeader)
+	timestamp := r.Header.Get(slack.TimestampHeader)
+	if !slack.Verify(c.SigningSecret, signature, timestamp, body, time.Now()) {
+		log.Debugln("invalid Slack signature")
+		w.WriteHeader(401)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid request signature",
+		})
+		return nil, false
+	}
+
+	form, err = url.ParseQuery(string(body))
+	if err != nil {
+		w.WriteHeader(400)
+		json.NewEncoder(w).Encode(response{
+			Message: "invalid form",
+		})
+		return nil, false
+	}
+	return form, true
+}
+
+// source gives the source of a Slack channel, or of its team when the channel
+// is not mapped
+func (c SlackController) source(teamID, channelID string) string {
+	if source, ok := c.Sources[channelID]; ok {
+		return source
+	}
+	if source, ok := c.Sources[teamID]; ok {
+		return source
+	}
+	return teamID
+}
+
+func (c SlackController) randomExcuse(ctx context.Context, source string) slack.Message {
+	log := logger.Get(ctx)
+
+	excuse, err := c.RedisStore.GetRandom(ctx, source)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get random excuse"))
+		return slackEphemeral("Internal error")
+	}
+	if excuse == nil {
+		return slackEphemeral("There is no excuse yet, add one with `/excuse add`")
+	}
+	return c.excuseCard(ctx, source, *excuse)
+}
+
+func (c SlackController) showExcuse(ctx context.Context, source, id string) slack.Message {
+	log := logger.Get(ctx)
+
+	excuse, err := c.RedisStore.Get(ctx, source, id)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get excuse"))
+		return slackEphemeral("Internal error")
+	}
+	if excuse == nil {
+		return slackEphemeral("This excuse has been deleted")
+	}
+	return c.excuseCard(ctx, source, *excuse)
+}
+
+func (c SlackController) upvoteExcuse(ctx context.Context, source, id, userID string) slack.Message {
+	log := logger.Get(ctx)
+
+	excuse, err := c.RedisStore.Get(ctx, source, id)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get excuse"))
+		return slackEphemeral("Internal error")
+	}
+	if excuse == nil {
+		return slackEphemeral("This excuse has been deleted")
+	}
+	_, err = c.RedisStore.Upvote(ctx, source, id, userID)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to upvote excuse"))
+		return slackEphemeral("Internal error")
+	}
+
+	msg := c.excuseCard(ctx, source, *excuse)
+	msg.ReplaceOriginal = true
+	return msg
+}
+
+// addExcuse adds the excuse of "@author Title | Content", the author being
+// the mention escaped by Slack
+func (c SlackController) addExcuse(ctx context.Context, source string, cmd slack.Command, args string) slack.Message {
+	log := logger.Get(ctx)
+
+	author, rest := parseSlackMention(args)
+	parts := strings.SplitN(rest, "|", 2)
+	if author == nil || len(parts) != 2 {
+		return slackEphemeral(slackUsage)
+	}
+
+	excuse := models.Codexcuse{
+		Title:   strings.TrimSpace(parts[0]),
+		Content: strings.TrimSpace(parts[1]),
+		Author:  author,
+		Reporter: &models.User{
+			ID:       cmd.UserID,
+			UserName: cmd.UserName,
+		},
+	}
+	if excuse.Title == "" || excuse.Content == "" {
+		return slackEphemeral("The title and the content of an excuse are required\n" + slackUsage)
+	}
+
+	err := c.RedisStore.Add(ctx, source, &excuse)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save excuse"))
+		return slackEphemeral("Internal error")
+	}
+	return c.excuseCard(ctx, source, excuse)
+}
+
+func (c SlackController) searchExcuses(ctx context.Context, source, query string) slack.Message {
+	log := logger.Get(ctx)
+
+	if query == "" {
+		return slackEphemeral(slackUsage)
+	}
+	excuses, err := c.RedisStore.Search(ctx, source, query, maxSearchResults)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to search excuses"))
+		return slackEphemeral("Internal error")
+	}
+	if len(excuses) == 0 {
+		return slackEphemeral(fmt.Sprintf("No excuse matches _%s_", slack.Escape(query)))
+	}
+
+	text := fmt.Sprintf("Excuses matching _%s_", slack.Escape(query))
+	msg := slackEphemeral(text)
+	msg.Blocks = []slack.Block{{
+		Type: "section",
+		Text: slack.Markdown(text),
+	}}
+	for _, excuse := range excuses {
+		msg.Blocks = append(msg.Blocks, slack.Block{
+			Type: "section",
+			Text: slack.Markdown(fmt.Sprintf("*%s*\n%s", slack.Escape(excuse.Title), slack.Escape(firstLine(excuse.Content)))),
+			Accessory: &slack.Element{
+				Type:     "button",
+				Text:     slack.PlainText("Show"),
+				ActionID: slackShowAction,
+				Value:    excuse.ID,
+			},
+		})
+	}
+	return msg
+}
+
+// excuseCard is the message of a single excuse, with its upvotes and the
+// buttons to act on it
+func (c SlackController) excuseCard(ctx context.Context, source string, excuse models.Codexcuse) slack.Message {
+	log := logger.Get(ctx)
+
+	lines := strings.Split(slack.Escape(excuse.Content), "\n")
+	details := []string{}
+	if excuse.Author != nil {
+		details = append(details, "Author: "+slack.Escape(excuse.Author.UserName))
+	}
+	if excuse.Reporter != nil {
+		details = append(details, "Reporter: "+slack.Escape(excuse.Reporter.UserName))
+	}
+	upvotes, err := c.RedisStore.Upvotes(ctx, source, excuse.ID)
+	if err != nil {
+		log.WithError(err).Error("fail to count upvotes")
+	} else if upvotes > 0 {
+		details = append(details, fmt.Sprintf("Upvotes: %d", upvotes))
+	}
+	details = append(details, fmt.Sprintf("ID: `%s`", excuse.ID))
+
+	return slack.Message{
+		ResponseType: slack.ResponseInChannel,
+		Text:         slack.Escape(excuse.Title),
+		Blocks: []slack.Block{{
+			Type: "section",
+			Text: slack.Markdown(fmt.Sprintf("*%s*\n>%s", slack.Escape(excuse.Title), strings.Join(lines, "\n>"))),
+		}, {
+			Type:     "context",
+			Elements: []interface{}{slack.Markdown(strings.Join(details, " · "))},
+		}, {
+			Type: "actions",
+			Elements: []interface{}{slack.Element{
+				Type:     "button",
+				Text:     slack.PlainText("Another one"),
+				ActionID: slackAnotherAction,
+				Style:    "primary",
+			}, slack.Element{
+				Type:     "button",
+				Text:     slack.PlainText("Upvote"),
+				ActionID: slackUpvoteAction,
+				Value:    excuse.ID,
+			}},
+		}},
+	}
+}
+
+// parseSlackMention reads the user mention starting text, as escaped by Slack
+// in "<@U0123|name>" or "<@U0123>"
+func parseSlackMention(text string) (*models.User, string) {
+	if !strings.HasPrefix(text, "<@") {
+		return nil, text
+	}
+	end := strings.Index(text, ">")
+	if end == -1 {
+		return nil, text
+	}
+
+	user := &models.User{ID: text[2:end]}
+	if i := strings.Index(user.ID, "|"); i != -1 {
+		user.ID, user.UserName = user.ID[:i], user.ID[i+1:]
+	}
+	if user.UserName == "" {
+		user.UserName = user.ID
+	}
+	return user, strings.TrimSpace(text[end+1:])
+}
+
+func firstLine(text string) string {
+	if i := strings.Index(text, "\n"); i != -1 {
+		return text[:i]
+	}
+	return text
+}
+
+func slackEphemeral(text string) slack.Message {
+	return slack.Message{
+		ResponseType: slack.ResponseEphemeral,
+		Text:         text,
+	}
+}
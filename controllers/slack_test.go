@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/slack"
+)
+
+// redirectTransport sends every request to a test server, the Slack
+// response URLs are only accepted on hooks.slack.com
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme, r.URL.Host = t.target.Scheme, t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newSlackController(t *testing.T) SlackController {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	return NewSlackController(redisClient, config.Config{
+		SlackSigningSecret: "s3cr3t",
+		SlackSources:       map[string]string{"C-mapped": "guild"},
+	})
+}
+
+func postSlack(handler http.HandlerFunc, form url.Values, at time.Time) *httptest.ResponseRecorder {
+	body := form.Encode()
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/slack", strings.NewReader(body))
+	req.Header.Set(slack.TimestampHeader, timestamp)
+	req.Header.Set(slack.SignatureHeader, "v0="+hex.EncodeToString(mac.Sum(nil)))
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder
+}
+
+func slackCommand(t *testing.T, ctrl SlackController, channelID, text string) slack.Message {
+	t.Helper()
+	form := url.Values{"command": {"/excuse"}, "text": {text}, "team_id": {"T1"}, "channel_id": {channelID},
+		"user_id": {"U2"}, "user_name": {"alice"}}
+	recorder := postSlack(ctrl.Commands, form, time.Now())
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("%s: got %d %s, want 200", text, recorder.Code, recorder.Body)
+	}
+	var msg slack.Message
+	json.NewDecoder(recorder.Body).Decode(&msg)
+	return msg
+}
+
+func TestSlackCommandsSignature(t *testing.T) {
+	ctrl := newSlackController(t)
+	form := url.Values{"command": {"/excuse"}, "team_id": {"T1"}}
+
+	if recorder := postSlack(ctrl.Commands, form, time.Now()); recorder.Code != http.StatusOK {
+		t.Errorf("got %d %s, want 200", recorder.Code, recorder.Body)
+	}
+	if recorder := postSlack(ctrl.Commands, form, time.Now().Add(-10*time.Minute)); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("replayed request: got %d, want 401", recorder.Code)
+	}
+	ctrl.SigningSecret = "other"
+	if recorder := postSlack(ctrl.Commands, form, time.Now()); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("other secret: got %d, want 401", recorder.Code)
+	}
+}
+
+func TestSlackCommands(t *testing.T) {
+	ctrl := newSlackController(t)
+
+	msg := slackCommand(t, ctrl, "C1", "")
+	if msg.ResponseType != slack.ResponseEphemeral || !strings.Contains(msg.Text, "no excuse yet") {
+		t.Errorf("random without excuses: got %+v, want an ephemeral message", msg)
+	}
+	msg = slackCommand(t, ctrl, "C1", "add bob t | c")
+	if msg.ResponseType != slack.ResponseEphemeral || msg.Text != slackUsage {
+		t.Errorf("add without mention: got %+v, want the usage", msg)
+	}
+
+	msg = slackCommand(t, ctrl, "C1", "add <@U1|bob> Works on my machine | It <really> works")
+	if msg.ResponseType != slack.ResponseInChannel || msg.Text != "Works on my machine" {
+		t.Fatalf("add: got %+v, want the excuse card", msg)
+	}
+	details := msg.Blocks[1].Elements[0].(map[string]interface{})["text"].(string)
+	if !strings.Contains(details, "Author: bob") || !strings.Contains(details, "Reporter: alice") {
+		t.Errorf("add: got the details %q, want bob reported by alice", details)
+	}
+	if !strings.Contains(msg.Blocks[0].Text.Text, "It &lt;really&gt; works") {
+		t.Errorf("add: got %q, want the content escaped", msg.Blocks[0].Text.Text)
+	}
+
+	msg = slackCommand(t, ctrl, "C1", "random")
+	if msg.Text != "Works on my machine" {
+		t.Errorf("random: got %+v, want the excuse of the team", msg)
+	}
+	msg = slackCommand(t, ctrl, "C-mapped", "random")
+	if msg.ResponseType != slack.ResponseEphemeral {
+		t.Errorf("random in a mapped channel: got %+v, want none of the team excuses", msg)
+	}
+
+	msg = slackCommand(t, ctrl, "C1", "search machine")
+	if len(msg.Blocks) != 2 || msg.Blocks[1].Accessory == nil || msg.Blocks[1].Accessory.ActionID != slackShowAction {
+		t.Errorf("search: got %+v, want the excuse with a show button", msg)
+	}
+	msg = slackCommand(t, ctrl, "C1", "search <nothing>")
+	if msg.Text != "No excuse matches _&lt;nothing&gt;_" {
+		t.Errorf("search without results: got %q", msg.Text)
+	}
+	msg = slackCommand(t, ctrl, "C1", "unknown")
+	if msg.Text != slackUsage {
+		t.Errorf("unknown subcommand: got %q, want the usage", msg.Text)
+	}
+}
+
+func TestSlackInteractions(t *testing.T) {
+	ctrl := newSlackController(t)
+	responses := make(chan slack.Message, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slack.Message
+		json.NewDecoder(r.Body).Decode(&msg)
+		responses <- msg
+	}))
+	defer server.Close()
+	target, _ := url.Parse(server.URL)
+	ctrl.Client = &http.Client{Transport: redirectTransport{target: target}}
+
+	slackCommand(t, ctrl, "C1", "add <@U1|bob> t | c")
+
+	interact := func(responseURL, actionID string) {
+		t.Helper()
+		payload := `{"type": "block_actions", "team": {"id": "T1"}, "channel": {"id": "C1"}, "user": {"id": "U3"},
+			"actions": [{"action_id": "` + actionID + `"}], "response_url": "` + responseURL + `"}`
+		recorder := postSlack(ctrl.Interactions, url.Values{"payload": {payload}}, time.Now())
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: got %d %s, want 200", actionID, recorder.Code, recorder.Body)
+		}
+	}
+
+	interact("https://hooks.slack.com/actions/T1/1/x", slackAnotherAction)
+	select {
+	case msg := <-responses:
+		if msg.Text != "t" {
+			t.Errorf("another one: got %+v, want the excuse", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no response posted")
+	}
+
+	interact("https://evil.example.com/actions", slackAnotherAction)
+	interact("https://hooks.slack.com/actions/T1/1/x", "unknown")
+	select {
+	case msg := <-responses:
+		t.Errorf("got the response %+v, want none", msg)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	recorder := postSlack(ctrl.Interactions, url.Values{"payload": {"{"}}, time.Now())
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("invalid payload: got %d, want 400", recorder.Code)
+	}
+}
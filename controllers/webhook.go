@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+type WebhookController struct {
+	RedisStore *models.RedisStoreWebhooks
+}
+
+func NewWebhookController(redisClient *redis.Client) WebhookController {
+	return WebhookController{
+		RedisStore: &models.RedisStoreWebhooks{Client: redisClient},
+	}
+}
+
+type webhooksResp struct {
+	Webhooks []models.Webhook `json:"webhooks"`
+}
+
+type deadLettersResp struct {
+	DeadLetters []models.WebhookDelivery `json:"dead_letters"`
+}
+
+// GetWebhooks lists the webhooks of a source, secrets are not returned
+func (c WebhookController) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetWebhooks").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	webhooks, err := c.RedisStore.List(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get webhooks"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	hideWebhookSecrets(webhooks)
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(webhooksResp{
+		Webhooks: webhooks,
+	})
+}
+
+// GetWebhook gives the webhook with some ID, without its secret
+func (c WebhookController) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetWebhook").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	webhook, err := c.RedisStore.Get(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get webhook"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if webhook == nil {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	webhook.Secret = ""
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// AddWebhook subscribes a new webhook. The secret is generated when not
+// provided and is only returned by this call.
+func (c WebhookController) AddWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "AddWebhook").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	var webhook models.Webhook
+	_ = json.NewDecoder(r.Body).Decode(&webhook)
+
+	var retErrors []string
+	u, err := url.Parse(webhook.URL)
+	if webhook.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errorStr := "url field must be an absolute http(s) URL"
+		log.Debugln("fail to save webhook", errorStr)
+		retErrors = append(retErrors, errorStr)
+	}
+	if len(webhook.Events) == 0 {
+		errorStr := "missing events field"
+		log.Debugln("fail to save webhook", errorStr)
+		retErrors = append(retErrors, errorStr)
+	}
+	for _, event := range webhook.Events {
+		if !isWebhookEvent(event) {
+			errorStr := fmt.Sprintf("unknown event '%s', must be one of %s", event, strings.Join(models.WebhookEvents, ", "))
+			log.Debugln("fail to save webhook", errorStr)
+			retErrors = append(retErrors, errorStr)
+		}
+	}
+	if retErrors != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		errArray := make([]string, 0, len(retErrors))
+		for _, attrErrs := range retErrors {
+			errArray = append(errArray, fmt.Sprintf("\t→ %s", attrErrs))
+		}
+		resp := response{
+			Message: fmt.Sprintf("invalid arguments:\n%s", strings.Join(errArray, "\n")),
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = generateSecret()
+		if err != nil {
+			log.Error(errors.Wrap(err, "fail to generate webhook secret"))
+			resp := response{
+				Message: "Internal error",
+			}
+			w.WriteHeader(500)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	err = c.RedisStore.Add(ctx, vars["source"], &webhook)
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to save webhook"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(201)
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// DeleteWebhook deletes the webhook with some ID, its pending deliveries are
+// dropped by the workers
+func (c WebhookController) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteWebhook").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.Delete(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to delete webhook: "+vars["id"]))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(200)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetDeadLetters lists the deliveries of a source which failed for good, the
+// secrets of their targets are not returned
+func (c WebhookController) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetDeadLetters").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	deliveries, err := c.RedisStore.DeadLetters(ctx, vars["source"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to get dead letters"))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	hideDeliverySecrets(deliveries)
+
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(deadLettersResp{
+		DeadLetters: deliveries,
+	})
+}
+
+// ReplayDeadLetter queues a failed delivery again
+func (c WebhookController) ReplayDeadLetter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	log.WithField("function", "ReplayDeadLetter").WithField("path", r.URL.Path).Debug("received")
+	w.Header().Set("Content-Type", "application/json")
+	vars := mux.Vars(r)
+
+	found, err := c.RedisStore.Replay(ctx, vars["source"], vars["id"])
+	if err != nil {
+		log.Error(errors.Wrap(err, "fail to replay dead letter: "+vars["id"]))
+		resp := response{
+			Message: "Internal error",
+		}
+		w.WriteHeader(500)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp := response{
+			Message: "ID not found",
+		}
+		w.WriteHeader(404)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	w.WriteHeader(202)
+	resp := response{
+		Message: "ok",
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range models.WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hideWebhookSecrets blanks the secrets of the webhooks before they are
+// returned
+func hideWebhookSecrets(webhooks []models.Webhook) {
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+}
+
+// hideDeliverySecrets blanks the secrets of the targets of the deliveries
+// before they are returned
+func hideDeliverySecrets(deliveries []models.WebhookDelivery) {
+	for i := range deliveries {
+		if deliveries[i].Target != nil {
+			deliveries[i].Target.Secret = ""
+		}
+	}
+}
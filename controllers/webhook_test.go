@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookSecrets(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	ctrl := NewWebhookController(redisClient)
+	router := mux.NewRouter()
+	router.HandleFunc("/sources/{source}/webhooks", ctrl.GetWebhooks).Methods("GET")
+	router.HandleFunc("/sources/{source}/webhooks", ctrl.AddWebhook).Methods("POST")
+	router.HandleFunc("/sources/{source}/webhooks/dead-letters", ctrl.GetDeadLetters).Methods("GET")
+	router.HandleFunc("/sources/{source}/webhooks/{id}", ctrl.GetWebhook).Methods("GET")
+
+	recorder := serve(router, "POST", "/sources/guild/webhooks", `{"url": "https://example.com/hook", "events": ["excuse.created"]}`)
+	var webhook models.Webhook
+	json.NewDecoder(recorder.Body).Decode(&webhook)
+	if recorder.Code != http.StatusCreated || webhook.Secret == "" {
+		t.Fatalf("got %d %+v, want the webhook with its generated secret", recorder.Code, webhook)
+	}
+
+	ctx := context.Background()
+	failedAt := time.Now()
+	err := ctrl.RedisStore.DeadLetter(ctx, models.WebhookDelivery{
+		ID:       "d1",
+		RuleID:   "r1",
+		Target:   &models.HookTarget{Type: models.HookTargetHTTP, URL: "https://example.com/rule", Secret: "rule-secret"},
+		Source:   "guild",
+		Event:    models.EventHookRouted,
+		Payload:  json.RawMessage(`{}`),
+		FailedAt: &failedAt,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/sources/guild/webhooks", "/sources/guild/webhooks/" + webhook.ID, "/sources/guild/webhooks/dead-letters"} {
+		recorder := serve(router, "GET", path, "")
+		if recorder.Code != http.StatusOK || strings.Contains(recorder.Body.String(), "secret") {
+			t.Errorf("%s: got %d %s, want no secret", path, recorder.Code, recorder.Body)
+		}
+	}
+
+	// The dead letter keeps its secret for the replay
+	deadLetters, err := ctrl.RedisStore.DeadLetters(ctx, "guild")
+	if err != nil || len(deadLetters) != 1 || deadLetters[0].Target.Secret != "rule-secret" {
+		t.Fatalf("got %+v, %v, want the stored secret untouched", deadLetters, err)
+	}
+}
@@ -0,0 +1,585 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Frame types of the websocket protocol.
+//
+// Client to server:
+//
+//	{"type": "subscribe", "source": "guildID"}
+//	{"type": "unsubscribe", "source": "guildID"}
+//	{"type": "command", "id": "42", "command": "random", "source": "guildID"}
+//	{"type": "command", "id": "43", "command": "get", "source": "guildID", "excuse_id": "..."}
+//	{"type": "command", "id": "44", "command": "add", "source": "guildID", "excuse": {...}}
+//	{"type": "ping"}
+//
+// Server to client:
+//
+//	{"type": "subscribed", "source": "guildID"}
+//	{"type": "unsubscribed", "source": "guildID"}
+//	{"type": "result", "id": "42", "status": 200, "data": {...}}
+//	{"type": "event", "source": "guildID", "event": {...}}
+//	{"type": "error", "id": "42", "message": "..."}
+//	{"type": "pong"}
+const (
+	frameSubscribe    = "subscribe"
+	frameUnsubscribe  = "unsubscribe"
+	frameCommand      = "command"
+	framePing         = "ping"
+	frameSubscribed   = "subscribed"
+	frameUnsubscribed = "unsubscribed"
+	frameResult       = "result"
+	frameEvent        = "event"
+	frameError        = "error"
+	framePong         = "pong"
+)
+
+const (
+	commandRandom = "random"
+	commandGet    = "get"
+	commandAdd    = "add"
+)
+
+// wsWriteWait is the time allowed to write a frame to the peer
+const wsWriteWait = 10 * time.Second
+
+var (
+	errSendBufferFull = errors.New("send buffer full")
+	errShuttingDown   = errors.New("server shutting down")
+)
+
+// wsFrame is the union of all the fields a frame can carry
+type wsFrame struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id,omitempty"`
+	Command  string          `json:"command,omitempty"`
+	Source   string          `json:"source,omitempty"`
+	ExcuseID string          `json:"excuse_id,omitempty"`
+	Excuse   json.RawMessage `json:"excuse,omitempty"`
+	Status   int             `json:"status,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Event    json.RawMessage `json:"event,omitempty"`
+	Message  string          `json:"message,omitempty"`
+}
+
+// ClientLimiter limits the requests of the API clients on each source, by
+// route class
+type ClientLimiter interface {
+	// AllowClient counts a request of client on source, the limit is 0 when
+	// the class is not limited
+	AllowClient(ctx context.Context, class, source string, client *models.APIClient) (models.RateLimit, error)
+}
+
+// WebsocketSessions tracks the open websocket sessions. The HTTP server
+// doesn't wait for the hijacked connections on shutdown, they are closed by
+// Shutdown instead.
+type WebsocketSessions struct {
+	mu       sync.Mutex
+	sessions map[*wsSession]bool
+	closing  bool
+	wg       sync.WaitGroup
+}
+
+// DefaultWebsocketSessions holds the sessions of the controllers made by
+// NewWebsocketController
+var DefaultWebsocketSessions = NewWebsocketSessions()
+
+func NewWebsocketSessions() *WebsocketSessions {
+	return &WebsocketSessions{
+		sessions: map[*wsSession]bool{},
+	}
+}
+
+// add tracks s, it returns false once the sessions are closing
+func (ws *WebsocketSessions) add(s *wsSession) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closing {
+		return false
+	}
+	ws.sessions[s] = true
+	ws.wg.Add(1)
+	return true
+}
+
+func (ws *WebsocketSessions) remove(s *wsSession) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.sessions[s] {
+		delete(ws.sessions, s)
+		ws.wg.Done()
+	}
+}
+
+// Shutdown closes the sessions with a going away close frame and waits for
+// their commands to complete, or for ctx to be done. The sessions opened
+// afterwards are closed right away.
+func (ws *WebsocketSessions) Shutdown(ctx context.Context) error {
+	ws.mu.Lock()
+	ws.closing = true
+	sessions := make([]*wsSession, 0, len(ws.sessions))
+	for s := range ws.sessions {
+		sessions = append(sessions, s)
+	}
+	ws.mu.Unlock()
+
+	for _, s := range sessions {
+		s.close(websocket.CloseGoingAway, errShuttingDown)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ws.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type WebsocketController struct {
+	Excuses     ExcuseController
+	RedisClient *redis.Client
+	Config      config.Config
+	// Limiter applies the rate limits of the client to the commands, they are
+	// not limited when it is nil
+	Limiter  ClientLimiter
+	Sessions *WebsocketSessions
+	upgrader websocket.Upgrader
+}
+
+func NewWebsocketController(redisClient *redis.Client, config config.Config, limiter ClientLimiter) WebsocketController {
+	return WebsocketController{
+		Excuses:     NewExcuseController(redisClient),
+		RedisClient: redisClient,
+		Config:      config,
+		Limiter:     limiter,
+		Sessions:    DefaultWebsocketSessions,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+	}
+}
+
+// Serve upgrades the connection and runs the bot session until one of the
+// peers closes it
+func (c WebsocketController) Serve(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	log := logger.Get(ctx).WithField("function", "Serve")
+
+	log.WithField("path", r.URL.Path).Debug("received")
+
+	conn, err := c.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already answered with an HTTP error
+		log.WithError(err).Info("fail to upgrade websocket connection")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &wsSession{
+		ctrl:     c,
+		conn:     conn,
+		ctx:      ctx,
+		log:      log,
+		client:   models.APIClientFromContext(ctx),
+		ip:       ClientIP(r, c.Config.TrustProxyHeaders),
+		send:     make(chan wsFrame, c.Config.WebsocketSendBufferSize),
+		sources:  map[string]bool{},
+		inflight: make(chan struct{}, c.Config.WebsocketMaxInflightCommands),
+		done:     make(chan struct{}),
+	}
+	if !c.Sessions.add(s) {
+		s.close(websocket.CloseGoingAway, errShuttingDown)
+		return
+	}
+	defer c.Sessions.remove(s)
+	s.run()
+}
+
+// wsSession holds the state of a single websocket connection
+type wsSession struct {
+	ctrl WebsocketController
+	conn *websocket.Conn
+	ctx  context.Context
+	log  logrus.FieldLogger
+	// client is the API client of the connection, its sources and scopes
+	// restrict the subscriptions and the commands
+	client *models.APIClient
+	// ip is the address of the client, for the audit log
+	ip string
+
+	// send is the outgoing queue. Its capacity is the backpressure limit of
+	// the connection: a client unable to keep up is disconnected.
+	send chan wsFrame
+	// inflight bounds the number of commands processed concurrently
+	inflight chan struct{}
+
+	// commands tracks the commands in flight, which use the stores
+	commands sync.WaitGroup
+
+	mu      sync.Mutex
+	pubsub  *redis.PubSub
+	sources map[string]bool
+
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (s *wsSession) run() {
+	s.log.Info("websocket session started")
+	go s.writeLoop()
+	s.readLoop()
+	s.commands.Wait()
+
+	s.mu.Lock()
+	if s.pubsub != nil {
+		s.pubsub.Close()
+	}
+	s.mu.Unlock()
+	s.log.WithError(s.closeErr).Info("websocket session ended")
+}
+
+// close stops the session, the reason is sent to the peer in a close frame
+func (s *wsSession) close(code int, reason error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = reason
+		msg := ""
+		if reason != nil {
+			msg = reason.Error()
+		}
+		s.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, msg), time.Now().Add(wsWriteWait))
+		close(s.done)
+		s.conn.Close()
+	})
+}
+
+// enqueue adds a frame to the outgoing queue without ever blocking
+func (s *wsSession) enqueue(frame wsFrame) {
+	select {
+	case <-s.done:
+	case s.send <- frame:
+	default:
+		s.close(websocket.ClosePolicyViolation, errSendBufferFull)
+	}
+}
+
+func (s *wsSession) readLoop() {
+	pongWait := s.pongWait()
+	s.conn.SetReadLimit(s.ctrl.Config.WebsocketMaxMessageSize)
+	s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, reader, err := s.conn.NextReader()
+		if err != nil {
+			s.close(websocket.CloseNormalClosure, err)
+			return
+		}
+		s.conn.SetReadDeadline(time.Now().Add(pongWait))
+
+		var frame wsFrame
+		err = json.NewDecoder(reader).Decode(&frame)
+		if err == websocket.ErrReadLimit {
+			s.close(websocket.CloseMessageTooBig, err)
+			return
+		}
+		if err != nil {
+			// The rest of the message is skipped by the next read
+			s.enqueue(wsFrame{Type: frameError, Message: "invalid JSON frame: " + err.Error()})
+			continue
+		}
+
+		switch frame.Type {
+		case frameSubscribe:
+			s.subscribe(frame)
+		case frameUnsubscribe:
+			s.unsubscribe(frame)
+		case frameCommand:
+			select {
+			case s.inflight <- struct{}{}:
+				s.commands.Add(1)
+				go func() {
+					defer s.commands.Done()
+					defer func() { <-s.inflight }()
+					s.command(frame)
+				}()
+			default:
+				s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Status: http.StatusTooManyRequests, Message: "too many commands in flight"})
+			}
+		case framePing:
+			s.enqueue(wsFrame{Type: framePong, ID: frame.ID})
+		default:
+			s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: fmt.Sprintf("unknown frame type '%s'", frame.Type)})
+		}
+	}
+}
+
+func (s *wsSession) writeLoop() {
+	ticker := time.NewTicker(time.Duration(s.ctrl.Config.WebsocketPingPeriod) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case frame := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			err := s.conn.WriteJSON(frame)
+			if err != nil {
+				s.close(websocket.CloseInternalServerErr, errors.Wrap(err, "fail to write frame"))
+				return
+			}
+		case <-ticker.C:
+			err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			if err != nil {
+				s.close(websocket.CloseGoingAway, errors.Wrap(err, "fail to send ping"))
+				return
+			}
+		}
+	}
+}
+
+// pongWait is the time allowed to read the next pong from the peer. It must be
+// greater than the ping period.
+func (s *wsSession) pongWait() time.Duration {
+	return time.Duration(s.ctrl.Config.WebsocketPingPeriod) * time.Second * 3 / 2
+}
+
+func (s *wsSession) subscribe(frame wsFrame) {
+	if frame.Source == "" {
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: "missing source field"})
+		return
+	}
+	if !s.client.CanAccess(frame.Source) {
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Source: frame.Source, Message: "the client has no access to the source"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sources[frame.Source] {
+		s.enqueue(wsFrame{Type: frameSubscribed, ID: frame.ID, Source: frame.Source})
+		return
+	}
+	if len(s.sources) >= s.ctrl.Config.WebsocketMaxSubscriptions {
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Source: frame.Source, Message: "too many subscriptions"})
+		return
+	}
+
+	// The pubsub is created without channel, so that the error of the first
+	// subscription is not lost
+	first := s.pubsub == nil
+	if first {
+		s.pubsub = s.ctrl.RedisClient.Subscribe()
+	}
+	err := s.pubsub.Subscribe(models.EventsChannel(frame.Source))
+	if err != nil {
+		if first {
+			s.pubsub.Close()
+			s.pubsub = nil
+		}
+		s.log.WithError(err).Error("fail to subscribe to " + frame.Source)
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Source: frame.Source, Message: "Internal error"})
+		return
+	}
+
+	if first {
+		go s.forwardEvents(s.pubsub.Channel())
+	}
+	s.sources[frame.Source] = true
+	s.enqueue(wsFrame{Type: frameSubscribed, ID: frame.ID, Source: frame.Source})
+}
+
+func (s *wsSession) unsubscribe(frame wsFrame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sources[frame.Source] {
+		err := s.pubsub.Unsubscribe(models.EventsChannel(frame.Source))
+		if err != nil {
+			s.log.WithError(err).Error("fail to unsubscribe from " + frame.Source)
+			s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Source: frame.Source, Message: "Internal error"})
+			return
+		}
+		delete(s.sources, frame.Source)
+	}
+	s.enqueue(wsFrame{Type: frameUnsubscribed, ID: frame.ID, Source: frame.Source})
+}
+
+// forwardEvents relays the redis messages to the client until the pubsub is
+// closed
+func (s *wsSession) forwardEvents(messages <-chan *redis.Message) {
+	for msg := range messages {
+		var event models.Event
+		err := json.Unmarshal([]byte(msg.Payload), &event)
+		if err != nil {
+			s.log.WithError(err).Error("fail to unmarshal event")
+			continue
+		}
+		s.enqueue(wsFrame{Type: frameEvent, Source: event.Source, Event: json.RawMessage(msg.Payload)})
+	}
+}
+
+// command runs a command with the stores of the ExcuseController, the
+// result carries the status and the body the HTTP API would answer. The
+// commands count in the rate limits of the client like the requests.
+func (s *wsSession) command(frame wsFrame) {
+	if frame.Source == "" {
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: "missing source field"})
+		return
+	}
+	if !s.client.CanAccess(frame.Source) {
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Source: frame.Source, Message: "the client has no access to the source"})
+		return
+	}
+
+	var class string
+	switch frame.Command {
+	case commandRandom:
+		class = models.RateLimitRandom
+	case commandGet:
+		if frame.ExcuseID == "" {
+			s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: "missing excuse_id field"})
+			return
+		}
+		class = models.RateLimitRead
+	case commandAdd:
+		if !s.client.HasScope(models.ScopeExcusesWrite) {
+			s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: "the client lacks the scope " + models.ScopeExcusesWrite})
+			return
+		}
+		class = models.RateLimitWrite
+	default:
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: fmt.Sprintf("unknown command '%s'", frame.Command)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(s.ctrl.Config.ContextTimeout)*time.Second)
+	defer cancel()
+
+	if s.ctrl.Limiter != nil {
+		limit, err := s.ctrl.Limiter.AllowClient(ctx, class, frame.Source, s.client)
+		if err != nil {
+			s.log.WithError(err).Error("fail to rate limit command")
+		} else if !limit.Allowed {
+			retryAfter := int(math.Ceil(limit.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Status: http.StatusTooManyRequests,
+				Message: "too many requests, retry in " + strconv.Itoa(retryAfter) + "s"})
+			return
+		}
+	}
+
+	var status int
+	var data interface{}
+	switch frame.Command {
+	case commandRandom:
+		status, data = s.randomExcuse(ctx, frame)
+	case commandGet:
+		status, data = s.getExcuse(ctx, frame)
+	case commandAdd:
+		status, data = s.addExcuse(ctx, frame)
+	}
+
+	result := wsFrame{
+		Type:   frameResult,
+		ID:     frame.ID,
+		Status: status,
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		s.log.WithError(err).Error("fail to marshal command result")
+		s.enqueue(wsFrame{Type: frameError, ID: frame.ID, Message: "Internal error"})
+		return
+	}
+	result.Data = json.RawMessage(raw)
+	s.enqueue(result)
+}
+
+func (s *wsSession) randomExcuse(ctx context.Context, frame wsFrame) (int, interface{}) {
+	excuse, err := s.ctrl.Excuses.RedisStore.GetRandom(ctx, frame.Source)
+	if err != nil {
+		s.log.Error(errors.Wrap(err, "fail to get random excuse"))
+		return http.StatusInternalServerError, response{Message: "Internal error"}
+	}
+	return http.StatusOK, excuse
+}
+
+func (s *wsSession) getExcuse(ctx context.Context, frame wsFrame) (int, interface{}) {
+	excuse, err := s.ctrl.Excuses.RedisStore.Get(ctx, frame.Source, frame.ExcuseID)
+	if err != nil {
+		s.log.Error(errors.Wrap(err, "fail to get excuse"))
+		return http.StatusInternalServerError, response{Message: "Internal error"}
+	}
+	if excuse == nil {
+		return http.StatusUnprocessableEntity, response{Message: "ID not found"}
+	}
+	return http.StatusOK, excuse
+}
+
+// addExcuse adds the excuse of the frame and records it in the audit log
+func (s *wsSession) addExcuse(ctx context.Context, frame wsFrame) (int, interface{}) {
+	var excuse models.Codexcuse
+	_ = json.Unmarshal(frame.Excuse, &excuse)
+
+	status, data := http.StatusOK, interface{}(response{Message: "ok"})
+	trail := &models.AuditTrail{}
+	retErrors := validateExcuse(excuse)
+	if retErrors != nil {
+		status, data = http.StatusUnprocessableEntity, invalidArgumentsResp(retErrors)
+	} else {
+		err := s.ctrl.Excuses.RedisStore.Add(models.WithAuditTrail(ctx, trail), frame.Source, &excuse)
+		if err != nil {
+			s.log.Error(errors.Wrap(err, "fail to save excuse"))
+			status, data = http.StatusInternalServerError, response{Message: "Internal error"}
+		}
+	}
+
+	audit := &models.RedisStoreAudit{Client: s.ctrl.RedisClient}
+	// The commands of a session share the request ID of its upgrade
+	err := audit.RecordTrail(ctx, models.AuditRecord{
+		Action:     "WS " + frame.Command,
+		Client:     s.client.Name,
+		Source:     frame.Source,
+		Method:     http.MethodPost,
+		Path:       "/api/codexcuses/" + frame.Source,
+		StatusCode: status,
+		RequestID:  RequestIDFromContext(s.ctx),
+		IP:         s.ip,
+	}, trail)
+	if err != nil {
+		s.log.WithError(err).Error("fail to record audit log")
+	}
+	return status, data
+}
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/websocket"
+)
+
+// newTestRedis returns a client of a Redis server stopped at the end of the
+// test
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func testWebsocketConfig() config.Config {
+	return config.Config{
+		ContextTimeout:               5,
+		WebsocketPingPeriod:          30,
+		WebsocketMaxMessageSize:      65536,
+		WebsocketSendBufferSize:      64,
+		WebsocketMaxSubscriptions:    20,
+		WebsocketMaxInflightCommands: 8,
+	}
+}
+
+// writeLimiter allows a number of writes, the other classes are not limited
+type writeLimiter struct {
+	mu     sync.Mutex
+	writes int
+}
+
+func (l *writeLimiter) AllowClient(ctx context.Context, class, source string, client *models.APIClient) (models.RateLimit, error) {
+	if class != models.RateLimitWrite {
+		return models.RateLimit{Allowed: true}, nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writes--
+	return models.RateLimit{Allowed: l.writes >= 0, Limit: 1, RetryAfter: 30 * time.Second}, nil
+}
+
+// dialWebsocket serves ctrl to a client with access to every source
+func dialWebsocket(t *testing.T, ctrl WebsocketController) *websocket.Conn {
+	t.Helper()
+	client := &models.APIClient{
+		Name:    "bot",
+		Scopes:  []string{models.ScopeExcusesRead, models.ScopeExcusesWrite},
+		Sources: []string{models.AllSources},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctrl.Serve(w, r.WithContext(models.WithAPIClient(r.Context(), client)))
+	}))
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func exchange(t *testing.T, conn *websocket.Conn, frame string) wsFrame {
+	t.Helper()
+	err := conn.WriteMessage(websocket.TextMessage, []byte(frame))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var reply wsFrame
+	err = conn.ReadJSON(&reply)
+	if err != nil {
+		t.Fatalf("no reply to %s: %v", frame, err)
+	}
+	return reply
+}
+
+func TestWebsocketInvalidFramesKeepTheSession(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	conn := dialWebsocket(t, NewWebsocketController(redisClient, testWebsocketConfig(), nil))
+
+	for _, frame := range []string{`{"type": "ping"`, `{"type": "command", "id": 42}`, `[]`} {
+		reply := exchange(t, conn, frame)
+		if reply.Type != frameError {
+			t.Errorf("%s: got a %s frame, want an error", frame, reply.Type)
+		}
+	}
+	reply := exchange(t, conn, `{"type": "ping", "id": "1"}`)
+	if reply.Type != framePong || reply.ID != "1" {
+		t.Errorf("got %+v after the invalid frames, want a pong", reply)
+	}
+}
+
+func TestWebsocketSubscribeFailure(t *testing.T) {
+	server, redisClient := newTestRedis(t)
+	conn := dialWebsocket(t, NewWebsocketController(redisClient, testWebsocketConfig(), nil))
+
+	server.Close()
+	reply := exchange(t, conn, `{"type": "subscribe", "id": "1", "source": "guild"}`)
+	if reply.Type != frameError {
+		t.Fatalf("got a %s frame while Redis is down, want an error", reply.Type)
+	}
+
+	err := server.Restart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reply = exchange(t, conn, `{"type": "subscribe", "id": "2", "source": "guild"}`)
+	if reply.Type != frameSubscribed {
+		t.Fatalf("got %+v once Redis is back, want subscribed", reply)
+	}
+
+	// The subscription is effective once acknowledged by Redis
+	deadline := time.Now().Add(5 * time.Second)
+	for len(server.PubSubChannels("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	err = redisClient.Publish(models.EventsChannel("guild"), `{"type": "excuse.created", "source": "guild"}`).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var event wsFrame
+	err = conn.ReadJSON(&event)
+	if err != nil || event.Type != frameEvent || event.Source != "guild" {
+		t.Fatalf("got %+v, %v, want the event of guild", event, err)
+	}
+}
+
+func TestWebsocketCommands(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	limiter := &writeLimiter{writes: 1}
+	conn := dialWebsocket(t, NewWebsocketController(redisClient, testWebsocketConfig(), limiter))
+
+	excuse := `{"title": "t", "content": "c", "author": {"username": "a"}, "reporter": {"id": "1", "username": "r"}}`
+	reply := exchange(t, conn, `{"type": "command", "id": "1", "command": "add", "source": "guild", "excuse": `+excuse+`}`)
+	if reply.Type != frameResult || reply.Status != http.StatusOK {
+		t.Fatalf("add: got %+v, want a 200 result", reply)
+	}
+
+	reply = exchange(t, conn, `{"type": "command", "id": "2", "command": "add", "source": "guild", "excuse": `+excuse+`}`)
+	if reply.Type != frameError || reply.Status != http.StatusTooManyRequests {
+		t.Fatalf("add over the limit: got %+v, want a 429 error", reply)
+	}
+
+	reply = exchange(t, conn, `{"type": "command", "id": "3", "command": "add", "source": "guild", "excuse": {}}`)
+	if reply.Type != frameError || reply.Status != http.StatusTooManyRequests {
+		t.Fatalf("add over the limit: got %+v, want a 429 error", reply)
+	}
+
+	reply = exchange(t, conn, `{"type": "command", "id": "4", "command": "random", "source": "guild"}`)
+	if reply.Type != frameResult || reply.Status != http.StatusOK {
+		t.Fatalf("random: got %+v, want a 200 result", reply)
+	}
+	var got models.Codexcuse
+	err := json.Unmarshal(reply.Data, &got)
+	if err != nil || got.Title != "t" || got.ID == "" {
+		t.Fatalf("random: got %s, want the excuse added", reply.Data)
+	}
+
+	reply = exchange(t, conn, `{"type": "command", "id": "5", "command": "get", "source": "guild", "excuse_id": "`+got.ID+`"}`)
+	if reply.Type != frameResult || reply.Status != http.StatusOK {
+		t.Fatalf("get: got %+v, want a 200 result", reply)
+	}
+	reply = exchange(t, conn, `{"type": "command", "id": "6", "command": "get", "source": "guild", "excuse_id": "unknown"}`)
+	if reply.Type != frameResult || reply.Status != http.StatusUnprocessableEntity {
+		t.Fatalf("get unknown: got %+v, want a 422 result", reply)
+	}
+
+	records, err := (&models.RedisStoreAudit{Client: redisClient}).List(context.Background(), "guild", models.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Action != models.AuditExcuseCreated || records[0].StatusCode != http.StatusOK {
+		t.Fatalf("got the audit records %+v, want the one of the add", records)
+	}
+}
+
+func TestWebsocketSessionsShutdown(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	ctrl := NewWebsocketController(redisClient, testWebsocketConfig(), nil)
+	ctrl.Sessions = NewWebsocketSessions()
+	conn := dialWebsocket(t, ctrl)
+	if reply := exchange(t, conn, `{"type": "subscribe", "source": "guild"}`); reply.Type != frameSubscribed {
+		t.Fatalf("got %+v, want the subscription", reply)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := ctrl.Sessions.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("got %v, want the sessions ended", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v, want a going away close frame", err)
+	}
+
+	// The sessions opened afterwards are closed right away
+	conn = dialWebsocket(t, ctrl)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("got %v for a new session, want a going away close frame", err)
+	}
+}
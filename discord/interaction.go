@@ -0,0 +1,253 @@
+// Package discord holds the types of the Discord Interactions API used by
+// hook-manager, and the verification of the requests signed by Discord
+package discord
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SignatureHeader = "X-Signature-Ed25519"
+	TimestampHeader = "X-Signature-Timestamp"
+)
+
+// Interaction types
+const (
+	InteractionPing               = 1
+	InteractionApplicationCommand = 2
+	InteractionMessageComponent   = 3
+	InteractionAutocomplete       = 4
+	InteractionModalSubmit        = 5
+)
+
+// Interaction response types
+const (
+	ResponsePong                     = 1
+	ResponseChannelMessageWithSource = 4
+	ResponseUpdateMessage            = 7
+	ResponseAutocompleteResult       = 8
+	ResponseModal                    = 9
+)
+
+// Component types and styles
+const (
+	ComponentActionRow = 1
+	ComponentButton    = 2
+	ComponentTextInput = 4
+
+	ButtonPrimary   = 1
+	ButtonSecondary = 2
+	ButtonDanger    = 4
+
+	TextInputShort     = 1
+	TextInputParagraph = 2
+)
+
+// Application command option types
+const (
+	OptionSubcommand = 1
+	OptionString     = 3
+	OptionUser       = 6
+)
+
+// FlagEphemeral makes a message only visible to the user of the interaction
+const FlagEphemeral = 64
+
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type Member struct {
+	User *User `json:"user"`
+}
+
+type Interaction struct {
+	ID            string           `json:"id"`
+	ApplicationID string           `json:"application_id"`
+	Type          int              `json:"type"`
+	Data          *InteractionData `json:"data"`
+	GuildID       string           `json:"guild_id"`
+	ChannelID     string           `json:"channel_id"`
+	Member        *Member          `json:"member"`
+	User          *User            `json:"user"`
+	Token         string           `json:"token"`
+	Message       *Message         `json:"message"`
+}
+
+// Caller returns the user of the interaction, it is in Member inside a guild
+// and in User in direct messages
+func (i Interaction) Caller() *User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+type InteractionData struct {
+	ID            string      `json:"id"`
+	Name          string      `json:"name"`
+	Options       []Option    `json:"options"`
+	Resolved      *Resolved   `json:"resolved"`
+	CustomID      string      `json:"custom_id"`
+	ComponentType int         `json:"component_type"`
+	Components    []Component `json:"components"`
+}
+
+type Resolved struct {
+	Users map[string]User `json:"users"`
+}
+
+type Option struct {
+	Name    string          `json:"name"`
+	Type    int             `json:"type"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Options []Option        `json:"options,omitempty"`
+	Focused bool            `json:"focused,omitempty"`
+}
+
+// StringValue returns the value of a string, user or mentionable option
+func (o Option) StringValue() string {
+	var s string
+	json.Unmarshal(o.Value, &s)
+	return s
+}
+
+// Subcommand returns the subcommand of a command and its options
+func (d InteractionData) Subcommand() (string, []Option) {
+	if len(d.Options) == 1 && d.Options[0].Type == OptionSubcommand {
+		return d.Options[0].Name, d.Options[0].Options
+	}
+	return "", d.Options
+}
+
+// TextInput returns the value of a text input of a submitted modal
+func (d InteractionData) TextInput(customID string) string {
+	for _, row := range d.Components {
+		for _, c := range row.Components {
+			if c.CustomID == customID {
+				return c.Value
+			}
+		}
+	}
+	return ""
+}
+
+// FindOption returns the option with some name, nil if it was not given
+func FindOption(options []Option, name string) *Option {
+	for i := range options {
+		if options[i].Name == name {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+// FocusedOption returns the option the user is typing in an autocomplete
+// interaction, nil if there is none
+func FocusedOption(options []Option) *Option {
+	for i := range options {
+		if options[i].Focused {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+type Message struct {
+	ID     string  `json:"id"`
+	Embeds []Embed `json:"embeds"`
+}
+
+type InteractionResponse struct {
+	Type int           `json:"type"`
+	Data *ResponseData `json:"data,omitempty"`
+}
+
+type ResponseData struct {
+	Content    string      `json:"content,omitempty"`
+	Embeds     []Embed     `json:"embeds,omitempty"`
+	Flags      int         `json:"flags,omitempty"`
+	CustomID   string      `json:"custom_id,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Components []Component `json:"components,omitempty"`
+}
+
+// AutocompleteResponse answers an autocomplete interaction, Discord requires
+// the choices even when there are none
+type AutocompleteResponse struct {
+	Type int              `json:"type"`
+	Data AutocompleteData `json:"data"`
+}
+
+type AutocompleteData struct {
+	Choices []Choice `json:"choices"`
+}
+
+type Embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+	Footer      *EmbedFooter `json:"footer,omitempty"`
+}
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type EmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type Component struct {
+	Type        int         `json:"type"`
+	CustomID    string      `json:"custom_id,omitempty"`
+	Label       string      `json:"label,omitempty"`
+	Style       int         `json:"style,omitempty"`
+	Placeholder string      `json:"placeholder,omitempty"`
+	MinLength   int         `json:"min_length,omitempty"`
+	MaxLength   int         `json:"max_length,omitempty"`
+	Required    bool        `json:"required,omitempty"`
+	Value       string      `json:"value,omitempty"`
+	Components  []Component `json:"components,omitempty"`
+}
+
+type Choice struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ParsePublicKey decodes the hex encoded public key of a Discord application
+func ParsePublicKey(hexKey string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to decode public key")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key size")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Verify checks the Ed25519 signature of an interaction, computed by Discord
+// over the timestamp followed by the body
+func Verify(key ed25519.PublicKey, signature, timestamp string, body []byte) bool {
+	if len(key) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+
+	msg := make([]byte, 0, len(timestamp)+len(body))
+	msg = append(msg, timestamp...)
+	msg = append(msg, body...)
+	return ed25519.Verify(key, msg, sig)
+}
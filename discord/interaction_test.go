@@ -0,0 +1,79 @@
+package discord
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte(`{"type":1}`)
+	timestamp := "1625097600"
+	signature := hex.EncodeToString(ed25519.Sign(privateKey, append([]byte(timestamp), body...)))
+
+	tests := []struct {
+		name      string
+		key       ed25519.PublicKey
+		signature string
+		timestamp string
+		body      []byte
+		valid     bool
+	}{
+		{"valid", publicKey, signature, timestamp, body, true},
+		{"tampered body", publicKey, signature, timestamp, []byte(`{"type":2}`), false},
+		{"tampered timestamp", publicKey, signature, "1625097601", body, false},
+		{"other key", otherKey, signature, timestamp, body, false},
+		{"bad hex", publicKey, "zz" + signature[2:], timestamp, body, false},
+		{"short signature", publicKey, signature[:64], timestamp, body, false},
+		{"missing signature", publicKey, "", timestamp, body, false},
+		{"no key configured", nil, signature, timestamp, body, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Verify(test.key, test.signature, test.timestamp, test.body); got != test.valid {
+				t.Errorf("got %v, want %v", got, test.valid)
+			}
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ParsePublicKey(hex.EncodeToString(publicKey))
+	if err != nil || !key.Equal(publicKey) {
+		t.Errorf("got %x, %v, want the key", key, err)
+	}
+	for _, invalid := range []string{"zz", hex.EncodeToString(publicKey[:16]), ""} {
+		_, err := ParsePublicKey(invalid)
+		if err == nil {
+			t.Errorf("%q: got no error", invalid)
+		}
+	}
+}
+
+func TestSubcommand(t *testing.T) {
+	data := InteractionData{Options: []Option{{Name: "get", Type: OptionSubcommand, Options: []Option{
+		{Name: "id", Type: OptionString, Value: []byte(`"42"`), Focused: true},
+	}}}}
+	name, options := data.Subcommand()
+	if name != "get" || FindOption(options, "id").StringValue() != "42" || FocusedOption(options).Name != "id" {
+		t.Errorf("got the subcommand %s with %+v, want get with the id 42", name, options)
+	}
+	if FindOption(options, "user") != nil || FocusedOption(nil) != nil {
+		t.Error("got an option which was not given")
+	}
+}
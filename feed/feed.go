@@ -0,0 +1,165 @@
+// Package feed renders lists of entries as Atom and RSS 2.0 documents
+package feed
+
+import (
+	"encoding/xml"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AtomContentType = "application/atom+xml; charset=utf-8"
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+
+	atomNamespace       = "http://www.w3.org/2005/Atom"
+	dublinCoreNamespace = "http://purl.org/dc/elements/1.1/"
+	rssVersion          = "2.0"
+	defaultAuthor       = "hook-manager"
+)
+
+// Feed is a list of entries, newest first. ID must be a stable URI, Link is
+// the URL of the feed itself and may be empty.
+type Feed struct {
+	ID          string
+	Title       string
+	Description string
+	Link        string
+	Updated     time.Time
+	Entries     []Entry
+}
+
+// Entry is an item of a feed, ID must be stable for readers not to show it
+// twice
+type Entry struct {
+	ID        string
+	Title     string
+	Content   string
+	Author    string
+	Published time.Time
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    *atomLink   `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Author    *atomPerson `xml:"author"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	XMLNSDC string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	GUID        rssGUID `xml:"guid"`
+	Title       string  `xml:"title"`
+	Description string  `xml:"description"`
+	Creator     string  `xml:"dc:creator,omitempty"`
+	PubDate     string  `xml:"pubDate"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// Atom renders f as an Atom 1.0 document
+func Atom(f Feed) ([]byte, error) {
+	doc := atomFeed{
+		XMLNS:   atomNamespace,
+		ID:      f.ID,
+		Title:   f.Title,
+		Updated: f.Updated.UTC().Format(time.RFC3339),
+		Author:  atomPerson{Name: defaultAuthor},
+		Entries: make([]atomEntry, 0, len(f.Entries)),
+	}
+	if f.Link != "" {
+		doc.Link = &atomLink{Rel: "self", Href: f.Link}
+	}
+	for _, e := range f.Entries {
+		entry := atomEntry{
+			ID:        e.ID,
+			Title:     e.Title,
+			Updated:   e.Published.UTC().Format(time.RFC3339),
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "text", Text: e.Content},
+		}
+		if e.Author != "" {
+			entry.Author = &atomPerson{Name: e.Author}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+	return marshal(doc)
+}
+
+// RSS renders f as an RSS 2.0 document, the IDs of the entries are their
+// GUIDs
+func RSS(f Feed) ([]byte, error) {
+	doc := rssFeed{
+		Version: rssVersion,
+		XMLNSDC: dublinCoreNamespace,
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Description,
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(f.Entries)),
+		},
+	}
+	for _, e := range f.Entries {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			GUID:        rssGUID{Value: e.ID},
+			Title:       e.Title,
+			Description: e.Content,
+			Creator:     e.Author,
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+		})
+	}
+	return marshal(doc)
+}
+
+func marshal(doc interface{}) ([]byte, error) {
+	bytes, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to marshal feed")
+	}
+	return append([]byte(xml.Header), bytes...), nil
+}
@@ -0,0 +1,72 @@
+package feed
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testFeed = Feed{
+	ID:      "urn:codexcuses:source:guild",
+	Title:   "Excuses of guild",
+	Link:    "https://example.com/feeds/guild.atom",
+	Updated: time.Date(2024, 3, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600)),
+	Entries: []Entry{
+		{ID: "urn:uuid:1", Title: "It works <on my machine>", Content: "a & b", Author: "alice",
+			Published: time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{ID: "urn:uuid:2", Title: "Cache", Content: "c", Published: time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+	},
+}
+
+func TestAtom(t *testing.T) {
+	body, err := Atom(testFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(body)
+	for _, want := range []string{
+		`<?xml version="1.0" encoding="UTF-8"?>`,
+		`<feed xmlns="http://www.w3.org/2005/Atom">`,
+		`<updated>2024-03-01T09:00:00Z</updated>`,
+		`<link rel="self" href="https://example.com/feeds/guild.atom"></link>`,
+		`<title>It works &lt;on my machine&gt;</title>`,
+		`<content type="text">a &amp; b</content>`,
+		`<name>alice</name>`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("missing %s in\n%s", want, doc)
+		}
+	}
+	if strings.Count(doc, "<entry>") != 2 || strings.Count(doc, "<author>") != 2 {
+		t.Errorf("got\n%s\nwant 2 entries and the author of the feed and of the first entry", doc)
+	}
+
+	noLink := testFeed
+	noLink.Link = ""
+	body, _ = Atom(noLink)
+	if strings.Contains(string(body), "<link") {
+		t.Errorf("got a link without the one of the feed:\n%s", body)
+	}
+}
+
+func TestRSS(t *testing.T) {
+	body, err := RSS(testFeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := string(body)
+	for _, want := range []string{
+		`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		`<lastBuildDate>Fri, 01 Mar 2024 09:00:00 +0000</lastBuildDate>`,
+		`<guid isPermaLink="false">urn:uuid:1</guid>`,
+		`<description>a &amp; b</description>`,
+		`<dc:creator>alice</dc:creator>`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("missing %s in\n%s", want, doc)
+		}
+	}
+	if strings.Count(doc, "<item>") != 2 || strings.Count(doc, "<dc:creator>") != 1 {
+		t.Errorf("got\n%s\nwant 2 items and a single creator", doc)
+	}
+}
@@ -0,0 +1,139 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Statuses of the checks and of the instance. A degraded instance still
+// serves requests, with some features slowed down or unavailable.
+const (
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// DefaultTimeout is the time a check has to complete before it is down
+const DefaultTimeout = 2 * time.Second
+
+// Default holds the checks of the readiness of the instance
+var Default = NewChecker(DefaultTimeout)
+
+// CheckFunc returns nil when its dependency is up, an error made by Degraded
+// when it is degraded, and any other error when it is down. It should return
+// once ctx is done, it is reported down otherwise anyway.
+type CheckFunc func(ctx context.Context) error
+
+type degradedError struct {
+	error
+}
+
+// Degraded marks err as a degradation rather than an outage
+func Degraded(err error) error {
+	return degradedError{err}
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Message   string  `json:"message,omitempty"`
+}
+
+// Report is the outcome of every check, its status is the worst of them
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker runs the registered checks concurrently, each within the timeout
+type Checker struct {
+	Timeout time.Duration
+
+	mu     sync.Mutex
+	checks map[string]CheckFunc
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{
+		Timeout: timeout,
+		checks:  map[string]CheckFunc{},
+	}
+}
+
+// Register adds a check, replacing the one of the same name
+func (c *Checker) Register(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Run runs the checks, the results are sorted by name
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make(map[string]CheckFunc, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+	}
+	c.mu.Unlock()
+
+	results := make(chan Result, len(checks))
+	for name, check := range checks {
+		go func(name string, check CheckFunc) {
+			results <- c.run(ctx, name, check)
+		}(name, check)
+	}
+
+	report := Report{
+		Status: StatusUp,
+		Checks: make([]Result, 0, len(checks)),
+	}
+	for range checks {
+		result := <-results
+		report.Checks = append(report.Checks, result)
+		if result.Status == StatusDown ||
+			(result.Status == StatusDegraded && report.Status == StatusUp) {
+			report.Status = result.Status
+		}
+	}
+	sort.Slice(report.Checks, func(i, j int) bool {
+		return report.Checks[i].Name < report.Checks[j].Name
+	})
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, name string, check CheckFunc) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "check did not complete")
+	}
+
+	result := Result{
+		Name:      name,
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		if _, ok := err.(degradedError); ok {
+			result.Status = StatusDegraded
+		}
+		result.Message = err.Error()
+	}
+	return result
+}
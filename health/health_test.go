@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckerRun(t *testing.T) {
+	up := func(context.Context) error { return nil }
+	degraded := func(context.Context) error { return Degraded(errors.New("slow")) }
+	down := func(context.Context) error { return errors.New("unreachable") }
+
+	tests := []struct {
+		name   string
+		checks map[string]CheckFunc
+		status string
+	}{
+		{"no check", map[string]CheckFunc{}, StatusUp},
+		{"up", map[string]CheckFunc{"a": up, "b": up}, StatusUp},
+		{"degraded", map[string]CheckFunc{"a": up, "b": degraded}, StatusDegraded},
+		{"down", map[string]CheckFunc{"a": down, "b": degraded, "c": up}, StatusDown},
+	}
+	for _, test := range tests {
+		checker := NewChecker(time.Second)
+		for name, check := range test.checks {
+			checker.Register(name, check)
+		}
+		report := checker.Run(context.Background())
+		if report.Status != test.status || len(report.Checks) != len(test.checks) {
+			t.Errorf("%s: got %+v, want %s with %d checks", test.name, report, test.status, len(test.checks))
+		}
+	}
+
+	checker := NewChecker(time.Second)
+	checker.Register("b", down)
+	checker.Register("a", up)
+	checker.Register("c", degraded)
+	checker.Register("b", up)
+	report := checker.Run(context.Background())
+	want := []Result{
+		{Name: "a", Status: StatusUp},
+		{Name: "b", Status: StatusUp},
+		{Name: "c", Status: StatusDegraded, Message: "slow"},
+	}
+	if len(report.Checks) != len(want) {
+		t.Fatalf("got %+v, want %+v", report.Checks, want)
+	}
+	for i, result := range report.Checks {
+		result.LatencyMs = 0
+		if result != want[i] {
+			t.Errorf("got %+v, want %+v", result, want[i])
+		}
+	}
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	release := make(chan struct{})
+	defer close(release)
+	checker.Register("stuck", func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	start := time.Now()
+	report := checker.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("got the report after %v, want it within the timeout", elapsed)
+	}
+	stuck := report.Checks[0]
+	if report.Status != StatusDown || stuck.Status != StatusDown || !strings.Contains(stuck.Message, "check did not complete") || stuck.LatencyMs < 50 {
+		t.Errorf("got %+v for the stuck check, want it down after the timeout", stuck)
+	}
+}
@@ -0,0 +1,39 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// RedisPing checks that Redis answers a PING
+func RedisPing(client *redis.Client) CheckFunc {
+	return func(ctx context.Context) error {
+		err := client.Ping().Err()
+		if err != nil {
+			return errors.Wrap(err, "fail to ping redis")
+		}
+		return nil
+	}
+}
+
+// RedisPool reports the instance degraded when every connection of the pool
+// is in use, or when requests waited in vain for a connection since the
+// previous check
+func RedisPool(client *redis.Client, poolSize int) CheckFunc {
+	var lastTimeouts uint32
+	return func(ctx context.Context) error {
+		stats := client.PoolStats()
+		previous := atomic.SwapUint32(&lastTimeouts, stats.Timeouts)
+		if stats.Timeouts > previous {
+			return Degraded(fmt.Errorf("%d requests for a redis connection timed out", stats.Timeouts-previous))
+		}
+		if int(stats.TotalConns) >= poolSize && stats.IdleConns == 0 {
+			return Degraded(fmt.Errorf("the %d connections of the redis pool are in use", stats.TotalConns))
+		}
+		return nil
+	}
+}
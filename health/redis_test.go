@@ -0,0 +1,85 @@
+package health
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+)
+
+func TestRedisPing(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	defer client.Close()
+	check := RedisPing(client)
+
+	err = check(context.Background())
+	if err != nil {
+		t.Fatalf("got %v, want redis up", err)
+	}
+	server.Close()
+	err = check(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "fail to ping redis") {
+		t.Fatalf("got %v, want redis down", err)
+	}
+	if _, ok := err.(degradedError); ok {
+		t.Error("got redis degraded, want it down")
+	}
+}
+
+func TestRedisPool(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{
+		Addr:        server.Addr(),
+		MaxRetries:  0,
+		PoolSize:    1,
+		PoolTimeout: 10 * time.Millisecond,
+	})
+	defer client.Close()
+	check := RedisPool(client, 1)
+	ctx := context.Background()
+
+	if err := check(ctx); err != nil {
+		t.Fatalf("got %v for an idle pool, want it up", err)
+	}
+
+	// The transaction holds the only connection of the pool while it runs
+	err = client.Watch(func(tx *redis.Tx) error {
+		if client.Ping().Err() == nil {
+			t.Fatal("got a connection from a full pool, want a pool timeout")
+		}
+
+		tests := []struct {
+			name    string
+			message string
+		}{
+			{"timeouts", "1 requests for a redis connection timed out"},
+			{"same timeouts", "the 1 connections of the redis pool are in use"},
+		}
+		for _, test := range tests {
+			err := check(ctx)
+			if _, ok := err.(degradedError); !ok || err.Error() != test.message {
+				t.Errorf("%s: got %v, want degraded with %q", test.name, err, test.message)
+			}
+		}
+		return nil
+	}, "key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := check(ctx); err != nil {
+		t.Fatalf("got %v once the connection is released, want the pool up", err)
+	}
+}
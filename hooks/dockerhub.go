@@ -0,0 +1,143 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// States of a Docker Hub callback
+const (
+	DockerHubStateSuccess = "success"
+	DockerHubStateFailure = "failure"
+	DockerHubStateError   = "error"
+)
+
+// VerifyDockerHubToken checks the token given in the hook URL, Docker Hub does
+// not sign its hooks
+func VerifyDockerHubToken(secret, token string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1
+}
+
+type dockerHubPayload struct {
+	CallbackURL string `json:"callback_url"`
+	PushData    struct {
+		PushedAt json.Number `json:"pushed_at"`
+		Pusher   string      `json:"pusher"`
+		Tag      string      `json:"tag"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+		RepoURL  string `json:"repo_url"`
+	} `json:"repository"`
+}
+
+// DockerHubCallbackURL returns the callback URL of a payload, even when the
+// rest of it is invalid, so that the failure can be reported to Docker Hub
+func DockerHubCallbackURL(body []byte) string {
+	var payload struct {
+		CallbackURL string `json:"callback_url"`
+	}
+	_ = json.Unmarshal(body, &payload)
+	return payload.CallbackURL
+}
+
+// ParseDockerHub normalises an image push. Docker Hub does not identify its
+// deliveries, the ID is derived from the callback URL, unique to each push,
+// or else from the repository, the tag and the push time, so that the
+// redeliveries are recognised.
+func ParseDockerHub(body []byte) (*models.HookEvent, error) {
+	var payload dockerHubPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal Docker Hub payload")
+	}
+	if payload.Repository.RepoName == "" {
+		return nil, errors.New("missing repository")
+	}
+
+	return &models.HookEvent{
+		ID:         dockerHubDeliveryID(payload),
+		Provider:   models.HookProviderDockerHub,
+		Type:       models.HookTypePush,
+		Repository: payload.Repository.RepoName,
+		Tag:        payload.PushData.Tag,
+		Actor:      payload.PushData.Pusher,
+		URL:        payload.Repository.RepoURL,
+		ReceivedAt: time.Now().UTC(),
+	}, nil
+}
+
+func dockerHubDeliveryID(payload dockerHubPayload) string {
+	var name string
+	switch {
+	case payload.CallbackURL != "":
+		name = payload.CallbackURL
+	case payload.PushData.PushedAt != "":
+		name = strings.Join([]string{payload.Repository.RepoName, payload.PushData.Tag, payload.PushData.PushedAt.String()}, ":")
+	default:
+		return uuid.New().String()
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte("dockerhub:"+name)).String()
+}
+
+// ValidDockerHubCallback checks that the callback URL targets one of the
+// allowed hosts, so that a forged payload cannot make the server call any URL
+func ValidDockerHubCallback(callbackURL string, allowedHosts []string) bool {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return false
+	}
+	for _, host := range allowedHosts {
+		if u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+type dockerHubCallback struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+	Context     string `json:"context"`
+}
+
+// DockerHubCallback reports the state of the processing of a hook to
+// Docker Hub
+func DockerHubCallback(ctx context.Context, client *http.Client, callbackURL, state, description string) error {
+	body, err := json.Marshal(dockerHubCallback{
+		State:       state,
+		Description: description,
+		Context:     "hook-manager",
+	})
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal callback")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "fail to build callback request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "fail to send callback")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected callback status code %d", res.StatusCode)
+	}
+	return nil
+}
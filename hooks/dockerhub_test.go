@@ -0,0 +1,130 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParseDockerHub(t *testing.T) {
+	body := []byte(`{"callback_url": "https://registry.hub.docker.com/u/o/r/hook/1/",
+		"push_data": {"pushed_at": 1417566161, "pusher": "alice", "tag": "latest"},
+		"repository": {"repo_name": "o/r", "repo_url": "https://hub.docker.com/r/o/r"}}`)
+	event, err := ParseDockerHub(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Repository != "o/r" || event.Tag != "latest" || event.Actor != "alice" || event.URL != "https://hub.docker.com/r/o/r" {
+		t.Errorf("got %+v, want the push of alice", event)
+	}
+
+	// The redeliveries share their ID
+	again, _ := ParseDockerHub(body)
+	if event.ID == "" || again.ID != event.ID {
+		t.Errorf("got the IDs %q and %q for the same payload, want the same one", event.ID, again.ID)
+	}
+	other, _ := ParseDockerHub([]byte(`{"callback_url": "https://registry.hub.docker.com/u/o/r/hook/2/", "repository": {"repo_name": "o/r"}}`))
+	if other.ID == event.ID {
+		t.Error("got the same ID for another callback URL")
+	}
+
+	noCallback := `{"push_data": {"pushed_at": 1417566161, "tag": "latest"}, "repository": {"repo_name": "o/r"}}`
+	first, _ := ParseDockerHub([]byte(noCallback))
+	second, _ := ParseDockerHub([]byte(noCallback))
+	if first.ID != second.ID {
+		t.Errorf("got the IDs %q and %q without callback URL, want the one of the push time", first.ID, second.ID)
+	}
+	later, _ := ParseDockerHub([]byte(`{"push_data": {"pushed_at": 1417566162, "tag": "latest"}, "repository": {"repo_name": "o/r"}}`))
+	if later.ID == first.ID {
+		t.Error("got the same ID for a later push")
+	}
+
+	for _, invalid := range []string{`{`, `{"callback_url": "x"}`, `{"repository": {"repo_name": 42}}`} {
+		_, err := ParseDockerHub([]byte(invalid))
+		if err == nil {
+			t.Errorf("%s: got no error", invalid)
+		}
+	}
+}
+
+func TestDockerHubCallbackURL(t *testing.T) {
+	tests := map[string]string{
+		`{"callback_url": "https://registry.hub.docker.com/u/o/r/hook/1/"}`:                                  "https://registry.hub.docker.com/u/o/r/hook/1/",
+		`{"callback_url": "https://registry.hub.docker.com/u/o/r/hook/1/", "repository": {"repo_name": 42}}`: "https://registry.hub.docker.com/u/o/r/hook/1/",
+		`{"repository": {"repo_name": "o/r"}}`:                                                               "",
+		`{`:                                                                                                  "",
+	}
+	for body, want := range tests {
+		if got := DockerHubCallbackURL([]byte(body)); got != want {
+			t.Errorf("%s: got %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestValidDockerHubCallback(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	allowed := []string{"registry.hub.docker.com", u.Host}
+
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://registry.hub.docker.com/u/o/r/hook/1/", true},
+		{server.URL + "/callback", true},
+		{"https://evil.example.com/u/o/r/hook/1/", false},
+		{"https://registry.hub.docker.com.evil.example.com/", false},
+		{"https://registry.hub.docker.com@evil.example.com/", false},
+		{"https://registry.hub.docker.com:8443/", false},
+		{"ftp://registry.hub.docker.com/", false},
+		{"registry.hub.docker.com/u/o/r/hook/1/", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := ValidDockerHubCallback(test.url, allowed); got != test.valid {
+			t.Errorf("%s: got %v, want %v", test.url, got, test.valid)
+		}
+	}
+}
+
+func TestDockerHubCallback(t *testing.T) {
+	var got dockerHubCallback
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got a %s request of %s, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	tests := []struct {
+		state       string
+		description string
+	}{
+		{DockerHubStateSuccess, "hook received"},
+		{DockerHubStateFailure, "fail to process hook"},
+	}
+	for _, test := range tests {
+		err := DockerHubCallback(ctx, server.Client(), server.URL, test.state, test.description)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := dockerHubCallback{State: test.state, Description: test.description, Context: "hook-manager"}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	status = http.StatusNotFound
+	err := DockerHubCallback(ctx, server.Client(), server.URL, DockerHubStateSuccess, "hook received")
+	if err == nil {
+		t.Error("got no error on a 404")
+	}
+}
@@ -0,0 +1,58 @@
+package hooks
+
+import (
+	"encoding/json"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	GiteaEventHeader     = "X-Gitea-Event"
+	GiteaDeliveryHeader  = "X-Gitea-Delivery"
+	GiteaSignatureHeader = "X-Gitea-Signature"
+)
+
+// VerifyGiteaSignature checks the X-Gitea-Signature header of a hook, the hex
+// encoded HMAC-SHA256 of the body without any prefix
+func VerifyGiteaSignature(secret, signature string, body []byte) bool {
+	return validHMAC(secret, signature, "", body)
+}
+
+// giteaPayload is close to the GitHub one, only a few fields are named
+// differently
+type giteaPayload struct {
+	githubPayload
+	CompareURL string `json:"compare_url"`
+	RefType    string `json:"ref_type"`
+}
+
+// ParseGitea normalises the push, create (of a tag), pull_request, release and
+// workflow_run events
+func ParseGitea(eventName, deliveryID string, body []byte) (*models.HookEvent, error) {
+	switch eventName {
+	case "push", "create", "pull_request", "release", "workflow_run":
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+
+	var payload giteaPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal Gitea payload")
+	}
+
+	if eventName == "create" {
+		if payload.RefType != "tag" {
+			return nil, ErrUnsupportedEvent
+		}
+		// Gitea gives the bare tag name, normalise it as a tag push
+		eventName = "push"
+		payload.Ref = "refs/tags/" + payload.Ref
+	}
+	if payload.Compare == "" {
+		payload.Compare = payload.CompareURL
+	}
+
+	return githubEvent(models.HookProviderGitea, eventName, deliveryID, payload.githubPayload), nil
+}
@@ -0,0 +1,96 @@
+package hooks
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+func TestVerifyGiteaSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		body      []byte
+		valid     bool
+	}{
+		{"valid", "s3cr3t", sign("s3cr3t", body), body, true},
+		{"tampered body", "s3cr3t", sign("s3cr3t", body), []byte(`{"ref":"refs/heads/dev"}`), false},
+		{"other secret", "s3cr3t", sign("other", body), body, false},
+		{"GitHub prefix", "s3cr3t", "sha256=" + sign("s3cr3t", body), body, false},
+		{"invalid hex", "s3cr3t", "zz", body, false},
+		{"missing signature", "s3cr3t", "", body, false},
+		{"no secret configured", "", sign("", body), body, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyGiteaSignature(test.secret, test.signature, test.body); got != test.valid {
+				t.Errorf("got %v, want %v", got, test.valid)
+			}
+		})
+	}
+}
+
+func TestParseGitea(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		body  string
+		want  models.HookEvent
+	}{
+		{
+			name:  "push",
+			event: "push",
+			body: `{"ref": "refs/heads/main", "compare_url": "https://gitea.example.com/o/r/compare/a...b",
+				"repository": {"full_name": "o/r"}, "sender": {"login": "alice"}}`,
+			want: models.HookEvent{Type: models.HookTypePush, Branch: "main", Repository: "o/r", Actor: "alice",
+				URL: "https://gitea.example.com/o/r/compare/a...b"},
+		},
+		{
+			name:  "tag creation",
+			event: "create",
+			body:  `{"ref": "v1.0.0", "ref_type": "tag", "repository": {"full_name": "o/r"}, "sender": {"login": "alice"}}`,
+			want:  models.HookEvent{Type: models.HookTypeTag, Tag: "v1.0.0", Repository: "o/r", Actor: "alice"},
+		},
+		{
+			name:  "merged pull request",
+			event: "pull_request",
+			body: `{"action": "closed", "repository": {"full_name": "o/r"}, "sender": {"login": "bob"},
+				"pull_request": {"title": "Add x", "html_url": "https://gitea.example.com/o/r/pulls/1", "merged": true, "head": {"ref": "feature"}}}`,
+			want: models.HookEvent{Type: models.HookTypePullRequest, Action: "merged", Branch: "feature", Title: "Add x",
+				Repository: "o/r", Actor: "bob", URL: "https://gitea.example.com/o/r/pulls/1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := ParseGitea(test.event, "delivery-1", []byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.ID != "delivery-1" || event.Provider != models.HookProviderGitea || event.ReceivedAt.IsZero() {
+				t.Errorf("got the ID %s and provider %s, want the ones of the delivery", event.ID, event.Provider)
+			}
+			got := *event
+			got.ID, got.Provider, got.Commits, got.ReceivedAt = "", "", nil, test.want.ReceivedAt
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	for _, unsupported := range []struct{ event, body string }{
+		{"issues", `{}`},
+		{"create", `{"ref": "feature", "ref_type": "branch"}`},
+	} {
+		_, err := ParseGitea(unsupported.event, "d", []byte(unsupported.body))
+		if err != ErrUnsupportedEvent {
+			t.Errorf("%s %s: got %v, want ErrUnsupportedEvent", unsupported.event, unsupported.body, err)
+		}
+	}
+	_, err := ParseGitea("push", "d", []byte(`{`))
+	if err == nil || err == ErrUnsupportedEvent {
+		t.Errorf("got %v for an invalid body, want a parse error", err)
+	}
+}
@@ -0,0 +1,147 @@
+package hooks
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/pkg/errors"
+)
+
+const (
+	GitHubEventHeader     = "X-GitHub-Event"
+	GitHubDeliveryHeader  = "X-GitHub-Delivery"
+	GitHubSignatureHeader = "X-Hub-Signature-256"
+)
+
+// VerifyGitHubSignature checks the X-Hub-Signature-256 header of a hook
+func VerifyGitHubSignature(secret, signature string, body []byte) bool {
+	return validHMAC(secret, signature, "sha256=", body)
+}
+
+type githubUser struct {
+	Login string `json:"login"`
+}
+
+type githubPayload struct {
+	Action     string `json:"action"`
+	Ref        string `json:"ref"`
+	Compare    string `json:"compare"`
+	Deleted    bool   `json:"deleted"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+	Sender  githubUser `json:"sender"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	PullRequest struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+		Head    struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	} `json:"pull_request"`
+	Issue struct {
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+	} `json:"issue"`
+	Release struct {
+		Name    string `json:"name"`
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+	WorkflowRun struct {
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+	} `json:"workflow_run"`
+}
+
+// ParseGitHub normalises the push, pull_request, issues, release and
+// workflow_run events
+func ParseGitHub(eventName, deliveryID string, body []byte) (*models.HookEvent, error) {
+	switch eventName {
+	case "push", "pull_request", "issues", "release", "workflow_run":
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+
+	var payload githubPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal GitHub payload")
+	}
+
+	return githubEvent(models.HookProviderGitHub, eventName, deliveryID, payload), nil
+}
+
+// githubEvent normalises a payload in the GitHub format, which is shared by
+// other providers such as Gitea
+func githubEvent(provider, eventName, deliveryID string, payload githubPayload) *models.HookEvent {
+	event := &models.HookEvent{
+		ID:         deliveryID,
+		Provider:   provider,
+		Action:     payload.Action,
+		Repository: payload.Repository.FullName,
+		Actor:      payload.Sender.Login,
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	switch eventName {
+	case "push":
+		event.Type = models.HookTypePush
+		event.Branch, event.Tag = branchOrTag(payload.Ref)
+		if event.Tag != "" {
+			event.Type = models.HookTypeTag
+		}
+		if payload.Deleted {
+			event.Action = "deleted"
+		}
+		event.URL = payload.Compare
+		for _, c := range payload.Commits {
+			event.Commits = append(event.Commits, models.HookCommit{
+				ID:      c.ID,
+				Message: c.Message,
+				Author:  c.Author.Name,
+				URL:     c.URL,
+			})
+		}
+	case "pull_request":
+		event.Type = models.HookTypePullRequest
+		event.Branch = payload.PullRequest.Head.Ref
+		event.Title = payload.PullRequest.Title
+		event.URL = payload.PullRequest.HTMLURL
+		if payload.Action == "closed" && payload.PullRequest.Merged {
+			event.Action = "merged"
+		}
+	case "issues":
+		event.Type = models.HookTypeIssue
+		event.Title = payload.Issue.Title
+		event.URL = payload.Issue.HTMLURL
+	case "release":
+		event.Type = models.HookTypeRelease
+		event.Tag = payload.Release.TagName
+		event.Title = payload.Release.Name
+		event.URL = payload.Release.HTMLURL
+	case "workflow_run":
+		event.Type = models.HookTypePipeline
+		event.Branch = payload.WorkflowRun.HeadBranch
+		event.Title = payload.WorkflowRun.Name
+		event.Status = payload.WorkflowRun.Conclusion
+		if event.Status == "" {
+			event.Status = payload.WorkflowRun.Status
+		}
+		event.URL = payload.WorkflowRun.HTMLURL
+	}
+
+	return event
+}
@@ -0,0 +1,115 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+func sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyGitHubSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		body      []byte
+		valid     bool
+	}{
+		{"valid", "s3cr3t", "sha256=" + sign("s3cr3t", body), body, true},
+		{"tampered body", "s3cr3t", "sha256=" + sign("s3cr3t", body), []byte(`{"ref":"refs/heads/dev"}`), false},
+		{"other secret", "s3cr3t", "sha256=" + sign("other", body), body, false},
+		{"missing prefix", "s3cr3t", sign("s3cr3t", body), body, false},
+		{"sha1 prefix", "s3cr3t", "sha1=" + sign("s3cr3t", body), body, false},
+		{"invalid hex", "s3cr3t", "sha256=zz", body, false},
+		{"missing signature", "s3cr3t", "", body, false},
+		{"no secret configured", "", "sha256=" + sign("", body), body, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyGitHubSignature(test.secret, test.signature, test.body); got != test.valid {
+				t.Errorf("got %v, want %v", got, test.valid)
+			}
+		})
+	}
+}
+
+func TestParseGitHub(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		body  string
+		want  models.HookEvent
+	}{
+		{
+			name:  "push",
+			event: "push",
+			body: `{"ref": "refs/heads/main", "compare": "https://github.com/o/r/compare/a...b",
+				"repository": {"full_name": "o/r"}, "sender": {"login": "alice"},
+				"commits": [{"id": "b", "message": "fix", "url": "https://github.com/o/r/commit/b", "author": {"name": "Alice"}}]}`,
+			want: models.HookEvent{Type: models.HookTypePush, Branch: "main", Repository: "o/r", Actor: "alice",
+				URL: "https://github.com/o/r/compare/a...b"},
+		},
+		{
+			name:  "tag push",
+			event: "push",
+			body:  `{"ref": "refs/tags/v1.0.0", "repository": {"full_name": "o/r"}, "sender": {"login": "alice"}}`,
+			want:  models.HookEvent{Type: models.HookTypeTag, Tag: "v1.0.0", Repository: "o/r", Actor: "alice"},
+		},
+		{
+			name:  "merged pull request",
+			event: "pull_request",
+			body: `{"action": "closed", "repository": {"full_name": "o/r"}, "sender": {"login": "bob"},
+				"pull_request": {"title": "Add x", "html_url": "https://github.com/o/r/pull/1", "merged": true, "head": {"ref": "feature"}}}`,
+			want: models.HookEvent{Type: models.HookTypePullRequest, Action: "merged", Branch: "feature", Title: "Add x",
+				Repository: "o/r", Actor: "bob", URL: "https://github.com/o/r/pull/1"},
+		},
+		{
+			name:  "failed workflow run",
+			event: "workflow_run",
+			body: `{"action": "completed", "repository": {"full_name": "o/r"}, "sender": {"login": "bot"},
+				"workflow_run": {"name": "CI", "head_branch": "main", "status": "completed", "conclusion": "failure"}}`,
+			want: models.HookEvent{Type: models.HookTypePipeline, Action: "completed", Branch: "main", Title: "CI",
+				Status: "failure", Repository: "o/r", Actor: "bot"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := ParseGitHub(test.event, "delivery-1", []byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.ID != "delivery-1" || event.Provider != models.HookProviderGitHub || event.ReceivedAt.IsZero() {
+				t.Errorf("got the ID %s and provider %s, want the ones of the delivery", event.ID, event.Provider)
+			}
+			got := *event
+			got.ID, got.Provider, got.Commits, got.ReceivedAt = "", "", nil, test.want.ReceivedAt
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	event, _ := ParseGitHub("push", "d", []byte(tests[0].body))
+	if len(event.Commits) != 1 || event.Commits[0].Author != "Alice" || event.Commits[0].Message != "fix" {
+		t.Errorf("got the commits %+v, want the one of the push", event.Commits)
+	}
+
+	_, err := ParseGitHub("star", "d", []byte(`{}`))
+	if err != ErrUnsupportedEvent {
+		t.Errorf("got %v for a star event, want ErrUnsupportedEvent", err)
+	}
+	_, err = ParseGitHub("push", "d", []byte(`{`))
+	if err == nil || err == ErrUnsupportedEvent {
+		t.Errorf("got %v for an invalid body, want a parse error", err)
+	}
+}
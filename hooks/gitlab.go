@@ -0,0 +1,127 @@
+package hooks
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	GitLabTokenHeader     = "X-Gitlab-Token"
+	GitLabEventHeader     = "X-Gitlab-Event"
+	GitLabEventUUIDHeader = "X-Gitlab-Event-UUID"
+)
+
+// VerifyGitLabToken checks the X-Gitlab-Token header, GitLab sends the secret
+// token as is
+func VerifyGitLabToken(secret, token string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1
+}
+
+type gitlabProject struct {
+	PathWithNamespace string `json:"path_with_namespace"`
+	WebURL            string `json:"web_url"`
+}
+
+type gitlabPayload struct {
+	ObjectKind   string        `json:"object_kind"`
+	Ref          string        `json:"ref"`
+	After        string        `json:"after"`
+	UserUsername string        `json:"user_username"`
+	Project      gitlabProject `json:"project"`
+	User         struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Commits []struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+		Author  struct {
+			Name string `json:"name"`
+		} `json:"author"`
+	} `json:"commits"`
+	ObjectAttributes struct {
+		ID           int    `json:"id"`
+		Title        string `json:"title"`
+		URL          string `json:"url"`
+		Action       string `json:"action"`
+		SourceBranch string `json:"source_branch"`
+		Ref          string `json:"ref"`
+		Tag          bool   `json:"tag"`
+		Status       string `json:"status"`
+	} `json:"object_attributes"`
+}
+
+// deletedRef is the "after" commit of a push deleting a branch or a tag
+const deletedRef = "0000000000000000000000000000000000000000"
+
+// ParseGitLab normalises the push, tag_push, merge_request and pipeline
+// events. Old GitLab versions do not send an event UUID, a new ID is then given
+// to the delivery.
+func ParseGitLab(eventUUID string, body []byte) (*models.HookEvent, error) {
+	var payload gitlabPayload
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal GitLab payload")
+	}
+
+	if eventUUID == "" {
+		eventUUID = uuid.New().String()
+	}
+	event := &models.HookEvent{
+		ID:         eventUUID,
+		Provider:   models.HookProviderGitLab,
+		Repository: payload.Project.PathWithNamespace,
+		Actor:      payload.User.Username,
+		ReceivedAt: time.Now().UTC(),
+	}
+
+	switch payload.ObjectKind {
+	case "push", "tag_push":
+		event.Type = models.HookTypePush
+		event.Actor = payload.UserUsername
+		event.Branch, event.Tag = branchOrTag(payload.Ref)
+		if event.Tag != "" {
+			event.Type = models.HookTypeTag
+		}
+		if payload.After == deletedRef {
+			event.Action = "deleted"
+		}
+		event.URL = payload.Project.WebURL
+		for _, c := range payload.Commits {
+			event.Commits = append(event.Commits, models.HookCommit{
+				ID:      c.ID,
+				Message: c.Message,
+				Author:  c.Author.Name,
+				URL:     c.URL,
+			})
+		}
+	case "merge_request":
+		event.Type = models.HookTypePullRequest
+		event.Action = payload.ObjectAttributes.Action
+		if event.Action == "merge" {
+			event.Action = "merged"
+		}
+		event.Branch = payload.ObjectAttributes.SourceBranch
+		event.Title = payload.ObjectAttributes.Title
+		event.URL = payload.ObjectAttributes.URL
+	case "pipeline":
+		event.Type = models.HookTypePipeline
+		if payload.ObjectAttributes.Tag {
+			event.Tag = payload.ObjectAttributes.Ref
+		} else {
+			event.Branch = payload.ObjectAttributes.Ref
+		}
+		event.Status = payload.ObjectAttributes.Status
+		event.URL = fmt.Sprintf("%s/-/pipelines/%d", payload.Project.WebURL, payload.ObjectAttributes.ID)
+	default:
+		return nil, ErrUnsupportedEvent
+	}
+
+	return event, nil
+}
@@ -0,0 +1,118 @@
+package hooks
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+func TestVerifyGitLabToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		token  string
+		valid  bool
+	}{
+		{"valid", "t0k3n", "t0k3n", true},
+		{"other token", "t0k3n", "other", false},
+		{"prefix", "t0k3n", "t0k", false},
+		{"missing token", "t0k3n", "", false},
+		{"no secret configured", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyGitLabToken(test.secret, test.token); got != test.valid {
+				t.Errorf("got %v, want %v", got, test.valid)
+			}
+		})
+	}
+}
+
+func TestParseGitLab(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want models.HookEvent
+	}{
+		{
+			name: "push",
+			body: `{"object_kind": "push", "ref": "refs/heads/main", "after": "b", "user_username": "alice",
+				"project": {"path_with_namespace": "g/p", "web_url": "https://gitlab.example.com/g/p"},
+				"commits": [{"id": "b", "message": "fix", "url": "https://gitlab.example.com/g/p/-/commit/b", "author": {"name": "Alice"}}]}`,
+			want: models.HookEvent{Type: models.HookTypePush, Branch: "main", Repository: "g/p", Actor: "alice",
+				URL: "https://gitlab.example.com/g/p"},
+		},
+		{
+			name: "deleted branch",
+			body: `{"object_kind": "push", "ref": "refs/heads/old", "after": "0000000000000000000000000000000000000000",
+				"user_username": "alice", "project": {"path_with_namespace": "g/p", "web_url": "https://gitlab.example.com/g/p"}}`,
+			want: models.HookEvent{Type: models.HookTypePush, Action: "deleted", Branch: "old", Repository: "g/p", Actor: "alice",
+				URL: "https://gitlab.example.com/g/p"},
+		},
+		{
+			name: "tag push",
+			body: `{"object_kind": "tag_push", "ref": "refs/tags/v1.0.0", "after": "b", "user_username": "alice",
+				"project": {"path_with_namespace": "g/p", "web_url": "https://gitlab.example.com/g/p"}}`,
+			want: models.HookEvent{Type: models.HookTypeTag, Tag: "v1.0.0", Repository: "g/p", Actor: "alice",
+				URL: "https://gitlab.example.com/g/p"},
+		},
+		{
+			name: "merged merge request",
+			body: `{"object_kind": "merge_request", "user": {"username": "bob"}, "project": {"path_with_namespace": "g/p"},
+				"object_attributes": {"title": "Add x", "url": "https://gitlab.example.com/g/p/-/merge_requests/1",
+				"action": "merge", "source_branch": "feature"}}`,
+			want: models.HookEvent{Type: models.HookTypePullRequest, Action: "merged", Branch: "feature", Title: "Add x",
+				Repository: "g/p", Actor: "bob", URL: "https://gitlab.example.com/g/p/-/merge_requests/1"},
+		},
+		{
+			name: "failed pipeline",
+			body: `{"object_kind": "pipeline", "user": {"username": "bot"},
+				"project": {"path_with_namespace": "g/p", "web_url": "https://gitlab.example.com/g/p"},
+				"object_attributes": {"id": 7, "ref": "main", "status": "failed"}}`,
+			want: models.HookEvent{Type: models.HookTypePipeline, Branch: "main", Status: "failed", Repository: "g/p",
+				Actor: "bot", URL: "https://gitlab.example.com/g/p/-/pipelines/7"},
+		},
+		{
+			name: "tag pipeline",
+			body: `{"object_kind": "pipeline", "user": {"username": "bot"},
+				"project": {"path_with_namespace": "g/p", "web_url": "https://gitlab.example.com/g/p"},
+				"object_attributes": {"id": 8, "ref": "v1.0.0", "tag": true, "status": "success"}}`,
+			want: models.HookEvent{Type: models.HookTypePipeline, Tag: "v1.0.0", Status: "success", Repository: "g/p",
+				Actor: "bot", URL: "https://gitlab.example.com/g/p/-/pipelines/8"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event, err := ParseGitLab("uuid-1", []byte(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event.ID != "uuid-1" || event.Provider != models.HookProviderGitLab || event.ReceivedAt.IsZero() {
+				t.Errorf("got the ID %s and provider %s, want the ones of the delivery", event.ID, event.Provider)
+			}
+			got := *event
+			got.ID, got.Provider, got.Commits, got.ReceivedAt = "", "", nil, test.want.ReceivedAt
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+
+	event, _ := ParseGitLab("", []byte(tests[0].body))
+	if event.ID == "" {
+		t.Error("got no ID without event UUID")
+	}
+	if len(event.Commits) != 1 || event.Commits[0].Author != "Alice" || event.Commits[0].Message != "fix" {
+		t.Errorf("got the commits %+v, want the one of the push", event.Commits)
+	}
+
+	_, err := ParseGitLab("uuid-2", []byte(`{"object_kind": "note"}`))
+	if err != ErrUnsupportedEvent {
+		t.Errorf("got %v for a note event, want ErrUnsupportedEvent", err)
+	}
+	_, err = ParseGitLab("uuid-2", []byte(`{`))
+	if err == nil || err == ErrUnsupportedEvent {
+		t.Errorf("got %v for an invalid body, want a parse error", err)
+	}
+}
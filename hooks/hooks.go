@@ -0,0 +1,40 @@
+// Package hooks authenticates the hooks received from the supported providers
+// and normalises their payloads into models.HookEvent
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupportedEvent is returned by the parsers for the events hook-manager
+// does not handle. Such hooks are acknowledged but ignored.
+var ErrUnsupportedEvent = errors.New("unsupported event")
+
+// validHMAC checks a hex encoded HMAC-SHA256 of body, with an optional prefix
+// such as "sha256="
+func validHMAC(secret, signature, prefix string, body []byte) bool {
+	if secret == "" || !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// branchOrTag splits a git ref in its branch or tag name
+func branchOrTag(ref string) (branch string, tag string) {
+	if strings.HasPrefix(ref, "refs/tags/") {
+		return "", strings.TrimPrefix(ref, "refs/tags/")
+	}
+	return strings.TrimPrefix(ref, "refs/heads/"), ""
+}
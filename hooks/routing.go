@@ -0,0 +1,203 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// DefaultTemplate formats the events of the rules without a template
+const DefaultTemplate = `[{{.Repository}}] {{.Actor}} {{.Type}}{{if .Action}} {{.Action}}{{end}}` +
+	`{{if .Branch}} on {{.Branch}}{{end}}{{if .Tag}} {{.Tag}}{{end}}{{if .Title}}: {{.Title}}{{end}}` +
+	`{{if .Status}} ({{.Status}}){{end}}{{if .URL}} {{.URL}}{{end}}`
+
+// maxMessageLength is the length limit of a Discord message, other targets
+// get the same limit
+const maxMessageLength = 2000
+
+// SampleEvent is rendered by the template previews when no event is given
+var SampleEvent = models.HookEvent{
+	ID:         "00000000-0000-0000-0000-000000000000",
+	Provider:   models.HookProviderGitHub,
+	Type:       models.HookTypePush,
+	Repository: "curzolapierre/hook-manager",
+	Branch:     "main",
+	Actor:      "octocat",
+	URL:        "https://github.com/curzolapierre/hook-manager/compare/a1b2c3d...e4f5a6b",
+	Commits: []models.HookCommit{{
+		ID:      "e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f3",
+		Message: "Fix the excuse of the day",
+		Author:  "The Octocat",
+	}},
+	ReceivedAt: time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC),
+}
+
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	// short abbreviates a commit SHA
+	"short": func(s string) string {
+		if len(s) > 7 {
+			return s[:7]
+		}
+		return s
+	},
+	// firstLine keeps the summary of a commit message
+	"firstLine": func(s string) string {
+		return strings.SplitN(s, "\n", 2)[0]
+	},
+}
+
+// ParseTemplate parses a rule template, the default one when empty
+func ParseTemplate(tmpl string) (*template.Template, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	return template.New("rule").Funcs(templateFuncs).Option("missingkey=zero").Parse(tmpl)
+}
+
+// Render formats event with a rule template
+func Render(tmpl string, event models.HookEvent) (string, error) {
+	t, err := ParseTemplate(tmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to parse template")
+	}
+
+	var buf bytes.Buffer
+	err = t.Execute(&buf, event)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to render template")
+	}
+
+	msg := buf.String()
+	if len(msg) > maxMessageLength {
+		msg = msg[:maxMessageLength]
+		for !utf8.ValidString(msg) {
+			msg = msg[:len(msg)-1]
+		}
+	}
+	return msg, nil
+}
+
+// Matches tells whether event passes all the filters of match
+func Matches(match models.HookMatch, event models.HookEvent) bool {
+	if match.Provider != "" && match.Provider != event.Provider {
+		return false
+	}
+	if match.Type != "" && match.Type != event.Type {
+		return false
+	}
+	if match.Actor != "" && match.Actor != event.Actor {
+		return false
+	}
+	if match.Repository != "" && !globMatch(match.Repository, event.Repository) {
+		return false
+	}
+	if match.Branch != "" && !globMatch(match.Branch, event.Branch) {
+		return false
+	}
+	return true
+}
+
+func globMatch(pattern, value string) bool {
+	ok, err := path.Match(pattern, value)
+	return err == nil && ok
+}
+
+// Router sends the incoming hooks of a source to the targets of its rules
+type Router struct {
+	Rules    *models.RedisStoreHookRules
+	Webhooks *models.RedisStoreWebhooks
+}
+
+func NewRouter(redisClient *redis.Client) *Router {
+	return &Router{
+		Rules:    &models.RedisStoreHookRules{Client: redisClient},
+		Webhooks: &models.RedisStoreWebhooks{Client: redisClient},
+	}
+}
+
+type discordMessage struct {
+	Content string `json:"content"`
+}
+
+type httpMessage struct {
+	Source  string           `json:"source"`
+	RuleID  string           `json:"rule_id"`
+	Message string           `json:"message"`
+	Event   models.HookEvent `json:"event"`
+}
+
+// Route runs all the rules of the source against event. Channel targets are
+// published right away, the others are queued for delivery by the webhook
+// workers. It returns the number of matching rules.
+func (r *Router) Route(ctx context.Context, source string, event models.HookEvent) (int, error) {
+	log := logger.Get(ctx)
+
+	rules, err := r.Rules.List(ctx, source)
+	if err != nil {
+		return 0, errors.Wrap(err, "fail to list rules")
+	}
+
+	matched := 0
+	for _, rule := range rules {
+		if !Matches(rule.Match, event) {
+			continue
+		}
+		matched++
+
+		msg, err := Render(rule.Template, event)
+		if err != nil {
+			// A template may fail on a single event, the other rules still apply
+			log.WithError(err).Error("fail to render rule " + rule.ID)
+			continue
+		}
+
+		for _, target := range rule.Targets {
+			err = r.send(ctx, source, rule, target, msg, event)
+			if err != nil {
+				return matched, errors.Wrapf(err, "fail to send to %s target of rule %s", target.Type, rule.ID)
+			}
+		}
+	}
+	return matched, nil
+}
+
+func (r *Router) send(ctx context.Context, source string, rule models.HookRule, target models.HookTarget, msg string, event models.HookEvent) error {
+	var payload interface{}
+	switch target.Type {
+	case models.HookTargetChannel:
+		res := r.Webhooks.Publish(models.HookChannel(source, target.Channel), msg)
+		return res.Err()
+	case models.HookTargetDiscord:
+		payload = discordMessage{Content: msg}
+	default:
+		payload = httpMessage{Source: source, RuleID: rule.ID, Message: msg, Event: event}
+	}
+
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal message")
+	}
+
+	return r.Webhooks.Schedule(ctx, models.WebhookDelivery{
+		ID:        uuid.New().String(),
+		RuleID:    rule.ID,
+		Target:    &target,
+		Source:    source,
+		Event:     models.EventHookRouted,
+		Payload:   bytes,
+		CreatedAt: time.Now().UTC(),
+	}, time.Now())
+}
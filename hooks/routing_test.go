@@ -0,0 +1,143 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+)
+
+func TestMatches(t *testing.T) {
+	event := models.HookEvent{Provider: models.HookProviderGitHub, Type: models.HookTypePush,
+		Repository: "org/api", Branch: "release/1.2", Actor: "alice"}
+	tests := []struct {
+		name  string
+		match models.HookMatch
+		want  bool
+	}{
+		{"any", models.HookMatch{}, true},
+		{"all filters", models.HookMatch{Provider: models.HookProviderGitHub, Type: models.HookTypePush,
+			Repository: "org/*", Branch: "release/*", Actor: "alice"}, true},
+		{"other provider", models.HookMatch{Provider: models.HookProviderGitLab}, false},
+		{"other type", models.HookMatch{Type: models.HookTypeTag}, false},
+		{"other actor", models.HookMatch{Actor: "bob"}, false},
+		{"other repository", models.HookMatch{Repository: "other/*"}, false},
+		{"glob does not cross slashes", models.HookMatch{Branch: "release*"}, false},
+		{"invalid glob", models.HookMatch{Repository: "org/["}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Matches(test.match, event); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"default", "", "[curzolapierre/hook-manager] octocat push on main " + SampleEvent.URL},
+		{"functions", `{{upper .Actor}} {{range .Commits}}{{short .ID}} {{firstLine .Message}}{{end}}`, "OCTOCAT e4f5a6b Fix the excuse of the day"},
+		{"missing field", `{{.Title}}|{{.Tag}}`, "|"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Render(test.template, SampleEvent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`{{.Actor`, `{{.Unknown}}`, `{{index .Commits 5}}`} {
+		_, err := Render(invalid, SampleEvent)
+		if err == nil {
+			t.Errorf("%s: got no error", invalid)
+		}
+	}
+
+	// The messages are cut to the Discord limit without splitting a rune
+	got, err := Render(strings.Repeat("é", maxMessageLength), SampleEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > maxMessageLength || !strings.HasSuffix(got, "é") {
+		t.Errorf("got a message of %d bytes ending with %q, want it cut on a rune", len(got), got[len(got)-2:])
+	}
+}
+
+func TestRoute(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	router := NewRouter(client)
+
+	ctx := context.Background()
+	rules := []models.HookRule{
+		{Name: "pushes", Match: models.HookMatch{Type: models.HookTypePush}, Template: "{{.Actor}} pushed",
+			Targets: []models.HookTarget{
+				{Type: models.HookTargetHTTP, URL: "https://example.com/hook", Secret: "s3cr3t"},
+				{Type: models.HookTargetChannel, Channel: "ci"},
+			}},
+		{Name: "tags", Match: models.HookMatch{Type: models.HookTypeTag},
+			Targets: []models.HookTarget{{Type: models.HookTargetDiscord, URL: "https://discord.com/api/webhooks/1/x"}}},
+	}
+	for i := range rules {
+		err := router.Rules.Save(ctx, "guild", &rules[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pubsub := client.Subscribe(models.HookChannel("guild", "ci"))
+	defer pubsub.Close()
+	_, err = pubsub.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matched, err := router.Route(ctx, "guild", SampleEvent)
+	if err != nil || matched != 1 {
+		t.Fatalf("got %d, %v, want the push rule matched", matched, err)
+	}
+
+	msg, err := pubsub.ReceiveTimeout(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := msg.(*redis.Message); !ok || m.Payload != "octocat pushed" {
+		t.Errorf("got %v on the channel, want the rendered message", msg)
+	}
+
+	delivery, err := router.Webhooks.Claim(ctx, time.Minute)
+	if err != nil || delivery == nil {
+		t.Fatalf("got %v, %v, want the delivery to the HTTP target", delivery, err)
+	}
+	if delivery.RuleID != rules[0].ID || delivery.Target == nil || delivery.Target.Secret != "s3cr3t" || delivery.Event != models.EventHookRouted {
+		t.Errorf("got the delivery %+v, want the one of the HTTP target", delivery)
+	}
+	var payload httpMessage
+	err = json.Unmarshal(delivery.Payload, &payload)
+	if err != nil || payload.Message != "octocat pushed" || payload.Source != "guild" || payload.Event.Actor != "octocat" {
+		t.Errorf("got the payload %s, want the message and the event", delivery.Payload)
+	}
+	if next, _ := router.Webhooks.Claim(ctx, time.Minute); next != nil {
+		t.Errorf("got the delivery %+v of an unmatched rule", next)
+	}
+}
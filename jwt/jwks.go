@@ -0,0 +1,225 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrUnknownKey is returned for a key ID missing from the key set
+var ErrUnknownKey = errors.New("unknown key")
+
+// minRefetchInterval bounds how often an unknown key ID makes the JWKS
+// fetched again, so that forged key IDs cannot flood its server
+const minRefetchInterval = time.Minute
+
+const maxJWKSSize = 1024 * 1024
+
+// KeySet holds the public keys of a JWKS by key ID
+type KeySet map[string]crypto.PublicKey
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS reads the RSA and EC signature keys of a JSON Web Key Set, the
+// other keys are skipped
+func ParseJWKS(data []byte) (KeySet, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal JWKS")
+	}
+
+	keys := KeySet{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid key "+k.Kid)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, errors.New("unsupported curve " + k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid x")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid y")
+	}
+	key := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point not on curve")
+	}
+	return key, nil
+}
+
+// JWKS loads a key set from a URL or a file and caches it for RefreshInterval.
+// A key ID missing from the cache makes it reloaded, at most once a minute,
+// to pick up the rotated keys. The key set is loaded without holding the
+// lock, by a single caller at a time.
+type JWKS struct {
+	URL             string
+	File            string
+	RefreshInterval time.Duration
+	Client          *http.Client
+
+	mu          sync.RWMutex
+	keys        KeySet
+	err         error
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	// loading is closed once the load in progress ends, nil if there is none
+	loading chan struct{}
+}
+
+// Key returns the public key with some key ID
+func (j *JWKS) Key(kid string) (crypto.PublicKey, error) {
+	j.mu.RLock()
+	_, known := j.keys[kid]
+	stale := time.Since(j.fetchedAt) > j.RefreshInterval
+	due := (j.keys == nil || stale || !known) && time.Since(j.attemptedAt) > minRefetchInterval
+	j.mu.RUnlock()
+
+	if due {
+		// A known key is used while another caller refreshes a stale set
+		j.refresh(!known)
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	key, ok := j.keys[kid]
+	if !ok && j.keys == nil && j.err != nil {
+		return nil, j.err
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// refresh loads the key set unless it was attempted less than a minute ago.
+// If a load is in progress, refresh waits for it when wait is set.
+func (j *JWKS) refresh(wait bool) {
+	j.mu.Lock()
+	if j.loading != nil {
+		loading := j.loading
+		j.mu.Unlock()
+		if wait {
+			<-loading
+		}
+		return
+	}
+	if time.Since(j.attemptedAt) <= minRefetchInterval {
+		j.mu.Unlock()
+		return
+	}
+	j.attemptedAt = time.Now()
+	loading := make(chan struct{})
+	j.loading = loading
+	j.mu.Unlock()
+
+	keys, err := j.load()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// A failing refresh keeps the previous keys until the next attempt
+	j.err = err
+	if err == nil {
+		j.keys = keys
+		j.fetchedAt = time.Now()
+	}
+	j.loading = nil
+	close(loading)
+}
+
+func (j *JWKS) load() (KeySet, error) {
+	if j.File != "" {
+		data, err := ioutil.ReadFile(j.File)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to read JWKS file")
+		}
+		return ParseJWKS(data)
+	}
+
+	res, err := j.Client.Get(j.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to fetch JWKS")
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fail to fetch JWKS: status %d", res.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, maxJWKSSize))
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read JWKS")
+	}
+	return ParseJWKS(data)
+}
@@ -0,0 +1,145 @@
+package jwt
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+	set, err := ParseJWKS(keys.jwks("rsa"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set) != 2 || set["rsa"] == nil || set["ec"] == nil {
+		t.Fatalf("got %v, want the RSA and EC keys", set)
+	}
+
+	tests := []struct {
+		name  string
+		jwks  string
+		keys  int
+		valid bool
+	}{
+		{"encryption key", `{"keys": [{"kid": "a", "kty": "RSA", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`, 0, true},
+		{"symmetric key", `{"keys": [{"kid": "a", "kty": "oct", "k": "c2VjcmV0"}]}`, 0, true},
+		{"unsupported curve", `{"keys": [{"kid": "a", "kty": "EC", "crv": "P-192", "x": "AQ", "y": "AQ"}]}`, 0, false},
+		{"point not on curve", `{"keys": [{"kid": "a", "kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`, 0, false},
+		{"invalid exponent", `{"keys": [{"kid": "a", "kty": "RSA", "n": "AQAB", "e": ""}]}`, 0, false},
+		{"not JSON", `keys`, 0, false},
+	}
+	for _, test := range tests {
+		set, err := ParseJWKS([]byte(test.jwks))
+		if (err == nil) != test.valid || len(set) != test.keys {
+			t.Errorf("%s: got %v, %v, want %d keys and valid %v", test.name, set, err, test.keys, test.valid)
+		}
+	}
+}
+
+func TestJWKSRefetch(t *testing.T) {
+	keys := newTestKeys(t)
+	server := &jwksServer{body: keys.jwks("rsa")}
+	j := newJWKS(t, server)
+
+	if _, err := j.Key("rsa"); err != nil {
+		t.Fatal(err)
+	}
+	// An unknown key ID is not fetched again within a minute
+	if _, err := j.Key("rotated"); err != ErrUnknownKey {
+		t.Fatalf("got %v, want ErrUnknownKey", err)
+	}
+	if n := server.count(); n != 1 {
+		t.Fatalf("got %d fetches, want 1", n)
+	}
+
+	server.mu.Lock()
+	server.body = keys.jwks("rsa", "rotated")
+	server.mu.Unlock()
+	j.attemptedAt = time.Now().Add(-2 * minRefetchInterval)
+	if _, err := j.Key("rotated"); err != nil {
+		t.Fatalf("got %v once the JWKS can be fetched again, want the rotated key", err)
+	}
+
+	// A failing refresh keeps the keys
+	server.mu.Lock()
+	server.body = []byte(`not JSON`)
+	server.mu.Unlock()
+	j.attemptedAt, j.fetchedAt = time.Now().Add(-2*minRefetchInterval), time.Now().Add(-2*time.Hour)
+	if _, err := j.Key("rsa"); err != nil {
+		t.Fatalf("got %v after a failed refresh, want the previous key", err)
+	}
+	if n := server.count(); n != 3 {
+		t.Fatalf("got %d fetches, want 3", n)
+	}
+}
+
+func TestJWKSFetchesOutsideTheLock(t *testing.T) {
+	keys := newTestKeys(t)
+	server := &jwksServer{body: keys.jwks("rsa", "rotated")}
+	j := newJWKS(t, server)
+	if _, err := j.Key("rsa"); err != nil {
+		t.Fatal(err)
+	}
+
+	server.mu.Lock()
+	server.block, server.received = make(chan struct{}), make(chan struct{}, 10)
+	server.mu.Unlock()
+	j.attemptedAt, j.fetchedAt = time.Now().Add(-2*minRefetchInterval), time.Now().Add(-2*time.Hour)
+	j.keys = KeySet{"rsa": j.keys["rsa"]}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j.Key("rotated")
+		}()
+	}
+	<-server.received
+
+	// The known key is served while the set is fetched
+	done := make(chan error)
+	go func() {
+		_, err := j.Key("rsa")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the known key waits for the fetch")
+	}
+
+	close(server.block)
+	wg.Wait()
+	if n := server.count(); n != 2 {
+		t.Errorf("got %d fetches, want a single one for the concurrent callers", n)
+	}
+	if _, err := j.Key("rotated"); err != nil {
+		t.Errorf("got %v once fetched, want the rotated key", err)
+	}
+}
+
+func TestJWKSErrors(t *testing.T) {
+	j := newJWKS(t, http.NotFoundHandler())
+	if _, err := j.Key("rsa"); err == nil || err == ErrUnknownKey {
+		t.Errorf("got %v when the JWKS cannot be fetched, want the fetch error", err)
+	}
+
+	keys := newTestKeys(t)
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	err := ioutil.WriteFile(file, keys.jwks("rsa"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j = &JWKS{File: file, RefreshInterval: time.Hour}
+	if _, err := j.Key("ec"); err != nil {
+		t.Errorf("got %v, want the key of the file", err)
+	}
+}
@@ -0,0 +1,264 @@
+// Package jwt verifies the JSON Web Tokens signed with the RSA or ECDSA keys
+// of a JWKS, and reads their registered claims and the scopes and sources of
+// the caller
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrMalformed        = errors.New("malformed token")
+	ErrAlgorithm        = errors.New("unsupported signing algorithm")
+	ErrSignature        = errors.New("invalid signature")
+	ErrExpired          = errors.New("token expired")
+	ErrNotYetValid      = errors.New("token not valid yet")
+	ErrInvalidIssuer    = errors.New("invalid issuer")
+	ErrInvalidAudience  = errors.New("invalid audience")
+	ErrMissingExpiresAt = errors.New("missing exp claim")
+	ErrMissingSubject   = errors.New("missing sub claim")
+)
+
+// KeyProvider gives the public key of a key ID
+type KeyProvider interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// Claims are the claims of a verified token. The scopes are read from the
+// space separated scope claim or from the scp array, the sources from the
+// claim configured in the Verifier.
+type Claims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	Scopes    []string
+	Sources   []string
+}
+
+// Verifier checks the signature of the tokens and that they are valid now,
+// for Issuer and Audience
+type Verifier struct {
+	Issuer       string
+	Audience     string
+	SourcesClaim string
+	Keys         KeyProvider
+	// Leeway is the clock skew tolerated on exp and nbf
+	Leeway time.Duration
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type algorithm struct {
+	hash crypto.Hash
+	// size is the size of each integer of an ECDSA signature, 0 for RSA
+	size int
+}
+
+var algorithms = map[string]algorithm{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"ES256": {hash: crypto.SHA256, size: 32},
+	"ES384": {hash: crypto.SHA384, size: 48},
+	"ES512": {hash: crypto.SHA512, size: 66},
+}
+
+// Verify checks token and returns its claims
+func (v *Verifier) Verify(token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	err := decodeSegment(parts[0], &h)
+	if err != nil {
+		return nil, err
+	}
+	alg, ok := algorithms[h.Alg]
+	if !ok {
+		return nil, ErrAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	key, err := v.Keys.Key(h.Kid)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get key "+h.Kid)
+	}
+	err = verifySignature(alg, key, []byte(parts[0]+"."+parts[1]), signature)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]json.RawMessage
+	err = decodeSegment(parts[1], &raw)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := v.readClaims(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.ExpiresAt.IsZero() {
+		return nil, ErrMissingExpiresAt
+	}
+	// The subject names the client, in the rate limits, the audit log and the
+	// CSRF tokens of the admin UI
+	if claims.Subject == "" {
+		return nil, ErrMissingSubject
+	}
+	if now.After(claims.ExpiresAt.Add(v.Leeway)) {
+		return nil, ErrExpired
+	}
+	var nbf *float64
+	if json.Unmarshal(raw["nbf"], &nbf) == nil && nbf != nil && now.Add(v.Leeway).Before(fromNumericDate(*nbf)) {
+		return nil, ErrNotYetValid
+	}
+	if claims.Issuer != v.Issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if !contains(claims.Audience, v.Audience) {
+		return nil, ErrInvalidAudience
+	}
+	return claims, nil
+}
+
+func (v *Verifier) readClaims(raw map[string]json.RawMessage) (*Claims, error) {
+	claims := &Claims{}
+	var err error
+	for name, dest := range map[string]*string{"iss": &claims.Issuer, "sub": &claims.Subject} {
+		if value, ok := raw[name]; ok {
+			if json.Unmarshal(value, dest) != nil {
+				return nil, errors.Wrap(ErrMalformed, "invalid "+name+" claim")
+			}
+		}
+	}
+	if value, ok := raw["exp"]; ok {
+		var exp float64
+		if json.Unmarshal(value, &exp) != nil {
+			return nil, errors.Wrap(ErrMalformed, "invalid exp claim")
+		}
+		claims.ExpiresAt = fromNumericDate(exp)
+	}
+
+	claims.Audience, err = stringOrList(raw["aud"])
+	if err != nil {
+		return nil, errors.Wrap(ErrMalformed, "invalid aud claim")
+	}
+
+	// scope is space separated by RFC 8693, scp is a list for some providers
+	if value, ok := raw["scope"]; ok {
+		var scope string
+		if json.Unmarshal(value, &scope) != nil {
+			return nil, errors.Wrap(ErrMalformed, "invalid scope claim")
+		}
+		claims.Scopes = strings.Fields(scope)
+	} else {
+		claims.Scopes, err = stringOrList(raw["scp"])
+		if err != nil {
+			return nil, errors.Wrap(ErrMalformed, "invalid scp claim")
+		}
+	}
+
+	claims.Sources, err = stringOrList(raw[v.SourcesClaim])
+	if err != nil {
+		return nil, errors.Wrap(ErrMalformed, "invalid "+v.SourcesClaim+" claim")
+	}
+	return claims, nil
+}
+
+func verifySignature(alg algorithm, key crypto.PublicKey, signed, signature []byte) error {
+	var digest []byte
+	switch alg.hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signed)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signed)
+		digest = sum[:]
+	default:
+		sum := sha512.Sum512(signed)
+		digest = sum[:]
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg.size != 0 {
+			return ErrAlgorithm
+		}
+		if rsa.VerifyPKCS1v15(key, alg.hash, digest, signature) != nil {
+			return ErrSignature
+		}
+	case *ecdsa.PublicKey:
+		if alg.size == 0 || len(signature) != 2*alg.size || (key.Curve.Params().BitSize+7)/8 != alg.size {
+			return ErrAlgorithm
+		}
+		r := new(big.Int).SetBytes(signature[:alg.size])
+		s := new(big.Int).SetBytes(signature[alg.size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return ErrSignature
+		}
+	default:
+		return ErrAlgorithm
+	}
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrMalformed
+	}
+	if json.Unmarshal(data, v) != nil {
+		return ErrMalformed
+	}
+	return nil
+}
+
+// stringOrList reads a claim which is either a string or a list of strings
+func stringOrList(value json.RawMessage) ([]string, error) {
+	if len(value) == 0 {
+		return nil, nil
+	}
+	var list []string
+	if json.Unmarshal(value, &list) == nil {
+		return list, nil
+	}
+	var single string
+	err := json.Unmarshal(value, &single)
+	if err != nil {
+		return nil, err
+	}
+	return []string{single}, nil
+}
+
+func fromNumericDate(date float64) time.Time {
+	return time.Unix(0, int64(date*float64(time.Second)))
+}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
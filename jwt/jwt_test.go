@@ -0,0 +1,232 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// testKeys are the signing keys of the tests, published by jwksServer
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// jwks returns the JWKS of the public keys, with the RSA key under each of
+// rsaKids
+func (k testKeys) jwks(rsaKids ...string) []byte {
+	keys := []map[string]string{{
+		"kid": "ec", "kty": "EC", "use": "sig", "crv": "P-256",
+		"x": b64(k.ec.X.FillBytes(make([]byte, 32))), "y": b64(k.ec.Y.FillBytes(make([]byte, 32))),
+	}}
+	for _, kid := range rsaKids {
+		keys = append(keys, map[string]string{
+			"kid": kid, "kty": "RSA", "use": "sig",
+			"n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes()),
+		})
+	}
+	data, _ := json.Marshal(map[string]interface{}{"keys": keys})
+	return data
+}
+
+// sign returns a token of the claims signed with alg, with the RSA key for
+// the RS algorithms, the EC key for ES256 and the RSA public key as HMAC
+// secret for HS256
+func (k testKeys) sign(t *testing.T, alg, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	switch alg {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k.rsa, crypto.SHA256, digest[:])
+	case "ES256":
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, k.ec, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	case "HS256":
+		public, _ := x509.MarshalPKIXPublicKey(&k.rsa.PublicKey)
+		mac := hmac.New(sha256.New, public)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(signature)
+}
+
+// jwksServer serves the JWKS of keys and counts the requests
+type jwksServer struct {
+	mu       sync.Mutex
+	body     []byte
+	requests int
+	// block, when set, holds the requests until it is closed
+	block chan struct{}
+	// received gets a value when a request arrives
+	received chan struct{}
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests++
+	body, block, received := s.body, s.block, s.received
+	s.mu.Unlock()
+	if received != nil {
+		received <- struct{}{}
+	}
+	if block != nil {
+		<-block
+	}
+	w.Write(body)
+}
+
+func (s *jwksServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func newJWKS(t *testing.T, handler http.Handler) *JWKS {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return &JWKS{URL: server.URL, RefreshInterval: time.Hour, Client: server.Client()}
+}
+
+func TestVerify(t *testing.T) {
+	keys := newTestKeys(t)
+	v := &Verifier{
+		Issuer:       "https://issuer.example.com",
+		Audience:     "hook-manager",
+		SourcesClaim: "tenants",
+		Keys:         newJWKS(t, &jwksServer{body: keys.jwks("rsa")}),
+		Leeway:       30 * time.Second,
+	}
+	now := time.Now()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss": v.Issuer, "sub": "alice", "aud": "hook-manager", "exp": now.Add(time.Hour).Unix(),
+		}
+		for name, value := range changes {
+			if value == nil {
+				delete(c, name)
+			} else {
+				c[name] = value
+			}
+		}
+		return c
+	}
+	valid := keys.sign(t, "RS256", "rsa", claims(nil))
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256", valid, nil},
+		{"ES256", keys.sign(t, "ES256", "ec", claims(nil)), nil},
+		{"alg none", b64([]byte(`{"alg":"none","kid":"rsa"}`)) + "." + b64([]byte(`{"sub":"alice"}`)) + ".", ErrAlgorithm},
+		{"HS256 with the RSA public key", keys.sign(t, "HS256", "rsa", claims(nil)), ErrAlgorithm},
+		{"ES256 header with the RSA key", keys.sign(t, "RS256", "ec", claims(nil)), ErrAlgorithm},
+		{"unknown kid", keys.sign(t, "RS256", "other", claims(nil)), ErrUnknownKey},
+		{"tampered claims", valid[:len(valid)/2] + "x" + valid[len(valid)/2+1:], ErrSignature},
+		{"two segments", b64([]byte(`{"alg":"RS256"}`)) + "." + b64([]byte(`{}`)), ErrMalformed},
+		{"expired within the leeway", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-20 * time.Second).Unix()})), nil},
+		{"expired", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})), ErrExpired},
+		{"no exp", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"exp": nil})), ErrMissingExpiresAt},
+		{"no sub", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"sub": nil})), ErrMissingSubject},
+		{"empty sub", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"sub": ""})), ErrMissingSubject},
+		{"nbf within the leeway", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(20 * time.Second).Unix()})), nil},
+		{"not valid yet", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})), ErrNotYetValid},
+		{"other issuer", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"iss": "https://evil.com"})), ErrInvalidIssuer},
+		{"other audience", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"aud": "other"})), ErrInvalidAudience},
+		{"audience in a list", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"aud": []string{"other", "hook-manager"}})), nil},
+		{"no audience", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"aud": nil})), ErrInvalidAudience},
+		{"invalid scope", keys.sign(t, "RS256", "rsa", claims(map[string]interface{}{"scope": 42})), ErrMalformed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := v.Verify(test.token, now)
+			if errors.Cause(err) != test.err {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	keys := newTestKeys(t)
+	v := &Verifier{Issuer: "iss", Audience: "aud", SourcesClaim: "tenants", Keys: newJWKS(t, &jwksServer{body: keys.jwks("rsa")})}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		claims  map[string]interface{}
+		scopes  []string
+		sources []string
+	}{
+		{"space separated scope", map[string]interface{}{"scope": "excuses:read excuses:write", "tenants": []string{"a", "b"}},
+			[]string{"excuses:read", "excuses:write"}, []string{"a", "b"}},
+		{"scp list", map[string]interface{}{"scp": []string{"excuses:read"}, "tenants": "a"},
+			[]string{"excuses:read"}, []string{"a"}},
+		{"scope over scp", map[string]interface{}{"scope": "admin", "scp": []string{"excuses:read"}},
+			[]string{"admin"}, nil},
+		{"sources of another claim", map[string]interface{}{"sources": []string{"a"}},
+			nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.claims["iss"], test.claims["aud"], test.claims["sub"], test.claims["exp"] = "iss", "aud", "alice", exp
+			claims, err := v.Verify(keys.sign(t, "ES256", "ec", test.claims), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "alice" || claims.ExpiresAt.Unix() != exp {
+				t.Errorf("got the subject %s expiring at %v, want the ones of the token", claims.Subject, claims.ExpiresAt)
+			}
+			if !reflect.DeepEqual(claims.Scopes, test.scopes) || !reflect.DeepEqual(claims.Sources, test.sources) {
+				t.Errorf("got the scopes %v and sources %v, want %v and %v", claims.Scopes, claims.Sources, test.scopes, test.sources)
+			}
+		})
+	}
+}
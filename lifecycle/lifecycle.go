@@ -0,0 +1,156 @@
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/pkg/errors"
+)
+
+// Manager starts the components of the process in the order of their
+// registration and stops them in the reverse order, so that a component is
+// stopped before the ones it depends on.
+type Manager struct {
+	mu         sync.Mutex
+	components []component
+	// started is the number of components started, they are the first ones
+	started int
+
+	failOnce sync.Once
+	failed   chan struct{}
+	failure  error
+}
+
+type component struct {
+	name  string
+	start func(ctx context.Context) error
+	stop  func(ctx context.Context) error
+}
+
+func New() *Manager {
+	return &Manager{
+		failed: make(chan struct{}),
+	}
+}
+
+// Register adds a component. Start must not block, the component runs in
+// background until Stop is called. Stop should return once the work in
+// progress is done or ctx is done. Either of them may be nil.
+func (m *Manager) Register(name string, start, stop func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.components = append(m.components, component{
+		name:  name,
+		start: start,
+		stop:  stop,
+	})
+}
+
+// Start starts the components. When one of them fails, the ones already
+// started are stopped and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	log := logger.Get(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for m.started < len(m.components) {
+		c := m.components[m.started]
+		if c.start != nil {
+			err := c.start(ctx)
+			if err != nil {
+				err = errors.Wrapf(err, "fail to start %s", c.name)
+				m.stopStarted(ctx)
+				return err
+			}
+		}
+		log.Debugf("Started %s", c.name)
+		m.started++
+	}
+	return nil
+}
+
+// Fail reports that a running component failed, the first error is kept and
+// Failed is closed. The process is expected to stop.
+func (m *Manager) Fail(err error) {
+	m.failOnce.Do(func() {
+		m.failure = err
+		close(m.failed)
+	})
+}
+
+// Failed is closed when a running component failed
+func (m *Manager) Failed() <-chan struct{} {
+	return m.failed
+}
+
+// Err returns the error of the component which failed, if any
+func (m *Manager) Err() error {
+	select {
+	case <-m.failed:
+		return m.failure
+	default:
+		return nil
+	}
+}
+
+// Stop stops the started components in the reverse order of their
+// registration, the ones which don't stop before ctx is done are left behind.
+// It returns the first error met, every component is stopped anyway.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stopStarted(ctx)
+}
+
+func (m *Manager) stopStarted(ctx context.Context) error {
+	log := logger.Get(ctx)
+
+	var firstErr error
+	for ; m.started > 0; m.started-- {
+		c := m.components[m.started-1]
+		if c.stop == nil {
+			continue
+		}
+		begin := time.Now()
+		err := stopWithin(ctx, c.stop)
+		if err != nil {
+			err = errors.Wrapf(err, "fail to stop %s", c.name)
+			log.WithError(err).Error("Component not stopped cleanly")
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Infof("Stopped %s in %v", c.name, time.Since(begin).Round(time.Millisecond))
+	}
+	return firstErr
+}
+
+// lateStopTimeout is the time given to the stop of a component once the
+// grace period is over, so that the components stopping right away, such as
+// the clients being closed, are still stopped after a slow one
+const lateStopTimeout = 100 * time.Millisecond
+
+// stopWithin returns the error of stop, or the one of ctx when it is done
+// first. In the latter case, stop goes on in background.
+func stopWithin(ctx context.Context, stop func(ctx context.Context) error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- stop(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		timer := time.NewTimer(lateStopTimeout)
+		defer timer.Stop()
+		select {
+		case err := <-done:
+			return err
+		case <-timer.C:
+			return ctx.Err()
+		}
+	}
+}
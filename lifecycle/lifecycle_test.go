@@ -0,0 +1,132 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder registers components recording their starts and stops, the stops
+// run in their own goroutine
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) register(m *Manager, name string, startErr, stopErr error) {
+	m.Register(name, func(ctx context.Context) error {
+		r.record("start " + name)
+		return startErr
+	}, func(ctx context.Context) error {
+		r.record("stop " + name)
+		return stopErr
+	})
+}
+
+func (r *recorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func TestManagerOrder(t *testing.T) {
+	m := New()
+	r := &recorder{}
+	r.register(m, "redis", nil, nil)
+	m.Register("no-op", nil, nil)
+	r.register(m, "server", nil, nil)
+
+	err := m.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = m.Stop(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start redis", "start server", "stop server", "stop redis"}
+	if !reflect.DeepEqual(r.recorded(), want) {
+		t.Errorf("got %v, want %v", r.recorded(), want)
+	}
+
+	// The components are only stopped once
+	err = m.Stop(context.Background())
+	if err != nil || len(r.recorded()) != len(want) {
+		t.Errorf("got %v, %v on the second stop, want nothing stopped", r.recorded(), err)
+	}
+}
+
+func TestManagerStartFailure(t *testing.T) {
+	m := New()
+	r := &recorder{}
+	r.register(m, "redis", nil, nil)
+	r.register(m, "workers", errors.New("boom"), nil)
+	r.register(m, "server", nil, nil)
+
+	err := m.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "fail to start workers: boom") {
+		t.Fatalf("got %v, want the error of the workers", err)
+	}
+	want := []string{"start redis", "start workers", "stop redis"}
+	if !reflect.DeepEqual(r.recorded(), want) {
+		t.Errorf("got %v, want %v", r.recorded(), want)
+	}
+}
+
+func TestManagerStopErrors(t *testing.T) {
+	m := New()
+	r := &recorder{}
+	r.register(m, "redis", nil, nil)
+	r.register(m, "workers", nil, errors.New("boom"))
+	release := make(chan struct{})
+	defer close(release)
+	m.Register("server", nil, func(ctx context.Context) error {
+		// Keeps draining after the grace period
+		<-release
+		return nil
+	})
+
+	err := m.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = m.Stop(ctx)
+	if err == nil || !strings.Contains(err.Error(), "fail to stop server") {
+		t.Fatalf("got %v, want the error of the server", err)
+	}
+	// The components after the server are stopped once the grace period is
+	// over, and after the failed stop of the workers
+	want := []string{"start redis", "start workers", "stop workers", "stop redis"}
+	if !reflect.DeepEqual(r.recorded(), want) {
+		t.Errorf("got %v, want %v", r.recorded(), want)
+	}
+}
+
+func TestManagerFail(t *testing.T) {
+	m := New()
+	if m.Err() != nil {
+		t.Fatalf("got %v before any failure", m.Err())
+	}
+	m.Fail(errors.New("first"))
+	m.Fail(errors.New("second"))
+	select {
+	case <-m.Failed():
+	default:
+		t.Fatal("Failed is not closed")
+	}
+	if err := m.Err(); err == nil || err.Error() != "first" {
+		t.Errorf("got %v, want the first error", err)
+	}
+}
@@ -3,15 +3,26 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/Scalingo/go-utils/logger"
 	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/health"
+	"github.com/curzolapierre/hook-manager/lifecycle"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/curzolapierre/hook-manager/models"
 	redisCtr "github.com/curzolapierre/hook-manager/redis"
+	"github.com/curzolapierre/hook-manager/tracing"
+	"github.com/curzolapierre/hook-manager/webhooks"
 	"github.com/curzolapierre/hook-manager/webserver"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
@@ -32,13 +43,25 @@ func logLevel() logrus.Level {
 	}
 }
 
+// logFormatter is the formatter of LOGGER_FORMAT, json or text
+func logFormatter() logrus.Formatter {
+	switch os.Getenv("LOGGER_FORMAT") {
+	case "json":
+		return &logrus.JSONFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000Z07:00",
+		}
+	default:
+		return &logrus.TextFormatter{
+			TimestampFormat: "2006-01-02T15:04:05.000",
+			FullTimestamp:   true,
+		}
+	}
+}
+
 func initLogger() logrus.FieldLogger {
 	logger := logrus.New()
 	logger.SetLevel(logLevel())
-	logger.Formatter = &logrus.TextFormatter{
-		TimestampFormat: "2006-01-02T15:04:05.000",
-		FullTimestamp:   true,
-	}
+	logger.Formatter = logFormatter()
 
 	var fieldLogger logrus.FieldLogger = logger
 
@@ -55,34 +78,190 @@ func main() {
 		return
 	}
 
-	_, err = redisCtr.Client(config)
+	shutdownTracing, err := tracing.Init(ctx, config)
+	if err != nil {
+		log.WithError(err).Panic("fail to init tracing")
+		return
+	}
+
+	redisClient, err := redisCtr.Client(config)
 	if err != nil {
 		log.WithError(err).Panic("fail to init redis client")
 		return
 	}
 
+	models.TrashRetention = time.Duration(config.TrashRetention) * 24 * time.Hour
+	models.AuditLogMaxLength = config.AuditLogMaxLength
+	registerExcuseMetrics(metrics.Default, redisClient, config)
+
+	schema := &models.RedisStoreSchema{Client: redisClient}
+	err = schema.Init(ctx)
+	if err != nil {
+		log.WithError(err).Error("fail to init schema version")
+	}
+
 	httpListenAddr := fmt.Sprintf("%s:%s", config.HttpHost, config.HttpPort)
-	log.Infof("Starting the web server on %v", httpListenAddr)
+
+	tlsConfig, err := webserver.NewTLS(config)
+	if err != nil {
+		log.WithError(err).Panic("fail to init TLS")
+		return
+	}
+
+	// The components are stopped in the reverse order: the web server first,
+	// so that no new work is queued, then Redis, and the tracer last to flush
+	// the spans of the others
+	lc := lifecycle.New()
+	lc.Register("tracer", nil, shutdownTracing)
+	lc.Register("redis client", nil, func(context.Context) error {
+		return redisClient.Close()
+	})
+
+	webhookWorker := webhooks.NewWorker(redisClient, config)
+	health.Default.Timeout = time.Duration(config.HealthCheckTimeout) * time.Second
+	health.Default.Register("redis", health.RedisPing(redisClient))
+	health.Default.Register("redis_pool", health.RedisPool(redisClient, config.RedisPoolSize))
+	health.Default.Register("webhook_workers", webhookWorker.Check)
+	health.Default.Register("schema", schema.Check)
+	lc.Register("webhook delivery workers", func(ctx context.Context) error {
+		webhookWorker.Start(ctx)
+		return nil
+	}, func(context.Context) error {
+		webhookWorker.Stop()
+		return nil
+	})
 
 	// Define routers
-	router := webserver.NewRouter(ctx, config)
+	router := webserver.NewRouter(ctx, config, redisClient)
 
-	go func() {
-		err := http.ListenAndServe(httpListenAddr, router)
+	server := &http.Server{
+		Addr:         httpListenAddr,
+		Handler:      router,
+		ReadTimeout:  time.Duration(config.HttpReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(config.HttpWriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(config.HttpIdleTimeout) * time.Second,
+	}
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig.Config()
+	}
+	// The server doesn't wait for the websocket sessions, they are closed
+	// once it is drained, before Redis
+	lc.Register("websocket sessions", nil, controllers.DefaultWebsocketSessions.Shutdown)
+	lc.Register("web server", func(ctx context.Context) error {
+		// Listening right away reports an unavailable address as a start
+		// failure
+		listener, err := net.Listen("tcp", httpListenAddr)
 		if err != nil {
-			log.WithError(err).Error("Fail to start web server")
-			os.Exit(-1)
+			return err
 		}
-	}()
+		log.Infof("Starting the web server on %v", httpListenAddr)
+		go func() {
+			var err error
+			if tlsConfig != nil {
+				err = server.ServeTLS(listener, "", "")
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				lc.Fail(errors.Wrap(err, "web server stopped"))
+			}
+		}()
+		return nil
+	}, server.Shutdown)
+
+	err = lc.Start(ctx)
+	if err != nil {
+		log.WithError(err).Error("Fail to start")
+		os.Exit(exitFailure)
+	}
 
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
-	for range signals {
-		log.Info("Stopping the server")
-		// for _, stopper := range stoppers {
-		// 	stopper()
-		// }
-		os.Exit(0)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGHUP)
+	os.Exit(run(ctx, lc, signals, tlsConfig, time.Duration(config.ShutdownGracePeriod)*time.Second))
+}
+
+// registerExcuseMetrics exposes the number of excuses of each source, counted
+// on each scrape
+func registerExcuseMetrics(registry *metrics.Registry, redisClient *redis.Client, config config.Config) {
+	store := &models.RedisStoreCodexcuses{Client: redisClient}
+	registry.NewGaugeFunc("hook_manager_excuses", "Excuses by source.",
+		[]string{"source"}, func(ctx context.Context) ([]metrics.Sample, error) {
+			counts, err := store.CountBySource(ctx, config.RedisScanSize)
+			if err != nil {
+				return nil, err
+			}
+			samples := make([]metrics.Sample, 0, len(counts))
+			for source, count := range counts {
+				samples = append(samples, metrics.Sample{
+					LabelValues: []string{source},
+					Value:       float64(count),
+				})
+			}
+			return samples, nil
+		})
+}
+
+// Exit codes of the process
+const (
+	// exitOK is returned when every component stopped within the grace period
+	exitOK = 0
+	// exitFailure is returned when a component failed to start or to run
+	exitFailure = 1
+	// exitUnclean is returned when a component did not stop within the grace
+	// period, or failed to stop
+	exitUnclean = 2
+	// exitForced is returned when a second signal cuts the shutdown short
+	exitForced = 3
+)
+
+// run waits for a stop signal or the failure of a component, then stops the
+// components and returns the exit code of the process. SIGHUP reloads the TLS
+// certificates.
+func run(ctx context.Context, lc *lifecycle.Manager, signals chan os.Signal, tlsConfig *webserver.TLS, grace time.Duration) int {
+	log := logger.Get(ctx)
+
+	code := exitOK
+wait:
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Infof("Received %v, stopping the server", sig)
+				break wait
+			}
+			if tlsConfig == nil {
+				continue
+			}
+			err := tlsConfig.Reload()
+			if err != nil {
+				log.WithError(err).Error("fail to reload TLS certificates, the previous ones are kept")
+				continue
+			}
+			log.Info("Reloaded the TLS certificates")
+		case <-lc.Failed():
+			log.WithError(lc.Err()).Error("Stopping the server after a failure")
+			code = exitFailure
+			break wait
+		}
 	}
 
+	// A second stop signal exits without waiting for the end of the grace
+	// period
+	go func() {
+		for sig := range signals {
+			if sig != syscall.SIGHUP {
+				log.Infof("Received %v again, exiting now", sig)
+				os.Exit(exitForced)
+			}
+		}
+	}()
+
+	stopCtx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+	err := lc.Stop(stopCtx)
+	if err != nil && code == exitOK {
+		code = exitUnclean
+	}
+	log.Infof("Stopped with exit code %d", code)
+	return code
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		env   string
+		level logrus.Level
+	}{
+		{"", logrus.InfoLevel},
+		{"debug", logrus.DebugLevel},
+		{"warn", logrus.WarnLevel},
+		{"verbose", logrus.InfoLevel},
+	}
+	for _, test := range tests {
+		t.Setenv("LOGGER_LEVEL", test.env)
+		if got := logLevel(); got != test.level {
+			t.Errorf("%q: got %v, want %v", test.env, got, test.level)
+		}
+	}
+}
+
+func TestLogFormatter(t *testing.T) {
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", "level=info msg=request method=GET\n"},
+		{"text", "level=info msg=request method=GET\n"},
+		{"json", `{"level":"info","method":"GET","msg":"request"}` + "\n"},
+	}
+	for _, test := range tests {
+		t.Setenv("LOGGER_FORMAT", test.env)
+		formatter := logFormatter()
+		// The timestamps are left out to compare the lines
+		switch formatter := formatter.(type) {
+		case *logrus.TextFormatter:
+			formatter.DisableTimestamp = true
+		case *logrus.JSONFormatter:
+			formatter.DisableTimestamp = true
+		}
+		entry := logrus.NewEntry(logrus.New()).WithField("method", "GET")
+		entry.Level = logrus.InfoLevel
+		entry.Message = "request"
+		line, err := formatter.Format(entry)
+		if err != nil || string(line) != test.want {
+			t.Errorf("%q: got %q, %v, want %q", test.env, line, err, test.want)
+		}
+	}
+}
+
+func TestExcuseMetrics(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	defer client.Close()
+	registry := metrics.NewRegistry()
+	registerExcuseMetrics(registry, client, config.Config{RedisScanSize: 1})
+
+	ctx := context.Background()
+	excuses := &models.RedisStoreCodexcuses{Client: client}
+	for _, source := range []string{"guild", "guild", "team"} {
+		excuse := models.Codexcuse{Title: "t", Content: "c", Author: &models.User{UserName: "a"}, Reporter: &models.User{ID: "1", UserName: "r"}}
+		err := excuses.Add(ctx, source, &excuse)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err = registry.WriteTo(ctx, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE hook_manager_excuses gauge\n",
+		`hook_manager_excuses{source="guild"} 2` + "\n",
+		`hook_manager_excuses{source="team"} 1` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got\n%s\nwant %q", buf.String(), want)
+		}
+	}
+
+	// The gauge is left out while Redis is down
+	server.Close()
+	buf.Reset()
+	err = registry.WriteTo(ctx, &buf)
+	if err != nil || strings.Contains(buf.String(), "hook_manager_excuses") {
+		t.Errorf("got %v and\n%s\nwant the excuses left out", err, buf.String())
+	}
+}
@@ -0,0 +1,333 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Scalingo/go-utils/logger"
+)
+
+// ContentType is the one of the Prometheus text format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of the
+// latency histograms of requests
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry the packages declare their metrics in, served on
+// /metrics
+var Default = NewRegistry()
+
+// Registry holds metrics and writes them in the Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]family
+}
+
+type family interface {
+	write(ctx context.Context, w *bufio.Writer) error
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: map[string]family{},
+	}
+}
+
+// register adds a family, the name of a metric must be unique
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic("metric " + name + " registered twice")
+	}
+	r.families[name] = f
+}
+
+// WriteTo writes every metric, sorted by name. The metrics collected on
+// demand which fail are left out.
+func (r *Registry) WriteTo(ctx context.Context, w io.Writer) error {
+	log := logger.Get(ctx)
+
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	families := make([]family, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		families[i] = r.families[name]
+	}
+	r.mu.Unlock()
+
+	buf := bufio.NewWriter(w)
+	for i, f := range families {
+		err := f.write(ctx, buf)
+		if err != nil {
+			log.WithError(err).Errorf("fail to collect metric %s", names[i])
+		}
+	}
+	return buf.Flush()
+}
+
+// Handler serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		err := r.WriteTo(req.Context(), w)
+		if err != nil {
+			logger.Get(req.Context()).WithError(err).Error("fail to write metrics")
+		}
+	})
+}
+
+// CounterVec is a counter by label values
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labelValues []string
+	value       float64
+}
+
+// NewCounter registers a counter, its name should end with _total
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{
+		desc:   desc{name: name, help: help, typ: "counter", labels: labels},
+		values: map[string]*counterValue{},
+	}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the counter of the label values, given in the order of the
+// labels
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.checkLabels(labelValues)
+	key := strings.Join(labelValues, "\xff")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	value, ok := c.values[key]
+	if !ok {
+		value = &counterValue{labelValues: labelValues}
+		c.values[key] = value
+	}
+	value.value += v
+}
+
+func (c *CounterVec) write(ctx context.Context, w *bufio.Writer) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	c.writeHeader(w)
+	for _, key := range keys {
+		value := c.values[key]
+		c.writeSample(w, c.name, value.labelValues, nil, value.value)
+	}
+	return nil
+}
+
+// HistogramVec counts observations in buckets by label values
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	values  map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labelValues []string
+	// counts holds the observations of each bucket alone, they are
+	// accumulated when written
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the upper bounds of its buckets,
+// sorted in increasing order. The +Inf bucket is implicit.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		desc:    desc{name: name, help: help, typ: "histogram", labels: labels},
+		buckets: buckets,
+		values:  map[string]*histogramValue{},
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.checkLabels(labelValues)
+	key := strings.Join(labelValues, "\xff")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	value, ok := h.values[key]
+	if !ok {
+		value = &histogramValue{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = value
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	if i < len(h.buckets) {
+		value.counts[i]++
+	}
+	value.count++
+	value.sum += v
+}
+
+func (h *HistogramVec) write(ctx context.Context, w *bufio.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h.writeHeader(w)
+	for _, key := range keys {
+		value := h.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += value.counts[i]
+			h.writeSample(w, h.name+"_bucket", value.labelValues, []string{"le", formatFloat(bound)}, float64(cumulative))
+		}
+		h.writeSample(w, h.name+"_bucket", value.labelValues, []string{"le", "+Inf"}, float64(value.count))
+		h.writeSample(w, h.name+"_sum", value.labelValues, nil, value.sum)
+		h.writeSample(w, h.name+"_count", value.labelValues, nil, float64(value.count))
+	}
+	return nil
+}
+
+// Sample is a value collected on demand
+type Sample struct {
+	LabelValues []string
+	Value       float64
+}
+
+// CollectFunc returns the current values of a metric
+type CollectFunc func(ctx context.Context) ([]Sample, error)
+
+type funcFamily struct {
+	desc
+	collect CollectFunc
+}
+
+// NewGaugeFunc registers a gauge whose values are collected by collect on
+// each scrape
+func (r *Registry) NewGaugeFunc(name, help string, labels []string, collect CollectFunc) {
+	r.register(name, &funcFamily{
+		desc:    desc{name: name, help: help, typ: "gauge", labels: labels},
+		collect: collect,
+	})
+}
+
+// NewCounterFunc registers a counter maintained elsewhere, such as by a
+// library, whose values are collected by collect on each scrape
+func (r *Registry) NewCounterFunc(name, help string, labels []string, collect CollectFunc) {
+	r.register(name, &funcFamily{
+		desc:    desc{name: name, help: help, typ: "counter", labels: labels},
+		collect: collect,
+	})
+}
+
+func (f *funcFamily) write(ctx context.Context, w *bufio.Writer) error {
+	samples, err := f.collect(ctx)
+	if err != nil {
+		return err
+	}
+	f.writeHeader(w)
+	for _, sample := range samples {
+		f.checkLabels(sample.LabelValues)
+		f.writeSample(w, f.name, sample.LabelValues, nil, sample.Value)
+	}
+	return nil
+}
+
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) checkLabels(labelValues []string) {
+	if len(labelValues) != len(d.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", d.name, len(d.labels), len(labelValues)))
+	}
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// writeSample writes a line of the metric, extra is a label name and value
+// pair added to the labels of the metric, such as the bound of a bucket
+func (d desc) writeSample(w *bufio.Writer, name string, labelValues []string, extra []string, value float64) {
+	w.WriteString(name)
+	if len(d.labels) > 0 || len(extra) > 0 {
+		w.WriteByte('{')
+		for i, label := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, label, labelEscaper.Replace(labelValues[i]))
+		}
+		if len(extra) > 0 {
+			if len(d.labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extra[0], extra[1])
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
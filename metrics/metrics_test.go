@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape returns the text written by registry
+func scrape(t *testing.T, registry *Registry) string {
+	t.Helper()
+	var buf bytes.Buffer
+	err := registry.WriteTo(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestCounter(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("requests_total", "Requests.\nBy \\ route.", "route", "status")
+	counter.Inc("/b", "200")
+	counter.Inc("/a", "200")
+	counter.Add(2, "/a", "200")
+	counter.Inc("/a\"\n", "500")
+
+	want := `# HELP requests_total Requests.\nBy \\ route.
+# TYPE requests_total counter
+requests_total{route="/a\"\n",status="500"} 1
+requests_total{route="/a",status="200"} 3
+requests_total{route="/b",status="200"} 1
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogram("duration_seconds", "Duration.", []float64{.1, 1}, "route")
+	for _, v := range []float64{.05, .1, .5, 2} {
+		histogram.Observe(v, "/a")
+	}
+
+	want := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 2
+duration_seconds_bucket{route="/a",le="1"} 3
+duration_seconds_bucket{route="/a",le="+Inf"} 4
+duration_seconds_sum{route="/a"} 2.65
+duration_seconds_count{route="/a"} 4
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFuncFamilies(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGaugeFunc("b_connections", "Connections.", []string{"state"}, func(context.Context) ([]Sample, error) {
+		return []Sample{{LabelValues: []string{"idle"}, Value: 2}}, nil
+	})
+	registry.NewCounterFunc("a_hits_total", "Hits.", nil, func(context.Context) ([]Sample, error) {
+		return []Sample{{Value: 7}}, nil
+	})
+	registry.NewGaugeFunc("c_excuses", "Excuses.", nil, func(context.Context) ([]Sample, error) {
+		return nil, errors.New("redis down")
+	})
+
+	// The families are sorted by name, the failing one is left out
+	want := `# HELP a_hits_total Hits.
+# TYPE a_hits_total counter
+a_hits_total 7
+# HELP b_connections Connections.
+# TYPE b_connections gauge
+b_connections{state="idle"} 2
+`
+	if got := scrape(t, registry); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounter("requests_total", "Requests.").Inc()
+
+	recorder := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if got := recorder.Header().Get("Content-Type"); got != ContentType {
+		t.Errorf("got the content type %q, want %q", got, ContentType)
+	}
+	if !strings.Contains(recorder.Body.String(), "requests_total 1\n") {
+		t.Errorf("got %q, want the counter", recorder.Body)
+	}
+}
+
+func TestRegistryPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(registry *Registry)
+	}{
+		{"registered twice", func(registry *Registry) {
+			registry.NewCounter("requests_total", "Requests.")
+			registry.NewHistogram("requests_total", "Requests.", DefaultBuckets)
+		}},
+		{"missing label value", func(registry *Registry) {
+			registry.NewCounter("requests_total", "Requests.", "route", "status").Inc("/a")
+		}},
+		{"extra label value", func(registry *Registry) {
+			registry.NewHistogram("duration_seconds", "Duration.", DefaultBuckets).Observe(1, "/a")
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("got no panic")
+				}
+			}()
+			test.run(NewRegistry())
+		})
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{1, "1"},
+		{0.005, "0.005"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, test := range tests {
+		if got := formatFloat(test.v); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
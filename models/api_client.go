@@ -0,0 +1,344 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Scopes of the API clients, admin grants every other scope
+const (
+	ScopeExcusesRead  = "excuses:read"
+	ScopeExcusesWrite = "excuses:write"
+	// ScopeActingUser lets a client act on behalf of a Discord user
+	ScopeActingUser = "users:act"
+	ScopeAdmin      = "admin"
+
+	// AllSources in the sources of a client gives access to every source
+	AllSources = "*"
+)
+
+var Scopes = []string{ScopeExcusesRead, ScopeExcusesWrite, ScopeActingUser, ScopeAdmin}
+
+// ErrAPIClientExists is returned when creating a client with the name of
+// another one
+var ErrAPIClientExists = errors.New("API client already exists")
+
+// ErrCertSubjectTaken is returned when creating a client with the
+// certificate subject of another one
+var ErrCertSubjectTaken = errors.New("certificate subject already used by another API client")
+
+// APIClient is a caller of the API, authenticated by its name and a secret of
+// which only the bcrypt hash is kept
+type APIClient struct {
+	Name       string     `json:"name"`
+	SecretHash string     `json:"secret_hash"`
+	Scopes     []string   `json:"scopes"`
+	Sources    []string   `json:"sources"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RotatedAt  *time.Time `json:"rotated_at,omitempty"`
+	// RateLimits overrides the rate limits of the sources for this client
+	RateLimits RateLimits `json:"rate_limits,omitempty"`
+	// CertSubject is the subject of the client certificate authenticating
+	// the client, such as "CN=billing,O=Acme"
+	CertSubject string `json:"cert_subject,omitempty"`
+}
+
+type RedisStoreAPIClients struct {
+	*goRedis.Client
+}
+
+type apiClientContextKey struct{}
+
+// WithAPIClient returns a copy of ctx holding the client making the request
+func WithAPIClient(ctx context.Context, client *APIClient) context.Context {
+	return context.WithValue(ctx, apiClientContextKey{}, client)
+}
+
+// APIClientFromContext returns the client making the request, nil if the
+// request is not authenticated as an API client
+func APIClientFromContext(ctx context.Context) *APIClient {
+	client, _ := ctx.Value(apiClientContextKey{}).(*APIClient)
+	return client
+}
+
+// HasScope tells whether the client has scope, through the admin scope
+// otherwise
+func (c *APIClient) HasScope(scope string) bool {
+	if c == nil {
+		return false
+	}
+	for _, s := range c.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess tells whether the client may use the excuses and the settings of
+// source
+func (c *APIClient) CanAccess(source string) bool {
+	if c == nil {
+		return false
+	}
+	for _, s := range c.Sources {
+		if s == source || s == AllSources {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifySecret compares secret with the hash of the secret of the client
+func (c *APIClient) VerifySecret(secret string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(c.SecretHash), []byte(secret)) == nil
+}
+
+func hashSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to hash secret")
+	}
+	return string(hash), nil
+}
+
+// Create registers client with secret, it returns ErrAPIClientExists if the
+// name is taken and ErrCertSubjectTaken if its certificate subject is
+func (c *RedisStoreAPIClients) Create(ctx context.Context, client *APIClient, secret string) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Create").WithField("key", c.key())
+	log.Debugln("client:", client.Name)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	var err error
+	client.SecretHash, err = hashSecret(secret)
+	if err != nil {
+		return err
+	}
+	client.CreatedAt = time.Now().UTC()
+	bytes, err := json.Marshal(client)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal API client")
+	}
+
+	res := c.HSetNX(c.key(), client.Name, bytes)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to create API client")
+	}
+	if !res.Val() {
+		return ErrAPIClientExists
+	}
+	if client.CertSubject == "" {
+		return nil
+	}
+
+	res = c.HSetNX(c.subjectsKey(), client.CertSubject, client.Name)
+	if res.Err() == nil && res.Val() {
+		return nil
+	}
+	// The client is not kept without its subject
+	del := c.HDel(c.key(), client.Name)
+	if del.Err() != nil {
+		log.WithError(del.Err()).Error("fail to delete API client without subject")
+	}
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to save certificate subject of API client")
+	}
+	return ErrCertSubjectTaken
+}
+
+// GetByCertSubject returns the client authenticated by the certificates of
+// subject, nil if there is none
+func (c *RedisStoreAPIClients) GetByCertSubject(ctx context.Context, subject string) (*APIClient, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetByCertSubject").WithField("key", c.subjectsKey())
+	log.Debugln("subject:", subject)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.subjectsKey(), subject)
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get API client of subject: "+subject)
+	}
+	client, err := c.Get(ctx, res.Val())
+	if err != nil {
+		return nil, err
+	}
+	// The subject of a revoked client may be left over
+	if client == nil || client.CertSubject != subject {
+		return nil, nil
+	}
+	return client, nil
+}
+
+// Get returns the client named name, nil if there is none
+func (c *RedisStoreAPIClients) Get(ctx context.Context, name string) (*APIClient, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Get").WithField("key", c.key())
+	log.Debugln("client:", name)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.key(), name)
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get API client: "+name)
+	}
+
+	var client APIClient
+	err := json.Unmarshal([]byte(res.Val()), &client)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal API client")
+	}
+	return &client, nil
+}
+
+// List returns the clients sorted by name
+func (c *RedisStoreAPIClients) List(ctx context.Context) ([]APIClient, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "List").WithField("key", c.key())
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGetAll(c.key())
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get API clients")
+	}
+
+	clients := make([]APIClient, 0, len(res.Val()))
+	for _, v := range res.Val() {
+		var client APIClient
+		err := json.Unmarshal([]byte(v), &client)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal API client")
+		}
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Name < clients[j].Name
+	})
+	return clients, nil
+}
+
+// Rotate replaces the secret of a client, it returns false if there is no
+// client named name. The previous secret stops working at once.
+func (c *RedisStoreAPIClients) Rotate(ctx context.Context, name, secret string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Rotate").WithField("key", c.key())
+	log.Debugln("client:", name)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	hash, err := hashSecret(secret)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	err = c.Watch(func(tx *goRedis.Tx) error {
+		res := tx.HGet(c.key(), name)
+		if res.Err() == goRedis.Nil {
+			return nil
+		}
+		if res.Err() != nil {
+			return errors.Wrap(res.Err(), "fail to get API client: "+name)
+		}
+
+		var client APIClient
+		err := json.Unmarshal([]byte(res.Val()), &client)
+		if err != nil {
+			return errors.Wrap(err, "fail to unmarshal API client")
+		}
+		now := time.Now().UTC()
+		client.SecretHash = hash
+		client.RotatedAt = &now
+		bytes, err := json.Marshal(client)
+		if err != nil {
+			return errors.Wrap(err, "fail to marshal API client")
+		}
+
+		_, err = tx.Pipelined(func(pipe goRedis.Pipeliner) error {
+			pipe.HSet(c.key(), name, bytes)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		found = true
+		return nil
+	}, c.key())
+	if err != nil {
+		return false, errors.Wrap(err, "fail to rotate API client secret: "+name)
+	}
+	return found, nil
+}
+
+// Revoke deletes a client, it returns false if there is no client named name
+func (c *RedisStoreAPIClients) Revoke(ctx context.Context, name string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Revoke").WithField("key", c.key())
+	log.Debugln("client:", name)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	client, err := c.Get(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if client == nil {
+		return false, nil
+	}
+
+	pipe := c.TxPipeline()
+	res := pipe.HDel(c.key(), name)
+	if client.CertSubject != "" {
+		pipe.HDel(c.subjectsKey(), client.CertSubject)
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return false, errors.Wrap(err, "fail to revoke API client: "+name)
+	}
+	return res.Val() == 1, nil
+}
+
+func (c *RedisStoreAPIClients) key() string {
+	return fmt.Sprintf("%sAPIClients", redis.Prefix())
+}
+
+// subjectsKey indexes the names of the clients by certificate subject
+func (c *RedisStoreAPIClients) subjectsKey() string {
+	return fmt.Sprintf("%sAPIClientSubjects", redis.Prefix())
+}
@@ -0,0 +1,91 @@
+package models
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAPIClientAccess(t *testing.T) {
+	reader := &APIClient{Scopes: []string{ScopeExcusesRead}, Sources: []string{"guild"}}
+	admin := &APIClient{Scopes: []string{ScopeAdmin}, Sources: []string{AllSources}}
+	var anonymous *APIClient
+
+	tests := []struct {
+		name   string
+		client *APIClient
+		scope  string
+		source string
+		has    bool
+		access bool
+	}{
+		{"own scope and source", reader, ScopeExcusesRead, "guild", true, true},
+		{"other scope and source", reader, ScopeExcusesWrite, "other", false, false},
+		{"admin", admin, ScopeActingUser, "other", true, true},
+		{"anonymous", anonymous, ScopeExcusesRead, "guild", false, false},
+	}
+	for _, test := range tests {
+		if got := test.client.HasScope(test.scope); got != test.has {
+			t.Errorf("%s: HasScope(%s) = %v, want %v", test.name, test.scope, got, test.has)
+		}
+		if got := test.client.CanAccess(test.source); got != test.access {
+			t.Errorf("%s: CanAccess(%s) = %v, want %v", test.name, test.source, got, test.access)
+		}
+	}
+}
+
+func TestRedisStoreAPIClients(t *testing.T) {
+	store := &RedisStoreAPIClients{Client: newTestRedis(t)}
+	ctx := context.Background()
+
+	client := &APIClient{Name: "bot", Scopes: []string{ScopeExcusesRead}, CertSubject: "CN=bot"}
+	err := store.Create(ctx, client, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.SecretHash == "" || client.SecretHash == "s3cr3t" || client.CreatedAt.IsZero() {
+		t.Fatalf("got the client %+v, want its secret hashed", client)
+	}
+	if err := store.Create(ctx, &APIClient{Name: "bot"}, "other"); err != ErrAPIClientExists {
+		t.Errorf("got %v for a taken name, want ErrAPIClientExists", err)
+	}
+	if err := store.Create(ctx, &APIClient{Name: "other", CertSubject: "CN=bot"}, "other"); err != ErrCertSubjectTaken {
+		t.Errorf("got %v for a taken subject, want ErrCertSubjectTaken", err)
+	}
+	if other, _ := store.Get(ctx, "other"); other != nil {
+		t.Errorf("got %+v, want the client of the taken subject not kept", other)
+	}
+
+	stored, err := store.Get(ctx, "bot")
+	if err != nil || stored == nil || !stored.VerifySecret("s3cr3t") || stored.VerifySecret("other") {
+		t.Fatalf("got %+v, %v, want the client verifying its secret only", stored, err)
+	}
+	bySubject, err := store.GetByCertSubject(ctx, "CN=bot")
+	if err != nil || bySubject == nil || bySubject.Name != "bot" {
+		t.Fatalf("got %+v, %v for the subject, want the client", bySubject, err)
+	}
+
+	rotated, err := store.Rotate(ctx, "bot", "n3w")
+	if err != nil || !rotated {
+		t.Fatalf("got %v, %v on rotation, want it rotated", rotated, err)
+	}
+	stored, _ = store.Get(ctx, "bot")
+	if stored.VerifySecret("s3cr3t") || !stored.VerifySecret("n3w") || stored.RotatedAt == nil {
+		t.Errorf("got %+v after the rotation, want only the new secret valid", stored)
+	}
+	if rotated, err := store.Rotate(ctx, "unknown", "n3w"); err != nil || rotated {
+		t.Errorf("got %v, %v rotating an unknown client, want false", rotated, err)
+	}
+
+	revoked, err := store.Revoke(ctx, "bot")
+	if err != nil || !revoked {
+		t.Fatalf("got %v, %v on revocation, want it revoked", revoked, err)
+	}
+	stored, _ = store.Get(ctx, "bot")
+	bySubject, _ = store.GetByCertSubject(ctx, "CN=bot")
+	if stored != nil || bySubject != nil {
+		t.Errorf("got %+v and %+v after the revocation, want neither", stored, bySubject)
+	}
+	if clients, err := store.List(ctx); err != nil || len(clients) != 0 {
+		t.Errorf("got %v, %v, want no client left", clients, err)
+	}
+}
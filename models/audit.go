@@ -0,0 +1,368 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// Actions of the audit records of the changes of excuses and API clients. The
+// records of the other calls have the method and the route as action, such as
+// "PUT /api/sources/{source}/feed".
+const (
+	AuditExcuseCreated  = "excuse.created"
+	AuditExcuseUpdated  = "excuse.updated"
+	AuditExcuseDeleted  = "excuse.deleted"
+	AuditExcuseRestored = "excuse.restored"
+	AuditExcusePurged   = "excuse.purged"
+	AuditClientCreated  = "client.created"
+	AuditClientRotated  = "client.rotated"
+	AuditClientRevoked  = "client.revoked"
+)
+
+// AuditRecord is an entry of the audit log of a source. The changes of the
+// API clients are recorded in the global audit log, without source.
+type AuditRecord struct {
+	ID         string     `json:"id"`
+	Action     string     `json:"action"`
+	Client     string     `json:"client"`
+	ActingUser string     `json:"acting_user,omitempty"`
+	Source     string     `json:"source,omitempty"`
+	ExcuseID   string     `json:"excuse_id,omitempty"`
+	Before     *Codexcuse `json:"before,omitempty"`
+	After      *Codexcuse `json:"after,omitempty"`
+	// APIClient is the client created, rotated or revoked
+	APIClient  string    `json:"api_client,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// AuditFilter selects records of the audit log, an empty field matches any
+// value
+type AuditFilter struct {
+	Action     string
+	Client     string
+	ActingUser string
+	ExcuseID   string
+	Since      time.Time
+	Until      time.Time
+	// Before is the ID of the last record of the previous page
+	Before string
+	Limit  int
+}
+
+// AuditTrail collects the changes of excuses made while serving a request,
+// the stores add them to the trail of the context
+type AuditTrail struct {
+	mu      sync.Mutex
+	changes []AuditRecord
+}
+
+type auditTrailContextKey struct{}
+
+// WithAuditTrail returns a copy of ctx in which the changes of excuses are
+// added to trail
+func WithAuditTrail(ctx context.Context, trail *AuditTrail) context.Context {
+	return context.WithValue(ctx, auditTrailContextKey{}, trail)
+}
+
+// Changes returns the changes added to the trail
+func (t *AuditTrail) Changes() []AuditRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]AuditRecord(nil), t.changes...)
+}
+
+// auditChange adds a change of an excuse to the trail of ctx, if any
+func auditChange(ctx context.Context, action, source, id string, before, after *Codexcuse) {
+	trail, _ := ctx.Value(auditTrailContextKey{}).(*AuditTrail)
+	if trail == nil {
+		return
+	}
+	trail.mu.Lock()
+	defer trail.mu.Unlock()
+	trail.changes = append(trail.changes, AuditRecord{
+		Action:   action,
+		Source:   source,
+		ExcuseID: id,
+		Before:   before,
+		After:    after,
+	})
+}
+
+type RedisStoreAudit struct {
+	*goRedis.Client
+}
+
+// AuditLogMaxLength caps, approximately, the records kept per source, 0 keeps
+// all of them
+var AuditLogMaxLength int64 = 100000
+
+// RecordTrail appends to the audit log a record per change of trail, each
+// completed with the fields of the request in base, or base itself when the
+// request changed no excuse
+func (c *RedisStoreAudit) RecordTrail(ctx context.Context, base AuditRecord, trail *AuditTrail) error {
+	changes := trail.Changes()
+	if len(changes) == 0 {
+		return c.Record(ctx, base)
+	}
+	for _, change := range changes {
+		record := base
+		record.Action = change.Action
+		record.Source = change.Source
+		record.ExcuseID = change.ExcuseID
+		record.Before = change.Before
+		record.After = change.After
+		err := c.Record(ctx, record)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record appends a record to the audit log of its source
+func (c *RedisStoreAudit) Record(ctx context.Context, record AuditRecord) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Record").WithField("key", c.key(record.Source))
+	log.Debugln("source:", record.Source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	before, err := marshalAuditExcuse(record.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalAuditExcuse(record.After)
+	if err != nil {
+		return err
+	}
+	if record.At.IsZero() {
+		record.At = time.Now()
+	}
+
+	res := c.XAdd(&goRedis.XAddArgs{
+		Stream:       c.key(record.Source),
+		MaxLenApprox: AuditLogMaxLength,
+		Values: map[string]interface{}{
+			"action":      record.Action,
+			"client":      record.Client,
+			"acting_user": record.ActingUser,
+			"source":      record.Source,
+			"excuse_id":   record.ExcuseID,
+			"before":      before,
+			"after":       after,
+			"api_client":  record.APIClient,
+			"method":      record.Method,
+			"path":        record.Path,
+			"status_code": record.StatusCode,
+			"request_id":  record.RequestID,
+			"ip":          record.IP,
+			"at":          record.At.UTC().Format(time.RFC3339Nano),
+		},
+	})
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to add audit record")
+	}
+	return nil
+}
+
+// List returns the records of the audit log of source matching filter,
+// newest first
+func (c *RedisStoreAudit) List(ctx context.Context, source string, filter AuditFilter) ([]AuditRecord, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "List").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	start, end := filter.bounds()
+	if filter.Before != "" {
+		prev, ok := prevStreamID(filter.Before)
+		if !ok {
+			return nil, fmt.Errorf("invalid cursor '%s'", filter.Before)
+		}
+		end = prev
+	}
+
+	// The log is scanned by batches until the page is full, as most records
+	// may be filtered out
+	batchSize := int64(filter.Limit) * 4
+	records := []AuditRecord{}
+	for len(records) < filter.Limit {
+		res := c.XRevRangeN(c.key(source), end, start, batchSize)
+		if res.Err() != nil {
+			return nil, errors.Wrap(res.Err(), "fail to get range of audit log")
+		}
+		for _, msg := range res.Val() {
+			record := toAuditRecord(msg)
+			if filter.matches(record) {
+				records = append(records, record)
+				if len(records) == filter.Limit {
+					break
+				}
+			}
+		}
+		if int64(len(res.Val())) < batchSize {
+			break
+		}
+
+		var ok bool
+		end, ok = prevStreamID(res.Val()[len(res.Val())-1].ID)
+		if !ok {
+			break
+		}
+	}
+	return records, nil
+}
+
+// Export calls fn with each record of the audit log of source matching
+// filter, oldest first. The limit and the cursor of filter are ignored.
+func (c *RedisStoreAudit) Export(ctx context.Context, source string, filter AuditFilter, fn func(AuditRecord) error) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Export").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	const batchSize = 500
+	start, end := filter.bounds()
+	for {
+		res := c.XRangeN(c.key(source), start, end, batchSize)
+		if res.Err() != nil {
+			return errors.Wrap(res.Err(), "fail to get range of audit log")
+		}
+		for _, msg := range res.Val() {
+			record := toAuditRecord(msg)
+			if !filter.matches(record) {
+				continue
+			}
+			err := fn(record)
+			if err != nil {
+				return err
+			}
+		}
+		if len(res.Val()) < batchSize {
+			return nil
+		}
+
+		var ok bool
+		start, ok = nextStreamID(res.Val()[len(res.Val())-1].ID)
+		if !ok {
+			return nil
+		}
+	}
+}
+
+// bounds returns the stream IDs between which the records of the period of
+// the filter are
+func (f AuditFilter) bounds() (string, string) {
+	start, end := "-", "+"
+	if !f.Since.IsZero() {
+		start = strconv.FormatInt(toMillis(f.Since), 10)
+	}
+	if !f.Until.IsZero() {
+		end = strconv.FormatInt(toMillis(f.Until), 10)
+	}
+	return start, end
+}
+
+func (f AuditFilter) matches(record AuditRecord) bool {
+	if f.Action != "" && f.Action != record.Action {
+		return false
+	}
+	if f.Client != "" && f.Client != record.Client {
+		return false
+	}
+	if f.ActingUser != "" && f.ActingUser != record.ActingUser {
+		return false
+	}
+	if f.ExcuseID != "" && f.ExcuseID != record.ExcuseID {
+		return false
+	}
+	return true
+}
+
+func marshalAuditExcuse(excuse *Codexcuse) (string, error) {
+	if excuse == nil {
+		return "", nil
+	}
+	bytes, err := json.Marshal(excuse)
+	if err != nil {
+		return "", errors.Wrap(err, "fail to marshal excuse")
+	}
+	return string(bytes), nil
+}
+
+func toAuditRecord(msg goRedis.XMessage) AuditRecord {
+	value := func(field string) string {
+		v, _ := msg.Values[field].(string)
+		return v
+	}
+
+	record := AuditRecord{
+		ID:         msg.ID,
+		Action:     value("action"),
+		Client:     value("client"),
+		ActingUser: value("acting_user"),
+		Source:     value("source"),
+		ExcuseID:   value("excuse_id"),
+		APIClient:  value("api_client"),
+		Method:     value("method"),
+		Path:       value("path"),
+		RequestID:  value("request_id"),
+		IP:         value("ip"),
+	}
+	record.StatusCode, _ = strconv.Atoi(value("status_code"))
+	record.At, _ = time.Parse(time.RFC3339Nano, value("at"))
+	if before := value("before"); before != "" {
+		json.Unmarshal([]byte(before), &record.Before)
+	}
+	if after := value("after"); after != "" {
+		json.Unmarshal([]byte(after), &record.After)
+	}
+	return record
+}
+
+// nextStreamID returns the lowest stream ID greater than id, XRANGE has no
+// exclusive bound in the redis versions we support
+func nextStreamID(id string) (string, bool) {
+	ms, seq, ok := parseStreamID(id)
+	if !ok {
+		return "", false
+	}
+	if seq < 1<<64-1 {
+		return fmt.Sprintf("%d-%d", ms, seq+1), true
+	}
+	return fmt.Sprintf("%d-0", ms+1), true
+}
+
+// key is the stream of the audit log of source, the global one for an empty
+// source
+func (c *RedisStoreAudit) key(source string) string {
+	if source == "" {
+		return fmt.Sprintf("%sAuditLog:global", redis.Prefix())
+	}
+	return fmt.Sprintf("%sAuditLog:source:%s", redis.Prefix(), source)
+}
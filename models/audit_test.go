@@ -0,0 +1,150 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAuditTrail(t *testing.T) {
+	client := newTestRedis(t)
+	excuses := &RedisStoreCodexcuses{Client: client}
+	audit := &RedisStoreAudit{Client: client}
+
+	trail := &AuditTrail{}
+	ctx := WithAuditTrail(context.Background(), trail)
+	excuse := Codexcuse{Title: "t", Content: "c", Author: &User{UserName: "a"}, Reporter: &User{ID: "1", UserName: "r"}}
+	err := excuses.Add(ctx, "guild", &excuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := excuse
+	edited.Title = "edited"
+	_, err = excuses.Update(ctx, "guild", edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := AuditRecord{Action: "POST /api/codexcuses/{source}", Client: "bot", ActingUser: "1", Source: "guild", StatusCode: 200, RequestID: "req"}
+	err = audit.RecordTrail(ctx, base, trail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A request changing no excuse is recorded as is
+	err = audit.RecordTrail(context.Background(), AuditRecord{Action: "PUT /api/sources/{source}/feed", Client: "bot", Source: "guild"}, &AuditTrail{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := audit.List(context.Background(), "guild", AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	if records[0].Action != "PUT /api/sources/{source}/feed" || records[0].ExcuseID != "" {
+		t.Errorf("got %+v, want the record of the request", records[0])
+	}
+	updated, created := records[1], records[2]
+	if updated.Action != AuditExcuseUpdated || updated.Before.Title != "t" || updated.After.Title != "edited" {
+		t.Errorf("got %+v, want the update with the excuse before and after", updated)
+	}
+	if created.Action != AuditExcuseCreated || created.Before != nil || created.After.ID != excuse.ID {
+		t.Errorf("got %+v, want the creation", created)
+	}
+	for _, record := range records[1:] {
+		if record.Client != "bot" || record.ActingUser != "1" || record.RequestID != "req" || record.StatusCode != 200 ||
+			record.ExcuseID != excuse.ID || record.At.IsZero() || record.ID == "" {
+			t.Errorf("got %+v, want the fields of the request", record)
+		}
+	}
+
+	// The changes made without trail are not recorded
+	err = excuses.Delete(context.Background(), "guild", excuse.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := audit.List(context.Background(), "guild", AuditFilter{Limit: 10}); len(records) != 3 {
+		t.Errorf("got %d records, want the deletion without trail not recorded", len(records))
+	}
+}
+
+func TestAuditListAndExport(t *testing.T) {
+	audit := &RedisStoreAudit{Client: newTestRedis(t)}
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour)
+	clients := []string{"bot", "other", "bot", "bot", "other", "bot"}
+	for _, client := range clients {
+		err := audit.Record(ctx, AuditRecord{Action: AuditExcuseCreated, Client: client, Source: "guild"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Pages of the records of bot, newest first
+	var ids []string
+	before := ""
+	for page := 0; page < 3; page++ {
+		records, err := audit.List(ctx, "guild", AuditFilter{Client: "bot", Limit: 3, Before: before})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records {
+			if record.Client != "bot" {
+				t.Errorf("got a record of %s, want the ones of bot", record.Client)
+			}
+			ids = append(ids, record.ID)
+		}
+		if len(records) < 3 {
+			break
+		}
+		before = records[len(records)-1].ID
+	}
+	if len(ids) != 4 {
+		t.Errorf("got the records %v, want the 4 of bot", ids)
+	}
+
+	var exported []string
+	err := audit.Export(ctx, "guild", AuditFilter{Client: "bot", Since: start, Limit: 1}, func(record AuditRecord) error {
+		exported = append(exported, record.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 4 || exported[0] != ids[3] || exported[3] != ids[0] {
+		t.Errorf("got the export %v, want the records of bot oldest first", exported)
+	}
+
+	if records, _ := audit.List(ctx, "guild", AuditFilter{Until: start, Limit: 10}); len(records) != 0 {
+		t.Errorf("got %d records before the first one, want none", len(records))
+	}
+	if _, err := audit.List(ctx, "guild", AuditFilter{Before: "cursor", Limit: 10}); err == nil {
+		t.Error("got no error for an invalid cursor")
+	}
+	if records, _ := audit.List(ctx, "", AuditFilter{Limit: 10}); len(records) != 0 {
+		t.Errorf("got %d records in the global log, want the ones of the sources apart", len(records))
+	}
+}
+
+func TestStreamIDs(t *testing.T) {
+	tests := []struct {
+		id, next, prev string
+	}{
+		{"5-3", "5-4", "5-2"},
+		{"5-0", "5-1", "4-18446744073709551615"},
+		{"5-18446744073709551615", "6-0", "5-18446744073709551614"},
+	}
+	for _, test := range tests {
+		if next, ok := nextStreamID(test.id); !ok || next != test.next {
+			t.Errorf("nextStreamID(%s) = %s, want %s", test.id, next, test.next)
+		}
+		if prev, ok := prevStreamID(test.id); !ok || prev != test.prev {
+			t.Errorf("prevStreamID(%s) = %s, want %s", test.id, prev, test.prev)
+		}
+	}
+	if _, ok := nextStreamID("invalid"); ok {
+		t.Error("got a next ID of an invalid one")
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Scalingo/go-utils/logger"
@@ -57,6 +59,7 @@ func (c *RedisStoreCodexcuses) GetRandom(ctx context.Context, source string) (*C
 	if c == nil {
 		return nil, errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 
 	// Get all keys to know how many items there are in the table
 	rangeRes := c.ZRevRange(c.excuseIDKey(source), 0, -1)
@@ -96,6 +99,7 @@ func (c *RedisStoreCodexcuses) GetByUser(ctx context.Context, source string, use
 	if c == nil {
 		return nil, errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 
 	var excuses []Codexcuse
 
@@ -106,13 +110,50 @@ func (c *RedisStoreCodexcuses) GetByUser(ctx context.Context, source string, use
 	for _, c := range res.Val() {
 		var excuse Codexcuse
 		json.Unmarshal([]byte(c), &excuse)
-		if excuse.Author.ID == userID {
+		if excuse.Author != nil && excuse.Author.ID == userID {
 			excuses = append(excuses, excuse)
 		}
 	}
 	return &excuses, nil
 }
 
+// Search gives the excuses whose title or content contains query, case
+// insensitively, sorted by title and at most limit of them
+func (c *RedisStoreCodexcuses) Search(ctx context.Context, source, query string, limit int) ([]Codexcuse, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Search").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGetAll(c.key(source))
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get all excuses")
+	}
+
+	query = strings.ToLower(query)
+	excuses := []Codexcuse{}
+	for _, v := range res.Val() {
+		var excuse Codexcuse
+		json.Unmarshal([]byte(v), &excuse)
+		if strings.Contains(strings.ToLower(excuse.Title), query) || strings.Contains(strings.ToLower(excuse.Content), query) {
+			excuses = append(excuses, excuse)
+		}
+	}
+
+	sort.Slice(excuses, func(i, j int) bool {
+		return strings.ToLower(excuses[i].Title) < strings.ToLower(excuses[j].Title)
+	})
+	if len(excuses) > limit {
+		excuses = excuses[:limit]
+	}
+	return excuses, nil
+}
+
 func (c *RedisStoreCodexcuses) GetAll(ctx context.Context, source string, requestedPage int, excuses *[]Codexcuse) (Meta, error) {
 	log := logger.Get(ctx)
 
@@ -123,6 +164,7 @@ func (c *RedisStoreCodexcuses) GetAll(ctx context.Context, source string, reques
 	if c == nil {
 		return meta, errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 
 	// Get all keys to know how many items there are in the table
 	rangeRes := c.ZRevRange(c.excuseIDKey(source), 0, -1)
@@ -181,6 +223,7 @@ func (c *RedisStoreCodexcuses) Get(ctx context.Context, source, id string) (*Cod
 	if c == nil {
 		return nil, errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 	res := c.HGet(c.key(source), id)
 	if res.Val() == "" {
 		return nil, nil
@@ -197,7 +240,8 @@ func (c *RedisStoreCodexcuses) Get(ctx context.Context, source, id string) (*Cod
 	return &excuse, nil
 }
 
-func (c *RedisStoreCodexcuses) Add(ctx context.Context, source string, excuse Codexcuse) error {
+// Add saves a new excuse, its ID is set on excuse
+func (c *RedisStoreCodexcuses) Add(ctx context.Context, source string, excuse *Codexcuse) error {
 	log := logger.Get(ctx)
 
 	log.WithField("function", "Add").WithField("key", c.key(source))
@@ -205,37 +249,62 @@ func (c *RedisStoreCodexcuses) Add(ctx context.Context, source string, excuse Co
 	if c == nil {
 		return errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 
 	excuse.ID = uuid.New().String()
-	bytes, err := json.Marshal(excuse)
+	pipe := c.TxPipeline()
+	_, err := c.queueAdd(pipe, source, *excuse, time.Now())
 	if err != nil {
-		return errors.Wrap(err, "fail to marshal excuse")
+		return err
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return errors.Wrap(err, "fail to add excuse")
 	}
 
-	// Use a CodexcuseIDs key to store a sorted list of codexcuse's ID, sorted by
-	// creation timestamp
-	t := time.Now()
-	timestamp := int64(time.Nanosecond) * t.UnixNano() / int64(time.Millisecond)
-	zAddRes := c.ZAdd(c.excuseIDKey(source), goRedis.Z{
-		Score:  float64(timestamp),
-		Member: excuse.ID,
-	})
-	if zAddRes.Err() != nil || zAddRes.Val() != 1 {
-		return errors.Wrap(zAddRes.Err(), "fail to ZADD the ID of excuse")
+	log.Debugln("addedd excuse:", excuse.ID)
+	c.publish(ctx, source, EventExcuseCreated, excuse)
+	auditChange(ctx, AuditExcuseCreated, source, excuse.ID, nil, excuse)
+	return nil
+}
+
+// Update replaces an excuse, it returns false if there is no excuse with its
+// ID
+func (c *RedisStoreCodexcuses) Update(ctx context.Context, source string, excuse Codexcuse) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Update").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 
-	// Use Codexcuse key to store excuse content store by ID
-	res := c.HSet(c.key(source), excuse.ID, bytes)
-	if res.Err() != nil {
-		return errors.Wrap(res.Err(), "fail to set an excuses")
+	existing, err := c.Get(ctx, source, excuse.ID)
+	if err != nil {
+		return false, err
+	}
+	if existing == nil {
+		return false, nil
 	}
 
-	log.Debugln("addedd excuse:", excuse.ID)
-	return nil
+	pipe := c.TxPipeline()
+	_, err = c.queueUpdate(pipe, source, *existing, excuse)
+	if err != nil {
+		return false, err
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return false, errors.Wrap(err, "fail to update excuse: "+excuse.ID)
+	}
+
+	c.publish(ctx, source, EventExcuseUpdated, &excuse)
+	auditChange(ctx, AuditExcuseUpdated, source, excuse.ID, existing, &excuse)
+	return true, nil
 }
 
-// Delete remove from Codexcuse, CodescuseIDs and CodexcuseAuthors the field
-// corresponding with id parameter
+// Delete remove from Codexcuse, CodescuseIDs and CodexcuseTitles the field
+// corresponding with id parameter and moves the excuse to the trash
 func (c *RedisStoreCodexcuses) Delete(ctx context.Context, source, id string) error {
 	log := logger.Get(ctx)
 
@@ -244,20 +313,90 @@ func (c *RedisStoreCodexcuses) Delete(ctx context.Context, source, id string) er
 	if c == nil {
 		return errors.New("fail to get redis client")
 	}
+	c = c.withContext(ctx)
 
-	res := c.HDel(c.key(source), id)
-	if res.Err() != nil {
-		return errors.Wrap(res.Err(), "fail to delete hash of excuse: "+id)
+	excuse, err := c.Get(ctx, source, id)
+	if err != nil {
+		return err
 	}
 
-	res = c.ZRem(c.excuseIDKey(source), id)
-	if res.Err() != nil {
-		return errors.Wrap(res.Err(), "fail to delete ID of excuse: "+id)
+	pipe := c.TxPipeline()
+	_, err = c.queueDelete(pipe, source, id, excuse)
+	if err != nil {
+		return err
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return errors.Wrap(err, "fail to delete excuse: "+id)
 	}
 
+	if excuse != nil {
+		c.publish(ctx, source, EventExcuseDeleted, &Codexcuse{ID: id})
+		auditChange(ctx, AuditExcuseDeleted, source, id, excuse, nil)
+	}
 	return nil
 }
 
+// queueAdd queues the commands saving a new excuse, created at t
+func (c *RedisStoreCodexcuses) queueAdd(pipe goRedis.Pipeliner, source string, excuse Codexcuse, t time.Time) ([]goRedis.Cmder, error) {
+	bytes, err := json.Marshal(excuse)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to marshal excuse")
+	}
+
+	cmds := []goRedis.Cmder{
+		// Use a CodexcuseIDs key to store a sorted list of codexcuse's ID, sorted
+		// by creation timestamp
+		pipe.ZAdd(c.excuseIDKey(source), goRedis.Z{
+			Score:  float64(toMillis(t)),
+			Member: excuse.ID,
+		}),
+		// Use Codexcuse key to store excuse content store by ID
+		pipe.HSet(c.key(source), excuse.ID, bytes),
+	}
+	return append(cmds, c.queueIndex(pipe, source, excuse)...), nil
+}
+
+// queueUpdate queues the commands replacing excuse by updated
+func (c *RedisStoreCodexcuses) queueUpdate(pipe goRedis.Pipeliner, source string, excuse, updated Codexcuse) ([]goRedis.Cmder, error) {
+	bytes, err := json.Marshal(updated)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to marshal excuse")
+	}
+
+	cmds := []goRedis.Cmder{pipe.HSet(c.key(source), updated.ID, bytes)}
+	cmds = append(cmds, c.queueUnindex(pipe, source, excuse)...)
+	return append(cmds, c.queueIndex(pipe, source, updated)...), nil
+}
+
+// queueDelete queues the commands moving an excuse to the trash, excuse is
+// nil when it is not known. Its reports are dropped, its upvotes are kept
+// until it is purged.
+func (c *RedisStoreCodexcuses) queueDelete(pipe goRedis.Pipeliner, source, id string, excuse *Codexcuse) ([]goRedis.Cmder, error) {
+	cmds := []goRedis.Cmder{
+		pipe.HDel(c.key(source), id),
+		pipe.ZRem(c.excuseIDKey(source), id),
+		pipe.Del(c.reportsKey(source, id)),
+		pipe.ZRem(c.reportedKey(source), id),
+	}
+	if excuse == nil {
+		return cmds, nil
+	}
+
+	bytes, err := json.Marshal(excuse)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to marshal excuse")
+	}
+	cmds = append(cmds, c.queueUnindex(pipe, source, *excuse)...)
+	return append(cmds,
+		pipe.HSet(c.trashKey(source), id, bytes),
+		pipe.ZAdd(c.trashIDKey(source), goRedis.Z{
+			Score:  float64(toMillis(time.Now())),
+			Member: id,
+		}),
+	), nil
+}
+
 func (c *RedisStoreCodexcuses) key(source string) string {
 	return fmt.Sprintf("%sCodexcuse:source:%s", redis.Prefix(), source)
 }
@@ -0,0 +1,298 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	goRedis "github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	BatchAdd    = "add"
+	BatchDelete = "delete"
+	BatchPatch  = "patch"
+)
+
+// BatchOperation is an operation of a batch, Ref is chosen by the client to
+// match the operation with its result
+type BatchOperation struct {
+	Ref    string          `json:"ref"`
+	Op     string          `json:"op"`
+	ID     string          `json:"id,omitempty"`
+	Excuse *Codexcuse      `json:"excuse,omitempty"`
+	Patch  *CodexcusePatch `json:"patch,omitempty"`
+}
+
+// CodexcusePatch holds the fields of an excuse to change, a nil field is left
+// unchanged
+type CodexcusePatch struct {
+	Title    *string `json:"title,omitempty"`
+	Content  *string `json:"content,omitempty"`
+	Author   *User   `json:"author,omitempty"`
+	Reporter *User   `json:"reporter,omitempty"`
+}
+
+// BatchResult is the outcome of an operation, its status code follows the one
+// of the equivalent single request. The operations not run because another
+// one of a transactional batch failed have the status 424.
+type BatchResult struct {
+	Ref        string     `json:"ref"`
+	Op         string     `json:"op"`
+	StatusCode int        `json:"status_code"`
+	Excuse     *Codexcuse `json:"excuse,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	// Code tells why a forbidden operation was denied
+	Code string `json:"code,omitempty"`
+}
+
+// Apply returns excuse with the fields of the patch
+func (p CodexcusePatch) Apply(excuse Codexcuse) Codexcuse {
+	if p.Title != nil {
+		excuse.Title = *p.Title
+	}
+	if p.Content != nil {
+		excuse.Content = *p.Content
+	}
+	if p.Author != nil {
+		excuse.Author = p.Author
+	}
+	if p.Reporter != nil {
+		excuse.Reporter = p.Reporter
+	}
+	return excuse
+}
+
+// Batch runs operations on the excuses of source in a single pipeline. When
+// transactional is set, either every operation is applied or none: the batch
+// stops at the first failing operation and the excuses are watched so that a
+// concurrent change aborts it. Otherwise the failing operations are skipped.
+// The deletions and patches the actor may not make fail with a 403.
+// It returns whether the changes were committed.
+func (c *RedisStoreCodexcuses) Batch(ctx context.Context, source string, ops []BatchOperation, transactional bool, actor Actor) ([]BatchResult, bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Batch").WithField("key", c.key(source))
+	log.Debugln("source:", source, "operations:", len(ops))
+	if c == nil {
+		return nil, false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	if !transactional {
+		results, events, err := c.runBatch(ctx, c.Client, c.Pipeline(), source, ops, false, actor)
+		if err != nil {
+			return nil, false, err
+		}
+		c.publishBatch(ctx, source, events)
+		return results, true, nil
+	}
+
+	var results []BatchResult
+	var events []Event
+	var committed bool
+	err := c.Watch(func(tx *goRedis.Tx) error {
+		var err error
+		results, events, err = c.runBatch(ctx, tx, tx.TxPipeline(), source, ops, true, actor)
+		if err != nil {
+			return err
+		}
+		committed = events != nil
+		return nil
+	}, c.key(source))
+	if err == goRedis.TxFailedErr {
+		return nil, false, ErrConcurrentChange
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if committed {
+		c.publishBatch(ctx, source, events)
+	}
+	return results, committed, nil
+}
+
+// ErrConcurrentChange is returned when the excuses of a transactional batch
+// have changed while it was running
+var ErrConcurrentChange = errors.New("the excuses changed during the batch")
+
+// runBatch loads the excuses targeted by ops with reader, queues the changes
+// in pipe and executes it. In a transactional batch, nothing is executed if
+// an operation fails and the events are nil.
+func (c *RedisStoreCodexcuses) runBatch(ctx context.Context, reader goRedis.Cmdable, pipe goRedis.Pipeliner, source string, ops []BatchOperation, transactional bool, actor Actor) ([]BatchResult, []Event, error) {
+	log := logger.Get(ctx)
+
+	excuses, err := c.loadBatchTargets(reader, source, ops)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	results := make([]BatchResult, len(ops))
+	events := make([]Event, len(ops))
+	cmds := make([][]goRedis.Cmder, len(ops))
+	failed := false
+	for i, op := range ops {
+		results[i] = BatchResult{Ref: op.Ref, Op: op.Op}
+		if failed {
+			results[i].StatusCode = http.StatusFailedDependency
+			results[i].Error = "not run as another operation failed"
+			continue
+		}
+
+		switch op.Op {
+		case BatchAdd:
+			excuse := *op.Excuse
+			excuse.ID = uuid.New().String()
+			cmds[i], err = c.queueAdd(pipe, source, excuse, now)
+			if err != nil {
+				return nil, nil, err
+			}
+			excuses[excuse.ID] = &excuse
+			results[i].StatusCode = http.StatusCreated
+			results[i].Excuse = &excuse
+			events[i] = Event{Type: EventExcuseCreated, Excuse: &excuse}
+
+		case BatchDelete, BatchPatch:
+			excuse := excuses[op.ID]
+			if excuse == nil {
+				results[i].StatusCode = http.StatusNotFound
+				results[i].Error = "ID not found"
+				failed = transactional
+				continue
+			}
+			patch := op.Patch
+			if op.Op == BatchDelete {
+				patch = nil
+			}
+			if code := actor.Denied(*excuse, patch); code != "" {
+				results[i].StatusCode = http.StatusForbidden
+				results[i].Error = "the acting user may not change this excuse"
+				results[i].Code = code
+				failed = transactional
+				continue
+			}
+
+			if op.Op == BatchDelete {
+				cmds[i], err = c.queueDelete(pipe, source, op.ID, excuse)
+				if err != nil {
+					return nil, nil, err
+				}
+				excuses[op.ID] = nil
+				results[i].StatusCode = http.StatusOK
+				events[i] = Event{Type: EventExcuseDeleted, Excuse: &Codexcuse{ID: op.ID}, before: excuse}
+				continue
+			}
+
+			updated := op.Patch.Apply(*excuse)
+			cmds[i], err = c.queueUpdate(pipe, source, *excuse, updated)
+			if err != nil {
+				return nil, nil, err
+			}
+			excuses[op.ID] = &updated
+			results[i].StatusCode = http.StatusOK
+			results[i].Excuse = &updated
+			events[i] = Event{Type: EventExcuseUpdated, Excuse: &updated, before: excuse}
+		}
+	}
+
+	if failed {
+		// The operations before the failing one are not applied either
+		for i := range results {
+			if results[i].StatusCode < 300 {
+				results[i].StatusCode = http.StatusFailedDependency
+				results[i].Excuse = nil
+				results[i].Error = "not run as another operation failed"
+			}
+		}
+		return results, nil, nil
+	}
+
+	_, err = pipe.Exec()
+	if err == goRedis.TxFailedErr {
+		return nil, nil, err
+	}
+	if err != nil && transactional {
+		return nil, nil, errors.Wrap(err, "fail to execute batch")
+	}
+
+	// In a best-effort batch, an operation fails alone when one of its
+	// commands fails
+	applied := []Event{}
+	for i := range ops {
+		if cmdsErr(cmds[i]) != nil {
+			log.WithError(cmdsErr(cmds[i])).Error("fail to run batch operation " + ops[i].Ref)
+			results[i].StatusCode = http.StatusInternalServerError
+			results[i].Excuse = nil
+			results[i].Error = "Internal error"
+			continue
+		}
+		if events[i].Type != "" {
+			applied = append(applied, events[i])
+		}
+	}
+	return results, applied, nil
+}
+
+// loadBatchTargets gets the excuses deleted or patched by ops, indexed by ID.
+// The missing excuses are nil.
+func (c *RedisStoreCodexcuses) loadBatchTargets(reader goRedis.Cmdable, source string, ops []BatchOperation) (map[string]*Codexcuse, error) {
+	excuses := map[string]*Codexcuse{}
+	ids := []string{}
+	for _, op := range ops {
+		if (op.Op == BatchDelete || op.Op == BatchPatch) && op.ID != "" {
+			if _, ok := excuses[op.ID]; !ok {
+				excuses[op.ID] = nil
+				ids = append(ids, op.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return excuses, nil
+	}
+
+	res := reader.HMGet(c.key(source), ids...)
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get excuses")
+	}
+	for i, v := range res.Val() {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var excuse Codexcuse
+		err := json.Unmarshal([]byte(str), &excuse)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal excuse")
+		}
+		excuses[ids[i]] = &excuse
+	}
+	return excuses, nil
+}
+
+func (c *RedisStoreCodexcuses) publishBatch(ctx context.Context, source string, events []Event) {
+	for _, event := range events {
+		c.publish(ctx, source, event.Type, event.Excuse)
+		switch event.Type {
+		case EventExcuseCreated:
+			auditChange(ctx, AuditExcuseCreated, source, event.Excuse.ID, nil, event.Excuse)
+		case EventExcuseUpdated:
+			auditChange(ctx, AuditExcuseUpdated, source, event.Excuse.ID, event.before, event.Excuse)
+		case EventExcuseDeleted:
+			auditChange(ctx, AuditExcuseDeleted, source, event.Excuse.ID, event.before, nil)
+		}
+	}
+}
+
+func cmdsErr(cmds []goRedis.Cmder) error {
+	for _, cmd := range cmds {
+		if cmd.Err() != nil {
+			return cmd.Err()
+		}
+	}
+	return nil
+}
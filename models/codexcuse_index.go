@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// The titles and the authors of the excuses of a source are indexed in
+// sorted sets with a score of 0, so that ZRANGEBYLEX gives the entries
+// starting with a prefix. A member is the lower-cased name followed by the ID
+// and the original name, separated by NUL bytes.
+const lexSeparator = "\x00"
+
+// Suggestion is an entry of the title or author index of a source
+type Suggestion struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SuggestTitles gives the excuses whose title starts with prefix, case
+// insensitively
+func (c *RedisStoreCodexcuses) SuggestTitles(ctx context.Context, source, prefix string, limit int64) ([]Suggestion, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SuggestTitles").WithField("key", c.titlesKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	err := c.ensureIndexes(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return c.suggest(c.titlesKey(source), prefix, limit)
+}
+
+// SuggestAuthors gives the authors of the excuses whose username starts with
+// prefix, case insensitively. The ID of a suggestion is the user ID.
+func (c *RedisStoreCodexcuses) SuggestAuthors(ctx context.Context, source, prefix string, limit int64) ([]Suggestion, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SuggestAuthors").WithField("key", c.authorsKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	err := c.ensureIndexes(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	return c.suggest(c.authorsKey(source), prefix, limit)
+}
+
+func (c *RedisStoreCodexcuses) suggest(key, prefix string, limit int64) ([]Suggestion, error) {
+	min, max := "-", "+"
+	if prefix != "" {
+		prefix = strings.ToLower(prefix)
+		// No byte of an UTF-8 string is 0xff, every member starting with prefix
+		// sorts before this bound
+		min, max = "["+prefix, "["+prefix+"\xff"
+	}
+
+	res := c.ZRangeByLex(key, goRedis.ZRangeBy{
+		Min:   min,
+		Max:   max,
+		Count: limit,
+	})
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get range of index")
+	}
+
+	suggestions := make([]Suggestion, 0, len(res.Val()))
+	for _, member := range res.Val() {
+		parts := strings.SplitN(member, lexSeparator, 3)
+		if len(parts) != 3 {
+			continue
+		}
+		suggestions = append(suggestions, Suggestion{
+			ID:   parts[1],
+			Name: parts[2],
+		})
+	}
+	return suggestions, nil
+}
+
+// queueIndex queues the commands adding an excuse to the title and author
+// indexes
+func (c *RedisStoreCodexcuses) queueIndex(pipe goRedis.Pipeliner, source string, excuse Codexcuse) []goRedis.Cmder {
+	cmds := []goRedis.Cmder{
+		pipe.ZAdd(c.titlesKey(source), goRedis.Z{Member: lexMember(excuse.Title, excuse.ID)}),
+	}
+	if excuse.Author != nil && excuse.Author.ID != "" {
+		cmds = append(cmds, pipe.ZAdd(c.authorsKey(source), goRedis.Z{Member: lexMember(excuse.Author.UserName, excuse.Author.ID)}))
+	}
+	return cmds
+}
+
+// queueUnindex queues the command removing an excuse from the title index.
+// Its author is kept, the author index only serves suggestions and may list a
+// user without excuses.
+func (c *RedisStoreCodexcuses) queueUnindex(pipe goRedis.Pipeliner, source string, excuse Codexcuse) []goRedis.Cmder {
+	return []goRedis.Cmder{
+		pipe.ZRem(c.titlesKey(source), lexMember(excuse.Title, excuse.ID)),
+	}
+}
+
+// ensureIndexes builds the title and author indexes when the title index
+// does not hold every excuse, as for the sources whose excuses were added
+// before the indexes existed
+func (c *RedisStoreCodexcuses) ensureIndexes(ctx context.Context, source string) error {
+	log := logger.Get(ctx)
+
+	pipe := c.Pipeline()
+	titles := pipe.ZCard(c.titlesKey(source))
+	ids := pipe.ZCard(c.excuseIDKey(source))
+	_, err := pipe.Exec()
+	if err != nil {
+		return errors.Wrap(err, "fail to count indexed excuses")
+	}
+	if titles.Val() == ids.Val() {
+		return nil
+	}
+
+	res := c.HGetAll(c.key(source))
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to get all excuses")
+	}
+	log.Debugln("indexing excuses:", len(res.Val()))
+	pipe = c.Pipeline()
+	for _, v := range res.Val() {
+		var excuse Codexcuse
+		err := json.Unmarshal([]byte(v), &excuse)
+		if err != nil {
+			return errors.Wrap(err, "fail to unmarshal excuse")
+		}
+		c.queueIndex(pipe, source, excuse)
+	}
+	_, err = pipe.Exec()
+	if err != nil {
+		return errors.Wrap(err, "fail to index excuses")
+	}
+	return nil
+}
+
+func lexMember(name, id string) string {
+	return strings.ToLower(name) + lexSeparator + id + lexSeparator + name
+}
+
+func (c *RedisStoreCodexcuses) titlesKey(source string) string {
+	return fmt.Sprintf("%sCodexcuseTitles:source:%s", redis.Prefix(), source)
+}
+
+func (c *RedisStoreCodexcuses) authorsKey(source string) string {
+	return fmt.Sprintf("%sCodexcuseAuthors:source:%s", redis.Prefix(), source)
+}
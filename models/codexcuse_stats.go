@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// CodexcuseStats sums up the excuses of a source
+type CodexcuseStats struct {
+	Excuses      int         `json:"excuses"`
+	Reported     int64       `json:"reported"`
+	Trashed      int64       `json:"trashed"`
+	Authors      int         `json:"authors"`
+	TopAuthors   []UserCount `json:"top_authors"`
+	TopReporters []UserCount `json:"top_reporters"`
+}
+
+type UserCount struct {
+	UserName string `json:"username"`
+	Count    int    `json:"count"`
+}
+
+// topUsers is the length of the rankings of the stats
+const topUsers = 10
+
+// Sources lists the sources having excuses
+func (c *RedisStoreCodexcuses) Sources(ctx context.Context, scanSize int64) ([]string, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Sources")
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	prefix := c.excuseIDKey("")
+	sources := []string{}
+	var cursor uint64
+	for {
+		keys, next, err := c.Scan(cursor, prefix+"*", scanSize).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to scan sources")
+		}
+		for _, key := range keys {
+			sources = append(sources, strings.TrimPrefix(key, prefix))
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(sources)
+	return sources, nil
+}
+
+// Count returns the number of excuses of source
+func (c *RedisStoreCodexcuses) Count(ctx context.Context, source string) (int64, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Count").WithField("key", c.excuseIDKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return 0, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.ZCard(c.excuseIDKey(source))
+	if res.Err() != nil {
+		return 0, errors.Wrap(res.Err(), "fail to count excuses")
+	}
+	return res.Val(), nil
+}
+
+// CountBySource returns the number of excuses of each source having some
+func (c *RedisStoreCodexcuses) CountBySource(ctx context.Context, scanSize int64) (map[string]int64, error) {
+	sources, err := c.Sources(ctx, scanSize)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := c.Pipeline()
+	cmds := make([]*goRedis.IntCmd, len(sources))
+	for i, source := range sources {
+		cmds[i] = pipe.ZCard(c.excuseIDKey(source))
+	}
+	if len(sources) > 0 {
+		_, err = pipe.Exec()
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to count excuses")
+		}
+	}
+
+	counts := make(map[string]int64, len(sources))
+	for i, source := range sources {
+		counts[source] = cmds[i].Val()
+	}
+	return counts, nil
+}
+
+// Stats counts the excuses of source, and ranks their authors and reporters
+func (c *RedisStoreCodexcuses) Stats(ctx context.Context, source string) (CodexcuseStats, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Stats").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	stats := CodexcuseStats{}
+	if c == nil {
+		return stats, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	pipe := c.Pipeline()
+	all := pipe.HGetAll(c.key(source))
+	reported := pipe.ZCard(c.reportedKey(source))
+	trashed := pipe.ZCard(c.trashIDKey(source))
+	_, err := pipe.Exec()
+	if err != nil {
+		return stats, errors.Wrap(err, "fail to get stats")
+	}
+
+	authors := map[string]int{}
+	reporters := map[string]int{}
+	for _, v := range all.Val() {
+		var excuse Codexcuse
+		json.Unmarshal([]byte(v), &excuse)
+		if excuse.Author != nil {
+			authors[excuse.Author.UserName]++
+		}
+		if excuse.Reporter != nil {
+			reporters[excuse.Reporter.UserName]++
+		}
+	}
+
+	stats.Excuses = len(all.Val())
+	stats.Reported = reported.Val()
+	stats.Trashed = trashed.Val()
+	stats.Authors = len(authors)
+	stats.TopAuthors = rankUsers(authors)
+	stats.TopReporters = rankUsers(reporters)
+	return stats, nil
+}
+
+func rankUsers(counts map[string]int) []UserCount {
+	ranking := make([]UserCount, 0, len(counts))
+	for name, count := range counts {
+		ranking = append(ranking, UserCount{UserName: name, Count: count})
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		if ranking[i].Count != ranking[j].Count {
+			return ranking[i].Count > ranking[j].Count
+		}
+		return ranking[i].UserName < ranking[j].UserName
+	})
+	if len(ranking) > topUsers {
+		ranking = ranking[:topUsers]
+	}
+	return ranking
+}
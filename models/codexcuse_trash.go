@@ -0,0 +1,190 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// TrashRetention is the time a deleted excuse can be restored, it is purged
+// afterwards
+var TrashRetention = 30 * 24 * time.Hour
+
+// TrashedExcuse is an excuse in the trash of a source
+type TrashedExcuse struct {
+	Codexcuse
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Trash lists the deleted excuses of source, most recently deleted first,
+// after purging the ones older than TrashRetention
+func (c *RedisStoreCodexcuses) Trash(ctx context.Context, source string, limit int64) ([]TrashedExcuse, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Trash").WithField("key", c.trashKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	err := c.purgeExpired(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	res := c.ZRevRangeWithScores(c.trashIDKey(source), 0, limit-1)
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get range of trash")
+	}
+	if len(res.Val()) == 0 {
+		return []TrashedExcuse{}, nil
+	}
+
+	ids := make([]string, 0, len(res.Val()))
+	for _, z := range res.Val() {
+		ids = append(ids, z.Member.(string))
+	}
+	excuses := c.HMGet(c.trashKey(source), ids...)
+	if excuses.Err() != nil {
+		return nil, errors.Wrap(excuses.Err(), "fail to get trashed excuses")
+	}
+
+	trashed := make([]TrashedExcuse, 0, len(ids))
+	for i, v := range excuses.Val() {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		var excuse TrashedExcuse
+		err := json.Unmarshal([]byte(str), &excuse.Codexcuse)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal excuse")
+		}
+		excuse.DeletedAt = fromMillis(int64(res.Val()[i].Score))
+		trashed = append(trashed, excuse)
+	}
+	return trashed, nil
+}
+
+// Restore moves an excuse back from the trash, it returns false if it is not
+// in the trash. The restored excuse sorts as a new one.
+func (c *RedisStoreCodexcuses) Restore(ctx context.Context, source, id string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Restore").WithField("key", c.trashKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.trashKey(source), id)
+	if res.Err() == goRedis.Nil {
+		return false, nil
+	}
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to get trashed excuse: "+id)
+	}
+	var excuse Codexcuse
+	err := json.Unmarshal([]byte(res.Val()), &excuse)
+	if err != nil {
+		return false, errors.Wrap(err, "fail to unmarshal excuse")
+	}
+
+	pipe := c.TxPipeline()
+	_, err = c.queueAdd(pipe, source, excuse, time.Now())
+	if err != nil {
+		return false, err
+	}
+	pipe.HDel(c.trashKey(source), id)
+	pipe.ZRem(c.trashIDKey(source), id)
+	_, err = pipe.Exec()
+	if err != nil {
+		return false, errors.Wrap(err, "fail to restore excuse: "+id)
+	}
+
+	c.publish(ctx, source, EventExcuseCreated, &excuse)
+	auditChange(ctx, AuditExcuseRestored, source, id, nil, &excuse)
+	return true, nil
+}
+
+// Purge deletes an excuse of the trash for good, it returns false if it is
+// not in the trash
+func (c *RedisStoreCodexcuses) Purge(ctx context.Context, source, id string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Purge").WithField("key", c.trashKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	pipe := c.TxPipeline()
+	del := c.queuePurge(pipe, source, id)
+	_, err := pipe.Exec()
+	if err != nil {
+		return false, errors.Wrap(err, "fail to purge excuse: "+id)
+	}
+	if del.Val() != 1 {
+		return false, nil
+	}
+	auditChange(ctx, AuditExcusePurged, source, id, nil, nil)
+	return true, nil
+}
+
+func (c *RedisStoreCodexcuses) purgeExpired(ctx context.Context, source string) error {
+	log := logger.Get(ctx)
+
+	cutoff := toMillis(time.Now().Add(-TrashRetention))
+	res := c.ZRangeByScore(c.trashIDKey(source), goRedis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(cutoff, 10),
+	})
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to get expired trashed excuses")
+	}
+	if len(res.Val()) == 0 {
+		return nil
+	}
+
+	log.Debugln("purging expired excuses:", len(res.Val()))
+	pipe := c.TxPipeline()
+	for _, id := range res.Val() {
+		c.queuePurge(pipe, source, id)
+	}
+	_, err := pipe.Exec()
+	if err != nil {
+		return errors.Wrap(err, "fail to purge expired excuses")
+	}
+	return nil
+}
+
+// queuePurge queues the commands deleting an excuse of the trash and its
+// upvotes, the returned command counts the deleted excuses
+func (c *RedisStoreCodexcuses) queuePurge(pipe goRedis.Pipeliner, source, id string) *goRedis.IntCmd {
+	del := pipe.HDel(c.trashKey(source), id)
+	pipe.ZRem(c.trashIDKey(source), id)
+	pipe.Del(c.upvotesKey(source, id))
+	return del
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func (c *RedisStoreCodexcuses) trashKey(source string) string {
+	return fmt.Sprintf("%sCodexcuseTrash:source:%s", redis.Prefix(), source)
+}
+
+func (c *RedisStoreCodexcuses) trashIDKey(source string) string {
+	return fmt.Sprintf("%sCodexcuseTrashIDs:source:%s", redis.Prefix(), source)
+}
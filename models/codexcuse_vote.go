@@ -0,0 +1,171 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// Upvote records the upvote of a user for an excuse, a user only counts once.
+// It returns the number of upvotes of the excuse.
+func (c *RedisStoreCodexcuses) Upvote(ctx context.Context, source, id, userID string) (int64, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Upvote").WithField("key", c.upvotesKey(source, id))
+	log.Debugln("source:", source)
+	if c == nil {
+		return 0, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SAdd(c.upvotesKey(source, id), userID)
+	if res.Err() != nil {
+		return 0, errors.Wrap(res.Err(), "fail to upvote excuse: "+id)
+	}
+	return c.Upvotes(ctx, source, id)
+}
+
+// Upvotes gives the number of upvotes of an excuse
+func (c *RedisStoreCodexcuses) Upvotes(ctx context.Context, source, id string) (int64, error) {
+	if c == nil {
+		return 0, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SCard(c.upvotesKey(source, id))
+	if res.Err() != nil {
+		return 0, errors.Wrap(res.Err(), "fail to count upvotes of excuse: "+id)
+	}
+	return res.Val(), nil
+}
+
+// Report records the report of an excuse by a user. The first report of each
+// user publishes an excuse.reported event, it returns false for the next
+// ones.
+func (c *RedisStoreCodexcuses) Report(ctx context.Context, source string, excuse *Codexcuse, userID string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Report").WithField("key", c.reportsKey(source, excuse.ID))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	pipe := c.TxPipeline()
+	res := pipe.SAdd(c.reportsKey(source, excuse.ID), userID)
+	// The moderation queue keeps the time of the first report
+	pipe.ZAddNX(c.reportedKey(source), goRedis.Z{
+		Score:  float64(toMillis(time.Now())),
+		Member: excuse.ID,
+	})
+	_, err := pipe.Exec()
+	if err != nil {
+		return false, errors.Wrap(err, "fail to report excuse: "+excuse.ID)
+	}
+	if res.Val() == 0 {
+		return false, nil
+	}
+
+	c.publish(ctx, source, EventExcuseReported, excuse)
+	return true, nil
+}
+
+// ReportedExcuse is an excuse of the moderation queue of a source
+type ReportedExcuse struct {
+	Codexcuse
+	Reports    int64     `json:"reports"`
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// Reported lists the reported excuses of source, the oldest report first
+func (c *RedisStoreCodexcuses) Reported(ctx context.Context, source string, limit int64) ([]ReportedExcuse, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Reported").WithField("key", c.reportedKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.ZRangeWithScores(c.reportedKey(source), 0, limit-1)
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get range of reported excuses")
+	}
+	if len(res.Val()) == 0 {
+		return []ReportedExcuse{}, nil
+	}
+
+	ids := make([]string, 0, len(res.Val()))
+	pipe := c.Pipeline()
+	counts := make([]*goRedis.IntCmd, 0, len(res.Val()))
+	for _, z := range res.Val() {
+		id := z.Member.(string)
+		ids = append(ids, id)
+		counts = append(counts, pipe.SCard(c.reportsKey(source, id)))
+	}
+	excuses := pipe.HMGet(c.key(source), ids...)
+	_, err := pipe.Exec()
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to get reported excuses")
+	}
+
+	reported := make([]ReportedExcuse, 0, len(ids))
+	for i, v := range excuses.Val() {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		excuse := ReportedExcuse{
+			Reports:    counts[i].Val(),
+			ReportedAt: fromMillis(int64(res.Val()[i].Score)),
+		}
+		err := json.Unmarshal([]byte(str), &excuse.Codexcuse)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal excuse")
+		}
+		reported = append(reported, excuse)
+	}
+	return reported, nil
+}
+
+// DismissReports removes an excuse from the moderation queue, it returns false
+// if it was not reported
+func (c *RedisStoreCodexcuses) DismissReports(ctx context.Context, source, id string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DismissReports").WithField("key", c.reportedKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	pipe := c.TxPipeline()
+	rem := pipe.ZRem(c.reportedKey(source), id)
+	pipe.Del(c.reportsKey(source, id))
+	_, err := pipe.Exec()
+	if err != nil {
+		return false, errors.Wrap(err, "fail to dismiss reports of excuse: "+id)
+	}
+	return rem.Val() == 1, nil
+}
+
+func (c *RedisStoreCodexcuses) upvotesKey(source, id string) string {
+	return fmt.Sprintf("%sCodexcuseUpvotes:source:%s:%s", redis.Prefix(), source, id)
+}
+
+func (c *RedisStoreCodexcuses) reportsKey(source, id string) string {
+	return fmt.Sprintf("%sCodexcuseReports:source:%s:%s", redis.Prefix(), source, id)
+}
+
+func (c *RedisStoreCodexcuses) reportedKey(source string) string {
+	return fmt.Sprintf("%sCodexcuseReported:source:%s", redis.Prefix(), source)
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	DeliveryIncoming = "incoming"
+	DeliveryOutgoing = "outgoing"
+)
+
+// DeliveryLogEntry is an incoming hook or an outgoing delivery attempt, as
+// recorded in the delivery log of a source
+type DeliveryLogEntry struct {
+	ID         string      `json:"id"`
+	Direction  string      `json:"direction"`
+	Provider   string      `json:"provider,omitempty"`
+	EventID    string      `json:"event_id,omitempty"`
+	DeliveryID string      `json:"delivery_id,omitempty"`
+	WebhookID  string      `json:"webhook_id,omitempty"`
+	RuleID     string      `json:"rule_id,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Headers    http.Header `json:"headers,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	StatusCode int         `json:"status_code"`
+	LatencyMs  int64       `json:"latency_ms"`
+	Error      string      `json:"error,omitempty"`
+	At         time.Time   `json:"at"`
+}
+
+// DeliveryLogFilter selects entries of the delivery log, an empty field
+// matches any value. Status is either a code such as "404", a class such as
+// "5xx", or "error" for the entries without answer.
+type DeliveryLogFilter struct {
+	Direction  string
+	Provider   string
+	EventID    string
+	DeliveryID string
+	Status     string
+	Since      time.Time
+	Until      time.Time
+	// Before is the ID of the last entry of the previous page
+	Before string
+	Limit  int
+}
+
+type RedisStoreDeliveryLog struct {
+	*goRedis.Client
+}
+
+var (
+	// DeliveryLogMaxLength caps, approximately, the entries kept per source
+	DeliveryLogMaxLength int64 = 10000
+
+	// DeliveryLogMaxBodySize is the size after which the recorded bodies are
+	// truncated
+	DeliveryLogMaxBodySize = 64 * 1024
+
+	// redactedHeaders are never recorded as they carry credentials
+	redactedHeaders = []string{"Authorization", "Cookie", "X-Gitlab-Token"}
+)
+
+// Record appends an entry to the delivery log of source
+func (c *RedisStoreDeliveryLog) Record(ctx context.Context, source string, entry DeliveryLogEntry) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Record").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	headers := entry.Headers.Clone()
+	for _, h := range redactedHeaders {
+		if headers != nil && headers.Get(h) != "" {
+			headers.Set(h, "[redacted]")
+		}
+	}
+	headersBytes, err := json.Marshal(headers)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal headers")
+	}
+
+	body := entry.Body
+	if len(body) > DeliveryLogMaxBodySize {
+		body = body[:DeliveryLogMaxBodySize]
+	}
+	if entry.At.IsZero() {
+		entry.At = time.Now()
+	}
+
+	res := c.XAdd(&goRedis.XAddArgs{
+		Stream:       c.key(source),
+		MaxLenApprox: DeliveryLogMaxLength,
+		Values: map[string]interface{}{
+			"direction":   entry.Direction,
+			"provider":    entry.Provider,
+			"event_id":    entry.EventID,
+			"delivery_id": entry.DeliveryID,
+			"webhook_id":  entry.WebhookID,
+			"rule_id":     entry.RuleID,
+			"url":         entry.URL,
+			"headers":     string(headersBytes),
+			"body":        body,
+			"status_code": entry.StatusCode,
+			"latency_ms":  entry.LatencyMs,
+			"error":       entry.Error,
+			"at":          entry.At.UTC().Format(time.RFC3339Nano),
+		},
+	})
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to add delivery log entry")
+	}
+	return nil
+}
+
+// Get returns the entry with some ID, nil if it is not in the log anymore
+func (c *RedisStoreDeliveryLog) Get(ctx context.Context, source, id string) (*DeliveryLogEntry, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Get").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+	if _, _, ok := parseStreamID(id); !ok {
+		return nil, nil
+	}
+
+	res := c.XRange(c.key(source), id, id)
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get delivery log entry: "+id)
+	}
+	if len(res.Val()) == 0 {
+		return nil, nil
+	}
+	entry := toDeliveryLogEntry(res.Val()[0])
+	return &entry, nil
+}
+
+// List returns the entries matching filter, newest first
+func (c *RedisStoreDeliveryLog) List(ctx context.Context, source string, filter DeliveryLogFilter) ([]DeliveryLogEntry, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "List").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	end := "+"
+	if !filter.Until.IsZero() {
+		end = strconv.FormatInt(toMillis(filter.Until), 10)
+	}
+	if filter.Before != "" {
+		prev, ok := prevStreamID(filter.Before)
+		if !ok {
+			return nil, fmt.Errorf("invalid cursor '%s'", filter.Before)
+		}
+		end = prev
+	}
+	start := "-"
+	if !filter.Since.IsZero() {
+		start = strconv.FormatInt(toMillis(filter.Since), 10)
+	}
+
+	// The log is scanned by batches until the page is full, as most entries
+	// may be filtered out
+	batchSize := int64(filter.Limit) * 4
+	entries := []DeliveryLogEntry{}
+	for len(entries) < filter.Limit {
+		res := c.XRevRangeN(c.key(source), end, start, batchSize)
+		if res.Err() != nil {
+			return nil, errors.Wrap(res.Err(), "fail to get range of delivery log")
+		}
+		for _, msg := range res.Val() {
+			entry := toDeliveryLogEntry(msg)
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+				if len(entries) == filter.Limit {
+					break
+				}
+			}
+		}
+		if int64(len(res.Val())) < batchSize {
+			break
+		}
+
+		var ok bool
+		end, ok = prevStreamID(res.Val()[len(res.Val())-1].ID)
+		if !ok {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (f DeliveryLogFilter) matches(entry DeliveryLogEntry) bool {
+	if f.Direction != "" && f.Direction != entry.Direction {
+		return false
+	}
+	if f.Provider != "" && f.Provider != entry.Provider {
+		return false
+	}
+	if f.EventID != "" && f.EventID != entry.EventID {
+		return false
+	}
+	if f.DeliveryID != "" && f.DeliveryID != entry.DeliveryID {
+		return false
+	}
+	switch {
+	case f.Status == "":
+	case f.Status == "error":
+		if entry.Error == "" {
+			return false
+		}
+	case len(f.Status) == 3 && strings.HasSuffix(f.Status, "xx"):
+		if entry.StatusCode/100 != int(f.Status[0]-'0') {
+			return false
+		}
+	default:
+		if f.Status != strconv.Itoa(entry.StatusCode) {
+			return false
+		}
+	}
+	return true
+}
+
+func toDeliveryLogEntry(msg goRedis.XMessage) DeliveryLogEntry {
+	value := func(field string) string {
+		v, _ := msg.Values[field].(string)
+		return v
+	}
+
+	entry := DeliveryLogEntry{
+		ID:         msg.ID,
+		Direction:  value("direction"),
+		Provider:   value("provider"),
+		EventID:    value("event_id"),
+		DeliveryID: value("delivery_id"),
+		WebhookID:  value("webhook_id"),
+		RuleID:     value("rule_id"),
+		URL:        value("url"),
+		Body:       value("body"),
+		Error:      value("error"),
+	}
+	entry.StatusCode, _ = strconv.Atoi(value("status_code"))
+	entry.LatencyMs, _ = strconv.ParseInt(value("latency_ms"), 10, 64)
+	entry.At, _ = time.Parse(time.RFC3339Nano, value("at"))
+	json.Unmarshal([]byte(value("headers")), &entry.Headers)
+	return entry
+}
+
+func parseStreamID(id string) (ms uint64, seq uint64, ok bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err = strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return ms, seq, true
+}
+
+// prevStreamID returns the greatest stream ID lower than id, XREVRANGE has no
+// exclusive bound in the redis versions we support
+func prevStreamID(id string) (string, bool) {
+	ms, seq, ok := parseStreamID(id)
+	if !ok || (ms == 0 && seq == 0) {
+		return "", false
+	}
+	if seq > 0 {
+		return fmt.Sprintf("%d-%d", ms, seq-1), true
+	}
+	return fmt.Sprintf("%d-%d", ms-1, uint64(1<<64-1)), true
+}
+
+func (c *RedisStoreDeliveryLog) key(source string) string {
+	return fmt.Sprintf("%sHookDeliveryLog:source:%s", redis.Prefix(), source)
+}
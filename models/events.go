@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+)
+
+const (
+	EventExcuseCreated = "excuse.created"
+	EventExcuseDeleted = "excuse.deleted"
+	EventExcuseUpdated = "excuse.updated"
+	// EventExcuseReported is published the first time a user reports an excuse
+	EventExcuseReported = "excuse.reported"
+)
+
+// Event is published on the source's events channel every time an excuse of
+// this source changes
+type Event struct {
+	Type   string     `json:"type"`
+	Source string     `json:"source"`
+	Excuse *Codexcuse `json:"excuse"`
+
+	// before is the excuse before an update or a deletion, for the audit log
+	before *Codexcuse
+}
+
+// EventsChannel is the redis Pub/Sub channel on which the events of source are
+// published
+func EventsChannel(source string) string {
+	return fmt.Sprintf("%sCodexcuseEvents:source:%s", redis.Prefix(), source)
+}
+
+// publish broadcasts an event to the subscribers of source and queues its
+// webhook deliveries. A failure is only logged: the excuse has already been
+// saved at this point.
+func (c *RedisStoreCodexcuses) publish(ctx context.Context, source, eventType string, excuse *Codexcuse) {
+	log := logger.Get(ctx)
+
+	event := Event{
+		Type:   eventType,
+		Source: source,
+		Excuse: excuse,
+	}
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("fail to marshal event")
+		return
+	}
+
+	res := c.Publish(EventsChannel(source), string(bytes))
+	if res.Err() != nil {
+		log.WithError(res.Err()).Error("fail to publish event " + eventType)
+	}
+
+	webhooks := &RedisStoreWebhooks{c.Client}
+	err = webhooks.Enqueue(ctx, source, eventType, bytes)
+	if err != nil {
+		log.WithError(err).Error("fail to enqueue webhook deliveries of event " + eventType)
+	}
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goRedis "github.com/go-redis/redis"
+)
+
+// newTestRedis returns a client of a Redis server stopped at the end of the
+// test
+func newTestRedis(t *testing.T) *goRedis.Client {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := goRedis.NewClient(&goRedis.Options{Addr: server.Addr(), MaxRetries: 0})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func TestDeletePublishesOnlyExistingExcuses(t *testing.T) {
+	client := newTestRedis(t)
+	excuses := &RedisStoreCodexcuses{Client: client}
+	webhooks := &RedisStoreWebhooks{Client: client}
+	ctx := context.Background()
+
+	err := webhooks.Add(ctx, "guild", &Webhook{URL: "https://example.com", Secret: "s3cr3t", Events: []string{EventExcuseDeleted}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubsub := client.Subscribe(EventsChannel("guild"))
+	defer pubsub.Close()
+	_, err = pubsub.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = excuses.Delete(ctx, "guild", "unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err := webhooks.Claim(ctx, time.Minute)
+	if err != nil || delivery != nil {
+		t.Fatalf("got the delivery %+v, %v for an unknown excuse, want none", delivery, err)
+	}
+	if msg, err := pubsub.ReceiveTimeout(50 * time.Millisecond); err == nil {
+		t.Fatalf("got the message %v for an unknown excuse, want none", msg)
+	}
+
+	excuse := Codexcuse{Title: "t", Content: "c", Author: &User{UserName: "a"}, Reporter: &User{ID: "1", UserName: "r"}}
+	err = excuses.Add(ctx, "guild", &excuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = excuses.Delete(ctx, "guild", excuse.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery, err = webhooks.Claim(ctx, time.Minute)
+	if err != nil || delivery == nil || delivery.Event != EventExcuseDeleted {
+		t.Fatalf("got the delivery %+v, %v, want the one of the deletion", delivery, err)
+	}
+}
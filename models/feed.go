@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// FeedConfig sets who can read the feeds of a source: anyone when it is
+// public, otherwise the readers having its token
+type FeedConfig struct {
+	Public    bool      `json:"public"`
+	Token     string    `json:"token,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreatedExcuse is an excuse with the time it was added
+type CreatedExcuse struct {
+	Codexcuse
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RedisStoreFeeds struct {
+	*goRedis.Client
+}
+
+// GetConfig returns the feed configuration of source, nil if its feeds are
+// disabled
+func (c *RedisStoreFeeds) GetConfig(ctx context.Context, source string) (*FeedConfig, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetConfig").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Get(c.key(source))
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get feed config")
+	}
+
+	var config FeedConfig
+	err := json.Unmarshal([]byte(res.Val()), &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal feed config")
+	}
+	return &config, nil
+}
+
+func (c *RedisStoreFeeds) SetConfig(ctx context.Context, source string, config *FeedConfig) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SetConfig").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	config.UpdatedAt = time.Now().UTC()
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal feed config")
+	}
+
+	res := c.Set(c.key(source), bytes, 0)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set feed config")
+	}
+	return nil
+}
+
+// DeleteConfig disables the feeds of source, it returns false if they were
+// not enabled
+func (c *RedisStoreFeeds) DeleteConfig(ctx context.Context, source string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteConfig").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Del(c.key(source))
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to delete feed config")
+	}
+	return res.Val() == 1, nil
+}
+
+func (c *RedisStoreFeeds) key(source string) string {
+	return fmt.Sprintf("%sFeedConfig:source:%s", redis.Prefix(), source)
+}
+
+// Latest returns the newest excuses of source, the most recent first
+func (c *RedisStoreCodexcuses) Latest(ctx context.Context, source string, limit int64) ([]CreatedExcuse, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Latest").WithField("key", c.excuseIDKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.ZRevRangeWithScores(c.excuseIDKey(source), 0, limit-1)
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get range of IDs")
+	}
+	if len(res.Val()) == 0 {
+		return []CreatedExcuse{}, nil
+	}
+
+	ids := make([]string, 0, len(res.Val()))
+	for _, z := range res.Val() {
+		ids = append(ids, z.Member.(string))
+	}
+	excuses := c.HMGet(c.key(source), ids...)
+	if excuses.Err() != nil {
+		return nil, errors.Wrap(excuses.Err(), "fail to get latest excuses")
+	}
+
+	latest := make([]CreatedExcuse, 0, len(ids))
+	for i, v := range excuses.Val() {
+		str, ok := v.(string)
+		if !ok {
+			continue
+		}
+		excuse := CreatedExcuse{
+			CreatedAt: fromMillis(int64(res.Val()[i].Score)),
+		}
+		err := json.Unmarshal([]byte(str), &excuse.Codexcuse)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal excuse")
+		}
+		latest = append(latest, excuse)
+	}
+	return latest, nil
+}
@@ -0,0 +1,239 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	HookProviderGitHub    = "github"
+	HookProviderDockerHub = "dockerhub"
+	HookProviderGitLab    = "gitlab"
+	HookProviderGitea     = "gitea"
+
+	HookTypePush        = "push"
+	HookTypePullRequest = "pull_request"
+	HookTypeIssue       = "issue"
+	HookTypeRelease     = "release"
+	HookTypeTag         = "tag"
+	HookTypePipeline    = "pipeline"
+
+	// EventHookReceived is the webhook event type under which the incoming
+	// hooks of a source are forwarded
+	EventHookReceived = "hook.received"
+)
+
+// HookEvent is an incoming hook from any provider, normalised so that routing
+// and formatting do not depend on the provider
+type HookEvent struct {
+	ID         string       `json:"id"`
+	Provider   string       `json:"provider"`
+	Type       string       `json:"type"`
+	Action     string       `json:"action,omitempty"`
+	Repository string       `json:"repository"`
+	Branch     string       `json:"branch,omitempty"`
+	Tag        string       `json:"tag,omitempty"`
+	Actor      string       `json:"actor"`
+	Title      string       `json:"title,omitempty"`
+	Status     string       `json:"status,omitempty"`
+	URL        string       `json:"url,omitempty"`
+	Commits    []HookCommit `json:"commits,omitempty"`
+	ReceivedAt time.Time    `json:"received_at"`
+}
+
+// HookCommit is a commit of a push event
+type HookCommit struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+	Author  string `json:"author"`
+	URL     string `json:"url,omitempty"`
+}
+
+// HookConfig holds the secret used to authenticate the hooks of a provider
+// for a source. Callback enables the report of the processing state to the
+// providers supporting it.
+type HookConfig struct {
+	Provider  string    `json:"provider"`
+	Secret    string    `json:"secret,omitempty"`
+	Callback  bool      `json:"callback"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type RedisStoreHooks struct {
+	*goRedis.Client
+}
+
+var (
+	// HookEventsMaxLength caps the number of incoming hooks kept per source
+	HookEventsMaxLength int64 = 1000
+)
+
+// GetConfig returns the configuration of provider for source, nil if the source
+// does not accept hooks from this provider
+func (c *RedisStoreHooks) GetConfig(ctx context.Context, source, provider string) (*HookConfig, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetConfig").WithField("key", c.configKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.configKey(source), provider)
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get hook config: "+provider)
+	}
+
+	var config HookConfig
+	err := json.Unmarshal([]byte(res.Val()), &config)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal hook config")
+	}
+	return &config, nil
+}
+
+func (c *RedisStoreHooks) SetConfig(ctx context.Context, source string, config *HookConfig) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SetConfig").WithField("key", c.configKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	config.CreatedAt = time.Now().UTC()
+	bytes, err := json.Marshal(config)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal hook config")
+	}
+
+	res := c.HSet(c.configKey(source), config.Provider, bytes)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set hook config")
+	}
+	return nil
+}
+
+// DeleteConfig stops accepting the hooks of provider, it returns false if
+// they were not accepted
+func (c *RedisStoreHooks) DeleteConfig(ctx context.Context, source, provider string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteConfig").WithField("key", c.configKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HDel(c.configKey(source), provider)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to delete hook config: "+provider)
+	}
+	return res.Val() == 1, nil
+}
+
+// Record saves an incoming hook. It returns false when an event with the same
+// ID was already recorded, so that redelivered hooks are processed once.
+func (c *RedisStoreHooks) Record(ctx context.Context, source string, event HookEvent) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Record").WithField("key", c.eventsKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	bytes, err := json.Marshal(event)
+	if err != nil {
+		return false, errors.Wrap(err, "fail to marshal hook event")
+	}
+
+	res := c.HSetNX(c.eventsKey(source), event.ID, bytes)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to set hook event")
+	}
+	if !res.Val() {
+		return false, nil
+	}
+
+	zAddRes := c.ZAdd(c.eventIDsKey(source), goRedis.Z{
+		Score:  float64(toMillis(event.ReceivedAt)),
+		Member: event.ID,
+	})
+	if zAddRes.Err() != nil {
+		return false, errors.Wrap(zAddRes.Err(), "fail to ZADD the ID of hook event")
+	}
+
+	// Only keep the newest events
+	rangeRes := c.ZRange(c.eventIDsKey(source), 0, -HookEventsMaxLength-1)
+	if rangeRes.Err() != nil {
+		return false, errors.Wrap(rangeRes.Err(), "fail to get range of old IDs")
+	}
+	if len(rangeRes.Val()) > 0 {
+		c.HDel(c.eventsKey(source), rangeRes.Val()...)
+		c.ZRem(c.eventIDsKey(source), toInterfaces(rangeRes.Val())...)
+	}
+
+	return true, nil
+}
+
+// Get returns the recorded hook with some ID, nil if it is not kept anymore
+func (c *RedisStoreHooks) Get(ctx context.Context, source, id string) (*HookEvent, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Get").WithField("key", c.eventsKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.eventsKey(source), id)
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get hook event: "+id)
+	}
+
+	var event HookEvent
+	err := json.Unmarshal([]byte(res.Val()), &event)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal hook event")
+	}
+	return &event, nil
+}
+
+func (c *RedisStoreHooks) configKey(source string) string {
+	return fmt.Sprintf("%sHookConfigs:source:%s", redis.Prefix(), source)
+}
+
+func (c *RedisStoreHooks) eventsKey(source string) string {
+	return fmt.Sprintf("%sHookEvents:source:%s", redis.Prefix(), source)
+}
+
+func (c *RedisStoreHooks) eventIDsKey(source string) string {
+	return fmt.Sprintf("%sHookEventIDs:source:%s", redis.Prefix(), source)
+}
+
+func toInterfaces(values []string) []interface{} {
+	res := make([]interface{}, len(values))
+	for i, v := range values {
+		res[i] = v
+	}
+	return res
+}
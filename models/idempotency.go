@@ -0,0 +1,122 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// IdempotentRequest is the request made with an idempotency key. It is
+// pending until the response is saved.
+type IdempotentRequest struct {
+	Fingerprint string      `json:"fingerprint"`
+	Pending     bool        `json:"pending,omitempty"`
+	StatusCode  int         `json:"status_code,omitempty"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+type RedisStoreIdempotency struct {
+	*goRedis.Client
+}
+
+// Begin reserves an idempotency key of a client for the request with some
+// fingerprint, for lockTTL. When the key is already taken, it returns the
+// request of the key and false.
+func (c *RedisStoreIdempotency) Begin(ctx context.Context, client, key, fingerprint string, lockTTL time.Duration) (*IdempotentRequest, bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Begin").WithField("key", c.key(client, key))
+	if c == nil {
+		return nil, false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	pending, err := json.Marshal(IdempotentRequest{
+		Fingerprint: fingerprint,
+		Pending:     true,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, false, errors.Wrap(err, "fail to marshal idempotent request")
+	}
+
+	// The existing request may expire between SETNX and GET, then the key is
+	// tried again
+	for i := 0; i < 3; i++ {
+		set := c.SetNX(c.key(client, key), pending, lockTTL)
+		if set.Err() != nil {
+			return nil, false, errors.Wrap(set.Err(), "fail to reserve idempotency key")
+		}
+		if set.Val() {
+			return nil, true, nil
+		}
+
+		res := c.Get(c.key(client, key))
+		if res.Err() == goRedis.Nil {
+			continue
+		}
+		if res.Err() != nil {
+			return nil, false, errors.Wrap(res.Err(), "fail to get idempotent request")
+		}
+		var request IdempotentRequest
+		err = json.Unmarshal([]byte(res.Val()), &request)
+		if err != nil {
+			return nil, false, errors.Wrap(err, "fail to unmarshal idempotent request")
+		}
+		return &request, false, nil
+	}
+	return nil, false, errors.New("fail to reserve idempotency key")
+}
+
+// Save stores the response of the request of an idempotency key, it is
+// replayed for ttl
+func (c *RedisStoreIdempotency) Save(ctx context.Context, client, key string, request IdempotentRequest, ttl time.Duration) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Save").WithField("key", c.key(client, key))
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	request.Pending = false
+	bytes, err := json.Marshal(request)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal idempotent request")
+	}
+	res := c.Set(c.key(client, key), bytes, ttl)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to save idempotent request")
+	}
+	return nil
+}
+
+// Release frees an idempotency key, so that the request can be retried
+func (c *RedisStoreIdempotency) Release(ctx context.Context, client, key string) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Release").WithField("key", c.key(client, key))
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Del(c.key(client, key))
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to release idempotency key")
+	}
+	return nil
+}
+
+func (c *RedisStoreIdempotency) key(client, key string) string {
+	return fmt.Sprintf("%sIdempotencyKeys:client:%s:%s", redis.Prefix(), client, key)
+}
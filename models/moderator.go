@@ -0,0 +1,149 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// Codes of the changes of excuses denied to an actor
+const (
+	DeniedNotOwner    = "not_excuse_owner"
+	DeniedOwnerChange = "owner_change_requires_moderator"
+)
+
+// Actor is the user on behalf of whom the excuses are changed. A moderator
+// changes any excuse of the source, other users only the excuses they wrote
+// or reported.
+type Actor struct {
+	UserID    string
+	Moderator bool
+}
+
+// Denied returns why the actor may not apply patch to excuse, a nil patch
+// being a deletion. It returns an empty string when the change is allowed.
+func (a Actor) Denied(excuse Codexcuse, patch *CodexcusePatch) string {
+	if a.Moderator {
+		return ""
+	}
+	if !a.owns(excuse) {
+		return DeniedNotOwner
+	}
+	if patch != nil && (patch.Author != nil || patch.Reporter != nil) {
+		return DeniedOwnerChange
+	}
+	return ""
+}
+
+func (a Actor) owns(excuse Codexcuse) bool {
+	if a.UserID == "" {
+		return false
+	}
+	return (excuse.Author != nil && excuse.Author.ID == a.UserID) ||
+		(excuse.Reporter != nil && excuse.Reporter.ID == a.UserID)
+}
+
+type actingUserContextKey struct{}
+
+// WithActingUser returns a copy of ctx holding the ID of the Discord user on
+// behalf of whom the API client makes the request
+func WithActingUser(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, actingUserContextKey{}, userID)
+}
+
+// ActingUserFromContext returns the ID of the acting user, empty if the client
+// acts on its own behalf
+func ActingUserFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(actingUserContextKey{}).(string)
+	return userID
+}
+
+// RedisStoreModerators keeps the IDs of the moderators of each source
+type RedisStoreModerators struct {
+	*goRedis.Client
+}
+
+// List returns the IDs of the moderators of a source, sorted
+func (c *RedisStoreModerators) List(ctx context.Context, source string) ([]string, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "List").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SMembers(c.key(source))
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get moderators")
+	}
+	userIDs := res.Val()
+	sort.Strings(userIDs)
+	return userIDs, nil
+}
+
+// IsModerator tells whether a user moderates a source
+func (c *RedisStoreModerators) IsModerator(ctx context.Context, source, userID string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "IsModerator").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SIsMember(c.key(source), userID)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to check moderator: "+userID)
+	}
+	return res.Val(), nil
+}
+
+// Add makes a user a moderator of a source, it returns false if the user
+// already was
+func (c *RedisStoreModerators) Add(ctx context.Context, source, userID string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Add").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SAdd(c.key(source), userID)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to add moderator: "+userID)
+	}
+	return res.Val() == 1, nil
+}
+
+// Remove takes the moderator role of a source from a user, it returns false
+// if the user did not have it
+func (c *RedisStoreModerators) Remove(ctx context.Context, source, userID string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Remove").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SRem(c.key(source), userID)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to remove moderator: "+userID)
+	}
+	return res.Val() == 1, nil
+}
+
+func (c *RedisStoreModerators) key(source string) string {
+	return fmt.Sprintf("%sModerators:source:%s", redis.Prefix(), source)
+}
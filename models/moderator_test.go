@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestActorDenied(t *testing.T) {
+	excuse := Codexcuse{Author: &User{ID: "1", UserName: "a"}, Reporter: &User{ID: "2", UserName: "r"}}
+	noOwner := Codexcuse{Author: &User{UserName: "a"}}
+	rename := &CodexcusePatch{Title: stringPtr("new")}
+	newAuthor := &CodexcusePatch{Author: &User{ID: "3", UserName: "other"}}
+
+	tests := []struct {
+		name   string
+		actor  Actor
+		excuse Codexcuse
+		patch  *CodexcusePatch
+		denied string
+	}{
+		{"author deletes", Actor{UserID: "1"}, excuse, nil, ""},
+		{"reporter edits", Actor{UserID: "2"}, excuse, rename, ""},
+		{"other user deletes", Actor{UserID: "3"}, excuse, nil, DeniedNotOwner},
+		{"other user edits", Actor{UserID: "3"}, excuse, rename, DeniedNotOwner},
+		{"no user", Actor{}, noOwner, nil, DeniedNotOwner},
+		{"owner changes the author", Actor{UserID: "1"}, excuse, newAuthor, DeniedOwnerChange},
+		{"moderator changes the author", Actor{UserID: "3", Moderator: true}, excuse, newAuthor, ""},
+		{"moderator deletes", Actor{Moderator: true}, noOwner, nil, ""},
+	}
+	for _, test := range tests {
+		if got := test.actor.Denied(test.excuse, test.patch); got != test.denied {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.denied)
+		}
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestRedisStoreModerators(t *testing.T) {
+	store := &RedisStoreModerators{Client: newTestRedis(t)}
+	ctx := context.Background()
+
+	for _, userID := range []string{"2", "1", "2"} {
+		_, err := store.Add(ctx, "guild", userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	userIDs, err := store.List(ctx, "guild")
+	if err != nil || !reflect.DeepEqual(userIDs, []string{"1", "2"}) {
+		t.Fatalf("got %v, %v, want the moderators sorted", userIDs, err)
+	}
+	if moderator, _ := store.IsModerator(ctx, "other", "1"); moderator {
+		t.Error("the moderator of guild moderates another source")
+	}
+
+	removed, err := store.Remove(ctx, "guild", "1")
+	if err != nil || !removed {
+		t.Fatalf("got %v, %v, want the moderator removed", removed, err)
+	}
+	if removed, _ = store.Remove(ctx, "guild", "1"); removed {
+		t.Error("removed a user who is not a moderator")
+	}
+	if moderator, _ := store.IsModerator(ctx, "guild", "1"); moderator {
+		t.Error("the removed user is still a moderator")
+	}
+}
@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// Classes of the routes of the API, each has its own rate limit
+const (
+	RateLimitRead   = "read"
+	RateLimitWrite  = "write"
+	RateLimitRandom = "random"
+)
+
+var RateLimitClasses = []string{RateLimitRead, RateLimitWrite, RateLimitRandom}
+
+// RateLimits is the number of requests allowed per window, by route class. A
+// missing class falls back to the next level of configuration and a limit of
+// 0 disables the rate limiting of the class.
+type RateLimits map[string]int
+
+// RateLimit is the state of a bucket after a request
+type RateLimit struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, when this one
+	// is not
+	RetryAfter time.Duration
+}
+
+type RedisStoreRateLimits struct {
+	*goRedis.Client
+}
+
+// gcraScript implements the generic cell rate algorithm: the key holds the
+// theoretical arrival time of the next request, in milliseconds, which moves
+// forward by window/limit for each allowed request. A request is allowed as
+// long as this time is less than a window ahead. It returns whether the
+// request is allowed, the remaining requests, the time until the bucket is
+// full and the time until the next request is allowed.
+var gcraScript = goRedis.NewScript(`
+local now = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + interval
+local allowAt = newTat - window
+if allowAt > now then
+	return {0, 0, math.ceil(tat - now), math.ceil(allowAt - now)}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', math.ceil(newTat - now))
+return {1, math.floor((now - allowAt) / interval), math.ceil(newTat - now), 0}
+`)
+
+// Allow counts a request in bucket, allowed to get limit requests per window
+func (c *RedisStoreRateLimits) Allow(ctx context.Context, bucket string, limit int, window time.Duration, now time.Time) (RateLimit, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Allow").WithField("key", c.bucketKey(bucket))
+	log.Debugln("bucket:", bucket)
+	if c == nil {
+		return RateLimit{}, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	windowMs := float64(window) / float64(time.Millisecond)
+	res := gcraScript.Run(c.Client, []string{c.bucketKey(bucket)},
+		toMillis(now), windowMs/float64(limit), windowMs)
+	if res.Err() != nil {
+		return RateLimit{}, errors.Wrap(res.Err(), "fail to run rate limit script")
+	}
+	vals, ok := res.Val().([]interface{})
+	if !ok || len(vals) != 4 {
+		return RateLimit{}, errors.New("invalid rate limit script result")
+	}
+	ints := make([]int64, len(vals))
+	for i, v := range vals {
+		ints[i], _ = v.(int64)
+	}
+	return RateLimit{
+		Allowed:    ints[0] == 1,
+		Limit:      limit,
+		Remaining:  int(ints[1]),
+		Reset:      time.Duration(ints[2]) * time.Millisecond,
+		RetryAfter: time.Duration(ints[3]) * time.Millisecond,
+	}, nil
+}
+
+// GetSourceLimits returns the rate limits set for a source, nil if there are
+// none
+func (c *RedisStoreRateLimits) GetSourceLimits(ctx context.Context, source string) (RateLimits, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "GetSourceLimits").WithField("key", c.sourceKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Get(c.sourceKey(source))
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get rate limits")
+	}
+
+	var limits RateLimits
+	err := json.Unmarshal([]byte(res.Val()), &limits)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal rate limits")
+	}
+	return limits, nil
+}
+
+func (c *RedisStoreRateLimits) SetSourceLimits(ctx context.Context, source string, limits RateLimits) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "SetSourceLimits").WithField("key", c.sourceKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	bytes, err := json.Marshal(limits)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal rate limits")
+	}
+	res := c.Set(c.sourceKey(source), bytes, 0)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set rate limits")
+	}
+	return nil
+}
+
+// DeleteSourceLimits makes a source use the default rate limits, it returns
+// false if it already did
+func (c *RedisStoreRateLimits) DeleteSourceLimits(ctx context.Context, source string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeleteSourceLimits").WithField("key", c.sourceKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Del(c.sourceKey(source))
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to delete rate limits")
+	}
+	return res.Val() == 1, nil
+}
+
+func (c *RedisStoreRateLimits) bucketKey(bucket string) string {
+	return fmt.Sprintf("%sRateLimit:%s", redis.Prefix(), bucket)
+}
+
+func (c *RedisStoreRateLimits) sourceKey(source string) string {
+	return fmt.Sprintf("%sRateLimits:source:%s", redis.Prefix(), source)
+}
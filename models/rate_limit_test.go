@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRedisStoreRateLimitsAllow(t *testing.T) {
+	store := &RedisStoreRateLimits{Client: newTestRedis(t)}
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	allow := func(bucket string, at time.Duration) RateLimit {
+		t.Helper()
+		limit, err := store.Allow(ctx, bucket, 3, time.Minute, now.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return limit
+	}
+
+	// 3 requests a minute, one every 20s once the burst is spent
+	tests := []struct {
+		name string
+		at   time.Duration
+		want RateLimit
+	}{
+		{"first", 0, RateLimit{Allowed: true, Limit: 3, Remaining: 2, Reset: 20 * time.Second}},
+		{"second", 0, RateLimit{Allowed: true, Limit: 3, Remaining: 1, Reset: 40 * time.Second}},
+		{"third", 0, RateLimit{Allowed: true, Limit: 3, Remaining: 0, Reset: time.Minute}},
+		{"over the limit", time.Second, RateLimit{Limit: 3, Reset: 59 * time.Second, RetryAfter: 19 * time.Second}},
+		{"once an interval passed", 20 * time.Second, RateLimit{Allowed: true, Limit: 3, Remaining: 0, Reset: time.Minute}},
+		{"over the limit again", 30 * time.Second, RateLimit{Limit: 3, Reset: 50 * time.Second, RetryAfter: 10 * time.Second}},
+		{"once the bucket is full", 3 * time.Minute, RateLimit{Allowed: true, Limit: 3, Remaining: 2, Reset: 20 * time.Second}},
+	}
+	for _, test := range tests {
+		if got := allow("write:guild:bot", test.at); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+
+	if got := allow("write:guild:other", time.Second); !got.Allowed || got.Remaining != 2 {
+		t.Errorf("got %+v for another bucket, want it full", got)
+	}
+}
+
+func TestRedisStoreRateLimitsSourceLimits(t *testing.T) {
+	store := &RedisStoreRateLimits{Client: newTestRedis(t)}
+	ctx := context.Background()
+
+	limits, err := store.GetSourceLimits(ctx, "guild")
+	if err != nil || limits != nil {
+		t.Fatalf("got %v, %v, want no limits", limits, err)
+	}
+	err = store.SetSourceLimits(ctx, "guild", RateLimits{RateLimitWrite: 10, RateLimitRandom: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits, err = store.GetSourceLimits(ctx, "guild")
+	if err != nil || !reflect.DeepEqual(limits, RateLimits{RateLimitWrite: 10, RateLimitRandom: 0}) {
+		t.Fatalf("got %v, %v, want the limits set", limits, err)
+	}
+	if deleted, err := store.DeleteSourceLimits(ctx, "guild"); err != nil || !deleted {
+		t.Fatalf("got %v, %v, want the limits deleted", deleted, err)
+	}
+	if deleted, _ := store.DeleteSourceLimits(ctx, "guild"); deleted {
+		t.Error("deleted the limits twice")
+	}
+}
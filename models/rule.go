@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	HookTargetDiscord = "discord"
+	HookTargetHTTP    = "http"
+	HookTargetChannel = "channel"
+
+	// EventHookRouted is the delivery event type of the messages sent to the
+	// targets of a rule
+	EventHookRouted = "hook.routed"
+)
+
+// HookRule sends the incoming hooks matching Match to Targets, formatted with
+// Template
+type HookRule struct {
+	ID        string       `json:"id"`
+	Name      string       `json:"name"`
+	Match     HookMatch    `json:"match"`
+	Targets   []HookTarget `json:"targets"`
+	Template  string       `json:"template"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// HookMatch filters the incoming hooks, an empty field matches any value.
+// Repository and Branch are globs such as "org/*" or "release/*".
+type HookMatch struct {
+	Provider   string `json:"provider,omitempty"`
+	Type       string `json:"type,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Branch     string `json:"branch,omitempty"`
+	Actor      string `json:"actor,omitempty"`
+}
+
+// HookTarget is where the message of a rule is sent: a Discord webhook URL, a
+// generic HTTP endpoint (signed when Secret is set) or an internal Pub/Sub
+// channel
+type HookTarget struct {
+	Type    string `json:"type"`
+	URL     string `json:"url,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	Channel string `json:"channel,omitempty"`
+}
+
+type RedisStoreHookRules struct {
+	*goRedis.Client
+}
+
+func (c *RedisStoreHookRules) List(ctx context.Context, source string) ([]HookRule, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "List").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGetAll(c.key(source))
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get all rules")
+	}
+
+	rules := make([]HookRule, 0, len(res.Val()))
+	for _, v := range res.Val() {
+		var rule HookRule
+		err := json.Unmarshal([]byte(v), &rule)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal rule")
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (c *RedisStoreHookRules) Get(ctx context.Context, source, id string) (*HookRule, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Get").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.key(source), id)
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get rule: "+id)
+	}
+
+	var rule HookRule
+	err := json.Unmarshal([]byte(res.Val()), &rule)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal rule")
+	}
+	return &rule, nil
+}
+
+// Save creates the rule when its ID is empty, and replaces it otherwise
+func (c *RedisStoreHookRules) Save(ctx context.Context, source string, rule *HookRule) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Save").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	now := time.Now().UTC()
+	if rule.ID == "" {
+		rule.ID = uuid.New().String()
+		rule.CreatedAt = now
+	}
+	rule.UpdatedAt = now
+	bytes, err := json.Marshal(rule)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal rule")
+	}
+
+	res := c.HSet(c.key(source), rule.ID, bytes)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set rule")
+	}
+	return nil
+}
+
+// Delete removes the rule, it returns false if it did not exist
+func (c *RedisStoreHookRules) Delete(ctx context.Context, source, id string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Delete").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HDel(c.key(source), id)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to delete rule: "+id)
+	}
+	return res.Val() == 1, nil
+}
+
+func (c *RedisStoreHookRules) key(source string) string {
+	return fmt.Sprintf("%sHookRules:source:%s", redis.Prefix(), source)
+}
+
+// HookChannel is the redis Pub/Sub channel of an internal channel target
+func HookChannel(source, channel string) string {
+	return fmt.Sprintf("%sHookChannel:source:%s:%s", redis.Prefix(), source, channel)
+}
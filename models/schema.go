@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/health"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// SchemaVersion is the version of the layout of the data in Redis this
+// release reads and writes. It is raised by the releases which need the data
+// migrated.
+const SchemaVersion = 1
+
+type RedisStoreSchema struct {
+	*goRedis.Client
+}
+
+// Version returns the schema version of the data, 0 if none is recorded
+func (c *RedisStoreSchema) Version(ctx context.Context) (int, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Version").WithField("key", c.key())
+	if c == nil {
+		return 0, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Get(c.key())
+	if res.Err() == goRedis.Nil {
+		return 0, nil
+	}
+	if res.Err() != nil {
+		return 0, errors.Wrap(res.Err(), "fail to get schema version")
+	}
+	version, err := strconv.Atoi(res.Val())
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid schema version")
+	}
+	return version, nil
+}
+
+// Init records SchemaVersion as the version of the data, unless one is
+// already recorded. Migrations record the version they migrate to with Set.
+func (c *RedisStoreSchema) Init(ctx context.Context) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Init").WithField("key", c.key())
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.SetNX(c.key(), SchemaVersion, 0)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to init schema version")
+	}
+	return nil
+}
+
+func (c *RedisStoreSchema) Set(ctx context.Context, version int) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Set").WithField("key", c.key())
+	log.Debugln("version:", version)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.Client.Set(c.key(), version, 0)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set schema version")
+	}
+	return nil
+}
+
+// Check is the health check of the schema version: the instance is degraded
+// while the data waits for a migration, and down when the data was migrated
+// by a newer release
+func (c *RedisStoreSchema) Check(ctx context.Context) error {
+	version, err := c.Version(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case version > SchemaVersion:
+		return fmt.Errorf("the data has the schema version %d, newer than the version %d of this release", version, SchemaVersion)
+	case version < SchemaVersion:
+		return health.Degraded(fmt.Errorf("the data has the schema version %d and waits for a migration to the version %d", version, SchemaVersion))
+	}
+	return nil
+}
+
+func (c *RedisStoreSchema) key() string {
+	return fmt.Sprintf("%sSchemaVersion", redis.Prefix())
+}
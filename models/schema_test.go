@@ -0,0 +1,56 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/health"
+)
+
+func TestRedisStoreSchema(t *testing.T) {
+	schema := &RedisStoreSchema{Client: newTestRedis(t)}
+	ctx := context.Background()
+	checker := health.NewChecker(time.Second)
+	checker.Register("schema", schema.Check)
+
+	// The data of a release older than the schema versions waits for a
+	// migration
+	version, err := schema.Version(ctx)
+	if err != nil || version != 0 {
+		t.Fatalf("got %d, %v, want no version", version, err)
+	}
+	if report := checker.Run(ctx); report.Status != health.StatusDegraded {
+		t.Errorf("got %+v without a version, want degraded", report)
+	}
+
+	err = schema.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := checker.Run(ctx); report.Status != health.StatusUp {
+		t.Errorf("got %+v once initialized, want up", report)
+	}
+
+	// Init keeps the version of the data
+	err = schema.Set(ctx, SchemaVersion+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = schema.Init(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err = schema.Version(ctx)
+	if err != nil || version != SchemaVersion+1 {
+		t.Fatalf("got %d, %v, want the version kept by Init", version, err)
+	}
+	if report := checker.Run(ctx); report.Status != health.StatusDown {
+		t.Errorf("got %+v with a newer version, want down", report)
+	}
+
+	schema.Client.Set(schema.key(), "one", 0)
+	if _, err := schema.Version(ctx); err == nil {
+		t.Error("got no error for an invalid version")
+	}
+}
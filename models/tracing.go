@@ -0,0 +1,58 @@
+package models
+
+import (
+	"context"
+
+	"github.com/curzolapierre/hook-manager/redis"
+)
+
+// The stores trace their commands as children of the span of the context of
+// each call, with a copy of their client bound to it
+
+func (c *RedisStoreAPIClients) withContext(ctx context.Context) *RedisStoreAPIClients {
+	return &RedisStoreAPIClients{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreAudit) withContext(ctx context.Context) *RedisStoreAudit {
+	return &RedisStoreAudit{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreCodexcuses) withContext(ctx context.Context) *RedisStoreCodexcuses {
+	return &RedisStoreCodexcuses{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreDeliveryLog) withContext(ctx context.Context) *RedisStoreDeliveryLog {
+	return &RedisStoreDeliveryLog{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreFeeds) withContext(ctx context.Context) *RedisStoreFeeds {
+	return &RedisStoreFeeds{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreHookRules) withContext(ctx context.Context) *RedisStoreHookRules {
+	return &RedisStoreHookRules{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreHooks) withContext(ctx context.Context) *RedisStoreHooks {
+	return &RedisStoreHooks{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreIdempotency) withContext(ctx context.Context) *RedisStoreIdempotency {
+	return &RedisStoreIdempotency{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreModerators) withContext(ctx context.Context) *RedisStoreModerators {
+	return &RedisStoreModerators{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreRateLimits) withContext(ctx context.Context) *RedisStoreRateLimits {
+	return &RedisStoreRateLimits{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreSchema) withContext(ctx context.Context) *RedisStoreSchema {
+	return &RedisStoreSchema{Client: redis.WithContext(ctx, c.Client)}
+}
+
+func (c *RedisStoreWebhooks) withContext(ctx context.Context) *RedisStoreWebhooks {
+	return &RedisStoreWebhooks{Client: redis.WithContext(ctx, c.Client)}
+}
@@ -0,0 +1,412 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/redis"
+	goRedis "github.com/go-redis/redis"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// Webhook is an outgoing webhook subscription of a source. Every event of
+// Events is POSTed to URL, signed with Secret.
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subscribed returns true if the webhook must be notified of eventType
+func (w Webhook) Subscribed(eventType string) bool {
+	for _, e := range w.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is a single event to deliver to a webhook, or to the
+// target of a hook rule when RuleID is set
+type WebhookDelivery struct {
+	ID         string          `json:"id"`
+	WebhookID  string          `json:"webhook_id,omitempty"`
+	RuleID     string          `json:"rule_id,omitempty"`
+	Target     *HookTarget     `json:"target,omitempty"`
+	Source     string          `json:"source"`
+	Event      string          `json:"event"`
+	Payload    json.RawMessage `json:"payload"`
+	Attempts   int             `json:"attempts"`
+	LastStatus int             `json:"last_status,omitempty"`
+	LastError  string          `json:"last_error,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FailedAt   *time.Time      `json:"failed_at,omitempty"`
+}
+
+type RedisStoreWebhooks struct {
+	*goRedis.Client
+}
+
+var (
+	// WebhookEvents lists the events a webhook can subscribe to
+	WebhookEvents = []string{EventExcuseCreated, EventExcuseDeleted, EventExcuseUpdated, EventExcuseReported, EventHookReceived}
+
+	// DeadLettersMaxLength caps the number of failed deliveries kept per source
+	DeadLettersMaxLength int64 = 1000
+)
+
+// claimDeliveryScript takes the first due delivery and pushes its score to the
+// end of the lease, so that a delivery claimed by a crashed worker is retried
+// once the lease expires.
+var claimDeliveryScript = goRedis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, 1)
+if #ids == 0 then
+	return false
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ids[1])
+return ids[1]
+`)
+
+func (c *RedisStoreWebhooks) List(ctx context.Context, source string) ([]Webhook, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "List").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGetAll(c.key(source))
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get all webhooks")
+	}
+
+	webhooks := make([]Webhook, 0, len(res.Val()))
+	for _, v := range res.Val() {
+		var webhook Webhook
+		err := json.Unmarshal([]byte(v), &webhook)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal webhook")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (c *RedisStoreWebhooks) Get(ctx context.Context, source, id string) (*Webhook, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Get").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HGet(c.key(source), id)
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get webhook: "+id)
+	}
+
+	var webhook Webhook
+	err := json.Unmarshal([]byte(res.Val()), &webhook)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal webhook")
+	}
+	return &webhook, nil
+}
+
+// Add saves a new webhook, its ID and creation date are set by the store
+func (c *RedisStoreWebhooks) Add(ctx context.Context, source string, webhook *Webhook) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Add").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	webhook.ID = uuid.New().String()
+	webhook.CreatedAt = time.Now().UTC()
+	bytes, err := json.Marshal(webhook)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal webhook")
+	}
+
+	res := c.HSet(c.key(source), webhook.ID, bytes)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set webhook")
+	}
+	return nil
+}
+
+// Delete removes the webhook, it returns false if it did not exist
+func (c *RedisStoreWebhooks) Delete(ctx context.Context, source, id string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Delete").WithField("key", c.key(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.HDel(c.key(source), id)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to delete webhook: "+id)
+	}
+	return res.Val() == 1, nil
+}
+
+// Enqueue schedules a delivery of payload to every webhook of the source
+// subscribed to eventType
+func (c *RedisStoreWebhooks) Enqueue(ctx context.Context, source, eventType string, payload []byte) error {
+	webhooks, err := c.List(ctx, source)
+	if err != nil {
+		return errors.Wrap(err, "fail to list webhooks")
+	}
+
+	for _, webhook := range webhooks {
+		if !webhook.Subscribed(eventType) {
+			continue
+		}
+
+		delivery := WebhookDelivery{
+			ID:        uuid.New().String(),
+			WebhookID: webhook.ID,
+			Source:    source,
+			Event:     eventType,
+			Payload:   payload,
+			CreatedAt: time.Now().UTC(),
+		}
+		err = c.Schedule(ctx, delivery, time.Now())
+		if err != nil {
+			return errors.Wrap(err, "fail to schedule delivery to webhook "+webhook.ID)
+		}
+	}
+	return nil
+}
+
+// Schedule saves the delivery and queues it for the given time
+func (c *RedisStoreWebhooks) Schedule(ctx context.Context, delivery WebhookDelivery, at time.Time) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Schedule").WithField("key", c.deliveriesKey())
+	log.Debugln("delivery:", delivery.ID)
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	bytes, err := json.Marshal(delivery)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal delivery")
+	}
+
+	res := c.HSet(c.deliveryKey(), delivery.ID, bytes)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to set delivery")
+	}
+
+	zAddRes := c.ZAdd(c.deliveriesKey(), goRedis.Z{
+		Score:  float64(toMillis(at)),
+		Member: delivery.ID,
+	})
+	if zAddRes.Err() != nil {
+		return errors.Wrap(zAddRes.Err(), "fail to ZADD the ID of delivery")
+	}
+	return nil
+}
+
+// Claim returns the next due delivery, or nil if there is none. The delivery
+// stays in the queue until the lease expires, it must be either completed,
+// rescheduled or dead-lettered before that.
+func (c *RedisStoreWebhooks) Claim(ctx context.Context, lease time.Duration) (*WebhookDelivery, error) {
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	now := time.Now()
+	res := claimDeliveryScript.Run(c.Client, []string{c.deliveriesKey()},
+		toMillis(now), toMillis(now.Add(lease)))
+	if res.Err() == goRedis.Nil {
+		return nil, nil
+	}
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to claim a delivery")
+	}
+
+	id, _ := res.Val().(string)
+	hRes := c.HGet(c.deliveryKey(), id)
+	if hRes.Err() == goRedis.Nil {
+		// Orphan ID, nothing to deliver anymore
+		c.ZRem(c.deliveriesKey(), id)
+		return nil, nil
+	}
+	if hRes.Err() != nil {
+		return nil, errors.Wrap(hRes.Err(), "fail to get delivery: "+id)
+	}
+
+	var delivery WebhookDelivery
+	err := json.Unmarshal([]byte(hRes.Val()), &delivery)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to unmarshal delivery")
+	}
+	return &delivery, nil
+}
+
+// Complete removes a delivery from the queue
+func (c *RedisStoreWebhooks) Complete(ctx context.Context, id string) error {
+	if c == nil {
+		return errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.ZRem(c.deliveriesKey(), id)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to delete ID of delivery: "+id)
+	}
+	res = c.HDel(c.deliveryKey(), id)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to delete delivery: "+id)
+	}
+	return nil
+}
+
+// DeadLetter removes a delivery from the queue and keeps it in the dead-letter
+// list of its source
+func (c *RedisStoreWebhooks) DeadLetter(ctx context.Context, delivery WebhookDelivery) error {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeadLetter").WithField("key", c.deadLettersKey(delivery.Source))
+	log.Debugln("delivery:", delivery.ID)
+
+	err := c.Complete(ctx, delivery.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	delivery.FailedAt = &now
+	bytes, err := json.Marshal(delivery)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal delivery")
+	}
+
+	res := c.LPush(c.deadLettersKey(delivery.Source), bytes)
+	if res.Err() != nil {
+		return errors.Wrap(res.Err(), "fail to push dead letter")
+	}
+	trimRes := c.LTrim(c.deadLettersKey(delivery.Source), 0, DeadLettersMaxLength-1)
+	if trimRes.Err() != nil {
+		return errors.Wrap(trimRes.Err(), "fail to trim dead letters")
+	}
+	return nil
+}
+
+// DeadLetters returns the failed deliveries of a source, newest first
+func (c *RedisStoreWebhooks) DeadLetters(ctx context.Context, source string) ([]WebhookDelivery, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "DeadLetters").WithField("key", c.deadLettersKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return nil, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.LRange(c.deadLettersKey(source), 0, -1)
+	if res.Err() != nil {
+		return nil, errors.Wrap(res.Err(), "fail to get dead letters")
+	}
+
+	deliveries := make([]WebhookDelivery, 0, len(res.Val()))
+	for _, v := range res.Val() {
+		var delivery WebhookDelivery
+		err := json.Unmarshal([]byte(v), &delivery)
+		if err != nil {
+			return nil, errors.Wrap(err, "fail to unmarshal delivery")
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// Replay moves a dead letter back to the delivery queue with a fresh attempts
+// counter. It returns false if no dead letter has this ID.
+func (c *RedisStoreWebhooks) Replay(ctx context.Context, source, id string) (bool, error) {
+	log := logger.Get(ctx)
+
+	log.WithField("function", "Replay").WithField("key", c.deadLettersKey(source))
+	log.Debugln("source:", source)
+	if c == nil {
+		return false, errors.New("fail to get redis client")
+	}
+	c = c.withContext(ctx)
+
+	res := c.LRange(c.deadLettersKey(source), 0, -1)
+	if res.Err() != nil {
+		return false, errors.Wrap(res.Err(), "fail to get dead letters")
+	}
+
+	for _, v := range res.Val() {
+		var delivery WebhookDelivery
+		err := json.Unmarshal([]byte(v), &delivery)
+		if err != nil || delivery.ID != id {
+			continue
+		}
+
+		remRes := c.LRem(c.deadLettersKey(source), 1, v)
+		if remRes.Err() != nil {
+			return false, errors.Wrap(remRes.Err(), "fail to remove dead letter: "+id)
+		}
+		if remRes.Val() == 0 {
+			// Replayed concurrently
+			return false, nil
+		}
+
+		delivery.Attempts = 0
+		delivery.FailedAt = nil
+		err = c.Schedule(ctx, delivery, time.Now())
+		if err != nil {
+			return false, errors.Wrap(err, "fail to schedule delivery: "+id)
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c *RedisStoreWebhooks) key(source string) string {
+	return fmt.Sprintf("%sWebhooks:source:%s", redis.Prefix(), source)
+}
+
+func (c *RedisStoreWebhooks) deadLettersKey(source string) string {
+	return fmt.Sprintf("%sWebhookDeadLetters:source:%s", redis.Prefix(), source)
+}
+
+// deliveryKey stores the deliveries content by ID, for all sources
+func (c *RedisStoreWebhooks) deliveryKey() string {
+	return fmt.Sprintf("%sWebhookDelivery", redis.Prefix())
+}
+
+// deliveriesKey is the queue of delivery IDs, sorted by next attempt timestamp
+func (c *RedisStoreWebhooks) deliveriesKey() string {
+	return fmt.Sprintf("%sWebhookDeliveries", redis.Prefix())
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
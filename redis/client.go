@@ -48,6 +48,7 @@ func Client(config config.Config) (*redis.Client, error) {
 			MaxRetries:  3,
 			IdleTimeout: 80 * time.Second,
 		})
+		instrument(redisClient)
 	})
 
 	if errInit != nil {
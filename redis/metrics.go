@@ -0,0 +1,93 @@
+package redis
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/go-redis/redis"
+)
+
+// commandBuckets are the upper bounds, in seconds, of the buckets of the
+// latency of the commands
+var commandBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+var (
+	commandDuration = metrics.Default.NewHistogram("hook_manager_redis_command_duration_seconds",
+		"Latency of the Redis commands, the pipelines and transactions are timed as a whole.",
+		commandBuckets, "command")
+	commandErrors = metrics.Default.NewCounter("hook_manager_redis_command_errors_total",
+		"Redis commands which failed, a missing key is not a failure.",
+		"command")
+)
+
+// instrument times the commands of client and exposes the stats of its
+// connection pool
+func instrument(client *redis.Client) {
+	timeCommands(client)
+	registerPoolMetrics(metrics.Default, client)
+}
+
+// timeCommands times the commands of client and counts their failures
+func timeCommands(client *redis.Client) {
+	client.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := process(cmd)
+			commandDuration.Observe(time.Since(start).Seconds(), cmd.Name())
+			if isFailure(err) {
+				commandErrors.Inc(cmd.Name())
+			}
+			return err
+		}
+	})
+	client.WrapProcessPipeline(func(process func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			start := time.Now()
+			err := process(cmds)
+			commandDuration.Observe(time.Since(start).Seconds(), "pipeline")
+			for _, cmd := range cmds {
+				if isFailure(cmd.Err()) {
+					commandErrors.Inc(cmd.Name())
+				}
+			}
+			return err
+		}
+	})
+}
+
+// registerPoolMetrics exposes the stats of the connection pool of client,
+// collected on each scrape
+func registerPoolMetrics(registry *metrics.Registry, client *redis.Client) {
+	registry.NewCounterFunc("hook_manager_redis_pool_requests_total",
+		"Connections requested to the Redis pool, by result: hit when a free connection was found, miss when one was opened, timeout when none was available in time.",
+		[]string{"result"}, func(context.Context) ([]metrics.Sample, error) {
+			stats := client.PoolStats()
+			return []metrics.Sample{
+				{LabelValues: []string{"hit"}, Value: float64(stats.Hits)},
+				{LabelValues: []string{"miss"}, Value: float64(stats.Misses)},
+				{LabelValues: []string{"timeout"}, Value: float64(stats.Timeouts)},
+			}, nil
+		})
+	registry.NewGaugeFunc("hook_manager_redis_pool_connections",
+		"Connections of the Redis pool, by state.",
+		[]string{"state"}, func(context.Context) ([]metrics.Sample, error) {
+			stats := client.PoolStats()
+			return []metrics.Sample{
+				{LabelValues: []string{"total"}, Value: float64(stats.TotalConns)},
+				{LabelValues: []string{"idle"}, Value: float64(stats.IdleConns)},
+			}, nil
+		})
+	registry.NewCounterFunc("hook_manager_redis_pool_stale_connections_total",
+		"Stale connections removed from the Redis pool.",
+		nil, func(context.Context) ([]metrics.Sample, error) {
+			return []metrics.Sample{{Value: float64(client.PoolStats().StaleConns)}}, nil
+		})
+}
+
+// isFailure tells whether err is a failure of a command, rather than a
+// missing key or a script to load before running it again with EVAL
+func isFailure(err error) bool {
+	return err != nil && err != redis.Nil && !strings.HasPrefix(err.Error(), "NOSCRIPT")
+}
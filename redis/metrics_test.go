@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/go-redis/redis"
+)
+
+// metricValue reads the value of the sample of registry starting with prefix,
+// "0" when there is none
+func metricValue(t *testing.T, registry *metrics.Registry, prefix string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	err := registry.WriteTo(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, prefix+" ") {
+			return strings.TrimPrefix(line, prefix+" ")
+		}
+	}
+	return "0"
+}
+
+func TestInstrument(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	defer client.Close()
+	timeCommands(client)
+	registry := metrics.NewRegistry()
+	registerPoolMetrics(registry, client)
+
+	// The commands are counted in the default registry, by other tests too
+	commands := []struct {
+		metric string
+		want   int
+	}{
+		{`hook_manager_redis_command_duration_seconds_count{command="get"}`, 2},
+		{`hook_manager_redis_command_duration_seconds_count{command="incr"}`, 1},
+		{`hook_manager_redis_command_duration_seconds_count{command="pipeline"}`, 1},
+		{`hook_manager_redis_command_errors_total{command="get"}`, 0},
+		{`hook_manager_redis_command_errors_total{command="incr"}`, 2},
+	}
+	before := make([]int, len(commands))
+	for i, command := range commands {
+		before[i], _ = strconv.Atoi(metricValue(t, metrics.Default, command.metric))
+	}
+
+	server.Set("title", "not a number")
+	client.Get("missing")
+	client.Get("title")
+	client.Incr("title")
+	pipe := client.Pipeline()
+	pipe.Incr("title")
+	pipe.Get("missing")
+	pipe.Exec()
+
+	for i, command := range commands {
+		after, _ := strconv.Atoi(metricValue(t, metrics.Default, command.metric))
+		if got := after - before[i]; got != command.want {
+			t.Errorf("%s: got %d more, want %d", command.metric, got, command.want)
+		}
+	}
+
+	pool := []struct {
+		metric string
+		want   string
+	}{
+		{`hook_manager_redis_pool_connections{state="total"}`, "1"},
+		{`hook_manager_redis_pool_connections{state="idle"}`, "1"},
+		{`hook_manager_redis_pool_requests_total{result="miss"}`, "1"},
+		{`hook_manager_redis_pool_requests_total{result="hit"}`, "3"},
+		{`hook_manager_redis_pool_stale_connections_total`, "0"},
+	}
+	for _, test := range pool {
+		if got := metricValue(t, registry, test.metric); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.metric, got, test.want)
+		}
+	}
+}
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err     error
+		failure bool
+	}{
+		{nil, false},
+		{redis.Nil, false},
+		{errors.New("NOSCRIPT No matching script. Please use EVAL."), false},
+		{errors.New("ERR value is not an integer or out of range"), true},
+		{errors.New("dial tcp: connection refused"), true},
+	}
+	for _, test := range tests {
+		if got := isFailure(test.err); got != test.failure {
+			t.Errorf("%v: got %v, want %v", test.err, got, test.failure)
+		}
+	}
+}
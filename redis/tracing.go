@@ -0,0 +1,93 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithContext returns a copy of client whose commands are traced as children
+// of the span of ctx, or client itself when the span is not recorded. The
+// commands of go-redis don't take a context, the stores use this copy to
+// tie them to the request.
+func WithContext(ctx context.Context, client *redis.Client) *redis.Client {
+	// A store calling another one with the same context keeps its client,
+	// its commands would be traced twice otherwise
+	if client == nil || client.Context() == ctx || !trace.SpanFromContext(ctx).IsRecording() {
+		return client
+	}
+	tracer := otel.Tracer("github.com/curzolapierre/hook-manager/redis")
+
+	traced := client.WithContext(ctx)
+	traced.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			operation := strings.ToUpper(cmd.Name())
+			_, span := tracer.Start(ctx, operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(commandAttributes(operation, cmd)...))
+			defer span.End()
+
+			err := process(cmd)
+			if isFailure(err) {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	})
+	traced.WrapProcessPipeline(func(process func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			operations := make([]string, len(cmds))
+			for i, cmd := range cmds {
+				operations[i] = strings.ToUpper(cmd.Name())
+			}
+			_, span := tracer.Start(ctx, "PIPELINE",
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					semconv.DBSystemRedis,
+					semconv.DBOperationKey.String("PIPELINE"),
+					attribute.StringSlice("db.redis.commands", operations),
+				))
+			defer span.End()
+
+			err := process(cmds)
+			if isFailure(err) {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			return err
+		}
+	})
+	return traced
+}
+
+// commandAttributes describes a command by its name and its key, its values
+// are left out of the traces
+func commandAttributes(operation string, cmd redis.Cmder) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		semconv.DBSystemRedis,
+		semconv.DBOperationKey.String(operation),
+	}
+	args := cmd.Args()
+	keyIndex := 1
+	if operation == "EVAL" || operation == "EVALSHA" {
+		// The script comes first, then the number of keys
+		keyIndex = 3
+		if len(args) < 3 || fmt.Sprint(args[2]) == "0" {
+			return attrs
+		}
+	}
+	if len(args) > keyIndex {
+		if key, ok := args[keyIndex].(string); ok {
+			attrs = append(attrs, attribute.String("db.redis.key", key))
+		}
+	}
+	return attrs
+}
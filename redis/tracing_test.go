@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newSpanRecorder records the spans ended during the test
+func newSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+	return recorder
+}
+
+// attributeValue returns the value of the attribute key of span, empty when
+// it is missing
+func attributeValue(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestWithContext(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	defer client.Close()
+	recorder := newSpanRecorder(t)
+
+	if traced := WithContext(context.Background(), client); traced != client {
+		t.Error("got a copy of the client without span, want the client")
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "GET /api/codexcuses/{source}/random")
+	traced := WithContext(ctx, client)
+	if traced == client || WithContext(ctx, traced) != traced {
+		t.Fatal("got the client traced twice or not at all")
+	}
+	server.Set("title", "not a number")
+	traced.Get("missing")
+	traced.Incr("title")
+	pipe := traced.Pipeline()
+	pipe.ZRevRange("ids", 0, 1)
+	pipe.HGet("excuses", "1")
+	pipe.Exec()
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 4 {
+		t.Fatalf("got %d spans, want the 3 of the commands and their parent", len(spans))
+	}
+	tests := []struct {
+		name      string
+		key       string
+		commands  string
+		errStatus bool
+	}{
+		{"GET", "missing", "", false},
+		{"INCR", "title", "", true},
+		{"PIPELINE", "", "[ZREVRANGE HGET]", false},
+	}
+	for i, test := range tests {
+		span := spans[i]
+		if span.Name() != test.name || span.SpanKind() != trace.SpanKindClient {
+			t.Errorf("span %d: got the %v span %s, want the client span %s", i, span.SpanKind(), span.Name(), test.name)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("%s: got the parent %s, want the span of the request", test.name, span.Parent().SpanID())
+		}
+		if got := attributeValue(span, "db.system"); got != "redis" {
+			t.Errorf("%s: got the system %q, want redis", test.name, got)
+		}
+		if got := attributeValue(span, "db.redis.key"); got != test.key {
+			t.Errorf("%s: got the key %q, want %q", test.name, got, test.key)
+		}
+		if got := attributeValue(span, "db.redis.commands"); got != test.commands {
+			t.Errorf("%s: got the commands %q, want %q", test.name, got, test.commands)
+		}
+		if got := span.Status().Code == codes.Error; got != test.errStatus {
+			t.Errorf("%s: got the status %v, want an error %v", test.name, span.Status(), test.errStatus)
+		}
+	}
+}
+
+func TestCommandAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  redis.Cmder
+		key  string
+	}{
+		{"GET", redis.NewStringCmd("get", "title"), "title"},
+		{"PING", redis.NewStatusCmd("ping"), ""},
+		{"EVAL", redis.NewCmd("eval", "return 1", 1, "bucket", "arg"), "bucket"},
+		{"EVALSHA", redis.NewCmd("evalsha", "sha", 0, "arg"), ""},
+		{"EVAL", redis.NewCmd("eval", "return 1"), ""},
+	}
+	for _, test := range tests {
+		key := ""
+		for _, attr := range commandAttributes(test.name, test.cmd) {
+			if attr.Key == "db.redis.key" {
+				key = attr.Value.AsString()
+			}
+		}
+		if key != test.key {
+			t.Errorf("%v: got the key %q, want %q", test.cmd.Args(), key, test.key)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Package slack holds the types of the Slack slash commands, interactivity
+// and Block Kit used by hook-manager, and the verification of the requests
+// signed by Slack
+package slack
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	SignatureHeader = "X-Slack-Signature"
+	TimestampHeader = "X-Slack-Request-Timestamp"
+
+	signatureVersion = "v0"
+
+	// MaxClockSkew is the maximal age of a request, older ones are rejected
+	// as possible replays
+	MaxClockSkew = 5 * time.Minute
+
+	// responseURLHost is the only host Slack gives response URLs on
+	responseURLHost = "hooks.slack.com"
+)
+
+const (
+	ResponseEphemeral = "ephemeral"
+	ResponseInChannel = "in_channel"
+
+	InteractionBlockActions = "block_actions"
+)
+
+// Verify checks the signature of a request of Slack, computed over the
+// version, the timestamp and the body, and that the request is recent
+func Verify(secret, signature, timestamp string, body []byte, now time.Time) bool {
+	if secret == "" || !strings.HasPrefix(signature, signatureVersion+"=") {
+		return false
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew > MaxClockSkew || skew < -MaxClockSkew {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, signatureVersion+"="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%s:", signatureVersion, timestamp)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// Command is a slash command, as posted by Slack in a form
+type Command struct {
+	Command     string
+	Text        string
+	TeamID      string
+	ChannelID   string
+	UserID      string
+	UserName    string
+	ResponseURL string
+}
+
+// ParseCommand reads a slash command from its form values
+func ParseCommand(form url.Values) Command {
+	return Command{
+		Command:     form.Get("command"),
+		Text:        strings.TrimSpace(form.Get("text")),
+		TeamID:      form.Get("team_id"),
+		ChannelID:   form.Get("channel_id"),
+		UserID:      form.Get("user_id"),
+		UserName:    form.Get("user_name"),
+		ResponseURL: form.Get("response_url"),
+	}
+}
+
+// Interaction is the payload of an interactive component, Slack posts it JSON
+// encoded in the payload field of a form
+type Interaction struct {
+	Type        string   `json:"type"`
+	Team        IDObject `json:"team"`
+	Channel     IDObject `json:"channel"`
+	User        User     `json:"user"`
+	Actions     []Action `json:"actions"`
+	ResponseURL string   `json:"response_url"`
+}
+
+type IDObject struct {
+	ID string `json:"id"`
+}
+
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type Action struct {
+	ActionID string `json:"action_id"`
+	Value    string `json:"value"`
+}
+
+// Message is the answer to a command or an interaction
+type Message struct {
+	ResponseType    string  `json:"response_type,omitempty"`
+	ReplaceOriginal bool    `json:"replace_original,omitempty"`
+	Text            string  `json:"text"`
+	Blocks          []Block `json:"blocks,omitempty"`
+}
+
+// Block is a Block Kit block, only the fields of the section, context and
+// actions blocks are supported. The elements are texts in a context block and
+// buttons in an actions block.
+type Block struct {
+	Type      string        `json:"type"`
+	Text      *Text         `json:"text,omitempty"`
+	Accessory *Element      `json:"accessory,omitempty"`
+	Elements  []interface{} `json:"elements,omitempty"`
+}
+
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Element is a button
+type Element struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	ActionID string `json:"action_id,omitempty"`
+	Value    string `json:"value,omitempty"`
+	Style    string `json:"style,omitempty"`
+}
+
+func Markdown(text string) *Text {
+	return &Text{Type: "mrkdwn", Text: text}
+}
+
+func PlainText(text string) *Text {
+	return &Text{Type: "plain_text", Text: text}
+}
+
+// Escape escapes the control characters of the Slack formatting
+func Escape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// ValidResponseURL checks that a response URL points to Slack, the answers of
+// interactions are posted to it
+func ValidResponseURL(responseURL string) bool {
+	u, err := url.Parse(responseURL)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.Host == responseURLHost
+}
+
+// Respond posts a message to the response URL of a command or an interaction
+func Respond(ctx context.Context, client *http.Client, responseURL string, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "fail to marshal message")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "fail to build response request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "fail to send response")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status code %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package slack
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1625097600, 0)
+	body := []byte("command=%2Fexcuse&text=random")
+	at := func(d time.Duration) string {
+		return strconv.FormatInt(now.Add(d).Unix(), 10)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		timestamp string
+		body      []byte
+		valid     bool
+	}{
+		{"valid", "s3cr3t", sign("s3cr3t", at(0), body), at(0), body, true},
+		{"4 minutes old", "s3cr3t", sign("s3cr3t", at(-4*time.Minute), body), at(-4 * time.Minute), body, true},
+		{"5 minutes old", "s3cr3t", sign("s3cr3t", at(-MaxClockSkew), body), at(-MaxClockSkew), body, true},
+		{"replayed after 6 minutes", "s3cr3t", sign("s3cr3t", at(-6*time.Minute), body), at(-6 * time.Minute), body, false},
+		{"6 minutes ahead", "s3cr3t", sign("s3cr3t", at(6*time.Minute), body), at(6 * time.Minute), body, false},
+		{"timestamp replaced", "s3cr3t", sign("s3cr3t", at(-6*time.Minute), body), at(0), body, false},
+		{"tampered body", "s3cr3t", sign("s3cr3t", at(0), body), at(0), []byte("command=%2Fexcuse&text=add"), false},
+		{"other secret", "s3cr3t", sign("other", at(0), body), at(0), body, false},
+		{"other version", "s3cr3t", "v1=" + sign("s3cr3t", at(0), body)[3:], at(0), body, false},
+		{"invalid hex", "s3cr3t", "v0=zz", at(0), body, false},
+		{"invalid timestamp", "s3cr3t", sign("s3cr3t", "now", body), "now", body, false},
+		{"no secret configured", "", sign("", at(0), body), at(0), body, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Verify(test.secret, test.signature, test.timestamp, test.body, now); got != test.valid {
+				t.Errorf("got %v, want %v", got, test.valid)
+			}
+		})
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	form := url.Values{
+		"command": {"/excuse"}, "text": {"  add <@U1|bob> t | c "}, "team_id": {"T1"}, "channel_id": {"C1"},
+		"user_id": {"U2"}, "user_name": {"alice"}, "response_url": {"https://hooks.slack.com/commands/1"},
+	}
+	want := Command{Command: "/excuse", Text: "add <@U1|bob> t | c", TeamID: "T1", ChannelID: "C1",
+		UserID: "U2", UserName: "alice", ResponseURL: "https://hooks.slack.com/commands/1"}
+	if got := ParseCommand(form); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestValidResponseURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://hooks.slack.com/actions/T1/1/x":    true,
+		"http://hooks.slack.com/actions/T1/1/x":     false,
+		"https://hooks.slack.com.evil.example.com/": false,
+		"https://hooks.slack.com@evil.example.com/": false,
+		"https://evil.example.com/":                 false,
+		"":                                          false,
+	}
+	for responseURL, want := range tests {
+		if got := ValidResponseURL(responseURL); got != want {
+			t.Errorf("%s: got %v, want %v", responseURL, got, want)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if got := Escape("<@U1> & <!channel>"); got != "&lt;@U1&gt; &amp; &lt;!channel&gt;" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestRespond(t *testing.T) {
+	var got Message
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	msg := Message{ResponseType: ResponseInChannel, ReplaceOriginal: true, Text: "t"}
+	err := Respond(context.Background(), server.Client(), server.URL, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ResponseType != msg.ResponseType || !got.ReplaceOriginal || got.Text != "t" {
+		t.Errorf("got %+v, want %+v", got, msg)
+	}
+
+	status = http.StatusNotFound
+	if err := Respond(context.Background(), server.Client(), server.URL, msg); err == nil {
+		t.Error("got no error on a 404")
+	}
+}
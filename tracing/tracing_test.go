@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestInit(t *testing.T) {
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+	ctx := context.Background()
+
+	tests := []struct {
+		exporter string
+		valid    bool
+	}{
+		{ExporterNone, true},
+		{ExporterStdout, true},
+		{ExporterOTLP, true},
+		{"jaeger", false},
+	}
+	for _, test := range tests {
+		shutdown, err := Init(ctx, config.Config{TracingExporter: test.exporter, TracingSampleRatio: 1})
+		if (err == nil) != test.valid {
+			t.Errorf("%q: got %v, want valid %v", test.exporter, err, test.valid)
+		}
+		if err != nil {
+			continue
+		}
+		err = shutdown(ctx)
+		if err != nil {
+			t.Errorf("%q: got %v on shutdown", test.exporter, err)
+		}
+	}
+
+	// The trace of the callers is propagated even without exporter
+	carrier := propagation.HeaderCarrier{}
+	carrier.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	if got := trace.SpanContextFromContext(ctx).TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("got the trace %s, want the one of the traceparent header", got)
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	if fields := LogFields(context.Background()); len(fields) != 0 {
+		t.Errorf("got %v without span, want no field", fields)
+	}
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+	fields := LogFields(ctx)
+	if fields["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || fields["span_id"] != "00f067aa0ba902b7" {
+		t.Errorf("got %v, want the IDs of the span", fields)
+	}
+}
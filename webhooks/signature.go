@@ -0,0 +1,21 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	SignatureHeader = "X-Hook-Manager-Signature-256"
+	EventHeader     = "X-Hook-Manager-Event"
+	DeliveryHeader  = "X-Hook-Manager-Delivery"
+)
+
+// Sign returns the value of the signature header of body: the hex encoded
+// HMAC-SHA256 of the body keyed with secret, prefixed by "sha256="
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,260 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/health"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+)
+
+// maxBackoff caps the delay between two attempts of a delivery
+const maxBackoff = time.Hour
+
+var deliveryAttempts = metrics.Default.NewCounter("hook_manager_webhook_deliveries_total",
+	"Attempts of webhook deliveries by outcome: delivered, retried, dead_lettered, or dropped when the webhook was deleted.",
+	"outcome")
+
+// Worker delivers the queued webhook deliveries with a pool of goroutines
+type Worker struct {
+	Store       *models.RedisStoreWebhooks
+	DeliveryLog *models.RedisStoreDeliveryLog
+	Client      *http.Client
+
+	concurrency  int
+	maxAttempts  int
+	backoffBase  time.Duration
+	pollInterval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	// heartbeat is the time, in Unix nanoseconds, a worker last polled the
+	// queue or ended a delivery
+	heartbeat int64
+}
+
+func NewWorker(redisClient *redis.Client, config config.Config) *Worker {
+	return &Worker{
+		Store:       &models.RedisStoreWebhooks{Client: redisClient},
+		DeliveryLog: &models.RedisStoreDeliveryLog{Client: redisClient},
+		Client: &http.Client{
+			Timeout: time.Duration(config.WebhookTimeout) * time.Second,
+		},
+		concurrency:  config.WebhookConcurrency,
+		maxAttempts:  config.WebhookMaxAttempts,
+		backoffBase:  time.Duration(config.WebhookBackoffBase) * time.Second,
+		pollInterval: time.Duration(config.WebhookPollInterval) * time.Millisecond,
+	}
+}
+
+// Start runs the pool in background until Stop is called
+func (w *Worker) Start(ctx context.Context) {
+	ctx, w.cancel = context.WithCancel(ctx)
+	logger.Get(ctx).Infof("Starting %d webhook delivery workers", w.concurrency)
+
+	for i := 0; i < w.concurrency; i++ {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.loop(ctx)
+		}()
+	}
+}
+
+// Stop waits for the deliveries in progress to end
+func (w *Worker) Stop() {
+	if w.cancel == nil {
+		return
+	}
+	w.cancel()
+	w.wg.Wait()
+}
+
+func (w *Worker) loop(ctx context.Context) {
+	log := logger.Get(ctx)
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Drain the due deliveries before waiting for the next tick
+		for ctx.Err() == nil {
+			w.beat()
+			delivery, err := w.Store.Claim(ctx, w.lease())
+			if err != nil {
+				log.WithError(err).Error("fail to claim webhook delivery")
+				break
+			}
+			if delivery == nil {
+				break
+			}
+			w.process(ctx, *delivery)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *Worker) beat() {
+	atomic.StoreInt64(&w.heartbeat, time.Now().UnixNano())
+}
+
+// Check is the health check of the pool: it is degraded when no worker polled
+// the queue for longer than a delivery attempt lasts
+func (w *Worker) Check(ctx context.Context) error {
+	heartbeat := atomic.LoadInt64(&w.heartbeat)
+	if heartbeat == 0 {
+		return health.Degraded(errors.New("the webhook delivery workers are not started"))
+	}
+	since := time.Since(time.Unix(0, heartbeat))
+	if since > 2*w.Client.Timeout+3*w.pollInterval {
+		return health.Degraded(fmt.Errorf("no webhook delivery worker polled the queue for %v", since.Round(time.Second)))
+	}
+	return nil
+}
+
+// process makes one attempt of a delivery and decides what comes next:
+// completion, retry later or dead letter
+func (w *Worker) process(ctx context.Context, delivery models.WebhookDelivery) {
+	log := logger.Get(ctx).WithField("delivery", delivery.ID)
+
+	var url, secret string
+	if delivery.Target != nil {
+		log = log.WithField("rule", delivery.RuleID)
+		url, secret = delivery.Target.URL, delivery.Target.Secret
+	} else {
+		log = log.WithField("webhook", delivery.WebhookID)
+		webhook, err := w.Store.Get(ctx, delivery.Source, delivery.WebhookID)
+		if err != nil {
+			log.WithError(err).Error("fail to get webhook of delivery")
+			return
+		}
+		if webhook == nil {
+			log.Info("webhook deleted, dropping delivery")
+			deliveryAttempts.Inc("dropped")
+			err = w.Store.Complete(ctx, delivery.ID)
+			if err != nil {
+				log.WithError(err).Error("fail to drop delivery")
+			}
+			return
+		}
+		url, secret = webhook.URL, webhook.Secret
+	}
+
+	delivery.Attempts++
+	status, err := w.deliver(ctx, url, secret, delivery)
+	if ctx.Err() != nil {
+		// Stopping: the delivery is retried once its lease expires
+		return
+	}
+	delivery.LastStatus = status
+	if err == nil {
+		log.Debugln("delivered after", delivery.Attempts, "attempts")
+		deliveryAttempts.Inc("delivered")
+		err = w.Store.Complete(ctx, delivery.ID)
+		if err != nil {
+			log.WithError(err).Error("fail to complete delivery")
+		}
+		return
+	}
+	delivery.LastError = err.Error()
+
+	if delivery.Attempts >= w.maxAttempts {
+		log.WithError(err).Info("delivery failed for good, moving it to the dead letters")
+		deliveryAttempts.Inc("dead_lettered")
+		err = w.Store.DeadLetter(ctx, delivery)
+		if err != nil {
+			log.WithError(err).Error("fail to dead-letter delivery")
+		}
+		return
+	}
+
+	backoff := w.backoff(delivery.Attempts)
+	log.WithError(err).Infof("delivery attempt %d failed, retrying in %v", delivery.Attempts, backoff)
+	deliveryAttempts.Inc("retried")
+	err = w.Store.Schedule(ctx, delivery, time.Now().Add(backoff))
+	if err != nil {
+		log.WithError(err).Error("fail to reschedule delivery")
+	}
+}
+
+// deliver POSTs the payload, signed when there is a secret. Any non-2xx answer
+// is an error. Every attempt is recorded in the delivery log of the source.
+func (w *Worker) deliver(ctx context.Context, url, secret string, delivery models.WebhookDelivery) (status int, err error) {
+	entry := models.DeliveryLogEntry{
+		Direction:  models.DeliveryOutgoing,
+		DeliveryID: delivery.ID,
+		WebhookID:  delivery.WebhookID,
+		RuleID:     delivery.RuleID,
+		URL:        url,
+		Body:       string(delivery.Payload),
+		At:         time.Now(),
+	}
+	defer func() {
+		entry.StatusCode = status
+		entry.LatencyMs = time.Since(entry.At).Milliseconds()
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		logErr := w.DeliveryLog.Record(ctx, delivery.Source, entry)
+		if logErr != nil {
+			logger.Get(ctx).WithError(logErr).Error("fail to record delivery attempt")
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, errors.Wrap(err, "fail to build request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hook-manager")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.ID)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, delivery.Payload))
+	}
+	entry.Headers = req.Header
+
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return 0, errors.Wrap(err, "fail to send request")
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64*1024))
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// backoff is the exponential delay before the next attempt
+func (w *Worker) backoff(attempts int) time.Duration {
+	backoff := float64(w.backoffBase) * math.Pow(2, float64(attempts-1))
+	if backoff > float64(maxBackoff) {
+		return maxBackoff
+	}
+	return time.Duration(backoff)
+}
+
+// lease is the time a worker owns a claimed delivery. It must outlast a
+// delivery attempt.
+func (w *Worker) lease() time.Duration {
+	return 2*w.Client.Timeout + time.Minute
+}
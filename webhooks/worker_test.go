@@ -0,0 +1,295 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/health"
+	"github.com/curzolapierre/hook-manager/metrics"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+)
+
+func newTestWorker(t *testing.T, maxAttempts int) *Worker {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return NewWorker(client, config.Config{
+		WebhookTimeout:      5,
+		WebhookConcurrency:  1,
+		WebhookMaxAttempts:  maxAttempts,
+		WebhookBackoffBase:  0,
+		WebhookPollInterval: 10,
+	})
+}
+
+// receiver answers the statuses in turn and records the requests
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	rcv.requests = append(rcv.requests, r)
+	rcv.bodies = append(rcv.bodies, body)
+	status := http.StatusOK
+	if len(rcv.statuses) > 0 {
+		status, rcv.statuses = rcv.statuses[0], rcv.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// processDue processes the deliveries due now, it returns how many there were
+func processDue(t *testing.T, w *Worker) int {
+	t.Helper()
+	ctx := context.Background()
+	n := 0
+	for {
+		delivery, err := w.Store.Claim(ctx, w.lease())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivery == nil {
+			return n
+		}
+		w.process(ctx, *delivery)
+		n++
+	}
+}
+
+func addWebhook(t *testing.T, w *Worker, url string) *models.Webhook {
+	t.Helper()
+	webhook := &models.Webhook{URL: url, Secret: "s3cr3t", Events: []string{models.EventExcuseCreated}}
+	err := w.Store.Add(context.Background(), "guild", webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '{"a":1}' | openssl dgst -sha256 -hmac key
+	want := "sha256=88a67f24bbcdaed0e6c997404bb79a743baf44c6bab2f4c27328e3009d22e342"
+	if got := Sign("key", []byte(`{"a":1}`)); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestWorkerSignsAndRetries(t *testing.T) {
+	w := newTestWorker(t, 3)
+	rcv := &receiver{statuses: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	webhook := addWebhook(t, w, server.URL)
+
+	ctx := context.Background()
+	payload := []byte(`{"type":"excuse.created"}`)
+	err := w.Store.Enqueue(ctx, "guild", models.EventExcuseCreated, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not subscribed
+	err = w.Store.Enqueue(ctx, "guild", models.EventExcuseDeleted, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed attempt is rescheduled right away as the backoff base is 0
+	if n := processDue(t, w); n != 2 {
+		t.Fatalf("got %d attempts, want 2", n)
+	}
+	if n := processDue(t, w); n != 0 {
+		t.Fatalf("got %d deliveries once delivered, want none", n)
+	}
+
+	if len(rcv.requests) != 2 {
+		t.Fatalf("got %d requests, want 2", len(rcv.requests))
+	}
+	for i, r := range rcv.requests {
+		if got := r.Header.Get(SignatureHeader); got != Sign(webhook.Secret, rcv.bodies[i]) {
+			t.Errorf("request %d: got the signature %s, want the one of the body", i, got)
+		}
+		if r.Header.Get(EventHeader) != models.EventExcuseCreated || r.Header.Get(DeliveryHeader) == "" {
+			t.Errorf("request %d: missing event or delivery header: %v", i, r.Header)
+		}
+	}
+	if rcv.requests[0].Header.Get(DeliveryHeader) != rcv.requests[1].Header.Get(DeliveryHeader) {
+		t.Error("the retry has another delivery ID")
+	}
+
+	deadLetters, err := w.Store.DeadLetters(ctx, "guild")
+	if err != nil || len(deadLetters) != 0 {
+		t.Fatalf("got the dead letters %v, %v, want none", deadLetters, err)
+	}
+}
+
+func TestWorkerDeadLettersAndReplay(t *testing.T) {
+	w := newTestWorker(t, 2)
+	rcv := &receiver{statuses: []int{http.StatusBadGateway, http.StatusGone}}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	addWebhook(t, w, server.URL)
+
+	ctx := context.Background()
+	err := w.Store.Enqueue(ctx, "guild", models.EventExcuseCreated, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := processDue(t, w); n != 2 {
+		t.Fatalf("got %d attempts, want 2", n)
+	}
+
+	deadLetters, err := w.Store.DeadLetters(ctx, "guild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("got %d dead letters, want 1", len(deadLetters))
+	}
+	letter := deadLetters[0]
+	if letter.Attempts != 2 || letter.LastStatus != http.StatusGone || letter.FailedAt == nil {
+		t.Fatalf("got the dead letter %+v, want 2 attempts ending with 410", letter)
+	}
+
+	replayed, err := w.Store.Replay(ctx, "guild", letter.ID)
+	if err != nil || !replayed {
+		t.Fatalf("got %v, %v on replay, want it replayed", replayed, err)
+	}
+	if n := processDue(t, w); n != 1 {
+		t.Fatalf("got %d deliveries after the replay, want 1", n)
+	}
+	deadLetters, _ = w.Store.DeadLetters(ctx, "guild")
+	if len(deadLetters) != 0 || len(rcv.requests) != 3 {
+		t.Fatalf("got %d dead letters and %d requests after the replay, want 0 and 3", len(deadLetters), len(rcv.requests))
+	}
+}
+
+func TestWorkerDropsDeliveriesOfDeletedWebhooks(t *testing.T) {
+	w := newTestWorker(t, 3)
+	rcv := &receiver{}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	webhook := addWebhook(t, w, server.URL)
+
+	ctx := context.Background()
+	err := w.Store.Enqueue(ctx, "guild", models.EventExcuseCreated, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = w.Store.Delete(ctx, "guild", webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processDue(t, w)
+	if n := processDue(t, w); n != 0 || len(rcv.requests) != 0 {
+		t.Fatalf("got %d deliveries and %d requests, want the delivery dropped", n, len(rcv.requests))
+	}
+}
+
+// deliveryOutcomes reads the counters of the delivery attempts by outcome
+func deliveryOutcomes(t *testing.T) map[string]int {
+	t.Helper()
+	var buf bytes.Buffer
+	err := metrics.Default.WriteTo(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := map[string]int{}
+	for _, outcome := range []string{"delivered", "retried", "dead_lettered", "dropped"} {
+		prefix := fmt.Sprintf(`hook_manager_webhook_deliveries_total{outcome="%s"} `, outcome)
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, prefix) {
+				outcomes[outcome], _ = strconv.Atoi(strings.TrimPrefix(line, prefix))
+			}
+		}
+	}
+	return outcomes
+}
+
+func TestWorkerDeliveryOutcomes(t *testing.T) {
+	w := newTestWorker(t, 2)
+	rcv := &receiver{statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+	webhook := addWebhook(t, w, server.URL)
+	before := deliveryOutcomes(t)
+
+	ctx := context.Background()
+	enqueue := func() {
+		err := w.Store.Enqueue(ctx, "guild", models.EventExcuseCreated, []byte(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Retried then dead-lettered, retried then delivered, then dropped
+	enqueue()
+	processDue(t, w)
+	enqueue()
+	processDue(t, w)
+	enqueue()
+	_, err := w.Store.Delete(ctx, "guild", webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processDue(t, w)
+
+	after := deliveryOutcomes(t)
+	want := map[string]int{"delivered": 1, "retried": 2, "dead_lettered": 1, "dropped": 1}
+	for outcome, n := range want {
+		if got := after[outcome] - before[outcome]; got != n {
+			t.Errorf("%s: got %d, want %d", outcome, got, n)
+		}
+	}
+}
+
+func TestWorkerCheck(t *testing.T) {
+	w := newTestWorker(t, 3)
+	checker := health.NewChecker(time.Second)
+	checker.Register("webhook_workers", w.Check)
+	ctx := context.Background()
+
+	if report := checker.Run(ctx); report.Status != health.StatusDegraded {
+		t.Errorf("got %+v before the start, want degraded", report)
+	}
+	w.beat()
+	if report := checker.Run(ctx); report.Status != health.StatusUp {
+		t.Errorf("got %+v after a poll, want up", report)
+	}
+	atomic.StoreInt64(&w.heartbeat, time.Now().Add(-2*w.Client.Timeout-time.Second).UnixNano())
+	if report := checker.Run(ctx); report.Status != health.StatusDegraded {
+		t.Errorf("got %+v after a stale poll, want degraded", report)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	w := &Worker{backoffBase: time.Second}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 20: maxBackoff} {
+		if got := w.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/tracing"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of a request, the one of the caller is kept
+// when it is valid
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs accepted from the callers
+const maxRequestIDLength = 128
+
+// accessLog is filled while serving a request with what the access log line
+// can't read from the request
+type accessLog struct {
+	client string
+}
+
+type accessLogContextKey struct{}
+
+// setAccessLogClient records the API client of the request for its access
+// log line
+func setAccessLogClient(ctx context.Context, client string) {
+	if entry, ok := ctx.Value(accessLogContextKey{}).(*accessLog); ok {
+		entry.client = client
+	}
+}
+
+// observe gives an ID to each request and a child of log with this ID in its
+// context, serves it in a span continuing the trace of the traceparent
+// header, then writes the access log line of the request and its metrics
+func observe(next http.Handler, log logrus.FieldLogger, routes routeTemplates, trustProxy bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		route := routes.of(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(serverSpanAttributes(route, r)...),
+			trace.WithAttributes(semconv.NetAttributesFromHTTPRequest("tcp", r)...),
+		)
+		defer span.End()
+
+		log := log.WithField("request_id", id).WithFields(tracing.LogFields(ctx))
+		entry := &accessLog{}
+		ctx = logger.ToCtx(ctx, log)
+		ctx = controllers.WithRequestID(ctx, id)
+		ctx = context.WithValue(ctx, accessLogContextKey{}, entry)
+
+		rw := negroni.NewResponseWriter(w)
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		duration := time.Since(start)
+		status := rw.Status()
+		if status == 0 {
+			// Nothing written, or the connection was hijacked by a websocket
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(status)...)
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(status, trace.SpanKindServer))
+		if entry.client != "" {
+			span.SetAttributes(semconv.EnduserIDKey.String(entry.client))
+		}
+		recordHTTPMetrics(route, r.Method, status, duration)
+
+		log.WithFields(logrus.Fields{
+			"method":      r.Method,
+			"route":       route,
+			"status":      status,
+			"bytes":       rw.Size(),
+			"duration_ms": float64(duration.Microseconds()) / 1000,
+			"client":      entry.client,
+			"ip":          controllers.ClientIP(r, trustProxy),
+			"user_agent":  r.UserAgent(),
+		}).Info("request")
+	})
+}
+
+// serverSpanAttributes describes r by its route template rather than its URI,
+// the path and the query may carry tokens, such as the ones of the private
+// feeds and of the Docker Hub hooks
+func serverSpanAttributes(route string, r *http.Request) []attribute.KeyValue {
+	attrs := semconv.HTTPServerAttributesFromHTTPRequest("", route, r)
+	for i, attr := range attrs {
+		if attr.Key == semconv.HTTPTargetKey {
+			attrs[i] = semconv.HTTPTargetKey.String(route)
+		}
+	}
+	return attrs
+}
+
+// validRequestID accepts the IDs of printable ASCII characters, so that they
+// can't forge log lines
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,231 @@
+package webserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/tracing"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newObservedRouter serves /api/excuses/{id} through observe, the log lines
+// are written in JSON to the returned buffer
+func newObservedRouter(handler http.HandlerFunc) (http.Handler, *bytes.Buffer) {
+	var buf bytes.Buffer
+	log := logrus.New()
+	log.Out = &buf
+	log.Formatter = &logrus.JSONFormatter{}
+	log.SetLevel(logrus.DebugLevel)
+
+	top := mux.NewRouter()
+	api := mux.NewRouter().PathPrefix("/api").Subrouter()
+	api.HandleFunc("/excuses/{id}", handler).Methods("GET")
+	top.PathPrefix("/api").Handler(api)
+	routes := routeTemplates{top: top, subrouters: map[string]*mux.Router{"/api": api}}
+	return observe(top, log, routes, false), &buf
+}
+
+// logLines parses the JSON log lines of buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		line := map[string]interface{}{}
+		err := json.Unmarshal(scanner.Bytes(), &line)
+		if err != nil {
+			t.Fatalf("got the log line %q, want JSON: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestObserve(t *testing.T) {
+	var requestID string
+	router, buf := newObservedRouter(func(w http.ResponseWriter, r *http.Request) {
+		requestID = controllers.RequestIDFromContext(r.Context())
+		setAccessLogClient(r.Context(), "bot")
+		logger.Get(r.Context()).Debug("handled")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/excuses/42", nil)
+	r.Header.Set(RequestIDHeader, "req-1")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, r)
+
+	if got := recorder.Header().Get(RequestIDHeader); got != "req-1" || requestID != "req-1" {
+		t.Errorf("got the request ID %q in the response and %q in the context, want the one of the caller", got, requestID)
+	}
+	lines := logLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("got %d log lines, want the one of the handler and the access log", len(lines))
+	}
+	if handled := lines[0]; handled["msg"] != "handled" || handled["request_id"] != "req-1" {
+		t.Errorf("got %v, want the line of the handler with the request ID", handled)
+	}
+	want := map[string]interface{}{
+		"msg":        "request",
+		"level":      "info",
+		"request_id": "req-1",
+		"method":     "GET",
+		"route":      "/api/excuses/{id}",
+		"status":     float64(201),
+		"bytes":      float64(5),
+		"client":     "bot",
+	}
+	access := lines[1]
+	for field, value := range want {
+		if access[field] != value {
+			t.Errorf("%s: got %v, want %v", field, access[field], value)
+		}
+	}
+	// The path may carry secrets, such as the token of the Docker Hub hooks
+	if path, ok := access["path"]; ok {
+		t.Errorf("got the path %v, want only the route template", path)
+	}
+	if _, ok := access["duration_ms"].(float64); !ok {
+		t.Errorf("got the duration %v, want a number of milliseconds", access["duration_ms"])
+	}
+}
+
+func TestObserveGeneratesRequestIDs(t *testing.T) {
+	router, buf := newObservedRouter(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{"none", ""},
+		{"newline", "req\nlevel=error"},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	ids := map[string]bool{}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+		if test.id != "" {
+			r.Header.Set(RequestIDHeader, test.id)
+		}
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+
+		got := recorder.Header().Get(RequestIDHeader)
+		if got == "" || got == test.id || ids[got] {
+			t.Errorf("%s: got the request ID %q, want a new one", test.name, got)
+		}
+		ids[got] = true
+	}
+
+	// An unrouted request without a status written is logged as 404, a
+	// handler writing nothing as 200
+	lines := logLines(t, buf)
+	for _, line := range lines {
+		if line["route"] != "unmatched" || line["status"] != float64(404) {
+			t.Errorf("got %v, want an unmatched 404", line)
+		}
+	}
+	buf.Reset()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/excuses/1", nil))
+	if lines := logLines(t, buf); len(lines) != 1 || lines[0]["status"] != float64(200) {
+		t.Errorf("got %v, want a 200", lines)
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"3f8e1c2a-5b7d-4e9f-a1c3-d5e7f9b1c3e5", true},
+		{"req_1:~!", true},
+		{strings.Repeat("a", maxRequestIDLength), true},
+		{"", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+		{"with space", false},
+		{"tab\t", false},
+		{"é", false},
+		{"del\x7f", false},
+	}
+	for _, test := range tests {
+		if got := validRequestID(test.id); got != test.valid {
+			t.Errorf("%q: got %v, want %v", test.id, got, test.valid)
+		}
+	}
+}
+
+func TestObserveTracing(t *testing.T) {
+	_, err := tracing.Init(context.Background(), config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	var handlerSpan trace.SpanContext
+	router, buf := newObservedRouter(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		setAccessLogClient(r.Context(), "bot")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/api/excuses/42?token=s3cr3t", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("got %d spans, want the one of the request", len(ended))
+	}
+	span := ended[0]
+	if span.Name() != "GET /api/excuses/{id}" || span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("got the %v span %s, want the server span of the route", span.SpanKind(), span.Name())
+	}
+	if span.Parent().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent().SpanID().String() != "00f067aa0ba902b7" || !span.Parent().IsRemote() {
+		t.Errorf("got the parent %+v, want the span of the traceparent header", span.Parent())
+	}
+	if handlerSpan.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("got the span %s in the handler, want %s", handlerSpan.SpanID(), span.SpanContext().SpanID())
+	}
+	if span.Status().Code != codes.Error {
+		t.Errorf("got the status %v for a 500, want an error", span.Status())
+	}
+	attributes := map[string]string{}
+	for _, attr := range span.Attributes() {
+		attributes[string(attr.Key)] = attr.Value.Emit()
+		if strings.Contains(attr.Value.Emit(), "s3cr3t") {
+			t.Errorf("got the token in the attribute %s", attr.Key)
+		}
+	}
+	// The target is the route template, so that the tokens of the paths and
+	// queries are not exported
+	for key, want := range map[string]string{"http.route": "/api/excuses/{id}", "http.target": "/api/excuses/{id}", "http.status_code": "500", "enduser.id": "bot"} {
+		if attributes[key] != want {
+			t.Errorf("%s: got %q, want %q", key, attributes[key], want)
+		}
+	}
+
+	lines := logLines(t, buf)
+	if len(lines) != 1 || lines[0]["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" || lines[0]["span_id"] != span.SpanContext().SpanID().String() {
+		t.Errorf("got %v, want the access log line with the IDs of the span", lines)
+	}
+}
@@ -0,0 +1,32 @@
+package webserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+const ActingUserHeader = "X-Acting-User"
+
+// ActingUser attaches to the request context the Discord user on behalf of
+// whom the client makes the request, given by the X-Acting-User header. Only
+// the clients with the users:act scope may send it. It runs after the
+// Authenticator.
+func ActingUser(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	userID := strings.TrimSpace(r.Header.Get(ActingUserHeader))
+	if userID == "" {
+		next(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	if !models.APIClientFromContext(ctx).HasScope(models.ScopeActingUser) {
+		endAPICall(w, http.StatusForbidden, errorResp{
+			Message: "the client lacks the scope " + models.ScopeActingUser + " to send " + ActingUserHeader,
+			Code:    "acting_user_not_trusted",
+		})
+		return
+	}
+	next(w, r.WithContext(models.WithActingUser(ctx, userID)))
+}
@@ -0,0 +1,40 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+func TestActingUser(t *testing.T) {
+	trusted := &models.APIClient{Scopes: []string{models.ScopeActingUser}}
+	untrusted := &models.APIClient{Scopes: []string{models.ScopeExcusesWrite}}
+
+	tests := []struct {
+		name       string
+		client     *models.APIClient
+		header     string
+		status     int
+		actingUser string
+	}{
+		{"trusted client", trusted, " 42 ", http.StatusOK, "42"},
+		{"untrusted client", untrusted, "42", http.StatusForbidden, ""},
+		{"no header", untrusted, "", http.StatusOK, ""},
+		{"admin", &models.APIClient{Scopes: []string{models.ScopeAdmin}}, "42", http.StatusOK, "42"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodDelete, "/api/v1/codexcuses/guild/1", nil)
+		r.Header.Set(ActingUserHeader, test.header)
+		r = r.WithContext(models.WithAPIClient(r.Context(), test.client))
+		actingUser := ""
+		recorder := httptest.NewRecorder()
+		ActingUser(recorder, r, func(w http.ResponseWriter, r *http.Request) {
+			actingUser = models.ActingUserFromContext(r.Context())
+		})
+		if recorder.Code != test.status || actingUser != test.actingUser {
+			t.Errorf("%s: got %d acting as %q, want %d acting as %q", test.name, recorder.Code, actingUser, test.status, test.actingUser)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package webserver
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+)
+
+// Audit records the mutating requests in the audit log of their source: a
+// record per change of excuse, or a record of the request when it changed
+// none. It runs once the request is authorized.
+func Audit(store *models.RedisStoreAudit, trustProxy bool) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := r.Context()
+			log := logger.Get(ctx)
+
+			rw, ok := w.(negroni.ResponseWriter)
+			if !ok {
+				rw = negroni.NewResponseWriter(w)
+			}
+			trail := &models.AuditTrail{}
+			next.ServeHTTP(rw, r.WithContext(models.WithAuditTrail(ctx, trail)))
+
+			template := r.URL.Path
+			if route := mux.CurrentRoute(r); route != nil {
+				template, _ = route.GetPathTemplate()
+			}
+			// A handler writing nothing is answered with 200
+			status := rw.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			vars := mux.Vars(r)
+			// The {id} of the routes of the excuses and of the admin UI is the
+			// ID of an excuse, the request is found by it even if it failed
+			excuseID := ""
+			if strings.Contains(template, "/codexcuses/") || strings.HasPrefix(template, "/admin/") {
+				excuseID = vars["id"]
+			}
+			err := store.RecordTrail(ctx, models.AuditRecord{
+				Action:     r.Method + " " + template,
+				Client:     models.APIClientFromContext(ctx).Name,
+				ActingUser: models.ActingUserFromContext(ctx),
+				Source:     vars["source"],
+				ExcuseID:   excuseID,
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				StatusCode: status,
+				RequestID:  controllers.RequestIDFromContext(ctx),
+				IP:         controllers.ClientIP(r, trustProxy),
+			}, trail)
+			if err != nil {
+				log.WithError(err).Error("fail to record audit log")
+			}
+		})
+	}
+}
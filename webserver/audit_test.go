@@ -0,0 +1,66 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func TestAudit(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	store := &models.RedisStoreAudit{Client: redisClient}
+	excuses := &models.RedisStoreCodexcuses{Client: redisClient}
+	excuse := models.Codexcuse{Title: "t", Content: "c", Author: &models.User{UserName: "a"}, Reporter: &models.User{ID: "1", UserName: "r"}}
+	err := excuses.Add(context.Background(), "guild", &excuse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(Audit(store, true))
+	router.HandleFunc("/codexcuses/{source}/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			excuses.Delete(r.Context(), "guild", mux.Vars(r)["id"])
+		}
+	}).Methods("GET", "DELETE")
+	router.HandleFunc("/sources/{source}/feed", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}).Methods("PUT")
+
+	serve := func(method, path string) {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("X-Real-IP", "203.0.113.7")
+		ctx := models.WithAPIClient(r.Context(), &models.APIClient{Name: "bot"})
+		ctx = models.WithActingUser(ctx, "1")
+		ctx = controllers.WithRequestID(ctx, "req-"+method)
+		router.ServeHTTP(httptest.NewRecorder(), r.WithContext(ctx))
+	}
+	serve("GET", "/codexcuses/guild/"+excuse.ID)
+	serve("DELETE", "/codexcuses/guild/"+excuse.ID)
+	serve("PUT", "/sources/guild/feed")
+
+	records, err := store.List(context.Background(), "guild", models.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want the ones of the DELETE and the PUT", len(records))
+	}
+	feed, deletion := records[0], records[1]
+	if feed.Action != "PUT /sources/{source}/feed" || feed.StatusCode != http.StatusUnprocessableEntity || feed.Path != "/sources/guild/feed" {
+		t.Errorf("got %+v, want the record of the failed request", feed)
+	}
+	if deletion.Action != models.AuditExcuseDeleted || deletion.ExcuseID != excuse.ID || deletion.Before == nil || deletion.StatusCode != http.StatusOK {
+		t.Errorf("got %+v, want the deletion of the excuse", deletion)
+	}
+	for _, record := range records {
+		if record.Client != "bot" || record.ActingUser != "1" || record.IP != "203.0.113.7" || record.RequestID != "req-"+record.Method {
+			t.Errorf("got %+v, want the client, acting user, IP and request ID", record)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package webserver
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/jwt"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+// unknownClient is verified in place of the clients not registered, so that
+// the response time does not tell which names exist. Its hash has the cost of
+// the registered ones and matches no secret given to a client.
+var unknownClient = &models.APIClient{
+	SecretHash: "$2a$10$7bUZYjMVK1e1./Ajj6SdseoRh9VEmqN7Trctu2fVh5vyco2WV.ltW",
+}
+
+// Authenticator identifies the API client of a request with Basic Auth, a
+// bearer JWT or a verified client certificate and attaches it to the request
+// context. The pair of BASIC_AUTH_API_USER and BASIC_AUTH_API_PASS, when set,
+// is a client with every scope on every source. The client of a JWT is named
+// after its subject and gets the scopes and the sources of its claims. The
+// client of a certificate is the one registered with its subject.
+type Authenticator struct {
+	Store    *models.RedisStoreAPIClients
+	RootUser string
+	RootPass string
+	Realm    string
+	// JWT is nil when bearer tokens are not accepted
+	JWT *jwt.Verifier
+
+	// verified keeps the SHA-256 of the secrets matching a bcrypt hash, so
+	// that bcrypt only runs on the first request of a client. It is keyed by
+	// client name and hash, a rotation makes the entry unused.
+	verified sync.Map
+}
+
+func (a *Authenticator) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	if token := bearerToken(r); token != "" {
+		a.serveJWT(w, r, token, next)
+		return
+	}
+
+	name, secret, ok := r.BasicAuth()
+	if !ok {
+		a.serveCertificate(w, r, next)
+		return
+	}
+
+	if a.RootUser != "" && a.RootPass != "" &&
+		subtle.ConstantTimeCompare([]byte(name), []byte(a.RootUser)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(secret), []byte(a.RootPass)) == 1 {
+		serveClient(w, r, next, &models.APIClient{
+			Name:    a.RootUser,
+			Scopes:  []string{models.ScopeAdmin},
+			Sources: []string{models.AllSources},
+		})
+		return
+	}
+
+	client, err := a.Store.Get(ctx, name)
+	if err != nil {
+		log.WithError(err).Error("fail to get API client")
+		endAPICall(w, http.StatusInternalServerError, errorResp{
+			Message: "Internal error",
+		})
+		return
+	}
+	if client == nil {
+		unknownClient.VerifySecret(secret)
+		a.unauthorized(w)
+		return
+	}
+	if !a.verify(client, secret) {
+		a.unauthorized(w)
+		return
+	}
+	serveClient(w, r, next, client)
+}
+
+func (a *Authenticator) serveJWT(w http.ResponseWriter, r *http.Request, token string, next http.HandlerFunc) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	if a.JWT == nil {
+		a.unauthorized(w)
+		return
+	}
+	claims, err := a.JWT.Verify(token, time.Now())
+	if err != nil {
+		log.WithError(err).Info("invalid bearer token")
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+a.Realm+`", error="invalid_token"`)
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+		return
+	}
+
+	// The colon keeps the JWT subjects apart from the Basic Auth users
+	serveClient(w, r, next, &models.APIClient{
+		Name:    "jwt:" + claims.Subject,
+		Scopes:  claims.Scopes,
+		Sources: claims.Sources,
+	})
+}
+
+// serveCertificate authenticates the requests without credentials with their
+// client certificate, verified against the CA bundle during the handshake
+func (a *Authenticator) serveCertificate(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		a.unauthorized(w)
+		return
+	}
+	subject := r.TLS.VerifiedChains[0][0].Subject.String()
+	client, err := a.Store.GetByCertSubject(ctx, subject)
+	if err != nil {
+		log.WithError(err).Error("fail to get API client of certificate")
+		endAPICall(w, http.StatusInternalServerError, errorResp{
+			Message: "Internal error",
+		})
+		return
+	}
+	if client == nil {
+		log.Infoln("no API client for certificate subject:", subject)
+		a.unauthorized(w)
+		return
+	}
+	serveClient(w, r, next, client)
+}
+
+// serveClient serves the request as client, whose name is added to the
+// logger of the request
+func serveClient(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, client *models.APIClient) {
+	ctx := models.WithAPIClient(r.Context(), client)
+	ctx = logger.ToCtx(ctx, logger.Get(ctx).WithField("client", client.Name))
+	setAccessLogClient(ctx, client.Name)
+	next(w, r.WithContext(ctx))
+}
+
+func (a *Authenticator) verify(client *models.APIClient, secret string) bool {
+	key := client.Name + "\x00" + client.SecretHash
+	sum := sha256.Sum256([]byte(secret))
+	if known, ok := a.verified.Load(key); ok {
+		knownSum := known.([sha256.Size]byte)
+		return subtle.ConstantTimeCompare(knownSum[:], sum[:]) == 1
+	}
+	if !client.VerifySecret(secret) {
+		return false
+	}
+	a.verified.Store(key, sum)
+	return true
+}
+
+func (a *Authenticator) unauthorized(w http.ResponseWriter) {
+	w.Header().Add("WWW-Authenticate", `Basic realm="`+a.Realm+`"`)
+	if a.JWT != nil {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="`+a.Realm+`"`)
+	}
+	w.WriteHeader(401)
+	w.Write([]byte("401 Unauthorized\n"))
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	return ""
+}
+
+// NewJWTVerifier returns the verifier of the bearer tokens, nil when they are
+// not configured
+func NewJWTVerifier(config config.Config) *jwt.Verifier {
+	if config.JWTIssuer == "" || (config.JWKSURL == "" && config.JWKSFile == "") {
+		return nil
+	}
+	return &jwt.Verifier{
+		Issuer:       config.JWTIssuer,
+		Audience:     config.JWTAudience,
+		SourcesClaim: config.JWTSourcesClaim,
+		Leeway:       time.Duration(config.JWTLeeway) * time.Second,
+		Keys: &jwt.JWKS{
+			URL:             config.JWKSURL,
+			File:            config.JWKSFile,
+			RefreshInterval: time.Duration(config.JWKSRefresh) * time.Second,
+			Client: &http.Client{
+				Timeout: 10 * time.Second,
+			},
+		},
+	}
+}
+
+// Authorize rejects the requests of the clients without the scope of the
+// route, or without access to the source of the route
+func Authorize(scopeOf func(r *http.Request) string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := models.APIClientFromContext(r.Context())
+
+			scope := scopeOf(r)
+			if !client.HasScope(scope) {
+				endAPICall(w, http.StatusForbidden, errorResp{
+					Message: "the client lacks the scope " + scope,
+				})
+				return
+			}
+			if source, ok := mux.Vars(r)["source"]; ok && !client.CanAccess(source) {
+				endAPICall(w, http.StatusForbidden, errorResp{
+					Message: "the client has no access to the source " + source,
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiScope is the scope required by a route of the API: reading or writing
+// the excuses, and admin for the settings of the sources
+func apiScope(r *http.Request) string {
+	template := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+
+	switch {
+	case strings.HasSuffix(template, "/ws"):
+		return models.ScopeExcusesRead
+	case strings.Contains(template, "/codexcuses/"):
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return models.ScopeExcusesRead
+		}
+		return models.ScopeExcusesWrite
+	default:
+		return models.ScopeAdmin
+	}
+}
+
+func adminScope(r *http.Request) string {
+	return models.ScopeAdmin
+}
@@ -0,0 +1,118 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// authenticate serves a request with the credentials through a, it returns
+// the status and the name of the client authenticated
+func authenticate(a *Authenticator, name, secret string) (int, string) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/sources", nil)
+	r.SetBasicAuth(name, secret)
+	client := ""
+	recorder := httptest.NewRecorder()
+	a.ServeHTTP(recorder, r, func(w http.ResponseWriter, r *http.Request) {
+		client = models.APIClientFromContext(r.Context()).Name
+	})
+	return recorder.Code, client
+}
+
+func TestAuthenticator(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	store := &models.RedisStoreAPIClients{Client: redisClient}
+	err := store.Create(context.Background(), &models.APIClient{Name: "bot"}, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Authenticator{Store: store, RootUser: "root", RootPass: "r00t", Realm: "test"}
+
+	tests := []struct {
+		name   string
+		user   string
+		secret string
+		status int
+		client string
+	}{
+		{"root", "root", "r00t", http.StatusOK, "root"},
+		{"root with a wrong password", "root", "wrong", http.StatusUnauthorized, ""},
+		{"client", "bot", "s3cr3t", http.StatusOK, "bot"},
+		{"client again", "bot", "s3cr3t", http.StatusOK, "bot"},
+		{"client with a wrong secret", "bot", "wrong", http.StatusUnauthorized, ""},
+		{"unknown client", "unknown", "s3cr3t", http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		status, client := authenticate(a, test.user, test.secret)
+		if status != test.status || client != test.client {
+			t.Errorf("%s: got %d as %q, want %d as %q", test.name, status, client, test.status, test.client)
+		}
+	}
+
+	// The secret verified before the rotation is not accepted anymore
+	_, err = store.Rotate(context.Background(), "bot", "n3w")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := authenticate(a, "bot", "s3cr3t"); status != http.StatusUnauthorized {
+		t.Errorf("got %d with the rotated secret, want 401", status)
+	}
+	if status, _ := authenticate(a, "bot", "n3w"); status != http.StatusOK {
+		t.Errorf("got %d with the new secret, want 200", status)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/sources", nil)
+	recorder := httptest.NewRecorder()
+	a.ServeHTTP(recorder, r, func(w http.ResponseWriter, r *http.Request) {})
+	if recorder.Code != http.StatusUnauthorized || recorder.Header().Get("WWW-Authenticate") != `Basic realm="test"` {
+		t.Errorf("got %d %v without credentials, want a Basic challenge", recorder.Code, recorder.Header())
+	}
+}
+
+func TestUnknownClientCostsAsMuchAsAClient(t *testing.T) {
+	cost, err := bcrypt.Cost([]byte(unknownClient.SecretHash))
+	if err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("got the cost %d, %v, want the one of the registered clients", cost, err)
+	}
+	if unknownClient.VerifySecret("") {
+		t.Fatal("the unknown client accepts an empty secret")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(Authorize(apiScope))
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/codexcuses/{source}", ok).Methods("GET", "POST")
+	router.HandleFunc("/sources/{source}/webhooks", ok).Methods("GET")
+
+	reader := &models.APIClient{Scopes: []string{models.ScopeExcusesRead}, Sources: []string{"guild"}}
+	admin := &models.APIClient{Scopes: []string{models.ScopeAdmin}, Sources: []string{models.AllSources}}
+	tests := []struct {
+		name   string
+		client *models.APIClient
+		method string
+		path   string
+		status int
+	}{
+		{"read", reader, http.MethodGet, "/codexcuses/guild", http.StatusOK},
+		{"write", reader, http.MethodPost, "/codexcuses/guild", http.StatusForbidden},
+		{"other source", reader, http.MethodGet, "/codexcuses/other", http.StatusForbidden},
+		{"settings", reader, http.MethodGet, "/sources/guild/webhooks", http.StatusForbidden},
+		{"admin", admin, http.MethodGet, "/sources/other/webhooks", http.StatusOK},
+		{"anonymous", nil, http.MethodGet, "/codexcuses/guild", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.path, nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r.WithContext(models.WithAPIClient(r.Context(), test.client)))
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
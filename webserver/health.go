@@ -0,0 +1,45 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/health"
+)
+
+type readinessResp struct {
+	Service     string          `json:"service"`
+	Environment string          `json:"environment"`
+	Status      string          `json:"status"`
+	Checks      []health.Result `json:"checks"`
+}
+
+// liveness answers as long as the process serves requests, whatever the state
+// of its dependencies
+func liveness(config config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		endAPICall(w, 200, heath{
+			Service:     "api",
+			Environment: config.GoEnv,
+			Status:      health.StatusUp,
+		})
+	}
+}
+
+// readiness runs the checks of checker. A degraded instance is still ready,
+// it is only unready when a check is down.
+func readiness(config config.Config, checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+		status := 200
+		if report.Status == health.StatusDown {
+			status = http.StatusServiceUnavailable
+		}
+		endAPICall(w, status, readinessResp{
+			Service:     "api",
+			Environment: config.GoEnv,
+			Status:      report.Status,
+			Checks:      report.Checks,
+		})
+	}
+}
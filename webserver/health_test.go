@@ -0,0 +1,81 @@
+package webserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/health"
+)
+
+func TestLiveness(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	liveness(config.Config{GoEnv: "test"}).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/live", nil))
+	var resp heath
+	err := json.Unmarshal(recorder.Body.Bytes(), &resp)
+	if err != nil || recorder.Code != http.StatusOK || resp.Status != health.StatusUp || resp.Environment != "test" {
+		t.Errorf("got %d %s, %v, want 200 and up", recorder.Code, recorder.Body, err)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   int
+		status string
+	}{
+		{"up", nil, http.StatusOK, health.StatusUp},
+		{"degraded", health.Degraded(errors.New("slow")), http.StatusOK, health.StatusDegraded},
+		{"down", errors.New("unreachable"), http.StatusServiceUnavailable, health.StatusDown},
+	}
+	for _, test := range tests {
+		checker := health.NewChecker(time.Second)
+		checker.Register("up", func(context.Context) error { return nil })
+		err := test.err
+		checker.Register("dependency", func(context.Context) error { return err })
+
+		recorder := httptest.NewRecorder()
+		readiness(config.Config{GoEnv: "test"}, checker).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+		var resp readinessResp
+		err = json.Unmarshal(recorder.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("%s: got %s, %v", test.name, recorder.Body, err)
+		}
+		if recorder.Code != test.code || resp.Status != test.status || len(resp.Checks) != 2 {
+			t.Errorf("%s: got %d %s, want %d and %s with 2 checks", test.name, recorder.Code, recorder.Body, test.code, test.status)
+		}
+		if dependency := resp.Checks[0]; dependency.Name != "dependency" || dependency.Status != test.status {
+			t.Errorf("%s: got the check %+v, want it %s", test.name, dependency, test.status)
+		}
+	}
+}
+
+func TestHealthRoutes(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	router := NewRouter(context.Background(), config.Config{}, redisClient)
+
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{http.MethodGet, "/health/ping", http.StatusOK},
+		{http.MethodGet, "/health/live", http.StatusOK},
+		{http.MethodHead, "/health/live", http.StatusOK},
+		{http.MethodPost, "/health/live", http.StatusMethodNotAllowed},
+		{http.MethodHead, "/health/ready", http.StatusOK},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, nil))
+		if recorder.Code != test.code {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.path, recorder.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	maxIdempotentRequestSize = 10 * 1024 * 1024
+	idempotencyLockTTL       = time.Minute
+)
+
+// idempotencyRecorder keeps the response written by a handler
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *idempotencyRecorder) WriteHeader(status int) {
+	if r.header == nil {
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	if r.header == nil {
+		r.WriteHeader(200)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotentCall tells Idempotency whether the request reached its handler
+type idempotentCall struct {
+	handled bool
+}
+
+type idempotencyContextKey struct{}
+
+// IdempotentHandler marks the requests reaching their route handler, it is
+// the last middleware of the routes. Idempotency only keeps the responses of
+// these requests, the rejections of the middlewares are not replayed.
+func IdempotentHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if call, ok := r.Context().Value(idempotencyContextKey{}).(*idempotentCall); ok {
+			call.handled = true
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Idempotency replays the response of a mutating request made again with the
+// same Idempotency-Key header. The keys are scoped by client, and a key
+// reused for another request is rejected. Only the responses of the handlers
+// are kept, and not the ones of the server errors, so that the request can be
+// retried. It runs after the Authenticator, ActingUser, Authorize and the rate
+// limits of the client, whose rejections do not reserve the key.
+func Idempotency(store *models.RedisStoreIdempotency, ttl time.Duration) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveIdempotent(store, ttl, w, r, next)
+		})
+	}
+}
+
+func serveIdempotent(store *models.RedisStoreIdempotency, ttl time.Duration, w http.ResponseWriter, r *http.Request, next http.Handler) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx := r.Context()
+	log := logger.Get(ctx).WithField("idempotency_key", key)
+	if len(key) > maxIdempotencyKeyLength {
+		endAPICall(w, http.StatusBadRequest, errorResp{
+			Message: "Idempotency-Key must be at most 255 characters",
+		})
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestSize))
+	if err != nil {
+		endAPICall(w, http.StatusRequestEntityTooLarge, errorResp{
+			Message: "fail to read body",
+		})
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n"))
+	// A key reused on behalf of another user is another request
+	hash.Write([]byte(models.ActingUserFromContext(ctx) + "\n"))
+	hash.Write(body)
+	fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+	client := models.APIClientFromContext(ctx).Name
+	existing, acquired, err := store.Begin(ctx, client, key, fingerprint, idempotencyLockTTL)
+	if err != nil {
+		log.WithError(err).Error("fail to reserve idempotency key")
+		endAPICall(w, http.StatusInternalServerError, errorResp{
+			Message: "Internal error",
+		})
+		return
+	}
+
+	if !acquired {
+		switch {
+		case existing.Fingerprint != fingerprint:
+			endAPICall(w, http.StatusUnprocessableEntity, errorResp{
+				Message: "Idempotency-Key has already been used for another request",
+			})
+		case existing.Pending:
+			endAPICall(w, http.StatusConflict, errorResp{
+				Message: "a request with this Idempotency-Key is in progress",
+			})
+		default:
+			log.Debugln("replaying idempotent request")
+			for name, values := range existing.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(existing.StatusCode)
+			w.Write(existing.Body)
+		}
+		return
+	}
+
+	recorder := &idempotencyRecorder{ResponseWriter: w, status: 200}
+	call := &idempotentCall{}
+	next.ServeHTTP(recorder, r.WithContext(context.WithValue(ctx, idempotencyContextKey{}, call)))
+
+	if !call.handled || recorder.status >= 500 {
+		err = store.Release(ctx, client, key)
+	} else {
+		err = store.Save(ctx, client, key, models.IdempotentRequest{
+			Fingerprint: fingerprint,
+			StatusCode:  recorder.status,
+			Header:      recorder.header,
+			Body:        recorder.body.Bytes(),
+			CreatedAt:   time.Now().UTC(),
+		}, ttl)
+	}
+	if err != nil {
+		log.WithError(err).Error("fail to save idempotent request")
+	}
+}
+
+type errorResp struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
@@ -0,0 +1,180 @@
+package webserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+	"github.com/urfave/negroni"
+)
+
+// newIdempotentServer serves the requests of the client named by the
+// X-Client header through Idempotency. The handler answers the number of
+// times it ran. The requests with X-Reject are rejected by a middleware
+// running before Idempotency, the ones with X-Forbid by a middleware running
+// after it.
+func newIdempotentServer(t *testing.T) (http.Handler, *models.RedisStoreIdempotency, *int) {
+	t.Helper()
+	_, redisClient := newTestRedis(t)
+	store := &models.RedisStoreIdempotency{Client: redisClient}
+
+	calls := 0
+	router := mux.NewRouter()
+	reject := func(header string, status int) mux.MiddlewareFunc {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get(header) != "" {
+					endAPICall(w, status, errorResp{Message: "rejected"})
+					return
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	router.Use(reject("X-Reject", http.StatusTooManyRequests), Idempotency(store, time.Hour),
+		reject("X-Forbid", http.StatusForbidden), IdempotentHandler)
+	router.HandleFunc("/excuses", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		status := http.StatusCreated
+		if string(body) == "fail" {
+			status = http.StatusInternalServerError
+		}
+		w.Header().Set("X-Call", fmt.Sprint(calls))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "call %d", calls)
+	}).Methods("POST", "GET")
+
+	n := negroni.New(negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		ctx := models.WithAPIClient(r.Context(), &models.APIClient{Name: r.Header.Get("X-Client")})
+		if user := r.Header.Get(ActingUserHeader); user != "" {
+			ctx = models.WithActingUser(ctx, user)
+		}
+		next(w, r.WithContext(ctx))
+	}), negroni.Wrap(router))
+	return n, store, &calls
+}
+
+func idempotentRequest(handler http.Handler, method, path, key, body string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Client", "bot")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	handler, _, calls := newIdempotentServer(t)
+
+	first := idempotentRequest(handler, "POST", "/excuses", "k1", "excuse")
+	if first.Code != http.StatusCreated || first.Body.String() != "call 1" {
+		t.Fatalf("got %d %s, want the first call", first.Code, first.Body)
+	}
+	replay := idempotentRequest(handler, "POST", "/excuses", "k1", "excuse")
+	if replay.Code != http.StatusCreated || replay.Body.String() != "call 1" || replay.Header().Get("X-Call") != "1" {
+		t.Errorf("got %d %s, want the response of the first call", replay.Code, replay.Body)
+	}
+	if replay.Header().Get(IdempotentReplayedHeader) != "true" || first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("got the %s headers %q and %q, want the replay flagged", IdempotentReplayedHeader,
+			first.Header().Get(IdempotentReplayedHeader), replay.Header().Get(IdempotentReplayedHeader))
+	}
+
+	// The keys are scoped by client, the requests without key are not kept
+	if got := idempotentRequest(handler, "POST", "/excuses", "k1", "excuse", "X-Client", "other"); got.Body.String() != "call 2" {
+		t.Errorf("got %s for another client, want a new call", got.Body)
+	}
+	idempotentRequest(handler, "POST", "/excuses", "", "excuse")
+	idempotentRequest(handler, "GET", "/excuses", "k2", "")
+	if got := idempotentRequest(handler, "GET", "/excuses", "k2", ""); got.Body.String() != "call 5" {
+		t.Errorf("got %s for a GET, want a new call", got.Body)
+	}
+	if *calls != 5 {
+		t.Errorf("got %d calls, want 5", *calls)
+	}
+}
+
+func TestIdempotencyFingerprint(t *testing.T) {
+	handler, _, calls := newIdempotentServer(t)
+	idempotentRequest(handler, "POST", "/excuses", "k1", "excuse", ActingUserHeader, "alice")
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		headers []string
+	}{
+		{"other body", "/excuses", "other excuse", []string{ActingUserHeader, "alice"}},
+		{"other query", "/excuses?force=true", "excuse", []string{ActingUserHeader, "alice"}},
+		{"other acting user", "/excuses", "excuse", []string{ActingUserHeader, "bob"}},
+		{"without acting user", "/excuses", "excuse", nil},
+	}
+	for _, test := range tests {
+		recorder := idempotentRequest(handler, "POST", test.path, "k1", test.body, test.headers...)
+		if recorder.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got %d %s, want 422", test.name, recorder.Code, recorder.Body)
+		}
+	}
+	if *calls != 1 {
+		t.Errorf("got %d calls, want 1", *calls)
+	}
+
+	long := strings.Repeat("k", maxIdempotencyKeyLength+1)
+	if recorder := idempotentRequest(handler, "POST", "/excuses", long, "excuse"); recorder.Code != http.StatusBadRequest {
+		t.Errorf("long key: got %d, want 400", recorder.Code)
+	}
+}
+
+func TestIdempotencyOnlyKeepsHandlerResponses(t *testing.T) {
+	handler, store, calls := newIdempotentServer(t)
+
+	// Neither the rejections of the middlewares nor the server errors are
+	// kept, the retries are run
+	rejected := idempotentRequest(handler, "POST", "/excuses", "k1", "excuse", "X-Reject", "true")
+	if rejected.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d, want 429", rejected.Code)
+	}
+	forbidden := idempotentRequest(handler, "POST", "/excuses", "k1", "excuse", "X-Forbid", "true")
+	if forbidden.Code != http.StatusForbidden {
+		t.Fatalf("got %d, want 403", forbidden.Code)
+	}
+	if got := idempotentRequest(handler, "POST", "/excuses", "k1", "excuse"); got.Code != http.StatusCreated || got.Body.String() != "call 1" {
+		t.Errorf("retry after the rejections: got %d %s, want the first call", got.Code, got.Body)
+	}
+
+	idempotentRequest(handler, "POST", "/excuses", "k2", "fail")
+	if got := idempotentRequest(handler, "POST", "/excuses", "k2", "fail"); got.Header().Get(IdempotentReplayedHeader) != "" || *calls != 3 {
+		t.Errorf("retry after a server error: got %s after %d calls, want it run again", got.Body, *calls)
+	}
+
+	_, acquired, err := store.Begin(context.Background(), "bot", "k3", "fingerprint", time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("got %v, %v, want the key reserved", acquired, err)
+	}
+	if got := idempotentRequest(handler, "POST", "/excuses", "k3", "excuse"); got.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reserved for another request: got %d, want 422", got.Code)
+	}
+
+	hash := sha256.Sum256([]byte("POST\n/excuses\n\nexcuse"))
+	_, acquired, err = store.Begin(context.Background(), "bot", "k4", hex.EncodeToString(hash[:]), time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("got %v, %v, want the key reserved", acquired, err)
+	}
+	if got := idempotentRequest(handler, "POST", "/excuses", "k4", "excuse"); got.Code != http.StatusConflict {
+		t.Errorf("request in progress: got %d %s, want 409", got.Code, got.Body)
+	}
+}
@@ -0,0 +1,56 @@
+package webserver
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/metrics"
+)
+
+var (
+	httpRequests = metrics.Default.NewCounter("hook_manager_http_requests_total",
+		"HTTP requests by route template, method and status.",
+		"route", "method", "status")
+	httpDuration = metrics.Default.NewHistogram("hook_manager_http_request_duration_seconds",
+		"Latency of the HTTP requests by route template, method and status.",
+		metrics.DefaultBuckets, "route", "method", "status")
+)
+
+// knownMethods are the methods counted under their name, the other ones are
+// counted as OTHER so that the callers can't add labels at will
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// recordHTTPMetrics counts and times a request
+func recordHTTPMetrics(route, method string, status int, duration time.Duration) {
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+	labels := []string{route, method, strconv.Itoa(status)}
+	httpRequests.Inc(labels...)
+	httpDuration.Observe(duration.Seconds(), labels...)
+}
+
+// metricsHandler serves the metrics of the default registry to the bearer of
+// token
+func metricsHandler(token string) http.Handler {
+	handler := metrics.Default.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			w.WriteHeader(401)
+			w.Write([]byte("401 Unauthorized\n"))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
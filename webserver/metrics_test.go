@@ -0,0 +1,116 @@
+package webserver
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/metrics"
+)
+
+// metricValue reads the value of the sample of the default registry starting
+// with prefix, "0" when there is none
+func metricValue(t *testing.T, prefix string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	err := metrics.Default.WriteTo(context.Background(), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, prefix+" ") {
+			return strings.TrimPrefix(line, prefix+" ")
+		}
+	}
+	return "0"
+}
+
+func TestMetricsHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{"no token", "t0k3n", "", http.StatusUnauthorized},
+		{"wrong token", "t0k3n", "Bearer wrong", http.StatusUnauthorized},
+		{"basic auth", "t0k3n", "Basic dDBrM246", http.StatusUnauthorized},
+		{"valid token", "t0k3n", "Bearer t0k3n", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		metricsHandler(test.token).ServeHTTP(recorder, r)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, recorder.Code, test.status)
+		}
+		if test.status == http.StatusUnauthorized && recorder.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: missing the WWW-Authenticate header", test.name)
+		}
+		if test.status == http.StatusOK && !strings.Contains(recorder.Body.String(), "# TYPE hook_manager_http_requests_total counter") {
+			t.Errorf("%s: got %q, want the HTTP metrics", test.name, recorder.Body)
+		}
+	}
+}
+
+func TestMetricsRoute(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"without token", "", http.StatusNotFound},
+		{"with token", "t0k3n", http.StatusOK},
+	}
+	for _, test := range tests {
+		router := NewRouter(context.Background(), config.Config{MetricsToken: test.token}, redisClient)
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Authorization", "Bearer "+test.token)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, r)
+		if recorder.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	router := NewRouter(context.Background(), config.Config{RateLimitIP: 100, RateLimitWindow: 60}, redisClient)
+
+	tests := []struct {
+		method string
+		path   string
+		series string
+	}{
+		{"POST", "/hooks/github/guild", `route="/hooks/github/{source}",method="POST",status="404"`},
+		{"GET", "/health/live", `route="/health/live",method="GET",status="200"`},
+		{"GET", "/nowhere/1", `route="unmatched",method="GET",status="404"`},
+		{"BREW", "/nowhere/2", `route="unmatched",method="OTHER",status="404"`},
+	}
+	for _, test := range tests {
+		requests := "hook_manager_http_requests_total{" + test.series + "}"
+		durations := "hook_manager_http_request_duration_seconds_count{" + test.series + "}"
+		before := metricValue(t, requests)
+
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(test.method, test.path, strings.NewReader(`{}`)))
+
+		after := metricValue(t, requests)
+		if before == after {
+			t.Errorf("%s %s: got %s requests before and after, want one more for %s", test.method, test.path, after, test.series)
+		}
+		if got := metricValue(t, durations); got != after {
+			t.Errorf("%s %s: got %s durations, want %s", test.method, test.path, got, after)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package webserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Scalingo/go-utils/logger"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// RateLimiter limits the requests of each client on each source, by route
+// class, and the requests of each IP address. The buckets are kept in Redis
+// so that the limits hold across the instances of the server. When Redis
+// fails, the requests are let through.
+type RateLimiter struct {
+	Store      *models.RedisStoreRateLimits
+	Window     time.Duration
+	Limits     models.RateLimits
+	IPLimit    int
+	TrustProxy bool
+}
+
+func NewRateLimiter(redisClient *redis.Client, config config.Config) *RateLimiter {
+	return &RateLimiter{
+		Store:      &models.RedisStoreRateLimits{Client: redisClient},
+		Window:     time.Duration(config.RateLimitWindow) * time.Second,
+		Limits:     config.RateLimits,
+		IPLimit:    config.RateLimitIP,
+		TrustProxy: config.TrustProxyHeaders,
+	}
+}
+
+// ByIP limits the requests of an IP address, it runs before the
+// Authenticator so that the secrets cannot be brute forced. Its headers are
+// only sent when the request is rejected, the ones of the client prevail.
+func (l *RateLimiter) ByIP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if l.IPLimit <= 0 {
+		next(w, r)
+		return
+	}
+	ctx := r.Context()
+	log := logger.Get(ctx)
+
+	limit, err := l.Store.Allow(ctx, "ip:"+controllers.ClientIP(r, l.TrustProxy), l.IPLimit, l.Window, time.Now())
+	if err != nil {
+		log.WithError(err).Error("fail to rate limit IP address")
+		next(w, r)
+		return
+	}
+	if !limit.Allowed {
+		l.setHeaders(w, limit)
+		tooManyRequests(w, limit)
+		return
+	}
+	next(w, r)
+}
+
+// ByClient limits the requests of the client of the context on the source of
+// the route. The limit of the route class is the one of the client, else the
+// one of the source, else the default one. The settings of the sources are
+// only limited by IP address, so that a source can't lock its admins out.
+func (l *RateLimiter) ByClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := logger.Get(ctx)
+
+		if apiScope(r) == models.ScopeAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, err := l.AllowClient(ctx, rateLimitClass(r), mux.Vars(r)["source"], models.APIClientFromContext(ctx))
+		if err != nil {
+			log.WithError(err).Error("fail to rate limit client")
+			next.ServeHTTP(w, r)
+			return
+		}
+		if limit.Limit == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		l.setHeaders(w, limit)
+		if !limit.Allowed {
+			tooManyRequests(w, limit)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// AllowClient counts a request of client on source in the bucket of the route
+// class. The limit is 0 when the class is not limited.
+func (l *RateLimiter) AllowClient(ctx context.Context, class, source string, client *models.APIClient) (models.RateLimit, error) {
+	max, err := l.limitOf(ctx, class, source, client)
+	if err != nil {
+		return models.RateLimit{}, errors.Wrap(err, "fail to get rate limits")
+	}
+	if max <= 0 {
+		return models.RateLimit{Allowed: true}, nil
+	}
+
+	clientName := ""
+	if client != nil {
+		clientName = client.Name
+	}
+	return l.Store.Allow(ctx, class+":"+source+":"+clientName, max, l.Window, time.Now())
+}
+
+func (l *RateLimiter) limitOf(ctx context.Context, class, source string, client *models.APIClient) (int, error) {
+	if client != nil {
+		if max, ok := client.RateLimits[class]; ok {
+			return max, nil
+		}
+	}
+	if source != "" {
+		limits, err := l.Store.GetSourceLimits(ctx, source)
+		if err != nil {
+			return 0, err
+		}
+		if max, ok := limits[class]; ok {
+			return max, nil
+		}
+	}
+	return l.Limits[class], nil
+}
+
+func (l *RateLimiter) setHeaders(w http.ResponseWriter, limit models.RateLimit) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(limit.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(limit.Reset)))
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Limit, int(l.Window/time.Second)))
+}
+
+func tooManyRequests(w http.ResponseWriter, limit models.RateLimit) {
+	retryAfter := ceilSeconds(limit.RetryAfter)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	endAPICall(w, http.StatusTooManyRequests, errorResp{
+		Message: "too many requests, retry in " + strconv.Itoa(retryAfter) + "s",
+		Code:    "rate_limited",
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// rateLimitClass is the class of a route of the API: the random excuses,
+// the other reads, and the writes
+func rateLimitClass(r *http.Request) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return models.RateLimitWrite
+	}
+	template := ""
+	if route := mux.CurrentRoute(r); route != nil {
+		template, _ = route.GetPathTemplate()
+	}
+	if strings.HasSuffix(template, "/codexcuses/{source}") && r.URL.Query().Get("random") != "" {
+		return models.RateLimitRandom
+	}
+	return models.RateLimitRead
+}
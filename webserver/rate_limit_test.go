@@ -0,0 +1,74 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/gorilla/mux"
+)
+
+func TestRateLimiterByClient(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	limiter := &RateLimiter{
+		Store:  &models.RedisStoreRateLimits{Client: redisClient},
+		Window: time.Minute,
+		Limits: models.RateLimits{models.RateLimitRead: 3, models.RateLimitWrite: 1, models.RateLimitRandom: 0},
+	}
+	err := limiter.Store.SetSourceLimits(context.Background(), "busy", models.RateLimits{models.RateLimitRead: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Use(limiter.ByClient)
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router.HandleFunc("/codexcuses/{source}", ok).Methods("GET", "POST")
+	router.HandleFunc("/sources/{source}/webhooks", ok).Methods("POST")
+
+	// allowed counts the requests let through out of n
+	allowed := func(client *models.APIClient, method, path string, n int) (int, *httptest.ResponseRecorder) {
+		count := 0
+		var recorder *httptest.ResponseRecorder
+		for i := 0; i < n; i++ {
+			r := httptest.NewRequest(method, path, nil)
+			recorder = httptest.NewRecorder()
+			router.ServeHTTP(recorder, r.WithContext(models.WithAPIClient(r.Context(), client)))
+			if recorder.Code == http.StatusOK {
+				count++
+			}
+		}
+		return count, recorder
+	}
+
+	bot := &models.APIClient{Name: "bot"}
+	tests := []struct {
+		name    string
+		client  *models.APIClient
+		method  string
+		path    string
+		allowed int
+	}{
+		{"default read limit", bot, "GET", "/codexcuses/guild", 3},
+		{"default write limit", bot, "POST", "/codexcuses/guild", 1},
+		{"random excuses not limited", bot, "GET", "/codexcuses/guild?random=true", 5},
+		{"limit of the source", bot, "GET", "/codexcuses/busy", 2},
+		{"limit of the client", &models.APIClient{Name: "vip", RateLimits: models.RateLimits{models.RateLimitRead: 4}}, "GET", "/codexcuses/busy", 4},
+		{"other client", &models.APIClient{Name: "other"}, "GET", "/codexcuses/guild", 3},
+		{"settings not limited", bot, "POST", "/sources/guild/webhooks", 5},
+	}
+	for _, test := range tests {
+		if got, _ := allowed(test.client, test.method, test.path, 5); got != test.allowed {
+			t.Errorf("%s: got %d requests allowed, want %d", test.name, got, test.allowed)
+		}
+	}
+
+	_, recorder := allowed(bot, "POST", "/codexcuses/guild", 1)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "60" ||
+		recorder.Header().Get("RateLimit-Policy") != "1;w=60" || recorder.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("got %d %v, want a 429 with the rate limit headers", recorder.Code, recorder.Header())
+	}
+}
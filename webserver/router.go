@@ -2,67 +2,257 @@ package webserver
 
 import (
 	"context"
-	"crypto/subtle"
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/Scalingo/go-utils/logger"
 	"github.com/curzolapierre/hook-manager/config"
 	"github.com/curzolapierre/hook-manager/controllers"
+	"github.com/curzolapierre/hook-manager/health"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
 	"github.com/gorilla/mux"
 	"github.com/urfave/negroni"
 )
 
-func NewRouter(ctx context.Context, config config.Config) *mux.Router {
+// NewRouter returns the handler of every route, which logs and measures the
+// requests
+func NewRouter(ctx context.Context, config config.Config, redisClient *redis.Client) http.Handler {
 	log := logger.Get(ctx)
-	username := config.BasicAuthApiUser
-	password := config.BasicAuthApiPass
+	authenticator := &Authenticator{
+		Store:    &models.RedisStoreAPIClients{Client: redisClient},
+		RootUser: config.BasicAuthApiUser,
+		RootPass: config.BasicAuthApiPass,
+		Realm:    "Provide user name and password",
+		JWT:      NewJWTVerifier(config),
+	}
+	rateLimiter := NewRateLimiter(redisClient, config)
+	audit := Audit(&models.RedisStoreAudit{Client: redisClient}, config.TrustProxyHeaders)
 
 	v1Path := "/api"
 	healthPath := "/health"
+	hooksPath := "/hooks"
+	discordPath := "/discord"
+	slackPath := "/slack"
+	adminPath := "/admin"
+	feedsPath := "/feeds"
+	metricsPath := "/metrics"
 
 	topRouter := mux.NewRouter().StrictSlash(true)
 	healthRouter := mux.NewRouter().PathPrefix(healthPath).Subrouter().StrictSlash(true)
 	v1Router := mux.NewRouter().PathPrefix(v1Path).Subrouter().StrictSlash(true)
+	hooksRouter := mux.NewRouter().PathPrefix(hooksPath).Subrouter().StrictSlash(true)
+	discordRouter := mux.NewRouter().PathPrefix(discordPath).Subrouter().StrictSlash(true)
+	slackRouter := mux.NewRouter().PathPrefix(slackPath).Subrouter().StrictSlash(true)
+	adminRouter := mux.NewRouter().PathPrefix(adminPath).Subrouter().StrictSlash(true)
+	feedsRouter := mux.NewRouter().PathPrefix(feedsPath).Subrouter().StrictSlash(true)
 
 	healthRouter.HandleFunc("/ping", func(w http.ResponseWriter, r *http.Request) {
 		log.Debug("Health check called")
-		log.Info("IP of user using x-forwarded-for:", w.Header().Get("x-forwarded-for"))
-		log.Info("IP of user using x-real-ip:", w.Header().Get("x-real-ip"))
+		log.Debug("IP of user using x-forwarded-for:", r.Header.Get("x-forwarded-for"))
+		log.Debug("IP of user using x-real-ip:", r.Header.Get("x-real-ip"))
 		endAPICall(w, 200, heath{
 			Service:     "api",
 			Environment: config.GoEnv,
 			Status:      "healthy",
 		})
 	})
+	healthRouter.HandleFunc("/live", liveness(config)).Methods("GET", "HEAD")
+	healthRouter.HandleFunc("/ready", readiness(config, health.Default)).Methods("GET", "HEAD")
+
+	idempotency := Idempotency(&models.RedisStoreIdempotency{Client: redisClient}, time.Duration(config.IdempotencyTTL)*time.Hour)
+	v1Router.Use(Authorize(apiScope), rateLimiter.ByClient, idempotency, audit, IdempotentHandler)
+	adminRouter.Use(Authorize(adminScope), audit)
 
-	addRoutes(v1Router)
+	addRoutes(v1Router, config, redisClient, rateLimiter)
+	addHookRoutes(hooksRouter, config, redisClient)
+	addDiscordRoutes(ctx, discordRouter, config, redisClient)
+	addSlackRoutes(slackRouter, config, redisClient)
+	addAdminRoutes(ctx, adminRouter, adminPath, config, redisClient)
+	addFeedRoutes(feedsRouter, config, redisClient)
+
+	/* Metrics are only served to the bearer of METRICS_TOKEN, a scrape counts the excuses of every source */
+	if config.MetricsToken != "" {
+		topRouter.Handle(metricsPath, metricsHandler(config.MetricsToken)).Methods("GET")
+	} else {
+		log.Info("METRICS_TOKEN is not set, the metrics are not served")
+	}
 
 	topRouter.PathPrefix(healthPath).Handler(negroni.New(
 		/* Health-check routes are unprotected */
 		negroni.Wrap(healthRouter),
 	))
 
+	topRouter.PathPrefix(hooksPath).Handler(negroni.New(
+		/* Hooks are authenticated by their provider signature or token */
+		negroni.HandlerFunc(rateLimiter.ByIP),
+		negroni.Wrap(hooksRouter),
+	))
+
+	topRouter.PathPrefix(discordPath).Handler(negroni.New(
+		/* Discord interactions are authenticated by their Ed25519 signature */
+		negroni.Wrap(discordRouter),
+	))
+
+	topRouter.PathPrefix(slackPath).Handler(negroni.New(
+		/* Slack requests are authenticated by their signature */
+		negroni.Wrap(slackRouter),
+	))
+
+	topRouter.PathPrefix(feedsPath).Handler(negroni.New(
+		/* Feeds are public or authenticated by the token of their source */
+		negroni.HandlerFunc(rateLimiter.ByIP),
+		negroni.Wrap(feedsRouter),
+	))
+
+	topRouter.PathPrefix(adminPath).Handler(negroni.New(
+		/* The forms of the admin UI are checked against CSRF by its controller */
+		negroni.HandlerFunc(rateLimiter.ByIP),
+		authenticator,
+		negroni.Wrap(adminRouter),
+	))
+
 	topRouter.PathPrefix(v1Path).Handler(negroni.New(
-		negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-			if BasicAuth(w, r, username, password, "Provide user name and password") {
-				/* Call the next handler if Basic-Auth succeeded */
-				next(w, r)
-			}
-		}),
+		/* The scopes, sources, rate limits and idempotency keys of the client are checked once routed */
+		negroni.HandlerFunc(rateLimiter.ByIP),
+		authenticator,
+		negroni.HandlerFunc(ActingUser),
 		negroni.Wrap(v1Router),
 	))
 
-	return topRouter
+	routes := routeTemplates{
+		top: topRouter,
+		subrouters: map[string]*mux.Router{
+			healthPath:  healthRouter,
+			hooksPath:   hooksRouter,
+			discordPath: discordRouter,
+			slackPath:   slackRouter,
+			adminPath:   adminRouter,
+			feedsPath:   feedsRouter,
+			v1Path:      v1Router,
+		},
+	}
+	return observe(topRouter, log, routes, config.TrustProxyHeaders)
 }
 
-func addRoutes(router *mux.Router) {
-	ctrl := controllers.NewExcuseController()
+func addRoutes(router *mux.Router, config config.Config, redisClient *redis.Client, rateLimiter *RateLimiter) {
+	ctrl := controllers.NewExcuseController(redisClient)
+	wsCtrl := controllers.NewWebsocketController(redisClient, config, rateLimiter)
+	webhookCtrl := controllers.NewWebhookController(redisClient)
+	hookCtrl := controllers.NewHookController(redisClient, config)
+	ruleCtrl := controllers.NewRuleController(redisClient)
+	deliveryCtrl := controllers.NewDeliveryController(redisClient)
+	feedCtrl := controllers.NewFeedController(redisClient, config)
+	moderatorCtrl := controllers.NewModeratorController(redisClient)
+	rateLimitCtrl := controllers.NewRateLimitController(redisClient)
+	auditCtrl := controllers.NewAuditController(redisClient)
+
+	router.HandleFunc("/ws", wsCtrl.Serve).Methods("GET")
 
 	router.HandleFunc("/codexcuses/{source}", ctrl.GetExcuses).Methods("GET")
 	router.HandleFunc("/codexcuses/{source}/{id}", ctrl.GetExcuse).Methods("GET")
 	router.HandleFunc("/codexcuses/{source}", ctrl.AddExcuse).Methods("POST")
+	router.HandleFunc("/codexcuses/{source}/batch", ctrl.BatchExcuses).Methods("POST")
 	router.HandleFunc("/codexcuses/{source}/{id}", ctrl.DeleteExcuse).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/webhooks", webhookCtrl.GetWebhooks).Methods("GET")
+	router.HandleFunc("/sources/{source}/webhooks", webhookCtrl.AddWebhook).Methods("POST")
+	router.HandleFunc("/sources/{source}/webhooks/dead-letters", webhookCtrl.GetDeadLetters).Methods("GET")
+	router.HandleFunc("/sources/{source}/webhooks/dead-letters/{id}/replay", webhookCtrl.ReplayDeadLetter).Methods("POST")
+	router.HandleFunc("/sources/{source}/webhooks/{id}", webhookCtrl.GetWebhook).Methods("GET")
+	router.HandleFunc("/sources/{source}/webhooks/{id}", webhookCtrl.DeleteWebhook).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/hooks/{provider}", hookCtrl.GetHookConfig).Methods("GET")
+	router.HandleFunc("/sources/{source}/hooks/{provider}", hookCtrl.SetHookConfig).Methods("PUT")
+	router.HandleFunc("/sources/{source}/hooks/{provider}", hookCtrl.DeleteHookConfig).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/rules", ruleCtrl.GetRules).Methods("GET")
+	router.HandleFunc("/sources/{source}/rules", ruleCtrl.AddRule).Methods("POST")
+	router.HandleFunc("/sources/{source}/rules/preview", ruleCtrl.PreviewRule).Methods("POST")
+	router.HandleFunc("/sources/{source}/rules/{id}", ruleCtrl.GetRule).Methods("GET")
+	router.HandleFunc("/sources/{source}/rules/{id}", ruleCtrl.UpdateRule).Methods("PUT")
+	router.HandleFunc("/sources/{source}/rules/{id}", ruleCtrl.DeleteRule).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/feed", feedCtrl.GetFeedConfig).Methods("GET")
+	router.HandleFunc("/sources/{source}/feed", feedCtrl.SetFeedConfig).Methods("PUT")
+	router.HandleFunc("/sources/{source}/feed", feedCtrl.DeleteFeedConfig).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/moderators", moderatorCtrl.GetModerators).Methods("GET")
+	router.HandleFunc("/sources/{source}/moderators/{user}", moderatorCtrl.AddModerator).Methods("PUT")
+	router.HandleFunc("/sources/{source}/moderators/{user}", moderatorCtrl.DeleteModerator).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/rate-limits", rateLimitCtrl.GetRateLimits).Methods("GET")
+	router.HandleFunc("/sources/{source}/rate-limits", rateLimitCtrl.SetRateLimits).Methods("PUT")
+	router.HandleFunc("/sources/{source}/rate-limits", rateLimitCtrl.DeleteRateLimits).Methods("DELETE")
+
+	router.HandleFunc("/sources/{source}/audit", auditCtrl.GetAuditLog).Methods("GET")
+	router.HandleFunc("/audit", auditCtrl.GetGlobalAuditLog).Methods("GET")
+
+	router.HandleFunc("/sources/{source}/deliveries", deliveryCtrl.GetDeliveries).Methods("GET")
+	router.HandleFunc("/sources/{source}/deliveries/{id}", deliveryCtrl.GetDelivery).Methods("GET")
+	router.HandleFunc("/sources/{source}/deliveries/{id}/replay", deliveryCtrl.ReplayDelivery).Methods("POST")
+}
+
+func addHookRoutes(router *mux.Router, config config.Config, redisClient *redis.Client) {
+	ctrl := controllers.NewHookController(redisClient, config)
+
+	router.HandleFunc("/github/{source}", ctrl.LogDelivery(models.HookProviderGitHub, ctrl.GitHub)).Methods("POST")
+	router.HandleFunc("/gitlab/{source}", ctrl.LogDelivery(models.HookProviderGitLab, ctrl.GitLab)).Methods("POST")
+	router.HandleFunc("/gitea/{source}", ctrl.LogDelivery(models.HookProviderGitea, ctrl.Gitea)).Methods("POST")
+	router.HandleFunc("/dockerhub/{source}", ctrl.LogDelivery(models.HookProviderDockerHub, ctrl.DockerHub)).Methods("POST")
+	router.HandleFunc("/dockerhub/{source}/{token}", ctrl.LogDelivery(models.HookProviderDockerHub, ctrl.DockerHub)).Methods("POST")
+}
+
+func addDiscordRoutes(ctx context.Context, router *mux.Router, config config.Config, redisClient *redis.Client) {
+	log := logger.Get(ctx)
+
+	ctrl, err := controllers.NewDiscordController(redisClient, config)
+	if err != nil {
+		log.WithError(err).Error("Discord interactions will be rejected")
+	}
+
+	router.HandleFunc("/interactions", ctrl.Interactions).Methods("POST")
+}
+
+func addSlackRoutes(router *mux.Router, config config.Config, redisClient *redis.Client) {
+	ctrl := controllers.NewSlackController(redisClient, config)
+
+	router.HandleFunc("/commands", ctrl.Commands).Methods("POST")
+	router.HandleFunc("/interactions", ctrl.Interactions).Methods("POST")
+}
+
+func addFeedRoutes(router *mux.Router, config config.Config, redisClient *redis.Client) {
+	ctrl := controllers.NewFeedController(redisClient, config)
+
+	router.HandleFunc("/{source}.{format:atom|rss}", ctrl.Feed).Methods("GET", "HEAD")
+}
+
+func addAdminRoutes(ctx context.Context, router *mux.Router, basePath string, config config.Config, redisClient *redis.Client) {
+	log := logger.Get(ctx)
+
+	ctrl, err := controllers.NewAdminController(redisClient, config, basePath)
+	if err != nil {
+		log.WithError(err).Error("the admin UI is disabled")
+		return
+	}
+
+	router.Use(ctrl.Protect)
+	router.PathPrefix("/static/").Handler(ctrl.Static).Methods("GET")
+
+	router.HandleFunc("/", ctrl.Sources).Methods("GET")
+	router.HandleFunc("/sources/{source}", ctrl.Excuses).Methods("GET")
+	router.HandleFunc("/sources/{source}/excuses", ctrl.CreateExcuse).Methods("POST")
+	router.HandleFunc("/sources/{source}/excuses/new", ctrl.NewExcuse).Methods("GET")
+	router.HandleFunc("/sources/{source}/excuses/{id}", ctrl.EditExcuse).Methods("GET")
+	router.HandleFunc("/sources/{source}/excuses/{id}", ctrl.UpdateExcuse).Methods("POST")
+	router.HandleFunc("/sources/{source}/excuses/{id}/delete", ctrl.DeleteExcuse).Methods("POST")
+	router.HandleFunc("/sources/{source}/moderation", ctrl.Moderation).Methods("GET")
+	router.HandleFunc("/sources/{source}/moderation/{id}/dismiss", ctrl.DismissReports).Methods("POST")
+	router.HandleFunc("/sources/{source}/trash", ctrl.Trash).Methods("GET")
+	router.HandleFunc("/sources/{source}/trash/{id}/restore", ctrl.RestoreExcuse).Methods("POST")
+	router.HandleFunc("/sources/{source}/trash/{id}/purge", ctrl.PurgeExcuse).Methods("POST")
+	router.HandleFunc("/sources/{source}/stats", ctrl.Stats).Methods("GET")
 }
 
 func endAPICall(w http.ResponseWriter, httpStatus int, anyStruct interface{}) {
@@ -78,19 +268,6 @@ func endAPICall(w http.ResponseWriter, httpStatus int, anyStruct interface{}) {
 	w.Write(result)
 }
 
-func BasicAuth(w http.ResponseWriter, r *http.Request, username, password, realm string) bool {
-	user, pass, ok := r.BasicAuth()
-
-	if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(username)) != 1 || subtle.ConstantTimeCompare([]byte(pass), []byte(password)) != 1 {
-		w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
-		w.WriteHeader(401)
-		w.Write([]byte("401 Unauthorized\n"))
-		return false
-	}
-
-	return true
-}
-
 type heath struct {
 	Service     string `json:"service"`
 	Environment string `json:"environment"`
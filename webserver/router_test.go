@@ -0,0 +1,115 @@
+package webserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+	"github.com/go-redis/redis"
+)
+
+// newTestRedis returns a client of a Redis server stopped at the end of the
+// test
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: 0})
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func TestHooksAreRateLimitedByIP(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	router := NewRouter(context.Background(), config.Config{RateLimitIP: 2, RateLimitWindow: 60}, redisClient)
+
+	for i, want := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("POST", "/hooks/github/guild", strings.NewReader(`{}`))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != want {
+			t.Errorf("request %d: got %d %s, want %d", i, recorder.Code, recorder.Body, want)
+		}
+	}
+}
+
+func TestFeedsAreRateLimitedByIP(t *testing.T) {
+	_, redisClient := newTestRedis(t)
+	router := NewRouter(context.Background(), config.Config{RateLimitIP: 2, RateLimitWindow: 60}, redisClient)
+
+	for i, want := range []int{http.StatusNotFound, http.StatusNotFound, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/feeds/guild.rss?token=guess", nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		if recorder.Code != want {
+			t.Errorf("request %d: got %d %s, want %d", i, recorder.Code, recorder.Body, want)
+		}
+	}
+}
+
+func TestRejectionsDoNotReserveIdempotencyKeys(t *testing.T) {
+	server, redisClient := newTestRedis(t)
+	router := NewRouter(context.Background(), config.Config{
+		RateLimitWindow: 60,
+		RateLimits:      map[string]int{models.RateLimitWrite: 1},
+		IdempotencyTTL:  24,
+	}, redisClient)
+	clients := &models.RedisStoreAPIClients{Client: redisClient}
+	err := clients.Create(context.Background(), &models.APIClient{
+		Name:    "bot",
+		Scopes:  []string{models.ScopeAdmin},
+		Sources: []string{"guild"},
+	}, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excuse := `{"title": "t", "content": "c", "author": {"username": "a"}, "reporter": {"id": "1", "username": "r"}}`
+	post := func(path, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(excuse))
+		req.SetBasicAuth("bot", "s3cr3t")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if got := post("/api/codexcuses/other", "k1"); got.Code != http.StatusForbidden {
+		t.Fatalf("got %d %s on another source, want 403", got.Code, got.Body)
+	}
+	if got := post("/api/codexcuses/guild", "k1"); got.Code != http.StatusOK {
+		t.Fatalf("got %d %s with the key of a forbidden request, want 200", got.Code, got.Body)
+	}
+	if got := post("/api/codexcuses/guild", "k2"); got.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %d %s over the rate limit, want 429", got.Code, got.Body)
+	}
+
+	// Once the bucket is emptied, the rejected request is run. The replays
+	// count in the rate limit too.
+	resetBuckets := func() {
+		for _, key := range server.Keys() {
+			if strings.Contains(key, "RateLimit:") {
+				server.Del(key)
+			}
+		}
+	}
+	resetBuckets()
+	got := post("/api/codexcuses/guild", "k2")
+	if got.Code != http.StatusOK || got.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Fatalf("got %d %s after the rate limit, want the request run", got.Code, got.Body)
+	}
+	resetBuckets()
+	if got := post("/api/codexcuses/guild", "k2"); got.Code != http.StatusOK || got.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("got %d %s, want the response replayed", got.Code, got.Body)
+	}
+}
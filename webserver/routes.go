@@ -0,0 +1,37 @@
+package webserver
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// routeTemplates finds the template of the route of a request, through the
+// subrouters the top router hands the requests to
+type routeTemplates struct {
+	top *mux.Router
+	// subrouters are keyed by the template of their prefix on the top router
+	subrouters map[string]*mux.Router
+}
+
+// of returns the template of the route of r, "unmatched" when there is none.
+// It does not depend on the request being routed, so that the requests
+// rejected before, by the authentication for instance, have one too.
+func (t routeTemplates) of(r *http.Request) string {
+	var match mux.RouteMatch
+	if !t.top.Match(r, &match) || match.Route == nil {
+		return "unmatched"
+	}
+	template, _ := match.Route.GetPathTemplate()
+	subrouter, ok := t.subrouters[template]
+	if !ok {
+		return template
+	}
+
+	match = mux.RouteMatch{}
+	if !subrouter.Match(r, &match) || match.Route == nil {
+		return "unmatched"
+	}
+	template, _ = match.Route.GetPathTemplate()
+	return template
+}
@@ -0,0 +1,123 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/pkg/errors"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLS holds the certificate of the server and the CA bundle of the client
+// certificates. Reload reads their files again: the next handshakes use them
+// while the open connections are kept.
+type TLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	MinVersion   uint16
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewTLS loads the certificate of the server, it returns nil when TLS is not
+// configured
+func NewTLS(config config.Config) (*TLS, error) {
+	if config.TLSCertFile == "" && config.TLSKeyFile == "" {
+		if config.TLSClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	minVersion, ok := tlsVersions[config.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("invalid TLS_MIN_VERSION '%s', must be 1.0, 1.1, 1.2 or 1.3", config.TLSMinVersion)
+	}
+
+	t := &TLS{
+		CertFile:     config.TLSCertFile,
+		KeyFile:      config.TLSKeyFile,
+		ClientCAFile: config.TLSClientCAFile,
+		MinVersion:   minVersion,
+	}
+	err := t.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Reload reads the certificate and the CA bundle again, they are kept
+// unchanged if one of them is invalid
+func (t *TLS) Reload() error {
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return errors.Wrap(err, "fail to load TLS certificate")
+	}
+
+	var clientCAs *x509.CertPool
+	if t.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(t.ClientCAFile)
+		if err != nil {
+			return errors.Wrap(err, "fail to read client CA bundle")
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return errors.New("no certificate found in the client CA bundle")
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cert = &cert
+	t.clientCAs = clientCAs
+	return nil
+}
+
+// Config returns the TLS configuration of the server. The client
+// certificates are verified when they are given, the clients without one
+// authenticate with their credentials.
+func (t *TLS) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:     t.MinVersion,
+		GetCertificate: t.certificate,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   t.MinVersion,
+				Certificates: []tls.Certificate{*t.cert},
+				// The HTTP/2 support of the server is only announced by the
+				// configuration of the handshake
+				NextProtos: []string{"h2", "http/1.1"},
+			}
+			if t.clientCAs != nil {
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				config.ClientCAs = t.clientCAs
+			}
+			return config, nil
+		},
+	}
+}
+
+func (t *TLS) certificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.cert, nil
+}
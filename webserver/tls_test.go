@@ -0,0 +1,239 @@
+package webserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/curzolapierre/hook-manager/config"
+	"github.com/curzolapierre/hook-manager/models"
+)
+
+// testCert is a certificate and its key, signed by parent or self-signed
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, subject pkix.Name, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and its key in dir, it returns their paths
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	err := ioutil.WriteFile(path, data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+	certFile, keyFile := newTestCert(t, pkix.Name{CommonName: "server"}, ca).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+
+	tests := []struct {
+		name   string
+		config config.Config
+		nilTLS bool
+		valid  bool
+	}{
+		{"not configured", config.Config{}, true, true},
+		{"CA without certificate", config.Config{TLSClientCAFile: caFile}, true, false},
+		{"certificate without key", config.Config{TLSCertFile: certFile}, true, false},
+		{"invalid min version", config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.4"}, true, false},
+		{"key of another certificate", config.Config{TLSCertFile: certFile, TLSKeyFile: filepath.Join(dir, "ca.key"), TLSMinVersion: "1.2"}, true, false},
+		{"CA bundle without certificate", config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.2", TLSClientCAFile: keyFile}, true, false},
+		{"certificate", config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.3"}, false, true},
+		{"client CA", config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.2", TLSClientCAFile: caFile}, false, true},
+	}
+	for _, test := range tests {
+		got, err := NewTLS(test.config)
+		if (got == nil) != test.nilTLS || (err == nil) != test.valid {
+			t.Errorf("%s: got %v, %v, want nil %v and valid %v", test.name, got, err, test.nilTLS, test.valid)
+		}
+	}
+}
+
+// newTLSServer answers the name of the client authenticated by a request,
+// with the configuration of serverTLS
+func newTLSServer(t *testing.T, serverTLS *TLS, store *models.RedisStoreAPIClients) *httptest.Server {
+	t.Helper()
+	authenticator := &Authenticator{Store: store, Realm: "test"}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authenticator.ServeHTTP(w, r, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(models.APIClientFromContext(r.Context()).Name))
+		})
+	}))
+	server.TLS = serverTLS.Config()
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// tlsGet requests url with the certificate, sent even if the server does
+// not ask for one of its issuer
+func tlsGet(url string, roots *x509.CertPool, maxVersion uint16, certs ...tls.Certificate) (int, string, *x509.Certificate, error) {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    roots,
+		MaxVersion: maxVersion,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if len(certs) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &certs[0], nil
+		},
+	}}}
+	defer client.CloseIdleConnections()
+	res, err := client.Get(url)
+	if err != nil {
+		return 0, "", nil, err
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(body), res.TLS.PeerCertificates[0], nil
+}
+
+func TestTLSClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+	certFile, keyFile := newTestCert(t, pkix.Name{CommonName: "server"}, ca).write(t, dir, "server")
+	caFile, _ := ca.write(t, dir, "ca")
+	serverTLS, err := NewTLS(config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.2", TLSClientCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, redisClient := newTestRedis(t)
+	store := &models.RedisStoreAPIClients{Client: redisClient}
+	err = store.Create(context.Background(), &models.APIClient{Name: "billing", CertSubject: "CN=billing,O=Acme"}, "s3cr3t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newTLSServer(t, serverTLS, store)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	billing := newTestCert(t, pkix.Name{CommonName: "billing", Organization: []string{"Acme"}}, ca)
+	unknown := newTestCert(t, pkix.Name{CommonName: "unknown"}, ca)
+	otherCA := newTestCert(t, pkix.Name{CommonName: "other ca"}, nil)
+	forged := newTestCert(t, pkix.Name{CommonName: "billing", Organization: []string{"Acme"}}, otherCA)
+
+	tests := []struct {
+		name      string
+		certs     []tls.Certificate
+		status    int
+		client    string
+		handshake bool
+	}{
+		{"registered subject", []tls.Certificate{billing.tlsCertificate()}, http.StatusOK, "billing", true},
+		{"unknown subject", []tls.Certificate{unknown.tlsCertificate()}, http.StatusUnauthorized, "", true},
+		{"no certificate", nil, http.StatusUnauthorized, "", true},
+		{"certificate of another CA", []tls.Certificate{forged.tlsCertificate()}, 0, "", false},
+	}
+	for _, test := range tests {
+		status, body, _, err := tlsGet(server.URL, roots, 0, test.certs...)
+		if (err == nil) != test.handshake {
+			t.Errorf("%s: got the error %v, want a handshake %v", test.name, err, test.handshake)
+			continue
+		}
+		if status != test.status || (test.client != "" && body != test.client) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, status, body, test.status, test.client)
+		}
+	}
+
+	if _, _, _, err := tlsGet(server.URL, roots, tls.VersionTLS11); err == nil {
+		t.Error("got a TLS 1.1 handshake, want it refused")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, pkix.Name{CommonName: "ca"}, nil)
+	first := newTestCert(t, pkix.Name{CommonName: "first"}, ca)
+	certFile, keyFile := first.write(t, dir, "server")
+	serverTLS, err := NewTLS(config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "1.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, redisClient := newTestRedis(t)
+	server := newTLSServer(t, serverTLS, &models.RedisStoreAPIClients{Client: redisClient})
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	newTestCert(t, pkix.Name{CommonName: "second"}, ca).write(t, dir, "server")
+	err = serverTLS.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, cert, err := tlsGet(server.URL, roots, 0); err != nil || cert.Subject.CommonName != "second" {
+		t.Fatalf("got the certificate %v, %v after the reload, want the second one", cert, err)
+	}
+
+	// An invalid certificate keeps the previous one
+	writeFile(t, certFile, []byte("not a certificate"))
+	if err := serverTLS.Reload(); err == nil {
+		t.Fatal("got no error reloading an invalid certificate")
+	}
+	if _, _, cert, err := tlsGet(server.URL, roots, 0); err != nil || cert.Subject.CommonName != "second" {
+		t.Errorf("got the certificate %v, %v, want the second one kept", cert, err)
+	}
+}